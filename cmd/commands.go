@@ -0,0 +1,733 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	aliases []string
+	args    string   // the positional arguments, for help
+	nargs   int      // how many positional arguments it takes; -1 if it checks itself
+	flags   []string // command flags, see flags.registerCommand
+	summary string
+	local   bool // runs without a VFS
+	server  bool // serves requests until interrupted, showing no progress
+	metrics bool // collects Prometheus metrics in env.metrics
+	run     func(e *env, args []string) error
+}
+
+// env is what a command runs with.
+type env struct {
+	ctx      context.Context
+	v        *vfs.VFS
+	f        *flags
+	toStdout bool                 // stdout carries the file
+	status   int                  // exit status of commands that succeed but find problems
+	metrics  *prometheus.Registry // nil unless command.metrics
+}
+
+// defaultOlderThan is how old an upload must be for cleanup and gc to
+// call it abandoned.
+const defaultOlderThan = 24 * time.Hour
+
+// errUsage reports arguments a command cannot run with.
+type errUsage struct{ msg string }
+
+func (e errUsage) Error() string { return e.msg }
+
+func usageErrorf(format string, args ...any) error {
+	return errUsage{fmt.Sprintf(format, args...)}
+}
+
+var commands = []*command{
+	{
+		name: "encode", args: "<inputfile...|-> s3://bucket/prefix/", nargs: -1,
+		flags:   []string{"force", "split-layout", "resume", "no-preserve", "preserve-owner", "expires", "retention", "legal-hold"},
+		summary: "store a file, stdin, or several files each at its own prefix",
+		run:     runEncode,
+	},
+	{
+		name: "restore", args: "s3://bucket/prefix/ [outputfile|-]", nargs: -1,
+		flags:   []string{"best-effort", "allow-gaps", "resume", "version", "snapshot", "as-of", "range", "no-preserve", "preserve-owner"},
+		summary: "write a stored file back out, or to stdout",
+		run:     runRestore,
+	},
+	{
+		name: "cat", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "write a stored file to stdout",
+		run: func(e *env, args []string) error {
+			return e.v.RestoreWriter(e.ctx, args[0], os.Stdout)
+		},
+	},
+	{
+		name: "append", args: "<inputfile> s3://bucket/prefix/", nargs: 2,
+		flags:   []string{"retention", "legal-hold"},
+		summary: "add a file's data to the end of a stored file",
+		run: func(e *env, args []string) error {
+			return e.v.Append(e.ctx, args[0], args[1])
+		},
+	},
+	{
+		name: "delete", args: "s3://bucket/prefix/", nargs: 1,
+		flags:   []string{"yes", "i-know-what-im-doing"},
+		summary: "delete everything under a prefix",
+		run:     runDelete,
+	},
+	{
+		name: "checksum", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "print the SHA-256 of a stored file",
+		run: func(e *env, args []string) error {
+			sum, err := e.v.Checksum(e.ctx, args[0])
+			if err == nil {
+				show(sum, func(sum string) { fmt.Println(sum) })
+			}
+			return err
+		},
+	},
+	{
+		name: "verify", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "check every chunk of an archive without writing it anywhere",
+		run: func(e *env, args []string) error {
+			report, err := e.v.Verify(e.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			show(report, printReport)
+			if !report.OK() {
+				e.status = exitIntegrity
+			}
+			return nil
+		},
+	},
+	{
+		name: "repair", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "restore the redundancy of an archive from mirrors and parity",
+		run: func(e *env, args []string) error {
+			report, err := e.v.Repair(e.ctx, args[0])
+			if err != nil {
+				return err
+			}
+			show(report, printRepair)
+			if !report.OK() {
+				e.status = exitIntegrity
+			}
+			return nil
+		},
+	},
+	{
+		name: "migrate", args: "s3://bucket/prefix/ [gs://bucket/prefix/]", nargs: -1,
+		summary: "bring an archive written by an older version up to date, or copy every archive to another store",
+		run:     runMigrate,
+	},
+	{
+		name: "cleanup", args: "s3://bucket/prefix/", nargs: 1,
+		flags:   []string{"older-than"},
+		summary: "delete abandoned uploads",
+		run: func(e *env, args []string) error {
+			removed, err := e.v.Cleanup(e.ctx, args[0], e.f.olderThan)
+			if err == nil {
+				show(removed, printRemoved)
+			}
+			return err
+		},
+	},
+	{
+		name: "gc", args: "s3://bucket/prefix/", nargs: 1,
+		flags:   []string{"older-than", "keep-versions", "expired"},
+		summary: "delete uploads, versions and chunks no archive needs, and expired archives",
+		run: func(e *env, args []string) error {
+			// With --dry-run the deletes are recorded instead of sent.
+			opts := vfs.GCOptions{OlderThan: e.f.olderThan, KeepVersions: e.f.keepVersions, Expired: e.f.expired}
+			report, err := e.v.GC(e.ctx, args[0], opts)
+			if err == nil {
+				show(report, func(r *vfs.GCReport) { printGC(r, e.f.dryRun) })
+			}
+			return err
+		},
+	},
+	{
+		name: "explain", args: "<inputfile> s3://bucket/prefix/", nargs: 2,
+		summary: "show what encoding a file would take, without uploading",
+		run: func(e *env, args []string) error {
+			plan, err := e.v.Explain(e.ctx, args[0], args[1])
+			if err == nil {
+				show(plan, printPlan)
+			}
+			return err
+		},
+	},
+	{
+		name: "estimate", args: "<inputfile> s3://bucket/prefix/", nargs: 2,
+		summary: "count the requests encoding a file would make, and what they cost",
+		run: func(e *env, args []string) error {
+			est, err := e.v.EstimateEncode(e.ctx, args[0], args[1])
+			if err == nil {
+				show(est, printEstimate)
+			}
+			return err
+		},
+	},
+	{
+		name: "list", aliases: []string{"ls"}, args: "s3://bucket/prefix/", nargs: 1,
+		summary: "list the archives under a prefix",
+		run: func(e *env, args []string) error {
+			entries, err := e.v.List(e.ctx, args[0])
+			if err == nil {
+				show(entries, printEntries)
+			}
+			return err
+		},
+	},
+	{
+		name: "stat", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "print the manifest of an archive",
+		run: func(e *env, args []string) error {
+			m, err := e.v.Stat(e.ctx, args[0])
+			if err == nil {
+				show(m, printManifest)
+			}
+			return err
+		},
+	},
+	{
+		name: "cp", aliases: []string{"copy"}, args: "s3://bucket/prefix/ s3://bucket/other/", nargs: 2,
+		summary: "copy an archive to another prefix",
+		run: func(e *env, args []string) error {
+			err := e.v.Copy(e.ctx, args[0], args[1])
+			if err == nil {
+				say("Copied to: %s\n", args[1])
+			}
+			return err
+		},
+	},
+	{
+		name: "mv", aliases: []string{"move", "rename"}, args: "s3://bucket/prefix/ s3://bucket/other/", nargs: 2,
+		summary: "move an archive to another prefix",
+		run: func(e *env, args []string) error {
+			err := e.v.Move(e.ctx, args[0], args[1])
+			if err == nil {
+				say("Moved to: %s\n", args[1])
+			}
+			return err
+		},
+	},
+	{
+		name: "sync", args: "<dir> s3://bucket/prefix/", nargs: 2,
+		flags:   []string{"delete", "no-preserve", "preserve-owner", "retention", "legal-hold"},
+		summary: "upload the files of a directory that changed",
+		run: func(e *env, args []string) error {
+			report, err := e.v.Sync(e.ctx, args[0], args[1], e.f.deleteRemoved)
+			if report != nil {
+				show(report, printSync)
+			}
+			return err
+		},
+	},
+	{
+		name: "watch", args: "<file|dir> s3://bucket/prefix/", nargs: 2,
+		flags:   []string{"debounce", "delete", "no-preserve", "preserve-owner", "retention", "legal-hold"},
+		summary: "store a file or directory again whenever it changes",
+		run: func(e *env, args []string) error {
+			if output != nil {
+				return usageErrorf("--json is not supported, watch runs until interrupted")
+			}
+			say("👀 Watching %s, press Ctrl-C to stop.\n", args[0])
+			return e.v.Watch(e.ctx, args[0], args[1], vfs.WatchOptions{
+				Debounce:      e.f.debounce,
+				DeleteRemoved: e.f.deleteRemoved,
+				OnSync:        printWatchRound,
+			})
+		},
+	},
+	{
+		name: "replicate", args: "s3://src-bucket/prefix/ s3://dst-bucket/prefix/", nargs: 2,
+		flags:   []string{"continuous", "interval", "delete"},
+		summary: "copy the archives under a prefix that are new or changed to another bucket or store",
+		run:     runReplicate,
+	},
+	{
+		name: "serve", args: "s3://bucket/prefix/", nargs: 1,
+		flags:   []string{"listen", "grpc-listen"},
+		summary: "serve the archives under a prefix over HTTP and gRPC",
+		server:  true,
+		metrics: true,
+		run:     runServe,
+	},
+	{
+		name: "serve-webdav", args: "s3://bucket/prefix/", nargs: 1,
+		flags:   []string{"listen", "cache-dir"},
+		summary: "share the files stored by name under a prefix over WebDAV",
+		server:  true,
+		run:     runServeWebDAV,
+	},
+	{
+		name: "mount", args: "s3://bucket/prefix/ <mountpoint>", nargs: 2,
+		flags:   []string{"cache-dir", "allow-other"},
+		summary: "mount the archives under a prefix as a read-only filesystem",
+		server:  true,
+		run:     runMount,
+	},
+	{
+		name: "du", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "show the storage used under a prefix",
+		run: func(e *env, args []string) error {
+			du, err := e.v.DiskUsage(e.ctx, args[0])
+			if err == nil {
+				show(du, printUsage)
+			}
+			return err
+		},
+	},
+	{
+		name: "encode-dir", args: "<dir> s3://bucket/prefix/", nargs: 2,
+		flags:   []string{"force", "expires", "retention", "legal-hold"},
+		summary: "store every file of a directory under a prefix",
+		run: func(e *env, args []string) error {
+			return overwrite(e, args[1], "already contains data", func(force bool) error {
+				return e.v.EncodeDir(e.ctx, args[0], args[1], force)
+			})
+		},
+	},
+	{
+		name: "restore-dir", args: "s3://bucket/prefix/ <dir>", nargs: 2,
+		summary: "write a stored directory back out",
+		run: func(e *env, args []string) error {
+			err := e.v.RestoreDir(e.ctx, args[0], args[1])
+			if err == nil {
+				say("Restored directory written to: %s\n", args[1])
+			}
+			return err
+		},
+	},
+	{
+		name: "put", args: "<inputfile> s3://bucket/prefix/", nargs: 2,
+		flags:   []string{"name", "force", "no-preserve", "preserve-owner", "retention", "legal-hold"},
+		summary: "add a file to the catalog of a prefix",
+		run: func(e *env, args []string) error {
+			return overwrite(e, args[1], "already holds a file by that name", func(force bool) error {
+				return e.v.EncodeNamed(e.ctx, args[0], args[1], e.f.name, force)
+			})
+		},
+	},
+	{
+		name: "get", args: "s3://bucket/prefix/ <name> <outputfile>", nargs: 3,
+		flags:   []string{"no-preserve", "preserve-owner"},
+		summary: "write a file of the catalog of a prefix back out",
+		run: func(e *env, args []string) error {
+			err := e.v.RestoreNamed(e.ctx, args[0], args[1], args[2])
+			if err == nil {
+				say("Restored file written to: %s\n", args[2])
+			}
+			return err
+		},
+	},
+	{
+		name: "files", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "list the catalog of a prefix",
+		run: func(e *env, args []string) error {
+			files, err := e.v.Catalog(e.ctx, args[0])
+			if err == nil {
+				show(files, printCatalog)
+			}
+			return err
+		},
+	},
+	{
+		name: "rm", args: "s3://bucket/prefix/ <name>", nargs: 2,
+		summary: "delete a file from the catalog of a prefix",
+		run: func(e *env, args []string) error {
+			return e.v.DeleteNamed(e.ctx, args[0], args[1])
+		},
+	},
+	{
+		name: "versions", args: "s3://bucket/prefix/", nargs: 1,
+		summary: "list the previous versions of an archive",
+		run: func(e *env, args []string) error {
+			versions, err := e.v.Versions(e.ctx, args[0])
+			if err == nil {
+				show(versions, printVersions)
+			}
+			return err
+		},
+	},
+	{
+		name: "rollback", args: "s3://bucket/prefix/ <N>", nargs: 2,
+		summary: "make version N of an archive current again",
+		run: func(e *env, args []string) error {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return usageErrorf("invalid version %q", args[1])
+			}
+			if err = e.v.Rollback(e.ctx, args[0], n); err == nil {
+				say("Rolled %s back to version %d\n", args[0], n)
+			}
+			return err
+		},
+	},
+	{
+		name: "snapshot", args: "create|restore|delete s3://bucket/prefix/ <name> | list s3://bucket/prefix/", nargs: -1,
+		summary: "keep, list, and go back to named states of an archive",
+		run:     runSnapshot,
+	},
+	{
+		name: "manifest", args: "export s3://bucket/prefix/ [file|-] | import <file|-> s3://bucket/prefix/", nargs: -1,
+		flags:   []string{"force"},
+		summary: "save the manifest of an archive as JSON, or store one saved before",
+		run:     runManifest,
+	},
+	{
+		name: "reassemble", args: "<chunkdir> <outputfile>", nargs: 2,
+		summary: "restore a file offline from a directory of chunks",
+		local:   true,
+		run: func(e *env, args []string) error {
+			err := vfs.ReassembleLocal(args[0], args[1])
+			if err == nil {
+				say("Restored file written to: %s\n", args[1])
+			}
+			return err
+		},
+	},
+	{
+		name: "version", nargs: 0,
+		summary: "print the version, commit and build date of vfs",
+		local:   true,
+		run: func(e *env, args []string) error {
+			show(vfs.Version(), printVersion)
+			return nil
+		},
+	},
+}
+
+// lookup returns the command called name or one of its aliases.
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+		for _, alias := range c.aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func runEncode(e *env, args []string) error {
+	if len(args) < 2 {
+		return usageErrorf("expected an input file and a URI, got %d argument(s)", len(args))
+	}
+	if len(args) > 2 || isGlob(args[0]) {
+		return runEncodeFiles(e, args[:len(args)-1], args[len(args)-1])
+	}
+	if args[0] != "-" {
+		// Errors are left for the encode to report.
+		if est, err := e.v.EstimateEncode(e.ctx, args[0], args[1]); err == nil && !e.f.quiet {
+			say("Estimated %s.\n", requestSummary(est))
+		}
+		return overwrite(e, args[1], "already contains data", func(force bool) error {
+			return e.v.Encode(e.ctx, args[0], args[1], force)
+		})
+	}
+	// stdin carries the data, so there is no asking before overwriting:
+	// --force is required.
+	err := e.v.EncodeReader(e.ctx, os.Stdin, args[1], e.f.force)
+	if errors.Is(err, vfs.ErrPrefixExists) {
+		err = fmt.Errorf("%w (use --force to overwrite)", err)
+	}
+	return err
+}
+
+// runEncodeFiles stores each file matching inputs, which may be glob
+// patterns, at its own prefix under uri.
+func runEncodeFiles(e *env, inputs []string, uri string) error {
+	var paths []string
+	for _, input := range inputs {
+		if input == "-" {
+			return usageErrorf("stdin cannot be encoded together with files")
+		}
+		if !isGlob(input) {
+			paths = append(paths, input)
+			continue
+		}
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return usageErrorf("%s: %v", input, err)
+		}
+		if len(matches) == 0 {
+			return usageErrorf("%s matches no files", input)
+		}
+		paths = append(paths, matches...)
+	}
+	var stored []string
+	err := overwrite(e, uri, "already holds archives of some of these files", func(force bool) error {
+		var err error
+		stored, err = e.v.EncodeFiles(e.ctx, paths, uri, force)
+		return err
+	})
+	if err != nil && len(stored) > 0 {
+		return errPartial{err}
+	}
+	if err == nil && stored != nil {
+		show(stored, func(stored []string) {
+			fmt.Printf("Stored %d file(s) under %s\n", len(stored), uri)
+		})
+	}
+	return err
+}
+
+// isGlob reports whether path is a pattern for filepath.Glob rather than a
+// file name, as when the shell did not expand it.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// overwrite runs encode, which stores a file at uri, and if uri is taken
+// asks whether to run it again with force (unless --force or --json).
+func overwrite(e *env, uri, taken string, encode func(force bool) error) error {
+	err := encode(e.f.force)
+	if !errors.Is(err, vfs.ErrPrefixExists) || output != nil {
+		return err
+	}
+	if !confirm(fmt.Sprintf("⚠️  %s %s. Overwrite?", uri, taken)) {
+		fmt.Println("✋ Upload canceled.")
+		return nil
+	}
+	return encode(true)
+}
+
+// runDelete asks before deleting unless --yes is given, and refuses to
+// empty a whole bucket without --i-know-what-im-doing.
+func runDelete(e *env, args []string) error {
+	uri := args[0]
+	if isBucketRoot(uri) && !e.f.allowRoot {
+		return usageErrorf("refusing to delete everything in %s without --i-know-what-im-doing", uri)
+	}
+	if !e.f.yes && !e.f.dryRun {
+		if output != nil || !isTerminal(os.Stdin) {
+			return usageErrorf("--yes is required to delete without a terminal to ask on")
+		}
+		du, err := e.v.DiskUsage(e.ctx, uri)
+		if err != nil {
+			return err
+		}
+		if len(du) == 0 {
+			say("Nothing stored under %s.\n", uri)
+			return nil
+		}
+		u := du[0] // uri itself, which includes everything below it
+		question := fmt.Sprintf("⚠️  Delete %d object(s) under %s, %d archive(s) holding %s, with %s?",
+			u.Objects, uri, u.Archives, formatBytes(u.Bytes), requestSummary(e.v.EstimateDelete(int64(u.Objects))))
+		if !confirm(question) {
+			fmt.Println("✋ Delete canceled.")
+			return nil
+		}
+	}
+	return e.v.Delete(e.ctx, uri)
+}
+
+// isBucketRoot reports whether uri names a whole bucket, with no prefix.
+func isBucketRoot(uri string) bool {
+	_, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return false
+	}
+	_, prefix, _ := strings.Cut(rest, "/")
+	return strings.Trim(prefix, "/") == ""
+}
+
+// runMigrate upgrades the archive at args[0], or copies every archive
+// under it to args[1].
+func runMigrate(e *env, args []string) error {
+	switch len(args) {
+	case 1:
+		err := e.v.Migrate(e.ctx, args[0])
+		if err == nil {
+			say("✅ Migration complete.\n")
+		}
+		return err
+	case 2:
+		report, err := e.v.Transfer(e.ctx, args[0], args[1])
+		if report != nil {
+			show(report, printTransfer)
+		}
+		return err
+	}
+	return usageErrorf("expected a URI and an optional destination URI, got %d argument(s)", len(args))
+}
+
+// runReplicate copies what changed under args[0] to args[1], once or
+// until interrupted.
+func runReplicate(e *env, args []string) error {
+	if !e.f.continuous {
+		report, err := e.v.Replicate(e.ctx, args[0], args[1], e.f.deleteRemoved)
+		if report != nil {
+			show(report, printReplicate)
+		}
+		return err
+	}
+	if output != nil {
+		return usageErrorf("--json is not supported with --continuous, which runs until interrupted")
+	}
+	say("🔁 Replicating %s to %s, press Ctrl-C to stop.\n", args[0], args[1])
+	return e.v.ReplicateContinuously(e.ctx, args[0], args[1], vfs.ReplicateOptions{
+		Interval:      e.f.interval,
+		DeleteRemoved: e.f.deleteRemoved,
+		OnPass:        printReplicatePass,
+	})
+}
+
+// runRestore writes the file at args[0] to args[1], or without it under
+// its original name in the current directory.
+func runRestore(e *env, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return usageErrorf("expected a URI and an optional output file, got %d argument(s)", len(args))
+	}
+	uri := args[0]
+	var err error
+	if e.f.version != 0 {
+		if uri, err = vfs.VersionURI(uri, e.f.version); err != nil {
+			return usageErrorf("invalid --version %d", e.f.version)
+		}
+	}
+	if e.f.snapshot != "" {
+		if uri, err = vfs.SnapshotURI(uri, e.f.snapshot); err != nil {
+			return usageErrorf("invalid --snapshot %q", e.f.snapshot)
+		}
+	}
+	if e.f.rangeLen > 0 {
+		return restoreRange(e, uri, args)
+	}
+	if e.toStdout {
+		return e.v.RestoreWriter(e.ctx, uri, os.Stdout)
+	}
+	var path string
+	if len(args) == 2 {
+		path, err = args[1], e.v.Restore(e.ctx, uri, args[1])
+	} else if path, err = e.v.RestoreInto(e.ctx, uri, "."); errors.Is(err, vfs.ErrNoFilename) {
+		return usageErrorf("%s: %v; name the output file", uri, err)
+	}
+	var decodeErr *vfs.DecodeError
+	if err == nil {
+		say("Restored file written to: %s\n", path)
+	} else if errors.As(err, &decodeErr) {
+		say("⚠️  Partial file written to: %s\n", path)
+	}
+	return err
+}
+
+// restoreRange writes the bytes --range selects of the file at uri to the
+// output file or stdout.
+func restoreRange(e *env, uri string, args []string) error {
+	if len(args) != 2 {
+		return usageErrorf("--range needs an output file or -")
+	}
+	if e.f.resume {
+		return usageErrorf("--range cannot be resumed")
+	}
+	if e.toStdout {
+		return e.v.RestoreRange(e.ctx, uri, os.Stdout, e.f.rangeOff, e.f.rangeLen)
+	}
+	out, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	err = e.v.RestoreRange(e.ctx, uri, out, e.f.rangeOff, e.f.rangeLen)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		say("Restored range written to: %s\n", args[1])
+	}
+	return err
+}
+
+func runManifest(e *env, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return usageErrorf("expected export and a URI with an optional file, or import, a file and a URI")
+	}
+	switch args[0] {
+	case "export":
+		if e.toStdout {
+			return e.v.ExportManifest(e.ctx, args[1], os.Stdout)
+		}
+		out, err := os.Create(args[2])
+		if err != nil {
+			return err
+		}
+		err = e.v.ExportManifest(e.ctx, args[1], out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			say("Manifest written to: %s\n", args[2])
+		}
+		return err
+	case "import":
+		if len(args) != 3 {
+			return usageErrorf("expected import, a file or -, and a URI")
+		}
+		in := os.Stdin
+		if args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		err := e.v.ImportManifest(e.ctx, args[2], in, e.f.force)
+		if errors.Is(err, vfs.ErrPrefixExists) {
+			err = fmt.Errorf("%w (use --force to replace it)", err)
+		}
+		if err == nil {
+			say("✅ Manifest stored at: %s\n", args[2])
+		}
+		return err
+	}
+	return usageErrorf("unknown manifest command %q", args[0])
+}
+
+func runSnapshot(e *env, args []string) error {
+	if len(args) == 2 && args[0] == "list" {
+		snapshots, err := e.v.Snapshots(e.ctx, args[1])
+		if err == nil {
+			show(snapshots, printSnapshots)
+		}
+		return err
+	}
+	if len(args) != 3 {
+		return usageErrorf("expected create, restore or delete, a URI and a name, or list and a URI")
+	}
+	uri, name := args[1], args[2]
+	switch args[0] {
+	case "create":
+		err := e.v.CreateSnapshot(e.ctx, uri, name)
+		if err == nil {
+			say("Snapshot %s of %s created\n", name, uri)
+		}
+		return err
+	case "restore":
+		err := e.v.RestoreSnapshot(e.ctx, uri, name)
+		if err == nil {
+			say("Restored %s to snapshot %s\n", uri, name)
+		}
+		return err
+	case "delete":
+		return e.v.DeleteSnapshot(e.ctx, uri, name)
+	}
+	return usageErrorf("unknown snapshot command %q", args[0])
+}
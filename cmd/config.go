@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configPath returns the config file read unless --config names another:
+// $XDG_CONFIG_HOME/vfs/config.yaml, by default ~/.config/vfs/config.yaml.
+func configPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "vfs", "config.yaml")
+}
+
+// loadConfig reads the config file at path: a YAML mapping from flag names
+// to their default values. A missing file is an empty config unless it was
+// asked for with --config.
+func loadConfig(path string, explicit bool) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg map[string]any
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// applyConfig sets the flags of set named in cfg that the command line
+// left unset, so flags override the config file. Settings for flags of
+// other commands are ignored. A list sets a repeatable flag once per item,
+// and a mapping sets it once per key=value pair.
+func applyConfig(set *flag.FlagSet, cfg map[string]any, path string) error {
+	given := make(map[string]bool)
+	set.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if set.Lookup(name) == nil {
+			if !isCommandFlag(name) {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			continue
+		}
+		if given[name] {
+			continue
+		}
+		var values []string
+		switch v := cfg[name].(type) {
+		case []any:
+			for _, item := range v {
+				values = append(values, fmt.Sprint(item))
+			}
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				values = append(values, fmt.Sprintf("%s=%v", k, v[k]))
+			}
+		case nil:
+		default:
+			values = []string{fmt.Sprint(v)}
+		}
+		for _, value := range values {
+			if err := set.Set(name, value); err != nil {
+				return fmt.Errorf("%s: invalid %s %q: %w", path, name, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// aliasesKey is the config setting that names remote roots, so that
+// "backup:db/" can stand for "s3://corp-bucket/backups/db/".
+const aliasesKey = "aliases"
+
+// takeAliases removes the aliases setting from cfg and returns it.
+func takeAliases(cfg map[string]any, path string) (map[string]string, error) {
+	raw, ok := cfg[aliasesKey]
+	if !ok {
+		return nil, nil
+	}
+	delete(cfg, aliasesKey)
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s must map names to URIs", path, aliasesKey)
+	}
+	aliases := make(map[string]string, len(m))
+	for name, uri := range m {
+		s, ok := uri.(string)
+		if !ok || !strings.Contains(s, "://") {
+			return nil, fmt.Errorf("%s: alias %q must be a URI like s3://bucket/prefix/", path, name)
+		}
+		aliases[name] = s
+	}
+	return aliases, nil
+}
+
+// expandAlias returns arg with a leading "name:" replaced by the URI the
+// alias name stands for. Other arguments, including URIs, are returned
+// unchanged.
+func expandAlias(arg string, aliases map[string]string) string {
+	name, rest, ok := strings.Cut(arg, ":")
+	if !ok || strings.HasPrefix(rest, "//") {
+		return arg
+	}
+	root, ok := aliases[name]
+	if !ok {
+		return arg
+	}
+	if rest == "" {
+		return root
+	}
+	return strings.TrimSuffix(root, "/") + "/" + strings.TrimPrefix(rest, "/")
+}
+
+// isCommandFlag reports whether some command has a flag called name.
+func isCommandFlag(name string) bool {
+	for _, c := range commands {
+		for _, f := range c.flags {
+			if f == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// dryRunPlan collects the writes that --dry-run skipped.
+type dryRunPlan struct {
+	Put      []string `json:"put"`    // URIs of objects that would be written
+	Delete   []string `json:"delete"` // URIs of objects that would be deleted
+	Bytes    int64    `json:"bytes"`  // body bytes that would be written
+	Requests int      `json:"requests"`
+
+	listed bool // print each write as it is planned
+}
+
+func (p *dryRunPlan) record(w vfs.PlannedWrite) {
+	p.Requests++
+	if w.Delete {
+		p.Delete = append(p.Delete, w.URIs...)
+	} else {
+		p.Put = append(p.Put, w.URIs...)
+		p.Bytes += w.Bytes
+	}
+	if !p.listed || output != nil {
+		return
+	}
+	for _, uri := range w.URIs {
+		if w.Delete {
+			fmt.Printf("Would delete: %s\n", uri)
+		} else {
+			fmt.Printf("Would put: %s (%d bytes)\n", uri, w.Bytes)
+		}
+	}
+}
+
+func printDryRun(p *dryRunPlan) {
+	fmt.Printf("Dry run: %d object(s) would be written (%d bytes) and %d deleted, in %d request(s).\n",
+		len(p.Put), p.Bytes, len(p.Delete), p.Requests)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// Exit codes, so scripts can tell failures apart without parsing messages.
+const (
+	exitOK        = 0
+	exitFailure   = 1 // any other failure
+	exitUsage     = 2 // invalid command, flags, arguments or config file
+	exitAuth      = 3 // missing or rejected credentials or encryption key
+	exitNotFound  = 4 // no archive, version, snapshot, bucket or object
+	exitIntegrity = 5 // damaged or incomplete data, or a failed verify or repair
+	exitPartial   = 6 // the command did only part of its work
+)
+
+// errPartial is the failure of a command that did part of its work.
+type errPartial struct{ error }
+
+func (e errPartial) Unwrap() error { return e.error }
+
+// exitCode returns the exit code for a command that failed with err.
+func exitCode(err error) int {
+	var usageErr errUsage
+	var decodeErr *vfs.DecodeError
+	var partialErr errPartial
+	switch {
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &decodeErr), errors.As(err, &partialErr):
+		return exitPartial
+	case isAuthError(err):
+		return exitAuth
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, vfs.ErrNoChunks),
+		errors.Is(err, vfs.ErrNoManifest), errors.Is(err, vfs.ErrNotInCatalog),
+		hasErrorCode(err, "NoSuchBucket", "NoSuchKey", "NotFoundException"):
+		return exitNotFound
+	case errors.Is(err, vfs.ErrChunkChecksum), errors.Is(err, vfs.ErrManifestMismatch),
+		errors.Is(err, vfs.ErrChunkGap), errors.Is(err, vfs.ErrIncompleteArchive):
+		return exitIntegrity
+	}
+	return exitFailure
+}
+
+// isAuthError reports whether err means the store or KMS refused the
+// credentials, there were none, or the archive's key is missing or wrong.
+func isAuthError(err error) bool {
+	if errors.Is(err, vfs.ErrEncrypted) || errors.Is(err, vfs.ErrDecrypt) {
+		return true
+	}
+	if hasErrorCode(err, "AccessDenied", "AccessDeniedException", "InvalidAccessKeyId",
+		"SignatureDoesNotMatch", "ExpiredToken", "ExpiredTokenException", "InvalidToken",
+		"UnrecognizedClientException", "AllAccessDisabled") {
+		return true
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return true
+		}
+	}
+	// The SDK reports credentials it cannot find with no error type of
+	// their own, only as signing or identity failures.
+	var signErr *v4.SigningError
+	return errors.As(err, &signErr) || strings.Contains(err.Error(), "get identity:")
+}
+
+func hasErrorCode(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && slices.Contains(codes, apiErr.ErrorCode())
+}
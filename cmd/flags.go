@@ -0,0 +1,395 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// flags holds the values of every flag. Global flags configure the VFS and
+// are accepted by every command; command flags only by the commands that
+// list them.
+type flags struct {
+	configFile     string
+	concurrency    int
+	profile        string
+	region         string
+	uaSuffix       string
+	threadsPerHost int
+	maxAttempts    int
+	rateLimit      float64
+	endpoint       string
+	pathStyle      bool
+	redisTTL       time.Duration
+	mirrors        []string
+	auditLog       string
+	readRepair     bool
+	encrypt        bool
+	keyFile        string
+	kmsKey         string
+	compress       string
+	obfuscate      bool
+	storageClass   string
+	tags           map[string]string
+	bodyThreshold  int64
+	autoMode       bool
+	chunkSize      int
+	versioning     bool
+	delta          bool
+	dedup          bool
+	parityData     int
+	parityShards   int
+	jsonOut        bool
+	quiet          bool
+	logLevel       slog.Level
+	logFormat      string
+
+	force         bool
+	splitLayout   bool
+	resume        bool
+	bestEffort    bool
+	allowGaps     bool
+	version       int
+	snapshot      string
+	rangeOff      int64
+	rangeLen      int64 // 0 without --range
+	asOf          time.Time
+	olderThan     time.Duration
+	keepVersions  int
+	expires       time.Duration
+	expired       bool
+	dryRun        bool
+	deleteRemoved bool
+	debounce      time.Duration
+	continuous    bool
+	interval      time.Duration
+	listen        string
+	grpcListen    string
+	cacheDir      string
+	allowOther    bool
+	noPreserve    bool
+	preserveOwner bool
+	lockMode      vfs.LockMode
+	retention     time.Duration
+	legalHold     bool
+	name          string
+	yes           bool
+	allowRoot     bool
+}
+
+// shortFlags maps the long names of flags to their one-letter forms.
+var shortFlags = map[string]string{
+	"force": "f",
+	"yes":   "y",
+	"quiet": "q",
+}
+
+// globalFlags lists the global flags in the order help shows them.
+var globalFlags = []string{
+	"config", "json", "quiet", "log-level", "log-format", "concurrency", "profile", "region", "endpoint-url", "user-agent-suffix", "threads-per-host", "max-attempts", "rate-limit",
+	"path-style", "redis-ttl", "mirror", "read-repair", "audit-log", "encrypt", "key-file",
+	"kms-key", "compress", "obfuscate", "storage-class", "tag", "body-threshold",
+	"auto-mode", "chunk-size", "versioning", "delta", "dedup", "parity", "dry-run",
+}
+
+// registerGlobal adds the flags of globalFlags to fs.
+func (f *flags) registerGlobal(fs *flag.FlagSet) {
+	fs.StringVar(&f.configFile, "config", "", "read defaults for flags from the file at `path` (default: ~/.config/vfs/config.yaml)")
+	fs.BoolVar(&f.jsonOut, "json", false, "print the result of the command as one JSON object")
+	fs.BoolVar(&f.quiet, "quiet", false, "print no progress, only warnings (on stderr)")
+	fs.TextVar(&f.logLevel, "log-level", slog.LevelInfo, "log messages of at least `level` (debug, info, warn or error) on stderr; debug logs every chunk and request (default: info)")
+	fs.Func("log-format", "log as `text|json` (default: text)", func(s string) error {
+		if s != "text" && s != "json" {
+			return errors.New("want text or json")
+		}
+		f.logFormat = s
+		return nil
+	})
+	fs.Func("concurrency", "transfer up to `n` chunks at a time (default: S3_CONCURRENCY or 8)", positiveInt(&f.concurrency))
+	fs.StringVar(&f.profile, "profile", "", "use the named `profile` from the shared AWS config files")
+	fs.StringVar(&f.region, "region", "", "send S3 requests to the AWS `region` (default: from the environment)")
+	fs.StringVar(&f.endpoint, "endpoint-url", "", "send S3 requests to the S3-compatible server at `url` (MinIO, Ceph RGW, Wasabi)")
+	fs.StringVar(&f.uaSuffix, "user-agent-suffix", "", "append `string` to the User-Agent of S3 requests")
+	fs.Func("threads-per-host", "cap HTTP connections per S3 host at `n` (default: unlimited)", positiveInt(&f.threadsPerHost))
+	fs.Func("max-attempts", "make up to `n` attempts per throttled upload or delete (default: 10)", positiveInt(&f.maxAttempts))
+	fs.Func("rate-limit", "cap uploads and deletes at `n` requests per second", positiveFloat(&f.rateLimit))
+	fs.StringVar(&f.endpoint, "endpoint", "", "same as --endpoint-url")
+	fs.BoolVar(&f.pathStyle, "path-style", false, "use path-style bucket addressing (or S3_FORCE_PATH_STYLE=true)")
+	fs.Func("redis-ttl", "expire keys written to redis:// after `duration`, e.g. 1h", func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err == nil && d <= 0 {
+			err = errors.New("must be positive")
+		}
+		f.redisTTL = d
+		return err
+	})
+	fs.Func("mirror", "also keep every archive under the root `uri` (repeatable)", func(s string) error {
+		f.mirrors = append(f.mirrors, s)
+		return nil
+	})
+	fs.BoolVar(&f.readRepair, "read-repair", false, "on restore, re-upload objects missing from a mirror copy")
+	fs.StringVar(&f.auditLog, "audit-log", "", "record every encode, append, restore and delete as an object under the root `uri`, or as a line of the local file at that path")
+	fs.BoolVar(&f.encrypt, "encrypt", false, "encrypt new archives and decrypt encrypted ones, with the passphrase in VFS_PASSPHRASE or the contents of --key-file")
+	fs.StringVar(&f.keyFile, "key-file", "", "read the encryption key from the file at `path`")
+	fs.StringVar(&f.kmsKey, "kms-key", "", "encrypt new archives, wrapping each file's key with the AWS KMS `key` (ID, ARN or alias)")
+	fs.StringVar(&f.compress, "compress", "", "compress new archives before chunking, with `gzip|zstd`")
+	fs.BoolVar(&f.obfuscate, "obfuscate", false, "scramble chunk data of new archives with a prefix-derived key")
+	fs.StringVar(&f.storageClass, "storage-class", "", "S3 storage `class` for new objects (e.g. STANDARD_IA, GLACIER_IR)")
+	fs.Func("tag", "tag every new S3 object with `key=value` (repeatable)", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return errors.New("want key=value")
+		}
+		if f.tags == nil {
+			f.tags = make(map[string]string)
+		}
+		f.tags[k] = v
+		return nil
+	})
+	fs.Func("body-threshold", "store files of at least `size` (e.g. 64MiB) in 8 MiB objects named <index>.<crc>.part instead of in keys", func(s string) error {
+		n, err := parseSize(s)
+		if err == nil && n <= 0 {
+			err = errors.New("must be positive")
+		}
+		f.bodyThreshold = n
+		return err
+	})
+	fs.BoolVar(&f.autoMode, "auto-mode", false, "store files in object bodies when the prefix leaves key-only chunks under 64 bytes")
+	fs.Func("chunk-size", "cap key-only chunks of new archives at `n` bytes (for shorter key limits)", positiveInt(&f.chunkSize))
+	fs.BoolVar(&f.versioning, "versioning", false, "keep the previous archive as a version when overwriting")
+	fs.BoolVar(&f.delta, "delta", false, "when overwriting, upload only the chunks that changed")
+	fs.BoolVar(&f.dedup, "dedup", false, "store chunks of new archives once per bucket, by content hash")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "list the objects the command would write and delete, and write nothing")
+	fs.Func("parity", "store parity chunks per stripe of data chunks, `data+parity` like 10+2, so restore can rebuild lost chunks", func(s string) error {
+		data, shards, ok := strings.Cut(s, "+")
+		d, err1 := strconv.Atoi(data)
+		p, err2 := strconv.Atoi(shards)
+		if !ok || err1 != nil || err2 != nil {
+			return errors.New("want data+parity like 10+2")
+		}
+		f.parityData, f.parityShards = d, p
+		return nil
+	})
+}
+
+// registerCommand adds the command flag called name to fs.
+func (f *flags) registerCommand(fs *flag.FlagSet, name string) {
+	switch name {
+	case "force":
+		fs.BoolVar(&f.force, name, false, "overwrite without asking")
+	case "split-layout":
+		fs.BoolVar(&f.splitLayout, name, false, "keep chunks under data/ and metadata under meta/")
+	case "resume":
+		fs.BoolVar(&f.resume, name, false, "continue an interrupted transfer")
+	case "best-effort":
+		fs.BoolVar(&f.bestEffort, name, false, "write what can be restored when chunks are damaged")
+	case "allow-gaps":
+		fs.BoolVar(&f.allowGaps, name, false, "restore even if chunk indices have gaps")
+	case "version":
+		fs.Func(name, "restore the previous version `N`", positiveInt(&f.version))
+	case "snapshot":
+		fs.StringVar(&f.snapshot, name, "", "restore the snapshot called `name`")
+	case "as-of":
+		fs.Func(name, "restore the file as it was at `time` (RFC 3339, or a date for its start in UTC), from the object versions of a versioned S3 bucket", func(s string) error {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				if t, err = time.Parse(time.DateOnly, s); err != nil {
+					return errors.New("want a time like 2026-10-01T12:00:00Z, or a date like 2026-10-01")
+				}
+			}
+			f.asOf = t
+			return nil
+		})
+	case "range":
+		fs.Func(name, "restore only bytes `first-last` of the file, counting from 0; without last, up to the end", func(s string) error {
+			first, last, ok := strings.Cut(s, "-")
+			off, err := strconv.ParseInt(first, 10, 64)
+			end := int64(math.MaxInt64)
+			if ok && last != "" && err == nil {
+				end, err = strconv.ParseInt(last, 10, 64)
+			}
+			if !ok || err != nil || off < 0 || end < off {
+				return errors.New("want first-last like 1048576-2097151, or first-")
+			}
+			f.rangeOff, f.rangeLen = off, end-off
+			if end < math.MaxInt64 {
+				f.rangeLen++
+			}
+			return nil
+		})
+	case "older-than":
+		fs.Func(name, "only touch uploads started more than `duration` ago (default: 24h)", func(s string) error {
+			d, err := time.ParseDuration(s)
+			if err == nil && d < 0 {
+				err = errors.New("must not be negative")
+			}
+			f.olderThan = d
+			return err
+		})
+	case "keep-versions":
+		fs.Func(name, "keep the newest `N` versions of each archive", positiveInt(&f.keepVersions))
+	case "expires":
+		fs.Func(name, "let gc --expired delete the archive `duration` after it is encoded, e.g. 168h or 7d", func(s string) error {
+			d, err := parseDays(s)
+			if err == nil && d <= 0 {
+				err = errors.New("must be positive")
+			}
+			f.expires = d
+			return err
+		})
+	case "expired":
+		fs.BoolVar(&f.expired, name, false, "also delete archives past their expiry")
+	case "delete":
+		fs.BoolVar(&f.deleteRemoved, name, false, "delete archives whose file, or source archive for replicate, is gone")
+	case "debounce":
+		fs.Func(name, "wait until the path has not changed for `duration` before storing it (default: 2s)", func(s string) error {
+			d, err := time.ParseDuration(s)
+			if err == nil && d <= 0 {
+				err = errors.New("must be positive")
+			}
+			f.debounce = d
+			return err
+		})
+	case "continuous":
+		fs.BoolVar(&f.continuous, name, false, "keep replicating until interrupted")
+	case "interval":
+		fs.Func(name, "with --continuous, wait `duration` between passes (default: 5m)", func(s string) error {
+			d, err := time.ParseDuration(s)
+			if err == nil && d <= 0 {
+				err = errors.New("must be positive")
+			}
+			f.interval = d
+			return err
+		})
+	case "listen":
+		fs.StringVar(&f.listen, name, ":8080", "accept HTTP connections on `address`, none if empty")
+	case "grpc-listen":
+		fs.StringVar(&f.grpcListen, name, "", "also accept gRPC connections on `address`")
+	case "cache-dir":
+		fs.StringVar(&f.cacheDir, name, "", "keep decoded files in `dir` (default: vfs/files in the user cache directory)")
+	case "no-preserve":
+		fs.BoolVar(&f.noPreserve, name, false, "neither record nor restore the mode and modification time of files")
+	case "preserve-owner":
+		fs.BoolVar(&f.preserveOwner, name, false, "record and restore the owner of files too (restoring it usually needs root)")
+	case "retention":
+		fs.Func(name, "lock new objects with S3 Object Lock in `mode:period`, like governance:90d or compliance:2160h", func(s string) error {
+			mode, period, ok := strings.Cut(s, ":")
+			m, err := vfs.ParseLockMode(mode)
+			if err != nil || !ok {
+				return errors.New("want governance or compliance, a colon and a period like 90d")
+			}
+			d, err := parseDays(period)
+			if err == nil && d <= 0 {
+				err = errors.New("period must be positive")
+			}
+			f.lockMode, f.retention = m, d
+			return err
+		})
+	case "legal-hold":
+		fs.BoolVar(&f.legalHold, name, false, "put new objects under an S3 Object Lock legal hold")
+	case "allow-other":
+		fs.BoolVar(&f.allowOther, name, false, "let other users read the mounted files")
+	case "yes":
+		fs.BoolVar(&f.yes, name, false, "delete without asking")
+	case "i-know-what-im-doing":
+		fs.BoolVar(&f.allowRoot, name, false, "allow deleting everything in a bucket")
+	case "name":
+		fs.StringVar(&f.name, name, "", "store the file as `name` (default: its base name)")
+	default:
+		panic("unknown command flag " + name)
+	}
+}
+
+// registerShort adds the one-letter forms of the flags in fs.
+func registerShort(fs *flag.FlagSet) {
+	fs.VisitAll(func(fl *flag.Flag) {
+		if short, ok := shortFlags[fl.Name]; ok {
+			fs.Var(fl.Value, short, fl.Usage)
+		}
+	})
+}
+
+// parseDays parses a duration like time.ParseDuration, also accepting a
+// whole number of days such as 90d.
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(s)
+}
+
+func positiveInt(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err == nil && n <= 0 {
+			err = errors.New("must be positive")
+		}
+		*p = n
+		return err
+	}
+}
+
+func positiveFloat(p *float64) func(string) error {
+	return func(s string) error {
+		n, err := strconv.ParseFloat(s, 64)
+		if err == nil && n <= 0 {
+			err = errors.New("must be positive")
+		}
+		*p = n
+		return err
+	}
+}
+
+// parseArgs parses args with fs, allowing flags before, between and after
+// the positional arguments, and returns the positional arguments. After
+// "--" everything is positional.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// printFlags lists the flags of fs called names, one per line with their
+// one-letter form and argument.
+func printFlags(w io.Writer, fs *flag.FlagSet, names []string) {
+	type line struct{ flag, usage string }
+	var lines []line
+	width := 0
+	for _, name := range names {
+		fl := fs.Lookup(name)
+		arg, usage := flag.UnquoteUsage(fl)
+		s := "    --" + name
+		if short, ok := shortFlags[name]; ok {
+			s = "-" + short + ", --" + name
+		}
+		if arg != "" {
+			s += " <" + arg + ">"
+		}
+		lines = append(lines, line{s, usage})
+		width = max(width, len(s))
+	}
+	for _, l := range lines {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, l.flag, l.usage)
+	}
+}
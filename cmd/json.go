@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// output is set by --json: commands then print nothing as they go, and
+// their result is written as one JSON object when they finish.
+var output *jsonOutput
+
+// jsonResult is what --json prints for a command.
+type jsonResult struct {
+	Command  string   `json:"command"`
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	Chunks   int      `json:"chunks"`   // chunks (or, for delete, objects) transferred
+	Bytes    int64    `json:"bytes"`    // decoded bytes transferred
+	Duration float64  `json:"duration"` // seconds
+	Warnings []string `json:"warnings,omitempty"`
+	Result   any      `json:"result,omitempty"`
+}
+
+type jsonOutput struct {
+	w       io.Writer
+	started time.Time
+	result  jsonResult
+}
+
+func newJSONOutput(command string, w io.Writer) *jsonOutput {
+	return &jsonOutput{w: w, started: time.Now(), result: jsonResult{Command: command}}
+}
+
+// progress totals the operations of the command and collects warnings.
+func (o *jsonOutput) progress(e vfs.Event) {
+	switch e.Kind {
+	case vfs.EventDone:
+		o.result.Chunks += e.Done
+		o.result.Bytes += e.BytesDone
+	case vfs.EventWarning:
+		o.result.Warnings = append(o.result.Warnings, strings.TrimSpace(strings.TrimPrefix(e.Message, "⚠️")))
+	}
+}
+
+// finish writes the result of a command that ended with err and the given
+// exit status, and exits if it failed (status is then nonzero).
+func (o *jsonOutput) finish(err error, status int) {
+	o.result.Duration = time.Since(o.started).Seconds()
+	o.result.OK = err == nil && status == 0
+	if err != nil {
+		o.result.Error = err.Error()
+	}
+	if encErr := json.NewEncoder(o.w).Encode(o.result); encErr != nil {
+		fmt.Fprintln(os.Stderr, encErr)
+	}
+	if !o.result.OK {
+		os.Exit(status)
+	}
+}
+
+// show prints v with print, or makes it the result with --json.
+func show[T any](v T, print func(T)) {
+	if output != nil {
+		output.result.Result = v
+		return
+	}
+	print(v)
+}
+
+// say prints a message for people, which --json leaves out.
+func say(format string, args ...any) {
+	if output == nil {
+		fmt.Printf(format, args...)
+	}
+}
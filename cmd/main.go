@@ -1,59 +1,699 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 
 	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/azure"
+	"github.com/vjeffz/vfs/vfs/dynamo"
+	"github.com/vjeffz/vfs/vfs/gcs"
+	"github.com/vjeffz/vfs/vfs/redisback"
+	"github.com/vjeffz/vfs/vfs/sftpback"
 )
 
-func usage() {
-	fmt.Println(`Usage:
-  vfs encode <inputfile> s3://bucket/prefix/ [--force]
-  vfs restore s3://bucket/prefix/ <outputfile>
-  vfs delete s3://bucket/prefix/`)
+const storageHelp = `Storage URIs:
+  s3://bucket/prefix/            Amazon S3
+  file:///path/prefix/           local directory (file://dir/ is relative)
+  gs://bucket/prefix/            Google Cloud Storage
+  az://container/prefix/         Azure Blob Storage (account from AZURE_STORAGE_ACCOUNT)
+  dynamodb://table/prefix/       DynamoDB items (partition key "pk", sort key "sk")
+  redis://host:port/prefix/      Redis keys (password from REDIS_PASSWORD)
+  sftp://[user@]host/path/       files on an SFTP server (SSH agent or ~/.ssh keys)
+  <alias>:path/                  path/ under a root named in the aliases of the config file`
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: vfs <command> [flags] <arguments>")
+	fmt.Fprintln(w, "\nCommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nRun 'vfs <command> --help' for the arguments and flags of a command.")
+	fmt.Fprintln(w, "\n"+storageHelp)
+	fmt.Fprintln(w, "\nGlobal flags:")
+	printFlags(w, newFlagSet(nil, &flags{}), globalFlags)
+}
+
+// commandHelp prints the arguments and flags of c.
+func commandHelp(w io.Writer, c *command) {
+	fmt.Fprintf(w, "Usage: vfs %s %s", c.name, c.args)
+	if len(c.flags) > 0 {
+		fmt.Fprint(w, " [flags]")
+	}
+	fmt.Fprintf(w, "\n\n%s.\n", strings.ToUpper(c.summary[:1])+c.summary[1:])
+	if len(c.aliases) > 0 {
+		fmt.Fprintf(w, "\nAliases: %s\n", strings.Join(c.aliases, ", "))
+	}
+	if len(c.flags) > 0 {
+		fmt.Fprintln(w, "\nFlags:")
+		printFlags(w, newFlagSet(c, &flags{}), c.flags)
+	}
+	fmt.Fprintln(w, "\nRun 'vfs --help' for the global flags.")
+}
+
+// newFlagSet returns the flags c accepts, set up to fill f. c may be nil
+// for the global flags only.
+func newFlagSet(c *command, f *flags) *flag.FlagSet {
+	fs := flag.NewFlagSet("vfs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if c != nil {
+		for _, name := range c.flags {
+			f.registerCommand(fs, name)
+		}
+	}
+	f.registerGlobal(fs)
+	registerShort(fs)
+	return fs
+}
+
+// usageFail reports that cmd was called wrong, and exits.
+func usageFail(cmd string, err error) {
+	fmt.Fprintf(os.Stderr, "vfs %s: %v\n", cmd, err)
+	fmt.Fprintf(os.Stderr, "Run 'vfs %s --help' for usage.\n", cmd)
+	os.Exit(exitUsage)
 }
 
 func main() {
-	if len(os.Args) < 3 {
-		usage()
-		os.Exit(1)
+	// Global flags may come before the command too.
+	pre := newFlagSet(nil, &flags{})
+	err := pre.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		usage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vfs: %v\nRun 'vfs --help' for usage.\n", err)
+		os.Exit(exitUsage)
+	}
+	rest := pre.Args()
+	if len(rest) == 0 {
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	if rest[0] == "help" {
+		if len(rest) > 1 && lookup(rest[1]) != nil {
+			commandHelp(os.Stdout, lookup(rest[1]))
+			return
+		}
+		usage(os.Stdout)
+		return
+	}
+	c := lookup(rest[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "vfs: unknown command %q\nRun 'vfs --help' for usage.\n", rest[0])
+		os.Exit(exitUsage)
+	}
+	f := &flags{olderThan: defaultOlderThan}
+	leading := os.Args[1 : len(os.Args)-len(rest)]
+	set := newFlagSet(c, f)
+	var aliases map[string]string
+	args, err := parseArgs(set, append(leading[:len(leading):len(leading)], rest[1:]...))
+	if errors.Is(err, flag.ErrHelp) {
+		commandHelp(os.Stdout, c)
+		return
+	}
+	if err == nil {
+		path := f.configFile
+		if path == "" {
+			path = configPath()
+		}
+		var cfg map[string]any
+		if cfg, err = loadConfig(path, f.configFile != ""); err == nil {
+			if aliases, err = takeAliases(cfg, path); err == nil {
+				err = applyConfig(set, cfg, path)
+			}
+		}
+	}
+	if err != nil {
+		usageFail(c.name, err)
+	}
+	slog.SetDefault(newLogger(os.Stderr, f))
+	for i, arg := range args {
+		args[i] = expandAlias(arg, aliases)
+	}
+	for i, mirror := range f.mirrors {
+		f.mirrors[i] = expandAlias(mirror, aliases)
+	}
+	f.auditLog = expandAlias(f.auditLog, aliases)
+	if c.nargs >= 0 && len(args) != c.nargs {
+		usageFail(c.name, fmt.Errorf("expected %d argument(s), got %d", c.nargs, len(args)))
+	}
+
+	// When the file goes to stdout, or with --quiet, only warnings are
+	// printed, on stderr. Without a terminal, a redrawn bar would fill logs
+	// with one long line, so progress is printed now and then instead.
+	toStdout := c.name == "cat" || c.name == "restore" && len(args) == 2 && args[1] == "-" ||
+		c.name == "manifest" && len(args) >= 2 && args[0] == "export" && (len(args) == 2 || args[2] == "-")
+	progress := newProgressBar()
+	switch {
+	case toStdout || f.quiet || c.server:
+		progress = printWarnings
+	case !isTerminal(os.Stdout):
+		progress = logProgress()
+	}
+	var plan *dryRunPlan
+	if f.dryRun {
+		// gc lists what it would delete itself.
+		plan = &dryRunPlan{listed: c.name != "gc"}
+		progress = printWarnings
+	}
+	if f.jsonOut {
+		// The result goes to stderr if stdout carries the file.
+		w := os.Stdout
+		if toStdout {
+			w = os.Stderr
+		}
+		output = newJSONOutput(c.name, w)
+		progress = output.progress
 	}
 
-	v, err := vfs.New()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	e := &env{ctx: ctx, f: f, toStdout: toStdout}
+	if !c.local {
+		opts, err := vfsOptions(f, progress)
+		if err != nil {
+			usageFail(c.name, err)
+		}
+		opts = append(opts, vfs.WithLogger(slog.Default()))
+		if plan != nil {
+			opts = append(opts, vfs.WithDryRun(plan.record))
+		}
+		if c.metrics {
+			e.metrics = prometheus.NewRegistry()
+			e.metrics.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+			opts = append(opts, vfs.WithMetrics(e.metrics))
+		}
+		if e.v, err = vfs.New(opts...); err != nil {
+			slog.Error("cannot initialize VFS", "error", err)
+			os.Exit(exitCode(err))
+		}
+	}
+	err = c.run(e, args)
+	var usageErr errUsage
+	if errors.As(err, &usageErr) {
+		usageFail(c.name, err)
+	}
+	if plan != nil && err == nil {
+		if output == nil {
+			printDryRun(plan)
+		} else if output.result.Result == nil {
+			output.result.Result = plan
+		}
+	}
+	status := e.status
 	if err != nil {
-		log.Fatalf("Failed to initialize VFS: %v", err)
+		status = exitCode(err)
 	}
+	if output != nil {
+		output.finish(err, status)
+	}
+	if err != nil {
+		slog.Error("command failed", "command", c.name, "error", err)
+	}
+	os.Exit(status)
+}
 
-	switch os.Args[1] {
-	case "encode":
-		force := len(os.Args) == 5 && os.Args[4] == "--force"
-		if len(os.Args) != 4 && !force {
-			usage()
-			os.Exit(1)
+// vfsOptions returns the options the flags in f ask for.
+func vfsOptions(f *flags, progress vfs.ProgressFunc) ([]vfs.Option, error) {
+	opts := []vfs.Option{
+		vfs.WithProgress(progress),
+		vfs.WithBackend("gs", gcs.Open()),
+		vfs.WithBackend("az", azure.Open("")),
+		vfs.WithBackend("dynamodb", dynamo.Open()),
+		vfs.WithBackend("redis", redisback.Open(redisback.Options{TTL: f.redisTTL})),
+		vfs.WithBackend("sftp", sftpback.Open(sftpback.Options{})),
+	}
+	if f.concurrency > 0 {
+		opts = append(opts, vfs.WithConcurrency(f.concurrency))
+	}
+	if f.profile != "" {
+		opts = append(opts, vfs.WithProfile(f.profile))
+	}
+	if f.region != "" {
+		opts = append(opts, vfs.WithRegion(f.region))
+	}
+	if f.uaSuffix != "" {
+		opts = append(opts, vfs.WithUserAgentSuffix(f.uaSuffix))
+	}
+	if f.endpoint != "" {
+		opts = append(opts, vfs.WithEndpoint(f.endpoint))
+	}
+	if f.pathStyle {
+		opts = append(opts, vfs.WithPathStyle())
+	}
+	if len(f.mirrors) > 0 {
+		opts = append(opts, vfs.WithMirrors(f.mirrors...))
+	}
+	if f.auditLog != "" {
+		opts = append(opts, vfs.WithAuditLog(f.auditLog))
+	}
+	if f.readRepair {
+		opts = append(opts, vfs.WithReadRepair())
+	}
+	if f.splitLayout {
+		opts = append(opts, vfs.WithSplitLayout())
+	}
+	if f.resume {
+		opts = append(opts, vfs.WithResume())
+	}
+	if f.bestEffort {
+		opts = append(opts, vfs.WithBestEffort())
+	}
+	if f.allowGaps {
+		opts = append(opts, vfs.WithAllowGaps())
+	}
+	if f.noPreserve {
+		opts = append(opts, vfs.WithNoPreserve())
+	}
+	if f.expires > 0 {
+		opts = append(opts, vfs.WithExpiry(f.expires))
+	}
+	if f.lockMode != "" {
+		opts = append(opts, vfs.WithRetention(f.lockMode, f.retention))
+	}
+	if f.legalHold {
+		opts = append(opts, vfs.WithLegalHold())
+	}
+	if !f.asOf.IsZero() {
+		opts = append(opts, vfs.WithAsOf(f.asOf))
+	}
+	if f.preserveOwner {
+		opts = append(opts, vfs.WithPreserveOwner())
+	}
+	if f.encrypt {
+		passphrase, err := readPassphrase(f.keyFile)
+		if err != nil {
+			return nil, err
 		}
-		err = v.Encode(os.Args[2], os.Args[3], force)
-	case "restore":
-		if len(os.Args) != 4 {
-			usage()
-			os.Exit(1)
+		opts = append(opts, vfs.WithPassphrase(passphrase))
+	}
+	if f.kmsKey != "" {
+		opts = append(opts, vfs.WithKMSKey(f.kmsKey))
+	}
+	if f.compress != "" {
+		c, err := vfs.ParseCompression(f.compress)
+		if err != nil {
+			return nil, err
 		}
-		err = v.Restore(os.Args[2], os.Args[3])
-	case "delete":
-		if len(os.Args) != 3 {
-			usage()
-			os.Exit(1)
+		opts = append(opts, vfs.WithCompression(c))
+	}
+	if f.obfuscate {
+		opts = append(opts, vfs.WithObfuscation())
+	}
+	if f.storageClass != "" {
+		opts = append(opts, vfs.WithStorageClass(f.storageClass))
+	}
+	if len(f.tags) > 0 {
+		opts = append(opts, vfs.WithObjectTags(f.tags))
+	}
+	if f.bodyThreshold > 0 {
+		opts = append(opts, vfs.WithBodyThreshold(f.bodyThreshold))
+	}
+	if f.autoMode {
+		opts = append(opts, vfs.WithAutoMode())
+	}
+	if f.chunkSize > 0 {
+		opts = append(opts, vfs.WithChunkSize(f.chunkSize))
+	}
+	if f.versioning {
+		opts = append(opts, vfs.WithVersioning())
+	}
+	if f.delta {
+		opts = append(opts, vfs.WithDelta())
+	}
+	if f.dedup {
+		opts = append(opts, vfs.WithDedup())
+	}
+	if f.parityData != 0 || f.parityShards != 0 {
+		opts = append(opts, vfs.WithParity(f.parityData, f.parityShards))
+	}
+	if f.threadsPerHost > 0 {
+		opts = append(opts, vfs.WithConnPool(vfs.ConnPool{MaxIdleConnsPerHost: f.threadsPerHost, MaxConnsPerHost: f.threadsPerHost}))
+	}
+	if f.maxAttempts > 0 {
+		opts = append(opts, vfs.WithRetryPolicy(vfs.RetryPolicy{MaxAttempts: f.maxAttempts}))
+	}
+	if f.rateLimit > 0 {
+		opts = append(opts, vfs.WithRateLimit(f.rateLimit))
+	}
+	return opts, nil
+}
+
+// readPassphrase returns the contents of keyFile, or VFS_PASSPHRASE if no
+// key file is given.
+func readPassphrase(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --key-file: %w", err)
 		}
-		err = v.Delete(os.Args[2])
-	default:
-		fmt.Printf("Unknown command: %s\n", os.Args[1])
-		usage()
-		os.Exit(1)
+		return key, nil
+	}
+	if p := os.Getenv("VFS_PASSPHRASE"); p != "" {
+		return []byte(p), nil
+	}
+	return nil, errors.New("--encrypt needs VFS_PASSPHRASE or --key-file")
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	resp, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(resp)) == "y"
+}
+
+func printReport(r *vfs.VerifyReport) {
+	fmt.Printf("Chunks:        %d (%d with checksum)\n", r.Chunks, r.Checksummed)
+	fmt.Printf("Size:          %d bytes\n", r.Size)
+	fmt.Printf("SHA-256:       %s\n", r.SHA256)
+	if r.Manifest == nil {
+		fmt.Println("Manifest:      none (run migrate to add one)")
+	} else {
+		fmt.Printf("Manifest:      %d chunks, %d bytes, %s\n", r.Manifest.Chunks, r.Manifest.Size, r.Manifest.SHA256)
+	}
+	for _, p := range r.Problems {
+		fmt.Printf("⚠️  %s\n", p)
+	}
+	if r.OK() {
+		fmt.Println("✅ Archive verified.")
 	}
+}
+
+func printRepair(r *vfs.RepairReport) {
+	if r.Copied > 0 {
+		fmt.Printf("Copied %d object(s) to mirror copies that lacked them.\n", r.Copied)
+	}
+	if len(r.Rebuilt) > 0 {
+		fmt.Printf("Rebuilt chunks %v from parity.\n", r.Rebuilt)
+	}
+	if r.Parity > 0 {
+		fmt.Printf("Stored %d parity shard(s) again.\n", r.Parity)
+	}
+	if !r.OK() {
+		fmt.Printf("⚠️  chunks %v are damaged and cannot be rebuilt\n", r.Lost)
+		return
+	}
+	fmt.Println("✅ Archive repaired.")
+}
+
+func printGC(r *vfs.GCReport, dryRun bool) {
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, uri := range r.Abandoned {
+		fmt.Printf("%s abandoned upload: %s\n", verb, uri)
+	}
+	for _, uri := range r.Expired {
+		fmt.Printf("%s expired archive: %s\n", verb, uri)
+	}
+	for _, uri := range r.Versions {
+		fmt.Printf("%s version: %s\n", verb, uri)
+	}
+	for _, key := range r.Orphans {
+		fmt.Printf("%s orphaned chunk: %s\n", verb, key)
+	}
+	for _, key := range r.Pool {
+		fmt.Printf("%s unused pool object: %s\n", verb, key)
+	}
+	if dryRun {
+		return // printDryRun sums it up
+	}
+	n := len(r.Abandoned) + len(r.Expired) + len(r.Versions) + len(r.Orphans) + len(r.Pool)
+	fmt.Printf("✅ GC complete, %d item(s) deleted.\n", n)
+}
+
+func printSync(r *vfs.SyncReport) {
+	for _, p := range r.Deleted {
+		fmt.Printf("Deleted: %s\n", p)
+	}
+	fmt.Printf("✅ Sync: %d uploaded, %d unchanged, %d deleted.\n", len(r.Uploaded), len(r.Unchanged), len(r.Deleted))
+}
 
+// printWatchRound prints what a round of watch stored, if anything.
+func printWatchRound(r *vfs.SyncReport, err error) {
+	now := time.Now().Format(time.TimeOnly)
 	if err != nil {
-		log.Fatalf("%s failed: %v", os.Args[1], err)
+		fmt.Fprintf(os.Stderr, "%s ⚠️  %v\n", now, err)
+		return
+	}
+	for _, p := range r.Uploaded {
+		fmt.Printf("%s Stored: %s\n", now, p)
+	}
+	for _, p := range r.Deleted {
+		fmt.Printf("%s Deleted: %s\n", now, p)
+	}
+}
+
+func printTransfer(r *vfs.TransferReport) {
+	for _, uri := range r.Copied {
+		fmt.Printf("Copied: %s\n", uri)
+	}
+	for _, uri := range r.Skipped {
+		fmt.Printf("Already there: %s\n", uri)
+	}
+	fmt.Printf("✅ Migration complete: %d copied, %d already there.\n", len(r.Copied), len(r.Skipped))
+}
+
+func printReplicate(r *vfs.ReplicateReport) {
+	for _, uri := range r.Copied {
+		fmt.Printf("Copied: %s\n", uri)
+	}
+	for _, uri := range r.Deleted {
+		fmt.Printf("Deleted: %s\n", uri)
+	}
+	fmt.Printf("✅ Replicate: %d copied, %d unchanged, %d deleted.\n", len(r.Copied), len(r.Unchanged), len(r.Deleted))
+}
+
+// printReplicatePass prints what a pass of replicate --continuous copied
+// or deleted, if anything.
+func printReplicatePass(r *vfs.ReplicateReport, err error) {
+	now := time.Now().Format(time.TimeOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s ⚠️  %v\n", now, err)
+		return
+	}
+	for _, uri := range r.Copied {
+		fmt.Printf("%s Copied: %s\n", now, uri)
+	}
+	for _, uri := range r.Deleted {
+		fmt.Printf("%s Deleted: %s\n", now, uri)
+	}
+}
+
+func printRemoved(removed []string) {
+	for _, p := range removed {
+		fmt.Printf("Removed abandoned upload: %s\n", p)
+	}
+	fmt.Printf("✅ Cleanup complete, %d removed.\n", len(removed))
+}
+
+func printEntries(entries []vfs.Entry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "URI\tSIZE\tCHUNKS\tMODIFIED\tFILENAME")
+	for _, e := range entries {
+		modified := e.Modified.Local().Format(time.DateTime)
+		if e.Err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t%s\t(%v)\n", e.URI, modified, e.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", e.URI, e.Manifest.Size, e.Manifest.Chunks, modified, e.Manifest.Filename)
+	}
+	w.Flush()
+}
+
+func printVersions(versions []vfs.ArchiveVersion) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSIZE\tENCODED\tREPLACED")
+	for _, ver := range versions {
+		replaced := ver.Modified.Local().Format(time.DateTime)
+		if ver.Err != nil {
+			fmt.Fprintf(w, "%d\t-\t-\t%s\t(%v)\n", ver.Number, replaced, ver.Err)
+			continue
+		}
+		encoded := "-"
+		if ver.Manifest.Created != nil {
+			encoded = ver.Manifest.Created.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", ver.Number, ver.Manifest.Size, encoded, replaced)
 	}
+	w.Flush()
 }
 
+func printSnapshots(snapshots []vfs.Snapshot) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tCREATED")
+	for _, s := range snapshots {
+		created := s.Modified.Local().Format(time.DateTime)
+		if s.Err != nil {
+			fmt.Fprintf(w, "%s\t-\t%s\t(%v)\n", s.Name, created, s.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s.Name, s.Manifest.Size, created)
+	}
+	w.Flush()
+}
+
+func printVersion(info vfs.BuildInfo) {
+	fmt.Printf("vfs %s\n", info.Version)
+	if info.Commit != "" {
+		modified := ""
+		if info.Modified {
+			modified = " (modified)"
+		}
+		fmt.Printf("commit:   %s%s\n", info.Commit, modified)
+	}
+	if info.Date != "" {
+		fmt.Printf("built:    %s\n", info.Date)
+	}
+	fmt.Printf("go:       %s %s\n", info.GoVersion, info.Platform)
+}
+
+func printCatalog(files []vfs.CatalogEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tADDED")
+	for _, e := range files {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", e.Name, e.Size, e.Added.Local().Format(time.DateTime))
+	}
+	w.Flush()
+}
+
+func printManifest(m *vfs.Manifest) {
+	if m.Filename != "" {
+		fmt.Printf("Filename:      %s\n", m.Filename)
+	}
+	fmt.Printf("Size:          %d bytes\n", m.Size)
+	fmt.Printf("Chunks:        %d of up to %d bytes\n", m.Chunks, m.ChunkSize)
+	fmt.Printf("SHA-256:       %s\n", m.SHA256)
+	if m.Created != nil {
+		fmt.Printf("Encoded:       %s\n", m.Created.Local().Format(time.RFC3339))
+	}
+	if m.Expires != nil {
+		fmt.Printf("Expires:       %s\n", m.Expires.Local().Format(time.RFC3339))
+	}
+	storage := "keys"
+	if m.Storage != "" {
+		storage = m.Storage
+	}
+	fmt.Printf("Storage:       %s\n", storage)
+	if m.Compression != vfs.CompressionNone {
+		fmt.Printf("Compression:   %s (%d bytes stored)\n", m.Compression, m.StoredSize)
+	} else {
+		fmt.Println("Compression:   none")
+	}
+	switch e := m.Encryption; {
+	case e == nil:
+		fmt.Println("Encryption:    none")
+	case e.KMSKeyID != "":
+		fmt.Printf("Encryption:    %s, key wrapped by KMS key %s\n", e.Algorithm, e.KMSKeyID)
+	default:
+		fmt.Printf("Encryption:    %s, key derived with %s\n", e.Algorithm, e.KDF)
+	}
+	if m.Obfuscated {
+		fmt.Println("Obfuscated:    yes")
+	}
+	if a := m.Attrs; a != nil {
+		fmt.Printf("Mode:          %v\n", a.Mode)
+		fmt.Printf("Modified:      %s\n", a.Modified.Local().Format(time.RFC3339))
+		if a.UID != nil && a.GID != nil {
+			fmt.Printf("Owner:         %d:%d\n", *a.UID, *a.GID)
+		}
+	}
+	if l := m.Lock; l != nil {
+		if l.RetainUntil != nil {
+			fmt.Printf("Object Lock:   %s until %s\n", l.Mode, l.RetainUntil.Local().Format(time.RFC3339))
+		}
+		if l.LegalHold {
+			fmt.Println("Legal hold:    yes")
+		}
+	}
+}
+
+func printUsage(usage []vfs.Usage) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BYTES\tARCHIVES\tCHUNKS\tOBJECTS\t\tURI")
+	for _, u := range usage {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t\t%s\n", u.Bytes, u.Archives, u.Chunks, u.Objects, u.URI)
+	}
+	w.Flush()
+}
+
+func printPlan(p *vfs.EncodePlan) {
+	fmt.Printf("Size:          %d bytes\n", p.Size)
+	fmt.Printf("Chunk size:    %d bytes\n", p.ChunkSize)
+	fmt.Printf("Chunks:        %d PutObject requests\n", p.Chunks)
+	fmt.Printf("Concurrency:   %d\n", p.Concurrency)
+	fmt.Printf("Request rate:  ~%.0f PUT/s\n", p.PutRate)
+	fmt.Printf("Duration:      ~%s\n", p.Duration.Round(time.Second))
+	for _, a := range p.Advice {
+		fmt.Println(a)
+	}
+}
+
+func printEstimate(est *vfs.RequestEstimate) {
+	fmt.Printf("PUT requests:     %d\n", est.Puts)
+	fmt.Printf("GET requests:     %d\n", est.Gets)
+	fmt.Printf("LIST requests:    %d\n", est.Lists)
+	fmt.Printf("DELETE requests:  %d\n", est.Deletes)
+	fmt.Printf("Request cost:     %s at S3 us-east-1 prices\n", formatCost(est.Cost))
+}
+
+// requestSummary describes est in a line, like "170 PUT, 1 LIST and 1
+// DELETE request(s), under $0.01".
+func requestSummary(est *vfs.RequestEstimate) string {
+	var counts []string
+	for _, c := range []struct {
+		n    int64
+		kind string
+	}{{est.Puts, "PUT"}, {est.Gets, "GET"}, {est.Lists, "LIST"}, {est.Deletes, "DELETE"}} {
+		if c.n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", c.n, c.kind))
+		}
+	}
+	s := counts[len(counts)-1]
+	if len(counts) > 1 {
+		s = strings.Join(counts[:len(counts)-1], ", ") + " and " + s
+	}
+	return s + " request(s), " + formatCost(est.Cost)
+}
+
+// formatCost formats dollars to the cent.
+func formatCost(dollars float64) string {
+	if dollars < 0.01 {
+		return "under $0.01"
+	}
+	return fmt.Sprintf("~$%.2f", dollars)
+}
+
+// parseSize parses a byte count such as "4096", "64MiB", or "1G". Units
+// are powers of 1024.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		shift  uint
+	}{{"KiB", 10}, {"MiB", 20}, {"GiB", 30}, {"TiB", 40}, {"K", 10}, {"M", 20}, {"G", 30}, {"T", 40}, {"B", 0}}
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			n, err := strconv.ParseInt(num, 10, 64)
+			return n << u.shift, err
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
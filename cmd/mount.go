@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/vjeffz/vfs/vfs/fusefs"
+)
+
+// runMount mounts the archives under args[0] at args[1] until interrupted
+// or unmounted from outside (umount, fusermount -u).
+func runMount(e *env, args []string) error {
+	srv, err := fusefs.Mount(e.v, args[0], args[1], fusefs.Options{
+		CacheDir:   e.f.cacheDir,
+		AllowOther: e.f.allowOther,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("mounted, press Ctrl-C to unmount", "uri", args[0], "dir", args[1])
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-e.ctx.Done():
+			if err := srv.Unmount(); err != nil {
+				slog.Warn("cannot unmount", "dir", args[1], "error", err)
+			}
+		case <-done:
+		}
+	}()
+	srv.Wait()
+	close(done)
+	return nil
+}
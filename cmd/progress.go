@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// printEvent prints the start of transfers and warnings, which every kind
+// of progress output shows the same way.
+func printEvent(e vfs.Event) {
+	switch e.Kind {
+	case vfs.EventStart:
+		switch e.Op {
+		case vfs.OpEncode, vfs.OpAppend:
+			if e.Total == 0 {
+				fmt.Println("Uploading chunks...") // streaming input of unknown size
+				break
+			}
+			fmt.Printf("Uploading %d chunks...\n", e.Total)
+		case vfs.OpRestore:
+			fmt.Printf("Downloading %d chunks...\n", e.Total)
+		}
+	case vfs.EventWarning:
+		fmt.Println(e.Message)
+	}
+}
+
+// chunkProgress describes how far the operation of e has come, or returns
+// "" for operations without a progress line.
+func chunkProgress(e vfs.Event) string {
+	switch e.Op {
+	case vfs.OpEncode, vfs.OpAppend:
+		if e.Total == 0 {
+			return fmt.Sprintf("Uploaded: %d", e.Done)
+		}
+		return fmt.Sprintf("Uploaded: %d/%d", e.Done, e.Total)
+	case vfs.OpRestore:
+		return fmt.Sprintf("Downloaded: %d/%d", e.Done, e.Total)
+	case vfs.OpDelete:
+		return fmt.Sprintf("Deleted: %d", e.Done)
+	case vfs.OpCopy:
+		return fmt.Sprintf("Copied: %d/%d", e.Done, e.Total)
+	}
+	return ""
+}
+
+func doneMessage(op vfs.Op) string {
+	switch op {
+	case vfs.OpEncode:
+		return "✅ Upload complete."
+	case vfs.OpAppend:
+		return "✅ Append complete."
+	case vfs.OpRestore:
+		return "✅ Download complete."
+	case vfs.OpDelete:
+		return "✅ Delete complete."
+	case vfs.OpCopy:
+		return "✅ Copy complete."
+	}
+	return ""
+}
+
+const (
+	barWidth  = 30
+	barRedraw = 100 * time.Millisecond // at most ten frames a second
+	clearLine = "\r\033[K"
+)
+
+// progressBar draws a bar with the percentage done, the throughput and
+// the time left on a terminal, redrawing it in place as chunks complete.
+type progressBar struct {
+	started time.Time
+	drawn   time.Time
+	line    string // what is on screen, to put back after a warning
+}
+
+// newProgressBar returns progress output for a terminal.
+func newProgressBar() vfs.ProgressFunc {
+	return (&progressBar{}).event
+}
+
+func (b *progressBar) event(e vfs.Event) {
+	switch e.Kind {
+	case vfs.EventStart:
+		b.started, b.drawn, b.line = time.Now(), time.Time{}, ""
+		printEvent(e)
+	case vfs.EventChunk:
+		if chunkProgress(e) == "" || time.Since(b.drawn) < barRedraw {
+			return
+		}
+		b.draw(e)
+	case vfs.EventDone:
+		if msg := doneMessage(e.Op); msg != "" {
+			b.draw(e)
+			fmt.Println("\n" + msg)
+			b.line = ""
+		}
+	case vfs.EventWarning:
+		fmt.Print(clearLine)
+		printEvent(e)
+		fmt.Print(b.line)
+	}
+}
+
+func (b *progressBar) draw(e vfs.Event) {
+	b.drawn = time.Now()
+	b.line = b.render(e, b.drawn.Sub(b.started))
+	fmt.Print(clearLine + b.line)
+}
+
+// render formats e as a bar line, elapsed after the transfer started.
+// Streaming uploads of unknown size get no bar, percentage or time left.
+func (b *progressBar) render(e vfs.Event, elapsed time.Duration) string {
+	fraction := -1.0 // unknown
+	switch {
+	case e.BytesTotal > 0:
+		fraction = float64(e.BytesDone) / float64(e.BytesTotal)
+	case e.Total > 0:
+		fraction = float64(e.Done) / float64(e.Total)
+	}
+	var parts []string
+	if fraction >= 0 {
+		fraction = min(fraction, 1)
+		filled := int(fraction * barWidth)
+		bar := strings.Repeat("=", filled)
+		if filled < barWidth {
+			bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+		}
+		parts = append(parts, fmt.Sprintf("[%s] %3.0f%%", bar, fraction*100))
+	}
+	parts = append(parts, chunkProgress(e))
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		if e.BytesDone > 0 {
+			parts = append(parts, formatBytes(int64(float64(e.BytesDone)/seconds))+"/s")
+		} else {
+			parts = append(parts, fmt.Sprintf("%.0f chunks/s", float64(e.Done)/seconds))
+		}
+	}
+	if fraction > 0 && fraction < 1 {
+		left := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		parts = append(parts, "ETA "+left.Round(time.Second).String())
+	}
+	return strings.Join(parts, "  ")
+}
+
+// formatBytes formats n with a binary unit, such as "12.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// logProgressInterval is how often progress is printed when stdout is not
+// a terminal.
+const logProgressInterval = 10 * time.Second
+
+// logProgress prints progress for log files and CI output, where \r does
+// not rewrite the line: one line per logProgressInterval at most, and one
+// when the operation ends.
+func logProgress() vfs.ProgressFunc {
+	var last time.Time
+	return func(e vfs.Event) {
+		switch e.Kind {
+		case vfs.EventChunk:
+			if line := chunkProgress(e); line != "" && time.Since(last) >= logProgressInterval {
+				last = time.Now()
+				fmt.Println(line)
+			}
+		case vfs.EventDone:
+			last = time.Time{}
+			if msg := doneMessage(e.Op); msg != "" {
+				fmt.Println(chunkProgress(e))
+				fmt.Println(msg)
+			}
+		default:
+			printEvent(e)
+		}
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// printWarnings reports only warnings, on stderr, for commands whose
+// stdout carries the file.
+func printWarnings(e vfs.Event) {
+	if e.Kind == vfs.EventWarning {
+		fmt.Fprintln(os.Stderr, e.Message)
+	}
+}
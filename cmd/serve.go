@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+
+	"github.com/vjeffz/vfs/vfs/davfs"
+	"github.com/vjeffz/vfs/vfs/grpcapi"
+	"github.com/vjeffz/vfs/vfs/grpcapi/vfspb"
+	"github.com/vjeffz/vfs/vfs/httpapi"
+)
+
+// shutdownTimeout is how long requests in flight may take to finish once
+// serve is interrupted.
+const shutdownTimeout = 30 * time.Second
+
+// runServe serves the archives under args[0] over HTTP, and over gRPC with
+// --grpc-listen, until interrupted or a listener fails.
+func runServe(e *env, args []string) error {
+	if e.f.listen == "" && e.f.grpcListen == "" {
+		return usageErrorf("nothing to serve with --listen and --grpc-listen both empty")
+	}
+	token := os.Getenv("VFS_API_TOKEN")
+	var stops []func()
+	defer func() {
+		var wg sync.WaitGroup
+		for _, stop := range stops {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stop()
+			}()
+		}
+		wg.Wait()
+	}()
+	errs := make(chan error, 2)
+
+	if e.f.listen != "" {
+		var opts []httpapi.Option
+		if token != "" {
+			opts = append(opts, httpapi.WithToken(token))
+		}
+		handler, err := httpapi.New(e.v, args[0], opts...)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		lis, err := net.Listen("tcp", e.f.listen)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(e.metrics, promhttp.HandlerOpts{}))
+		mux.Handle("/", instrumentHTTP(e.metrics, handler))
+		srv := &http.Server{Handler: mux}
+		go func() { errs <- srv.Serve(lis) }()
+		stops = append(stops, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			srv.Shutdown(ctx)
+		})
+		slog.Info("serving over HTTP", "uri", args[0], "address", lis.Addr().String())
+	}
+	if e.f.grpcListen != "" {
+		api, err := grpcapi.New(e.v, args[0])
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		var opts []grpc.ServerOption
+		if token != "" {
+			opts = grpcapi.WithToken(token)
+		}
+		lis, err := net.Listen("tcp", e.f.grpcListen)
+		if err != nil {
+			return err
+		}
+		srv := grpc.NewServer(opts...)
+		vfspb.RegisterVFSServer(srv, api)
+		go func() { errs <- srv.Serve(lis) }()
+		stops = append(stops, func() {
+			t := time.AfterFunc(shutdownTimeout, srv.Stop)
+			defer t.Stop()
+			srv.GracefulStop()
+		})
+		slog.Info("serving over gRPC", "uri", args[0], "address", lis.Addr().String())
+	}
+
+	select {
+	case <-e.ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+// instrumentHTTP times the requests h serves in a histogram registered
+// with reg.
+func instrumentHTTP(reg prometheus.Registerer, h http.Handler) http.Handler {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vfs",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP API's requests.",
+	}, []string{"method", "code"})
+	reg.MustRegister(duration)
+	return promhttp.InstrumentHandlerDuration(duration, h)
+}
+
+// runServeWebDAV shares the catalog under args[0] over WebDAV until
+// interrupted or the listener fails.
+func runServeWebDAV(e *env, args []string) error {
+	if e.f.listen == "" {
+		return usageErrorf("nothing to serve with --listen empty")
+	}
+	opts := []davfs.Option{davfs.WithCacheDir(e.f.cacheDir)}
+	if token := os.Getenv("VFS_API_TOKEN"); token != "" {
+		opts = append(opts, davfs.WithToken(token))
+	}
+	handler, err := davfs.New(e.v, args[0], opts...)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	lis, err := net.Listen("tcp", e.f.listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: handler}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(lis) }()
+	slog.Info("sharing over WebDAV", "uri", args[0], "address", lis.Addr().String())
+
+	select {
+	case <-e.ctx.Done():
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	case err := <-errs:
+		return err
+	}
+}
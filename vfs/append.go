@@ -0,0 +1,212 @@
+package vfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Append adds the contents of inputPath to the end of the archive at uri.
+// If the archive's last chunk is partial it is first filled up with new
+// data and rewritten under the same index. The manifest is extended from
+// the hash state it records, so the chunks already stored are not read
+// back; manifests without one are rebuilt from every chunk. The input is
+// read as its chunks are uploaded, so it need not fit in memory.
+//
+// The old key of a rewritten chunk is deleted only after every new chunk
+// and the extended manifest have been stored. If an upload or the manifest
+// fails, the new chunks are deleted again, leaving the archive as it was.
+// If only deleting the old key fails, the archive holds two copies of that
+// index and reads fail with ErrChunkGap until the key the error names is
+// deleted. Nothing already stored is lost either way.
+func (v *VFS) Append(ctx context.Context, inputPath, uri string) (err error) {
+	ctx, end := v.startOp(ctx, OpAppend, "Append", uri)
+	defer end(&err)
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	if err := v.checkCommitted(ctx, b, prefix, dataPrefix); err != nil {
+		return err
+	}
+	m, err := v.readManifest(ctx, b, manifestKey(prefix, dataPrefix))
+	if err != nil {
+		return err
+	}
+	if m.deduped() {
+		return fmt.Errorf("cannot append to %s: deduplicated archives are encoded again instead", uri)
+	}
+	if m.parity() != nil {
+		return fmt.Errorf("cannot append to %s: archives with parity are encoded again instead", uri)
+	}
+	chunks, err := v.listStored(ctx, b, dataPrefix, m.bodies())
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("%w at %s", ErrNoChunks, uri)
+	}
+	if m != nil {
+		m.seal(prefix, chunks)
+	}
+
+	// Keep the key format of the archive, so its chunks stay the same size.
+	last := chunks[len(chunks)-1]
+	format := keyFormat{checksum: last.checksum != "", width: chunks[0].width, codec: last.codec, body: m.bodies()}
+	if m != nil {
+		format.maxChunk = m.ChunkSize
+	}
+	chunkSize := format.chunkSize(b.KeyLimit(dataPrefix))
+	if chunkSize < 1 {
+		return errChunkSizeTooSmall
+	}
+
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	input := bufio.NewReader(file)
+	if _, err := input.Peek(1); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	sum, extend := resumeHash(m)
+	added := &countingWriter{w: io.Discard}
+	if extend {
+		added.w = sum
+	}
+	var stream io.Reader = io.TeeReader(input, added)
+	size := int64(-1)
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	stored := &countingWriter{w: io.Discard}
+	if c := m.compression(); c != CompressionNone {
+		// A compressed archive grows by another compressed stream.
+		compressed := compressReader(stream, c)
+		defer compressed.Close()
+		stream, size = io.TeeReader(compressed, stored), -1
+	}
+
+	lastData, err := last.decode()
+	if err != nil {
+		return fmt.Errorf("failed to decode last chunk: %w", err)
+	}
+
+	firstIndex := last.index + 1
+	rewrite := len(lastData) < chunkSize
+	if rewrite {
+		firstIndex = last.index
+		stream = io.MultiReader(bytes.NewReader(lastData), stream)
+		if size >= 0 {
+			size += int64(len(lastData))
+		}
+	}
+	if size >= 0 {
+		if lastIndex := firstIndex + int(chunkCount(size, chunkSize)) - 1; lastIndex > format.maxIndex() {
+			return fmt.Errorf("%w: appending needs chunk %d, but the archive's indices have %d digits", ErrTooManyChunks, lastIndex, format.digits())
+		}
+	}
+
+	// Chunks are read as they are uploaded, like encode does, so memory
+	// stays proportional to the concurrency rather than the input.
+	src := readerChunks(stream, chunkSize, size)
+	var uploaded int
+	next := src.next
+	src.next = func() ([]byte, error) {
+		data, err := next()
+		if len(data) > 0 {
+			uploaded++
+		}
+		return data, err
+	}
+	if err := v.uploadChunks(ctx, OpAppend, b, dataPrefix, src, firstIndex, format, nil, nil); err != nil {
+		return v.undoAppend(ctx, b, dataPrefix, m.bodies(), last, firstIndex, err)
+	}
+	if extend {
+		m.Size += added.n
+		if m.compression() != CompressionNone {
+			m.StoredSize += stored.n
+		}
+		m.Chunks = firstIndex + uploaded - 1
+		m.SHA256, m.SHA256State = hex.EncodeToString(sum.Sum(nil)), hashState(sum)
+		if err := v.writeManifest(ctx, b, manifestKey(prefix, dataPrefix), m); err != nil {
+			return v.undoAppend(ctx, b, dataPrefix, m.bodies(), last, firstIndex, fmt.Errorf("failed to update manifest: %w", err))
+		}
+	}
+	if rewrite {
+		if err := b.Delete(ctx, []string{last.key}); err != nil {
+			return fmt.Errorf("failed to remove the old copy of rewritten chunk %d; delete %s to finish the append: %w", last.index, last.key, err)
+		}
+	}
+	if !extend && m != nil {
+		if err := v.rebuildManifest(ctx, b, prefix, dataPrefix, m); err != nil {
+			return fmt.Errorf("failed to update manifest: %w", err)
+		}
+	}
+	return nil
+}
+
+// undoAppend deletes the chunks a failed append stored from firstIndex
+// on, keeping last, the archive's last chunk before it, and returns the
+// error that failed it.
+func (v *VFS) undoAppend(ctx context.Context, b Backend, dataPrefix string, bodies bool, last storedChunk, firstIndex int, cause error) error {
+	chunks, err := v.listStored(ctx, b, dataPrefix, bodies)
+	if err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to undo the append: %w", err))
+	}
+	var added []string
+	for _, c := range chunks {
+		if c.index >= firstIndex && c.key != last.key {
+			added = append(added, c.key)
+		}
+	}
+	if len(added) > 0 {
+		if err := v.deleteWithBackoff(ctx, b, added); err != nil {
+			return errors.Join(cause, fmt.Errorf("failed to undo the append: %w", err))
+		}
+	}
+	return cause
+}
+
+// hashState returns the state of h to record in a manifest, or nil if h
+// cannot save it.
+func hashState(h hash.Hash) []byte {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil
+	}
+	state, err := m.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
+// resumeHash returns the SHA-256 hash of the file the archive m describes
+// as it stood after its last byte, if m records the state.
+func resumeHash(m *Manifest) (hash.Hash, bool) {
+	if m == nil || len(m.SHA256State) == 0 {
+		return nil, false
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(m.SHA256State); err != nil {
+		return nil, false
+	}
+	return h, true
+}
@@ -0,0 +1,261 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "input.bin")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func TestAppend_PartialLastChunk(t *testing.T) {
+	const uri = "s3://bucket/log/"
+	chunkSize := calculateChunkSize("log/")
+	first := randomBytes(2*chunkSize + 100)
+	second := randomBytes(chunkSize + 50)
+
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	if err := v.Encode(context.Background(), writeTempFile(t, first), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(context.Background(), writeTempFile(t, second), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	want := append(append([]byte{}, first...), second...)
+	// The chunks plus the manifest.
+	if got, expected := len(fake.keys("bucket")), int(chunkCount(int64(len(want)), chunkSize))+1; got != expected {
+		t.Errorf("expected %d keys after append, got %d", expected, got)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(context.Background(), uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, want) {
+		t.Errorf("restored %d bytes, want concatenation of %d bytes", len(got), len(want))
+	}
+}
+
+func TestAppend_FullLastChunk(t *testing.T) {
+	const uri = "s3://bucket/full/"
+	chunkSize := calculateChunkSize("full/")
+	first := randomBytes(2 * chunkSize)
+	second := randomBytes(10)
+
+	v := newTestVFS(newFakeS3())
+	if err := v.Encode(context.Background(), writeTempFile(t, first), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(context.Background(), writeTempFile(t, second), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(context.Background(), uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, append(first, second...)) {
+		t.Error("restored data does not match the concatenation")
+	}
+}
+
+func TestAppend_LegacyArchiveKeepsKeyFormat(t *testing.T) {
+	fake := newFakeS3()
+	chunkSize := legacyKeyChunkSize(s3MaxKeyLengthBytes - len("old/"))
+	first := randomBytes(chunkSize + 10)
+	for i, c := range splitChunks(first, chunkSize) {
+		fake.objects["bucket/"+legacyChunkKey("old/", i+1, c)] = nil
+	}
+	v := newTestVFS(fake)
+	second := randomBytes(2 * chunkSize)
+	if err := v.Append(context.Background(), writeTempFile(t, second), "s3://bucket/old/"); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	for _, k := range fake.keys("bucket") {
+		if strings.Contains(k, ".") {
+			t.Errorf("expected legacy keys without checksum, got %q", k)
+		}
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(context.Background(), "s3://bucket/old/", &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), append(first, second...)) {
+		t.Error("restored data does not match")
+	}
+}
+
+func TestAppend_UnpaddedArchiveKeepsKeyFormat(t *testing.T) {
+	fake := newFakeS3()
+	format := keyFormat{checksum: true}
+	chunkSize := format.chunkSize(s3MaxKeyLengthBytes - len("unpadded/"))
+	first := randomBytes(chunkSize + 10)
+	for i, c := range splitChunks(first, chunkSize) {
+		fake.objects["bucket/"+format.key("unpadded/", i+1, c)] = nil
+	}
+	v := newTestVFS(fake)
+	second := randomBytes(2 * chunkSize)
+	if err := v.Append(context.Background(), writeTempFile(t, second), "s3://bucket/unpadded/"); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, "unpadded/0") {
+			t.Errorf("expected unpadded keys, got %q", k[:20])
+		}
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(context.Background(), "s3://bucket/unpadded/", &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), append(first, second...)) {
+		t.Error("restored data does not match")
+	}
+}
+
+func TestAppend_FailsWhenIndexWidthIsFull(t *testing.T) {
+	fake := newFakeS3()
+	format := keyFormat{checksum: true, width: 2}
+	chunkSize := format.chunkSize(s3MaxKeyLengthBytes - len("narrow/"))
+	for i := 1; i <= 98; i++ {
+		fake.objects["bucket/"+format.key("narrow/", i, randomBytes(chunkSize))] = nil
+	}
+	v := newTestVFS(fake)
+	err := v.Append(context.Background(), writeTempFile(t, randomBytes(2*chunkSize)), "s3://bucket/narrow/")
+	if !errors.Is(err, ErrTooManyChunks) {
+		t.Fatalf("expected ErrTooManyChunks, got %v", err)
+	}
+	if n := len(fake.keys("bucket")); n != 98 {
+		t.Errorf("expected nothing uploaded, found %d keys", n)
+	}
+}
+
+func TestAppend_NoArchive(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	if err := v.Append(context.Background(), writeTempFile(t, []byte("x")), "s3://bucket/missing/"); err == nil {
+		t.Error("expected error when appending to a missing archive")
+	}
+}
+
+func TestAppend_ExtendsManifestWithoutReadingChunks(t *testing.T) {
+	for name, c := range map[string]Compression{"plain": CompressionNone, "gzip": CompressionGzip} {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeS3()
+			ctx := context.Background()
+			v, cb := newCountingTestVFS(fake)
+			v.compression, v.bodyThreshold = c, 1
+			first, second := randomBytes(3000), randomBytes(2000)
+			if err := v.Encode(ctx, writeTempFile(t, first), "count://bucket/log/", false); err != nil {
+				t.Fatal(err)
+			}
+			cb.got = RequestEstimate{}
+			if err := v.Append(ctx, writeTempFile(t, second), "count://bucket/log/"); err != nil {
+				t.Fatal(err)
+			}
+			// The staging marker, the manifest and the partial last chunk.
+			if cb.got.Gets != 3 {
+				t.Errorf("expected 3 GETs, got %d", cb.got.Gets)
+			}
+
+			m, err := v.Stat(ctx, "count://bucket/log/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := sha256.Sum256(append(first, second...))
+			if m.Size != 5000 || m.SHA256 != hex.EncodeToString(want[:]) || m.Chunks != 1 {
+				t.Errorf("unexpected manifest after append: %+v", m)
+			}
+			var out bytes.Buffer
+			if err := v.RestoreWriter(ctx, "count://bucket/log/", &out); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), append(first, second...)) {
+				t.Error("restored data does not match the concatenation")
+			}
+		})
+	}
+}
+
+func TestAppend_FailedUploadLeavesArchiveAsItWas(t *testing.T) {
+	const uri = "s3://bucket/undo/"
+	chunkSize := calculateChunkSize("undo/")
+	first := randomBytes(chunkSize + 100)
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, first), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	before := fake.keys("bucket")
+
+	// The rewritten last chunk and one more go up, then the rest fails.
+	failAfter(fake, 2)
+	if err := v.Append(ctx, writeTempFile(t, randomBytes(3*chunkSize)), uri); err == nil {
+		t.Fatal("expected the append to fail")
+	}
+	fake.putHook = nil
+	if after := fake.keys("bucket"); strings.Join(after, " ") != strings.Join(before, " ") {
+		t.Errorf("expected the new chunks to be deleted again, got %v, had %v", after, before)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), first) {
+		t.Errorf("expected the archive as it was, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestAppend_FailedDeleteNamesTheOldChunk(t *testing.T) {
+	const uri = "s3://bucket/stale/"
+	chunkSize := calculateChunkSize("stale/")
+	first, second := randomBytes(chunkSize+100), randomBytes(500)
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, first), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	var old string // the partial last chunk, rewritten by the append
+	for _, k := range fake.keys("bucket") {
+		if c, ok := parseChunkName(strings.TrimPrefix(k, "stale/")); ok && c.index == 2 {
+			old = k
+		}
+	}
+
+	fake.deleteHook = func([]string) error { return errors.New("access denied") }
+	err := v.Append(ctx, writeTempFile(t, second), uri)
+	if err == nil || !strings.Contains(err.Error(), old) {
+		t.Fatalf("expected the error to name %s, got %v", old, err)
+	}
+	fake.deleteHook = nil
+	if err := v.RestoreWriter(ctx, uri, io.Discard); !errors.Is(err, ErrChunkGap) {
+		t.Errorf("expected ErrChunkGap while both copies are stored, got %v", err)
+	}
+	delete(fake.objects, "bucket/"+old)
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), append(first, second...)) {
+		t.Errorf("expected the appended archive once the old copy is deleted, got %d bytes, %v", out.Len(), err)
+	}
+}
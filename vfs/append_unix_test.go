@@ -0,0 +1,71 @@
+//go:build unix
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAppend_StreamsInput(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/log/"
+	first := randomBytes(3000)
+	if err := v.Encode(ctx, writeTempFile(t, first), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	fifo := filepath.Join(t.TempDir(), "input")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Skipf("no named pipes here: %v", err)
+	}
+
+	// The writer holds back the second half until a chunk of the first
+	// has been stored, which only happens if Append does not read to EOF
+	// first.
+	stored := make(chan struct{}, 1)
+	fake.putHook = func() error {
+		select {
+		case stored <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	second := randomBytes(20000)
+	wrote := make(chan error, 1)
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			wrote <- err
+			return
+		}
+		defer w.Close()
+		if _, err := w.Write(second[:10000]); err != nil {
+			wrote <- err
+			return
+		}
+		select {
+		case <-stored:
+		case <-time.After(5 * time.Second):
+			t.Error("expected a chunk stored before the input was complete")
+		}
+		_, err = w.Write(second[10000:])
+		wrote <- err
+	}()
+	if err := v.Append(ctx, fifo, uri); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-wrote; err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), append(first, second...)) {
+		t.Errorf("expected the concatenation back, got %d bytes, %v", out.Len(), err)
+	}
+}
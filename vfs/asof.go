@@ -0,0 +1,136 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrNotVersioned is returned with WithAsOf for backends that keep no
+	// earlier versions of objects.
+	ErrNotVersioned = errors.New("backend keeps no object versions")
+	// ErrReadOnly is returned with WithAsOf by operations that write.
+	ErrReadOnly = errors.New("bucket is read-only as of a past time")
+)
+
+// WithAsOf makes every read see buckets as they were at t, from the object
+// versions kept by S3 buckets with versioning enabled. Restore then writes
+// the file as it was at t, even if its chunks and manifest have since been
+// overwritten or deleted. Writes fail with ErrReadOnly, and backends that
+// keep no versions with ErrNotVersioned.
+func WithAsOf(t time.Time) Option {
+	return func(o *options) {
+		o.asOf = t
+	}
+}
+
+// objectVersion is one version of an object, or the marker its deletion
+// left.
+type objectVersion struct {
+	Object
+	ID      string
+	Deleted bool // a delete marker
+}
+
+// versionLister is implemented by backends that keep earlier versions of
+// objects.
+type versionLister interface {
+	// listVersions calls fn with successive pages of the versions and
+	// delete markers of the objects under prefix, newest first for each
+	// key. It fails with ErrNotVersioned if the store keeps no versions.
+	listVersions(ctx context.Context, prefix string, fn func([]objectVersion) error) error
+	// getVersion returns the body of version id of key.
+	getVersion(ctx context.Context, key, id string) ([]byte, error)
+}
+
+// asOfBackend is a read-only view of a backend as it was at a past time.
+// Every List and Get lists the versions under its key prefix.
+type asOfBackend struct {
+	b        Backend
+	versions versionLister
+	at       time.Time
+}
+
+func asOf(b Backend, at time.Time) (Backend, error) {
+	versions, ok := b.(versionLister)
+	if !ok {
+		return nil, ErrNotVersioned
+	}
+	return &asOfBackend{b: b, versions: versions, at: at}, nil
+}
+
+// objects returns the versions of the objects under prefix that were
+// current at a.at, in key order.
+func (a *asOfBackend) objects(ctx context.Context, prefix string) ([]objectVersion, error) {
+	current := make(map[string]objectVersion)
+	err := a.versions.listVersions(ctx, prefix, func(versions []objectVersion) error {
+		for _, ver := range versions {
+			if ver.LastModified.After(a.at) {
+				continue
+			}
+			if cur, ok := current[ver.Key]; ok && !ver.LastModified.After(cur.LastModified) {
+				continue
+			}
+			current[ver.Key] = ver
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]objectVersion, 0, len(current))
+	for _, ver := range current {
+		if !ver.Deleted {
+			objs = append(objs, ver)
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	return objs, nil
+}
+
+func (a *asOfBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	objs, err := a.objects(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, ver := range objs {
+		if ver.Key == key {
+			return a.versions.getVersion(ctx, key, ver.ID)
+		}
+	}
+	return nil, fmt.Errorf("%s as of %s: %w", key, a.at.Format(time.RFC3339), fs.ErrNotExist)
+}
+
+func (a *asOfBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	objs, err := a.objects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for len(objs) > 0 {
+		page := make([]Object, min(len(objs), listPageSize))
+		for i := range page {
+			page[i] = objs[i].Object
+		}
+		objs = objs[len(page):]
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *asOfBackend) Put(ctx context.Context, key string, body []byte) error {
+	return ErrReadOnly
+}
+
+func (a *asOfBackend) Delete(ctx context.Context, keys []string) error {
+	return ErrReadOnly
+}
+
+func (a *asOfBackend) KeyLimit(prefix string) int {
+	return a.b.KeyLimit(prefix)
+}
@@ -0,0 +1,166 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// versionedS3 is a fakeS3 for a bucket with versioning enabled: it keeps
+// every version of every object, stamped by a clock that ticks a second
+// per write.
+type versionedS3 struct {
+	*fakeS3
+	now      time.Time
+	versions map[string][]storedVersion // "bucket/key" -> oldest first
+}
+
+type storedVersion struct {
+	id       string
+	body     []byte
+	modified time.Time
+	deleted  bool
+}
+
+func newVersionedS3() *versionedS3 {
+	return &versionedS3{fakeS3: newFakeS3(), now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		versions: make(map[string][]storedVersion)}
+}
+
+// record adds a version of key, under f.mu.
+func (f *versionedS3) record(key string, body []byte, deleted bool) {
+	f.now = f.now.Add(time.Second)
+	f.versions[key] = append(f.versions[key], storedVersion{
+		id: fmt.Sprint(len(f.versions[key]) + 1), body: body, modified: f.now, deleted: deleted})
+}
+
+func (f *versionedS3) PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	out, err := f.fakeS3.PutObject(ctx, in, opts...)
+	if err == nil {
+		f.mu.Lock()
+		f.record(*in.Bucket+"/"+*in.Key, f.objects[*in.Bucket+"/"+*in.Key], false)
+		f.mu.Unlock()
+	}
+	return out, err
+}
+
+func (f *versionedS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, opts ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	for _, obj := range in.Delete.Objects {
+		if _, ok := f.objects[*in.Bucket+"/"+*obj.Key]; ok {
+			f.record(*in.Bucket+"/"+*obj.Key, nil, true)
+		}
+	}
+	f.mu.Unlock()
+	return f.fakeS3.DeleteObjects(ctx, in, opts...)
+}
+
+func (f *versionedS3) GetObject(ctx context.Context, in *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if in.VersionId == nil {
+		return f.fakeS3.GetObject(ctx, in, opts...)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ver := range f.versions[*in.Bucket+"/"+*in.Key] {
+		if ver.id == *in.VersionId && !ver.deleted {
+			return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(ver.body))}, nil
+		}
+	}
+	return nil, &s3types.NoSuchKey{}
+}
+
+// ListObjectVersions returns every version under the prefix in one page.
+func (f *versionedS3) ListObjectVersions(ctx context.Context, in *s3.ListObjectVersionsInput, _ ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.versions {
+		if key, ok := strings.CutPrefix(k, *in.Bucket+"/"); ok && strings.HasPrefix(key, *in.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := &s3.ListObjectVersionsOutput{}
+	for _, key := range keys {
+		versions := f.versions[*in.Bucket+"/"+key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			ver := versions[i]
+			if ver.deleted {
+				out.DeleteMarkers = append(out.DeleteMarkers, s3types.DeleteMarkerEntry{Key: &key, VersionId: &ver.id, LastModified: &ver.modified})
+				continue
+			}
+			size := int64(len(ver.body))
+			out.Versions = append(out.Versions, s3types.ObjectVersion{Key: &key, VersionId: &ver.id, LastModified: &ver.modified, Size: &size})
+		}
+	}
+	return out, nil
+}
+
+func TestWithAsOf_RestoresOverwrittenAndDeletedFile(t *testing.T) {
+	fake := newVersionedS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	first, second := randomBytes(5000), randomBytes(3000)
+	if err := v.Encode(ctx, writeTempFile(t, first), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	afterFirst := fake.now
+	if err := v.Encode(ctx, writeTempFile(t, second), "s3://bucket/f/", true); err != nil {
+		t.Fatal(err)
+	}
+	afterSecond := fake.now
+	if err := v.Delete(ctx, "s3://bucket/f/"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		at   time.Time
+		want []byte
+	}{
+		{afterFirst, first},
+		{afterSecond, second},
+	} {
+		v.asOf = tc.at
+		out := filepath.Join(t.TempDir(), "out.bin")
+		if err := v.Restore(ctx, "s3://bucket/f/", out); err != nil {
+			t.Fatalf("as of %v: %v", tc.at, err)
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, tc.want) {
+			t.Errorf("as of %v: restored %d bytes that differ from the file then", tc.at, len(got))
+		}
+	}
+
+	v.asOf = fake.now
+	if err := v.RestoreWriter(ctx, "s3://bucket/f/", &bytes.Buffer{}); !errors.Is(err, ErrNoChunks) {
+		t.Errorf("expected ErrNoChunks after the delete, got %v", err)
+	}
+}
+
+func TestWithAsOf_ReadOnlyAndNeedsVersions(t *testing.T) {
+	ctx := context.Background()
+	v := newTestVFS(newVersionedS3())
+	v.asOf = time.Now()
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/f/", false); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	v = newTestVFS(newFakeS3())
+	v.asOf = time.Now()
+	if err := v.RestoreWriter(ctx, "s3://bucket/f/", &bytes.Buffer{}); !errors.Is(err, ErrNotVersioned) {
+		t.Errorf("expected ErrNotVersioned from a client without versions, got %v", err)
+	}
+	if err := v.RestoreWriter(ctx, "file://"+t.TempDir()+"/f/", &bytes.Buffer{}); !errors.Is(err, ErrNotVersioned) {
+		t.Errorf("expected ErrNotVersioned from the file backend, got %v", err)
+	}
+}
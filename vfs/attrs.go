@@ -0,0 +1,70 @@
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FileAttrs are the attributes of an encoded file that Restore gives the
+// file it writes.
+type FileAttrs struct {
+	Mode     fs.FileMode `json:"mode"` // permission bits
+	Modified time.Time   `json:"modified"`
+	// UID and GID are the numeric owner, recorded with WithPreserveOwner
+	// on systems that have one.
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+}
+
+// WithNoPreserve makes Encode record no file attributes in the manifest,
+// and Restore leave the attributes of the files it writes as they are
+// created, with the umask applied and the current time.
+func WithNoPreserve() Option {
+	return func(o *options) {
+		o.noPreserve = true
+	}
+}
+
+// WithPreserveOwner makes Encode record the owner of the file too, and
+// Restore give it back, which usually needs root. A restore that cannot
+// warns and leaves the file to the user restoring it.
+func WithPreserveOwner() Option {
+	return func(o *options) {
+		o.preserveOwner = true
+	}
+}
+
+// fileAttrs returns the attributes of the file info describes to record,
+// or nil with WithNoPreserve.
+func (v *VFS) fileAttrs(info fs.FileInfo) *FileAttrs {
+	if v.noPreserve {
+		return nil
+	}
+	a := &FileAttrs{Mode: info.Mode().Perm(), Modified: info.ModTime().UTC()}
+	if v.preserveOwner {
+		if uid, gid, ok := fileOwner(info); ok {
+			a.UID, a.GID = &uid, &gid
+		}
+	}
+	return a
+}
+
+// applyAttrs gives f, restored from the archive m describes, the
+// attributes m records. The owner goes first, since changing it may clear
+// the setuid and setgid bits, and the modification time last.
+func (v *VFS) applyAttrs(f *os.File, m *Manifest) error {
+	if v.noPreserve || m == nil || m.Attrs == nil {
+		return nil
+	}
+	a := m.Attrs
+	if v.preserveOwner && a.UID != nil && a.GID != nil {
+		if err := f.Chown(*a.UID, *a.GID); err != nil {
+			v.warn(OpRestore, "⚠️  cannot restore the owner: "+err.Error())
+		}
+	}
+	if err := f.Chmod(a.Mode); err != nil {
+		return err
+	}
+	return os.Chtimes(f.Name(), a.Modified, a.Modified)
+}
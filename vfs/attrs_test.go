@@ -0,0 +1,106 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRestore_ReappliesModeAndModTime(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	input := writeTempFile(t, randomBytes(3000))
+	modified := time.Date(2020, 5, 17, 9, 30, 0, 0, time.UTC)
+	if err := os.Chmod(input, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(input, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, input, "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(ctx, "s3://bucket/f/", out); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640, got %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modified) {
+		t.Errorf("expected mtime %v, got %v", modified, info.ModTime())
+	}
+}
+
+func TestWithNoPreserve_RecordsNothing(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.noPreserve = true
+	input := writeTempFile(t, randomBytes(3000))
+	if err := os.Chtimes(input, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, input, "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := v.Stat(ctx, "s3://bucket/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Attrs != nil {
+		t.Errorf("expected no attributes, got %+v", m.Attrs)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(ctx, "s3://bucket/f/", out); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(out); err != nil || time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("expected a fresh mtime, got %v (%v)", info.ModTime(), err)
+	}
+}
+
+func TestWithPreserveOwner_RecordsOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files have no numeric owner")
+	}
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://bucket/plain/", false); err != nil {
+		t.Fatal(err)
+	}
+	v.preserveOwner = true
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://bucket/owned/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := v.Stat(ctx, "s3://bucket/plain/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Attrs.UID != nil {
+		t.Errorf("expected no owner without WithPreserveOwner, got %d", *plain.Attrs.UID)
+	}
+	owned, err := v.Stat(ctx, "s3://bucket/owned/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owned.Attrs.UID == nil || *owned.Attrs.UID != os.Getuid() || *owned.Attrs.GID != os.Getgid() {
+		t.Errorf("expected owner %d:%d, got %+v", os.Getuid(), os.Getgid(), owned.Attrs)
+	}
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(ctx, "s3://bucket/owned/", out); err != nil {
+		t.Fatal(err)
+	}
+}
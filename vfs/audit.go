@@ -0,0 +1,112 @@
+package vfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithAuditLog records every Encode, EncodeReader, Append, Restore,
+// RestoreWriter, RestoreRange and Delete, whether it succeeded or not, as
+// an AuditRecord. dest is either a root URI like s3://audit-bucket/vfs/,
+// under which each record is stored as its own JSON object named by time,
+// so records are never rewritten, or a local file, to which each record is
+// appended as a line of JSON. A record that cannot be written is reported
+// as a warning; the operation itself is not undone. Nothing is recorded
+// with WithDryRun.
+func WithAuditLog(dest string) Option {
+	return func(o *options) {
+		o.auditLog = dest
+	}
+}
+
+// AuditRecord is one entry of the audit log: who ran an operation, when,
+// on what, and how much it moved.
+type AuditRecord struct {
+	Time   time.Time `json:"time"` // when the operation started
+	User   string    `json:"user"` // the user running the process
+	Host   string    `json:"host"`
+	Op     Op        `json:"op"`
+	URI    string    `json:"uri"`
+	Bucket string    `json:"bucket"`
+	Prefix string    `json:"prefix"`
+	// Chunks is how many chunks were stored or restored, or objects
+	// deleted, and Bytes their decoded size.
+	Chunks   int64   `json:"chunks"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration"` // in seconds
+	Error    string  `json:"error,omitempty"`
+}
+
+// auditLog is where the records of a VFS go.
+type auditLog struct {
+	dest string
+	user string
+	host string
+	mu   sync.Mutex // serializes appends to a local file
+}
+
+func newAuditLog(dest string) *auditLog {
+	a := &auditLog{dest: dest, user: os.Getenv("USER")}
+	if u, err := user.Current(); err == nil {
+		a.user = u.Username
+	}
+	a.host, _ = os.Hostname()
+	return a
+}
+
+// writeAudit writes r to the audit log, even if ctx is canceled, since the
+// operation has been attempted either way.
+func (v *VFS) writeAudit(ctx context.Context, r AuditRecord) {
+	if v.dryRun != nil {
+		return
+	}
+	line, err := json.Marshal(r)
+	if err == nil {
+		if strings.Contains(v.audit.dest, "://") {
+			err = v.putAudit(context.WithoutCancel(ctx), r, line)
+		} else {
+			err = v.audit.append(line)
+		}
+	}
+	if err != nil {
+		v.warn(r.Op, "⚠️  failed to write audit record: "+err.Error())
+	}
+}
+
+// putAudit stores the record r, marshaled as body, in an object of its
+// own under the audit root, named so records list in time order.
+func (v *VFS) putAudit(ctx context.Context, r AuditRecord, body []byte) error {
+	// Records of reads as of a past time are still written now.
+	b, prefix, err := v.openAt(ctx, v.audit.dest, time.Time{})
+	if err != nil {
+		return err
+	}
+	var nonce [4]byte
+	rand.Read(nonce[:])
+	key := fmt.Sprintf("%s%s-%s-%s.json", prefix, r.Time.Format("20060102T150405.000000000Z"), r.Op, hex.EncodeToString(nonce[:]))
+	v.limiter.acquire()
+	return v.putWithBackoff(ctx, b, key, body)
+}
+
+// append adds line to the local audit file.
+func (a *auditLog) append(line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
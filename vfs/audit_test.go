@@ -0,0 +1,106 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog_AppendsToLocalFile(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	v.audit = newAuditLog(logPath)
+
+	data := randomBytes(5000)
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RestoreWriter(ctx, "s3://bucket/f/", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ctx, "s3://bucket/f/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RestoreWriter(ctx, "s3://bucket/f/", &bytes.Buffer{}); !errors.Is(err, ErrNoChunks) {
+		t.Fatalf("expected ErrNoChunks, got %v", err)
+	}
+
+	raw, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		var r AuditRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d", len(records))
+	}
+	encode, restore, del, failed := records[0], records[1], records[2], records[3]
+	if encode.Op != OpEncode || encode.Bucket != "bucket" || encode.Prefix != "f/" || encode.User == "" || encode.Time.IsZero() {
+		t.Errorf("unexpected encode record %+v", encode)
+	}
+	if encode.Chunks < 2 || encode.Bytes != int64(len(data)) {
+		t.Errorf("expected the chunks and bytes encoded, got %d and %d", encode.Chunks, encode.Bytes)
+	}
+	if restore.Op != OpRestore || restore.Chunks != encode.Chunks || restore.Bytes != encode.Bytes {
+		t.Errorf("expected the chunks encoded to be restored, got %+v", restore)
+	}
+	if del.Op != OpDelete || del.Chunks < encode.Chunks {
+		t.Errorf("expected every object to be deleted, got %+v", del)
+	}
+	if failed.Error == "" || failed.Chunks != 0 {
+		t.Errorf("expected a failed restore, got %+v", failed)
+	}
+}
+
+func TestAuditLog_StoresObjectsUnderRoot(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.audit = newAuditLog("s3://audit/vfs/")
+
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/f/", false); !errors.Is(err, ErrPrefixExists) {
+		t.Fatalf("expected ErrPrefixExists, got %v", err)
+	}
+	keys := fake.keys("audit")
+	if len(keys) != 2 {
+		t.Fatalf("expected a record object per operation, got %v", keys)
+	}
+	var r AuditRecord
+	if err := json.Unmarshal(fake.objects["audit/"+keys[1]], &r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(keys[1], "vfs/") || !strings.HasSuffix(keys[1], ".json") || r.URI != "s3://bucket/f/" || r.Error == "" {
+		t.Errorf("expected the second, failed encode at %s, got %+v", keys[1], r)
+	}
+}
+
+func TestAuditLog_NothingInDryRun(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	v.audit = newAuditLog(logPath)
+	v.dryRun = &dryRun{fn: func(PlannedWrite) {}}
+
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(100)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(logPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no audit log, got %v", err)
+	}
+}
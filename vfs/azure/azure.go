@@ -0,0 +1,149 @@
+// Package azure stores vfs archives in Azure Blob Storage containers. Blob
+// names allow 1024 characters, so chunks are sized exactly like S3 keys.
+//
+//	v, err := vfs.New(vfs.WithBackend("az", azure.Open("")))
+//	v.Encode(ctx, "file.txt", "az://my-container/path/", false)
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+const (
+	maxBlobNameBytes  = 1024
+	deleteConcurrency = 16
+)
+
+// Open returns a factory for az://container/prefix/ URIs on the storage
+// account at serviceURL (e.g. https://myaccount.blob.core.windows.net/).
+// The client is created on first use: from AZURE_STORAGE_CONNECTION_STRING
+// when it is set, otherwise with the default Azure credential chain. An
+// empty serviceURL is derived from AZURE_STORAGE_ACCOUNT.
+func Open(serviceURL string) vfs.BackendFactory {
+	var once sync.Once
+	var client *azblob.Client
+	var err error
+	return func(ctx context.Context, container string) (vfs.Backend, error) {
+		once.Do(func() {
+			client, err = newClient(serviceURL)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if container == "" {
+			return nil, fmt.Errorf("az URI is missing a container name")
+		}
+		return New(client, container), nil
+	}
+}
+
+func newClient(serviceURL string) (*azblob.Client, error) {
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		return azblob.NewClientFromConnectionString(cs, nil)
+	}
+	if serviceURL == "" {
+		account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+		if account == "" {
+			return nil, fmt.Errorf("set AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING")
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return azblob.NewClient(serviceURL, cred, nil)
+}
+
+// New returns a backend for container that uses an existing client.
+func New(client *azblob.Client, container string) vfs.Backend {
+	return &backend{client: client, container: container}
+}
+
+type backend struct {
+	client    *azblob.Client
+	container string
+}
+
+func (b *backend) Put(ctx context.Context, key string, body []byte) error {
+	_, err := b.client.UploadBuffer(ctx, b.container, key, body, nil)
+	return err
+}
+
+func (b *backend) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := b.client.DownloadStream(ctx, b.container, key, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, fmt.Errorf("az://%s/%s: %w", b.container, key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (b *backend) List(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	p := b.client.NewListBlobsFlatPager(b.container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for p.More() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		objs := make([]vfs.Object, 0, len(page.Segment.BlobItems))
+		for _, item := range page.Segment.BlobItems {
+			o := vfs.Object{Key: *item.Name}
+			if props := item.Properties; props != nil {
+				if props.ContentLength != nil {
+					o.Size = *props.ContentLength
+				}
+				if props.LastModified != nil {
+					o.LastModified = *props.LastModified
+				}
+			}
+			objs = append(objs, o)
+		}
+		if err := fn(objs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backend) Delete(ctx context.Context, keys []string) error {
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, deleteConcurrency)
+	for _, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := b.client.DeleteBlob(ctx, b.container, key, nil)
+			if err != nil && !bloberror.HasCode(err, bloberror.BlobNotFound) {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (b *backend) KeyLimit(prefix string) int {
+	return maxBlobNameBytes - len(prefix)
+}
@@ -0,0 +1,102 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// fakeBlob serves the Blob REST calls used by the backend for one
+// container named "c".
+type fakeBlob struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (f *fakeBlob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/c/")
+	switch {
+	case r.URL.Query().Get("comp") == "list":
+		var items strings.Builder
+		for blob := range f.blobs {
+			if strings.HasPrefix(blob, r.URL.Query().Get("prefix")) {
+				fmt.Fprintf(&items, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length></Properties></Blob>", blob, len(f.blobs[blob]))
+			}
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>%s</Blobs><NextMarker/></EnumerationResults>`, items.String())
+	case r.Method == http.MethodGet:
+		body, ok := f.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newTestBackend(t *testing.T, f *fakeBlob) vfs.Backend {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client, err := azblob.NewClientWithNoCredential(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(client, "c")
+}
+
+func TestGetMissingBlob(t *testing.T) {
+	b := newTestBackend(t, &fakeBlob{blobs: map[string][]byte{}})
+	if _, err := b.Get(context.Background(), "p/.vfs-manifest"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	f := &fakeBlob{blobs: map[string][]byte{"a/1-x": nil, "a/2-y": nil, "b/1-z": nil}}
+	b := newTestBackend(t, f)
+	ctx := context.Background()
+
+	var keys []string
+	err := b.List(ctx, "a/", func(objs []vfs.Object) error {
+		for _, o := range objs {
+			keys = append(keys, o.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys under a/, got %v", keys)
+	}
+
+	if err := b.Delete(ctx, append(keys, "a/3-missing")); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, ok := f.blobs["b/1-z"]; len(f.blobs) != 1 || !ok {
+		t.Errorf("expected only b/1-z to remain, got %v", f.blobs)
+	}
+}
@@ -0,0 +1,151 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Object describes a stored object.
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Backend is a key-value object store holding archives, for example one S3
+// bucket. Keys are slash-separated; chunk objects have empty bodies and
+// carry their data in the key.
+type Backend interface {
+	// Put stores body under key, replacing any existing object.
+	Put(ctx context.Context, key string, body []byte) error
+	// Get returns the body stored under key. A missing key yields an
+	// error wrapping fs.ErrNotExist.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List calls fn with successive pages of the objects under prefix, in
+	// lexical key order. An error returned by fn stops the listing and is
+	// returned from List.
+	List(ctx context.Context, prefix string, fn func([]Object) error) error
+	// Delete removes keys. Keys that do not exist are ignored.
+	Delete(ctx context.Context, keys []string) error
+	// KeyLimit returns how many bytes a key may have after prefix.
+	KeyLimit(prefix string) int
+}
+
+// ScopedBackend is implemented by backends that delete more than the keys
+// they are given, such as the directories left empty on a filesystem, and
+// so need the prefix of the URI they were opened for to stay within it.
+type ScopedBackend interface {
+	Backend
+	// Scoped returns the backend for a URI with the given prefix.
+	Scoped(prefix string) Backend
+}
+
+// BackendFactory opens the Backend for the bucket named in a URI.
+type BackendFactory func(ctx context.Context, bucket string) (Backend, error)
+
+// WithBackend serves URIs with the given scheme (e.g. "mem" for
+// mem://bucket/prefix/) from backends opened by f, replacing any built-in
+// backend for that scheme.
+func WithBackend(scheme string, f BackendFactory) Option {
+	return func(o *options) {
+		if o.backends == nil {
+			o.backends = make(map[string]BackendFactory)
+		}
+		o.backends[scheme] = f
+	}
+}
+
+var errStopListing = errors.New("stop listing")
+
+// open resolves uri to its backend and key prefix, mirrored if mirrors are
+// configured.
+func (v *VFS) open(ctx context.Context, uri string) (Backend, string, error) {
+	b, prefix, err := v.openURI(ctx, uri)
+	if err != nil || len(v.mirrors) == 0 {
+		return b, prefix, err
+	}
+	m, err := v.openMirrors(ctx, uri, b, prefix)
+	return m, prefix, err
+}
+
+// openURI resolves uri to its backend and key prefix, as of the time set by
+// WithAsOf.
+func (v *VFS) openURI(ctx context.Context, uri string) (Backend, string, error) {
+	return v.openAt(ctx, uri, v.asOf)
+}
+
+// openAt resolves uri to its backend and key prefix, as it was at the time
+// at unless at is zero.
+func (v *VFS) openAt(ctx context.Context, uri string, at time.Time) (Backend, string, error) {
+	scheme, bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	factory, ok := v.backends[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported URI scheme %q", scheme)
+	}
+	b, err := factory(ctx, bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	if s, ok := b.(ScopedBackend); ok {
+		b = s.Scoped(prefix)
+	}
+	root := fmt.Sprintf("%s://%s/", scheme, bucket)
+	if _, ok := b.(*s3Backend); v.lock.enabled() && !ok {
+		return nil, "", fmt.Errorf("%s: %w", root, ErrObjectLockUnsupported)
+	}
+	if !at.IsZero() {
+		if b, err = asOf(b, at); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", root, err)
+		}
+	}
+	b = v.instrument(scheme, root, b)
+	if v.dryRun != nil {
+		b = v.dryRun.wrap(root, b)
+	}
+	return b, prefix, nil
+}
+
+// parseURI splits scheme://bucket/prefix into its parts. The bucket is the
+// URI authority, including any "user@" and ":port". The prefix is either
+// empty or ends with a slash.
+func parseURI(uri string) (scheme, bucket, prefix string, err error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok || scheme == "" {
+		return "", "", "", fmt.Errorf("must start with <scheme>://, e.g. s3://")
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", err
+	}
+	if parsed.Host == "" && !strings.HasPrefix(rest, "/") {
+		return "", "", "", fmt.Errorf("invalid URI %q", uri)
+	}
+	prefix = strings.TrimLeft(parsed.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	bucket = parsed.Host
+	if parsed.User != nil {
+		bucket = parsed.User.String() + "@" + bucket
+	}
+	return scheme, bucket, prefix, nil
+}
+
+func (v *VFS) hasObjects(ctx context.Context, b Backend, prefix string) (bool, error) {
+	found := false
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		found = len(objs) > 0
+		return errStopListing
+	})
+	if err != nil && !errors.Is(err, errStopListing) {
+		return false, err
+	}
+	return found, nil
+}
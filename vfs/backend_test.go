@@ -0,0 +1,46 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithBackend_DispatchesOnScheme(t *testing.T) {
+	fake := newFakeS3()
+	var o options
+	WithBackend("mem", func(ctx context.Context, bucket string) (Backend, error) {
+		return &s3Backend{client: fake, bucket: bucket}, nil
+	})(&o)
+	v := newTestVFS(nil)
+	for scheme, f := range o.backends {
+		v.backends[scheme] = f
+	}
+
+	data := randomBytes(3000)
+	const uri = "mem://store/archive/"
+	if err := v.Encode(context.Background(), writeTempFile(t, data), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(fake.keys("store")) == 0 {
+		t.Fatal("expected chunks in the custom backend")
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	if err := v.Restore(context.Background(), uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, data) {
+		t.Error("restored data does not match")
+	}
+}
+
+func TestOpen_UnknownScheme(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	if err := v.Delete(context.Background(), "nope://bucket/prefix/"); err == nil {
+		t.Error("expected an error for an unregistered scheme")
+	}
+}
@@ -0,0 +1,121 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// storageBody marks archives whose data is stored in object bodies.
+const storageBody = "body"
+
+// partSuffix ends the name of a body part, "<index>.<crc>.part". Part names
+// have no '-', so they never parse as key-only chunks.
+const partSuffix = ".part"
+
+// WithBodyThreshold stores files of at least size bytes in object bodies,
+// bodyChunkSize bytes per object, instead of in keys. Each part is an
+// object of its own, named "<index>.<crc>.part", not a part of one S3
+// multipart upload: parts are uploaded in parallel on every backend, and
+// verify, append, and restore deal with them one by one like key-only
+// chunks. Large files then take a few thousand PUTs instead of millions,
+// while the manifest, URIs, and restore, append, verify, and delete work
+// as before. Input of unknown size
+// always goes into keys. Backends with small item limits, such as DynamoDB,
+// cannot hold body parts.
+func WithBodyThreshold(size int64) Option {
+	return func(o *options) {
+		o.bodyThreshold = size
+	}
+}
+
+// WithAutoMode stores files in object bodies, as WithBodyThreshold does,
+// whenever key-only chunks under their prefix would hold fewer than 64
+// bytes, which takes an absurd number of objects. Without it Encode only
+// warns. Input of unknown size still goes into keys.
+func WithAutoMode() Option {
+	return func(o *options) {
+		o.autoMode = true
+	}
+}
+
+// storesBodies reports whether a file of size bytes stored under
+// dataPrefix of b goes into object bodies.
+func (v *VFS) storesBodies(b Backend, dataPrefix string, size int64) bool {
+	return v.bodyThreshold > 0 && size >= v.bodyThreshold || v.autoBodies(b, dataPrefix, size)
+}
+
+// autoBodies reports whether WithAutoMode moves a file of size bytes
+// stored under dataPrefix of b into object bodies.
+func (v *VFS) autoBodies(b Backend, dataPrefix string, size int64) bool {
+	return v.autoMode && size >= 0 && storageModeAdvice(backendChunkSize(b, dataPrefix), size) != ""
+}
+
+// bodies reports whether the archive m describes stores its data in object
+// bodies.
+func (m *Manifest) bodies() bool {
+	return m != nil && m.Storage == storageBody
+}
+
+// partKey returns the key of the body part at index holding payload.
+func (f keyFormat) partKey(prefix string, index int, payload []byte) string {
+	return path.Join(prefix, fmt.Sprintf("%0*d.%s%s", f.width, index, chunkChecksum(payload), partSuffix))
+}
+
+// parsePartName parses "<index>.<crc>.part".
+func parsePartName(name string) (storedChunk, bool) {
+	name, ok := strings.CutSuffix(name, partSuffix)
+	if !ok {
+		return storedChunk{}, false
+	}
+	field, sum, ok := strings.Cut(name, ".")
+	if !ok || len(sum) != 8 {
+		return storedChunk{}, false
+	}
+	index, err := strconv.Atoi(field)
+	if err != nil {
+		return storedChunk{}, false
+	}
+	return storedChunk{index: index, checksum: sum, width: len(field)}, true
+}
+
+// listParts returns the body parts under prefix sorted by index. Each part
+// fetches its body when it is decoded.
+func (v *VFS) listParts(ctx context.Context, b Backend, prefix string) ([]storedChunk, error) {
+	var parts []storedChunk
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			c, ok := parsePartName(strings.TrimPrefix(strings.TrimPrefix(obj.Key, prefix), "/"))
+			if !ok {
+				continue
+			}
+			key := obj.Key
+			c.key, c.size = key, obj.Size
+			c.load = func() ([]byte, error) { return b.Get(ctx, key) }
+			parts = append(parts, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].index != parts[j].index {
+			return parts[i].index < parts[j].index
+		}
+		return parts[i].key < parts[j].key
+	})
+	return parts, nil
+}
+
+// listStored lists the chunks under prefix, or its body parts if bodies is
+// set.
+func (v *VFS) listStored(ctx context.Context, b Backend, prefix string, bodies bool) ([]storedChunk, error) {
+	if bodies {
+		return v.listParts(ctx, b, prefix)
+	}
+	return v.listChunks(ctx, b, prefix)
+}
@@ -0,0 +1,126 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBodyStorage_RoundTrip(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.bodyThreshold = 1 << 20
+	ctx := context.Background()
+	const uri = "s3://bucket/big/"
+	data := randomBytes(2*bodyChunkSize + 1000)
+	if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	keys := fake.keys("bucket")
+	if len(keys) != 4 {
+		t.Fatalf("expected 3 parts and a manifest, got %v", keys)
+	}
+	for _, k := range keys {
+		if !strings.HasSuffix(k, partSuffix) && !strings.HasSuffix(k, manifestName) {
+			t.Errorf("unexpected key %s", k)
+		}
+	}
+
+	extra := randomBytes(500)
+	if err := v.Append(ctx, writeTempFile(t, extra), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	data = append(data, extra...)
+	var out bytes.Buffer
+	if err := newTestVFS(fake).RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+	r, err := v.Verify(ctx, uri)
+	if err != nil || !r.OK() {
+		t.Fatalf("expected verify to pass, got %+v, %v", r, err)
+	}
+	if m := r.Manifest; m.Storage != storageBody || m.ChunkSize != bodyChunkSize || m.Chunks != 3 {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+
+	if err := v.Delete(ctx, uri); err != nil || len(fake.keys("bucket")) != 0 {
+		t.Errorf("expected delete to remove every part, got %v, %v", fake.keys("bucket"), err)
+	}
+}
+
+func TestBodyStorage_SmallFilesStayInKeys(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.bodyThreshold = 1 << 20
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1000)), "s3://bucket/small/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	for _, k := range fake.keys("bucket") {
+		if strings.HasSuffix(k, partSuffix) {
+			t.Errorf("expected no body parts, got %s", k)
+		}
+	}
+}
+
+func TestBodyStorage_ResumeEncrypted(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	v.bodyThreshold = 1
+	ctx := context.Background()
+	const uri = "s3://bucket/resume/"
+	data := randomBytes(3*bodyChunkSize + 10)
+	input := writeTempFile(t, data)
+
+	failAfter(fake, 3)
+	if err := v.Encode(ctx, input, uri, false); err == nil {
+		t.Fatal("expected the first encode to fail")
+	}
+	fake.putHook = nil
+	v.resume = true
+	if err := v.Encode(ctx, input, uri, false); err != nil {
+		t.Fatalf("resumed encode failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestParsePartName(t *testing.T) {
+	c, ok := parsePartName("000042.1a2b3c4d.part")
+	if !ok || c.index != 42 || c.checksum != "1a2b3c4d" || c.width != 6 {
+		t.Errorf("unexpected part %+v, %v", c, ok)
+	}
+	for _, name := range []string{"000042-AAAA.1a2b3c4d", "000042.part", ".vfs-manifest", "x.1a2b3c4d.part"} {
+		if _, ok := parsePartName(name); ok {
+			t.Errorf("expected %q not to parse as a part", name)
+		}
+	}
+}
+
+func TestAutoMode_StoresBodiesUnderLongPrefixes(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	long := "s3://bucket/" + strings.Repeat("p", 950) + "/"
+	data := randomBytes(5000)
+	v.autoMode = true
+	for uri, bodies := range map[string]bool{long: true, "s3://bucket/short/": false} {
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+			t.Fatal(err)
+		}
+		m, err := v.Stat(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.bodies() != bodies {
+			t.Errorf("%.30s…: expected bodies=%v, got storage %q", uri, bodies, m.Storage)
+		}
+		var out bytes.Buffer
+		if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("%.30s…: expected the file back, got %d bytes, %v", uri, out.Len(), err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	catalogName    = ".vfs-catalog"
+	catalogVersion = 1
+)
+
+// ErrNotInCatalog is returned for names that the catalog at a prefix does
+// not list.
+var ErrNotInCatalog = errors.New("name not in catalog")
+
+// CatalogEntry is a file stored by name with EncodeNamed.
+type CatalogEntry struct {
+	Name   string    `json:"name"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	Added  time.Time `json:"added"`
+}
+
+// catalog lists the named files under a prefix. It is stored as plain JSON
+// at <prefix>.vfs-catalog, so names are readable even if the files are
+// encrypted.
+type catalog struct {
+	Version int            `json:"version"`
+	Files   []CatalogEntry `json:"files"`
+}
+
+// namedURI returns the URI of the archive holding name under uri, at
+// "<uri><name>/".
+func namedURI(uri, name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	scheme, bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s/%s%s/", scheme, bucket, prefix, name), nil
+}
+
+// checkName rejects names that would not map to a single prefix level or
+// would be mistaken for a split layout.
+func checkName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+	case strings.ContainsAny(name, "/?#%"):
+	case name+"/" == dataDir || name+"/" == metaDir:
+	default:
+		return nil
+	}
+	return fmt.Errorf("invalid file name %q", name)
+}
+
+// EncodeNamed stores the file at inputPath under uri as name, which
+// defaults to the file's base name, and records it in the catalog at uri.
+// Each name gets its own archive at "<uri><name>/", so URIs of that form
+// work with every other command. force works as for Encode. Updating the
+// catalog is a read-modify-write: concurrent writers to one prefix may
+// lose each other's entries.
+func (v *VFS) EncodeNamed(ctx context.Context, inputPath, uri, name string, force bool) error {
+	if name == "" {
+		name = filepath.Base(inputPath)
+	}
+	fileURI, err := namedURI(uri, name)
+	if err != nil {
+		return err
+	}
+	if err := v.Encode(ctx, inputPath, fileURI, force); err != nil {
+		return err
+	}
+	m, err := v.Stat(ctx, fileURI)
+	if err != nil {
+		return err
+	}
+	return v.updateCatalog(ctx, uri, func(c *catalog) {
+		c.Files = removeEntry(c.Files, name)
+		c.Files = append(c.Files, CatalogEntry{Name: name, Size: m.Size, SHA256: m.SHA256, Added: time.Now().UTC()})
+	})
+}
+
+// RestoreNamed writes the file stored under uri as name to outputPath. It
+// fails with ErrNotInCatalog if the catalog does not list name.
+func (v *VFS) RestoreNamed(ctx context.Context, uri, name, outputPath string) error {
+	fileURI, err := v.lookupNamed(ctx, uri, name)
+	if err != nil {
+		return err
+	}
+	return v.Restore(ctx, fileURI, outputPath)
+}
+
+// DeleteNamed deletes the file stored under uri as name and removes it
+// from the catalog.
+func (v *VFS) DeleteNamed(ctx context.Context, uri, name string) error {
+	fileURI, err := v.lookupNamed(ctx, uri, name)
+	if err != nil {
+		return err
+	}
+	if err := v.deleteOne(ctx, fileURI); err != nil {
+		return err
+	}
+	return v.updateCatalog(ctx, uri, func(c *catalog) {
+		c.Files = removeEntry(c.Files, name)
+	})
+}
+
+// Catalog returns the files stored by name under uri, sorted by name. A
+// prefix without a catalog has no named files.
+func (v *VFS) Catalog(ctx context.Context, uri string) ([]CatalogEntry, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	c, err := readCatalog(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return c.Files, nil
+}
+
+func (v *VFS) lookupNamed(ctx context.Context, uri, name string) (string, error) {
+	fileURI, err := namedURI(uri, name)
+	if err != nil {
+		return "", err
+	}
+	files, err := v.Catalog(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range files {
+		if e.Name == name {
+			return fileURI, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s at %s", ErrNotInCatalog, name, uri)
+}
+
+func (v *VFS) updateCatalog(ctx context.Context, uri string, fn func(*catalog)) error {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	c, err := readCatalog(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	fn(c)
+	sort.Slice(c.Files, func(i, j int) bool { return c.Files[i].Name < c.Files[j].Name })
+	if len(c.Files) == 0 {
+		return b.Delete(ctx, []string{prefix + catalogName})
+	}
+	body, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.Put(ctx, prefix+catalogName, body)
+}
+
+// readCatalog returns the catalog at prefix, or an empty one if there is
+// none.
+func readCatalog(ctx context.Context, b Backend, prefix string) (*catalog, error) {
+	c := &catalog{Version: catalogVersion}
+	body, err := b.Get(ctx, prefix+catalogName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, c); err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %w", prefix+catalogName, err)
+	}
+	return c, nil
+}
+
+func removeEntry(files []CatalogEntry, name string) []CatalogEntry {
+	out := files[:0]
+	for _, e := range files {
+		if e.Name != name {
+			out = append(out, e)
+		}
+	}
+	return out
+}
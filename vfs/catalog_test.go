@@ -0,0 +1,74 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeNamed_CatalogRoundTrip(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/shared/"
+	report, notes := randomBytes(2000), randomBytes(100)
+	if err := v.EncodeNamed(ctx, writeTempFile(t, report), uri, "report.pdf", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	notesPath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(notesPath, notes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EncodeNamed(ctx, notesPath, uri, "", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	files, err := v.Catalog(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "notes.txt" || files[1].Name != "report.pdf" || files[1].Size != 2000 {
+		t.Fatalf("unexpected catalog %+v", files)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	if err := v.RestoreNamed(ctx, uri, "report.pdf", out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, report) {
+		t.Error("restored file does not match")
+	}
+	// Each name is an ordinary archive.
+	if m, err := v.Stat(ctx, uri+"notes.txt/"); err != nil || m.SHA256 != files[0].SHA256 {
+		t.Errorf("expected notes.txt/ to be an archive, got %v, %v", m, err)
+	}
+
+	if err := v.DeleteNamed(ctx, uri, "report.pdf"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := v.RestoreNamed(ctx, uri, "report.pdf", out); !errors.Is(err, ErrNotInCatalog) {
+		t.Errorf("expected ErrNotInCatalog, got %v", err)
+	}
+	if files, _ := v.Catalog(ctx, uri); len(files) != 1 || files[0].Name != "notes.txt" {
+		t.Errorf("expected only notes.txt to remain, got %+v", files)
+	}
+	if err := v.DeleteNamed(ctx, uri, "notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected nothing left, got %v", keys)
+	}
+}
+
+func TestEncodeNamed_RejectsInvalidNames(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	path := writeTempFile(t, []byte("x"))
+	for _, name := range []string{"a/b", "..", "meta", "x?y"} {
+		if err := v.EncodeNamed(context.Background(), path, "s3://bucket/p/", name, false); err == nil {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
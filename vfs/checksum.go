@@ -0,0 +1,45 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Checksum returns the hex SHA-256 of the file stored at uri. Chunk data
+// lives in the key names, so the hash is computed from the listing alone
+// without fetching any object.
+func (v *VFS) Checksum(ctx context.Context, uri string) (string, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	chunks, m, err := v.archiveChunks(ctx, b, prefix)
+	if err != nil {
+		return "", err
+	}
+	if len(chunks) == 0 {
+		return "", fmt.Errorf("%w at %s", ErrNoChunks, uri)
+	}
+
+	h := sha256.New()
+	out := decompressTo(h, m.compression())
+	defer out.Close()
+	for _, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		data, err := c.decode()
+		if err != nil {
+			return "", err
+		}
+		if _, err := out.Write(data); err != nil {
+			return "", fmt.Errorf("failed to decompress %s: %w", uri, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to decompress %s: %w", uri, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,32 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestChecksum_MatchesEncodedFile(t *testing.T) {
+	data := randomBytes(5000)
+	sum := sha256.Sum256(data)
+
+	v := newTestVFS(newFakeS3())
+	if err := v.Encode(context.Background(), writeTempFile(t, data), "s3://bucket/sum/", true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	got, err := v.Checksum(context.Background(), "s3://bucket/sum/")
+	if err != nil {
+		t.Fatalf("checksum failed: %v", err)
+	}
+	if want := hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("expected checksum %s, got %s", want, got)
+	}
+}
+
+func TestChecksum_NoChunks(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	if _, err := v.Checksum(context.Background(), "s3://bucket/empty/"); err == nil {
+		t.Error("expected error for an empty prefix")
+	}
+}
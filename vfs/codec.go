@@ -0,0 +1,135 @@
+package vfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// A chunkCodec transforms each chunk's bytes between the stream (after
+// compression) and the payload stored in its key. Codecs compose: an
+// archive can be compressed, obfuscated, and encrypted at once.
+type chunkCodec interface {
+	encode(index int, data []byte) []byte
+	decode(index int, payload []byte) ([]byte, error)
+	overhead() int // bytes encode adds to a chunk
+}
+
+// codecPipeline applies its codecs in order on encode and in reverse on
+// decode.
+type codecPipeline []chunkCodec
+
+// newCodecPipeline returns the codecs that are not nil as one codec, or
+// nil if there are none.
+func newCodecPipeline(codecs ...chunkCodec) chunkCodec {
+	var p codecPipeline
+	for _, c := range codecs {
+		if c != nil {
+			p = append(p, c)
+		}
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+func (p codecPipeline) encode(index int, data []byte) []byte {
+	for _, c := range p {
+		data = c.encode(index, data)
+	}
+	return data
+}
+
+func (p codecPipeline) decode(index int, payload []byte) ([]byte, error) {
+	var err error
+	for i := len(p) - 1; i >= 0; i-- {
+		if payload, err = p[i].decode(index, payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+func (p codecPipeline) overhead() int {
+	n := 0
+	for _, c := range p {
+		n += c.overhead()
+	}
+	return n
+}
+
+// gcmCodec seals chunks with the archive's data key, binding each to its
+// index with indexAD (see Encryption.IndexAD).
+type gcmCodec struct {
+	aead    cipher.AEAD
+	indexAD bool
+}
+
+// ad returns the associated data of the chunk at index.
+func (g gcmCodec) ad(index int) []byte {
+	if !g.indexAD {
+		return nil
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(index))
+}
+
+func (g gcmCodec) encode(index int, data []byte) []byte {
+	return encrypt(g.aead, data, g.ad(index))
+}
+
+func (g gcmCodec) decode(index int, payload []byte) ([]byte, error) {
+	return decrypt(g.aead, payload, g.ad(index))
+}
+
+func (g gcmCodec) overhead() int {
+	return sealOverhead
+}
+
+// WithObfuscation scrambles new archives' chunks with a key derived from
+// the archive prefix, so browsing the bucket does not show plaintext
+// fragments in key names. Anyone who knows the prefix can undo it, so it
+// is no substitute for encryption. It is recorded in the manifest, and it
+// composes with compression and encryption.
+func WithObfuscation() Option {
+	return func(o *options) {
+		o.obfuscate = true
+	}
+}
+
+// obfuscator XORs each chunk with an AES-CTR keystream keyed by the
+// archive prefix and started at the chunk index, so equal chunks look
+// different and resumed uploads produce the same keys.
+type obfuscator struct {
+	block cipher.Block
+}
+
+func newObfuscator(prefix string) obfuscator {
+	key := sha256.Sum256([]byte("vfs obfuscation\x00" + prefix))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err) // a 32-byte key is always valid
+	}
+	return obfuscator{block: block}
+}
+
+func (o obfuscator) xor(index int, data []byte) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[:8], uint64(index))
+	out := make([]byte, len(data))
+	cipher.NewCTR(o.block, iv).XORKeyStream(out, data)
+	return out
+}
+
+func (o obfuscator) encode(index int, data []byte) []byte {
+	return o.xor(index, data)
+}
+
+func (o obfuscator) decode(index int, payload []byte) ([]byte, error) {
+	return o.xor(index, payload), nil
+}
+
+func (o obfuscator) overhead() int {
+	return 0
+}
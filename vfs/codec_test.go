@@ -0,0 +1,69 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestObfuscation_HidesPlaintext(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.obfuscate = true
+	ctx := context.Background()
+	const uri = "s3://bucket/plain/"
+	data := bytes.Repeat([]byte("password=hunter2;"), 100)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	plain := base64.RawURLEncoding.EncodeToString(data[:24])
+	for _, k := range fake.keys("bucket") {
+		if strings.Contains(k, plain) {
+			t.Errorf("expected no plaintext in key %s", k[:40])
+		}
+	}
+
+	var out bytes.Buffer
+	if err := newTestVFS(fake).RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestObfuscation_IsDeterministic(t *testing.T) {
+	o := newObfuscator("a/")
+	data := []byte("same bytes")
+	if !bytes.Equal(o.encode(3, data), newObfuscator("a/").encode(3, data)) {
+		t.Error("expected the same chunk to obfuscate the same way")
+	}
+	if bytes.Equal(o.encode(3, data), o.encode(4, data)) {
+		t.Error("expected chunks at different indices to look different")
+	}
+	if bytes.Equal(o.encode(3, data), newObfuscator("b/").encode(3, data)) {
+		t.Error("expected the key to depend on the prefix")
+	}
+}
+
+func TestCodecs_Compose(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	v.obfuscate = true
+	v.compression = CompressionZstd
+	ctx := context.Background()
+	const uri = "s3://bucket/all/"
+	data := append(textPayload(10_000), randomBytes(2000)...)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(ctx, writeTempFile(t, data[:500]), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), append(data, data[:500]...)) {
+		t.Fatalf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+	if r, err := v.Verify(ctx, uri); err != nil || !r.OK() || !r.Manifest.Obfuscated {
+		t.Errorf("expected verify to pass on an obfuscated manifest, got %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,148 @@
+package vfs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names how an archive's stream is compressed before it is
+// split into chunks.
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression accepts "gzip", "zstd", or "none".
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case CompressionGzip, CompressionZstd:
+		return c, nil
+	case "none":
+		return CompressionNone, nil
+	}
+	return "", fmt.Errorf("unknown compression %q (want gzip, zstd, or none)", s)
+}
+
+// WithCompression compresses the input of Encode and EncodeReader before
+// it is split into chunks, which takes far fewer chunks for text. The
+// method is recorded in the manifest, and everything that reads an archive
+// decompresses it.
+func WithCompression(c Compression) Option {
+	return func(o *options) {
+		o.compression = c
+	}
+}
+
+// compressBound is the most a stream of size bytes can grow when
+// compressed, or -1 if size is unknown.
+func compressBound(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	return size + size/256 + 64
+}
+
+// compressReader returns the compressed stream of r. Both formats allow
+// concatenated streams, which is how Append extends a compressed archive.
+// Closing the returned reader stops the compression.
+func compressReader(r io.Reader, c Compression) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.WriteCloser
+		switch c {
+		case CompressionGzip:
+			w = gzip.NewWriter(pw)
+		case CompressionZstd:
+			// One encoder goroutine keeps the output the same from run to
+			// run, so a resumed encode produces the chunks it stored before.
+			zw, err := zstd.NewWriter(pw, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			w = zw
+		default:
+			pw.CloseWithError(fmt.Errorf("unknown compression %q", c))
+			return
+		}
+		_, err := io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func decompressReader(r io.Reader, c Compression) (io.Reader, error) {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", c)
+}
+
+// decompressTo returns a writer that decompresses what is written to it
+// into w. Close waits for the last of it and reports whether the stream
+// was valid; it may be called more than once. Without compression, writes go straight to w.
+func decompressTo(w io.Writer, c Compression) io.WriteCloser {
+	if c == CompressionNone {
+		return nopWriteCloser{w}
+	}
+	pr, pw := io.Pipe()
+	d := &decompressWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		r, err := decompressReader(pr, c)
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		if err == nil {
+			// Trailing data after the last stream is an error too.
+			var n int64
+			if n, err = io.Copy(io.Discard, pr); err == nil && n > 0 {
+				err = fmt.Errorf("%d bytes after the end of the %s stream", n, c)
+			}
+		}
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d
+}
+
+type decompressWriter struct {
+	pw    *io.PipeWriter
+	done  chan error
+	close sync.Once
+	err   error
+}
+
+func (d *decompressWriter) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+func (d *decompressWriter) Close() error {
+	d.close.Do(func() {
+		d.pw.Close()
+		d.err = <-d.done
+	})
+	return d.err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
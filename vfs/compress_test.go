@@ -0,0 +1,109 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func textPayload(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, `{"id": %d, "status": "ok", "tags": ["alpha", "beta"]}`+"\n", i)
+	}
+	return buf.Bytes()[:n]
+}
+
+func TestCompression_RoundTrip(t *testing.T) {
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(string(c), func(t *testing.T) {
+			fake := newFakeS3()
+			v := newTestVFS(fake)
+			v.compression = c
+			ctx := context.Background()
+			const uri = "s3://bucket/json/"
+			data := textPayload(50_000)
+			if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			plain := int(chunkCount(int64(len(data)), calculateChunkSize("json/")))
+			if n := len(fake.keys("bucket")) - 1; n*5 > plain {
+				t.Errorf("expected far fewer than %d chunks, got %d", plain, n)
+			}
+
+			reader := newTestVFS(fake) // decompression needs no option
+			var out bytes.Buffer
+			if err := reader.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("expected the file back, got %d bytes, %v", out.Len(), err)
+			}
+			sum := sha256.Sum256(data)
+			if got, err := reader.Checksum(ctx, uri); err != nil || got != hex.EncodeToString(sum[:]) {
+				t.Errorf("expected the checksum of the file, got %s, %v", got, err)
+			}
+			r, err := reader.Verify(ctx, uri)
+			if err != nil || !r.OK() {
+				t.Fatalf("expected verify to pass, got %+v, %v", r, err)
+			}
+			if m := r.Manifest; m.Compression != c || m.Size != int64(len(data)) || m.StoredSize == 0 || m.StoredSize >= m.Size {
+				t.Errorf("unexpected manifest %+v", m)
+			}
+		})
+	}
+}
+
+func TestCompression_Append(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.compression = CompressionZstd
+	ctx := context.Background()
+	const uri = "s3://bucket/log/"
+	first, second := textPayload(5000), textPayload(3000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(first), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(ctx, writeTempFile(t, second), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), append(first, second...)) {
+		t.Errorf("expected both parts back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestCompression_WithEncryptionAndResume(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	v.compression = CompressionGzip
+	ctx := context.Background()
+	const uri = "s3://bucket/both/"
+	data := append(textPayload(20_000), randomBytes(5000)...)
+	input := writeTempFile(t, data)
+
+	failAfter(fake, 4)
+	if err := v.Encode(ctx, input, uri, false); err == nil {
+		t.Fatal("expected the first encode to fail")
+	}
+	fake.putHook = nil
+	v.resume = true
+	if err := v.Encode(ctx, input, uri, false); err != nil {
+		t.Fatalf("resumed encode failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for in, want := range map[string]Compression{"gzip": CompressionGzip, "zstd": CompressionZstd, "none": CompressionNone} {
+		if got, err := ParseCompression(in); err != nil || got != want {
+			t.Errorf("ParseCompression(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Error("expected an unknown method to be rejected")
+	}
+}
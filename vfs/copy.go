@@ -0,0 +1,304 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Copy duplicates the archive at srcURI to dstURI, which may be in another
+// bucket or on another backend, without restoring it. Key-only chunks are
+// recreated from their names, and body parts are copied server-side where
+// the backend can, for example with S3 CopyObject. Obfuscated chunks are
+// keyed by their prefix, so they are decoded and re-encoded for the
+// destination. Like EncodeReader, Copy fails with ErrPrefixExists if the
+// destination already holds data.
+func (v *VFS) Copy(ctx context.Context, srcURI, dstURI string) error {
+	if err := checkDistinct(srcURI, dstURI); err != nil {
+		return err
+	}
+	dst, dstPrefix, err := v.open(ctx, dstURI)
+	if err != nil {
+		return err
+	}
+	if err := v.clearPrefix(ctx, dst, dstPrefix, dstURI, false); err != nil {
+		return err
+	}
+	return v.copyArchive(ctx, srcURI, dstURI)
+}
+
+// copyArchive copies the archive at srcURI to dstURI without checking
+// that the destination is empty. Chunks are listed before any is written,
+// so dstURI may lie below srcURI.
+func (v *VFS) copyArchive(ctx context.Context, srcURI, dstURI string) error {
+	src, srcPrefix, err := v.open(ctx, srcURI)
+	if err != nil {
+		return err
+	}
+	dst, dstPrefix, err := v.open(ctx, dstURI)
+	if err != nil {
+		return err
+	}
+	chunks, m, err := v.archiveChunks(ctx, src, srcPrefix)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("%w at %s", ErrNoChunks, srcURI)
+	}
+	if m.deduped() {
+		return v.copyDeduped(ctx, src, dst, srcPrefix, dstPrefix, m)
+	}
+
+	srcData, err := v.chunkPrefix(ctx, src, srcPrefix)
+	if err != nil {
+		return err
+	}
+	if m.parity() != nil {
+		shards, err := listParity(ctx, src, srcData, m.bodies())
+		if err != nil {
+			return err
+		}
+		m.seal(srcPrefix, shards)
+		chunks = append(chunks, shards...)
+	}
+	split := srcData != srcPrefix
+	dstData := dstPrefix
+	if split {
+		dstData = dstPrefix + dataDir
+	}
+	limit := dst.KeyLimit(dstData)
+	for _, c := range chunks {
+		if name := strings.TrimPrefix(c.key, srcData); len(name) > limit {
+			return fmt.Errorf("%w: chunk %d of %s, under %s; restore and encode it instead", errChunkTooLong, c.index, srcURI, dstURI)
+		}
+	}
+
+	var enc *Encryption
+	if m != nil {
+		enc = m.Encryption
+	}
+	staging := metaKey(dstPrefix, dstData, stagingName)
+	if err := v.beginStaging(ctx, dst, staging, enc); err != nil {
+		return err
+	}
+	if err := v.copyChunks(ctx, OpCopy, src, dst, srcData, dstData, chunks, m.recoder(dstPrefix)); err != nil {
+		return err
+	}
+	if split {
+		if err := v.writeLayoutMarker(ctx, dst, dstPrefix); err != nil {
+			return err
+		}
+	}
+	if m != nil {
+		if err := v.writeManifest(ctx, dst, manifestKey(dstPrefix, dstData), m); err != nil {
+			return err
+		}
+	}
+	return dst.Delete(ctx, []string{staging})
+}
+
+// errChunkTooLong is returned by Copy when a chunk name is too long for a
+// key under the destination prefix.
+var errChunkTooLong = errors.New("chunk does not fit in a key")
+
+// reencode writes the archive at srcURI to dstURI by restoring it through
+// a pipe into an encode with the VFS's own settings, for when its chunks
+// cannot be copied as they are. Nothing is written to local disk. The
+// file name, attributes, and expiry of the source manifest are kept.
+func (v *VFS) reencode(ctx context.Context, srcURI, dstURI string) error {
+	m, err := v.Stat(ctx, srcURI)
+	if err != nil && !errors.Is(err, ErrNoManifest) {
+		return err
+	}
+	file, size := Manifest{}, int64(-1)
+	if m != nil {
+		file, size = Manifest{Filename: m.Filename, Tar: m.Tar, Attrs: m.Attrs, Expires: m.Expires}, m.Size
+	}
+	b, prefix, err := v.open(ctx, dstURI)
+	if err != nil {
+		return err
+	}
+	v.debug(ctx, "re-encoding", "src", srcURI, "dst", dstURI)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(v.RestoreWriter(ctx, srcURI, pw))
+	}()
+	defer pr.Close()
+	return v.encode(ctx, b, prefix, pr, size, file)
+}
+
+// checkDistinct fails if one of two archive URIs lies within the other,
+// where copying would read its own output.
+func checkDistinct(a, b string) error {
+	as, ab, ap, err := parseURI(a)
+	if err != nil {
+		return err
+	}
+	bs, bb, bp, err := parseURI(b)
+	if err != nil {
+		return err
+	}
+	if as == bs && ab == bb && (strings.HasPrefix(ap, bp) || strings.HasPrefix(bp, ap)) {
+		return fmt.Errorf("%s and %s overlap", a, b)
+	}
+	return nil
+}
+
+// recoder returns the codec chunks of the archive m describes need when
+// they move to prefix, or nil if their stored bytes can be kept.
+func (m *Manifest) recoder(prefix string) chunkCodec {
+	if m == nil || !m.Obfuscated {
+		return nil
+	}
+	return m.codec(prefix)
+}
+
+// copyChunks recreates chunks stored under srcData on src under dstData on
+// dst, in parallel. If recode is not nil, each chunk is decoded and stored
+// again through it.
+func (v *VFS) copyChunks(ctx context.Context, op Op, src, dst Backend, srcData, dstData string, chunks []storedChunk, recode chunkCodec) error {
+	v.emit(Event{Op: op, Kind: EventStart, Total: len(chunks)})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	var done int
+	for _, c := range chunks {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		v.limiter.acquire()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := v.withBackoff(ctx, func() error { return copyChunk(ctx, src, dst, c, srcData, dstData, recode) })
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("chunk %d: %w", c.index, err)
+				}
+				return
+			}
+			done++
+			v.emit(Event{Op: op, Kind: EventChunk, Index: c.index, Done: done, Total: len(chunks)})
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	v.emit(Event{Op: op, Kind: EventDone, Done: done, Total: len(chunks)})
+	return nil
+}
+
+func copyChunk(ctx context.Context, src, dst Backend, c storedChunk, srcData, dstData string, recode chunkCodec) error {
+	if recode != nil {
+		data, err := c.decode()
+		if err != nil {
+			return err
+		}
+		format := keyFormat{checksum: c.checksum != "", width: c.width, codec: recode, body: c.load != nil}
+		key, body := format.object(dstData, c.index, data)
+		return dst.Put(ctx, key, body)
+	}
+	key := dstData + strings.TrimPrefix(c.key, srcData)
+	if c.load == nil {
+		return dst.Put(ctx, key, nil)
+	}
+	return copyObject(ctx, src, c.key, dst, key)
+}
+
+// objectCopier is implemented by backends that can copy an object from
+// another backend of the same kind without downloading it.
+type objectCopier interface {
+	// copyObject copies srcKey of src to dstKey, reporting false if it
+	// cannot copy from src.
+	copyObject(ctx context.Context, src Backend, srcKey, dstKey string) (bool, error)
+}
+
+// copyObject copies srcKey of src to dstKey of dst, server-side if dst
+// supports it.
+func copyObject(ctx context.Context, src Backend, srcKey string, dst Backend, dstKey string) error {
+	if c, ok := dst.(objectCopier); ok {
+		if copied, err := c.copyObject(ctx, src, srcKey, dstKey); copied || err != nil {
+			return err
+		}
+	}
+	body, err := src.Get(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	return dst.Put(ctx, dstKey, body)
+}
+
+// Move copies the archive at srcURI to dstURI like Copy, verifies the copy,
+// and only then deletes the source. Archives nested below srcURI and the
+// versions and snapshots of each are moved along, as by Transfer, since
+// deleting the source takes them too. If a copy does not verify, the source
+// is left in place and Move fails with ErrManifestMismatch. An archive
+// written before manifests is moved only if nothing is nested in it; Migrate
+// it first otherwise.
+func (v *VFS) Move(ctx context.Context, srcURI, dstURI string) error {
+	t, err := v.openTransfer(ctx, srcURI, dstURI)
+	if err != nil {
+		return err
+	}
+	dirs, err := transferDirs(ctx, t.src, t.srcPrefix)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 || len(dirs) == 1 && dirs[0] == "" {
+		return v.moveArchive(ctx, srcURI, dstURI)
+	}
+	if dirs[0] != "" {
+		return fmt.Errorf("%w at %s, which holds other archives; run Migrate first", ErrNoManifest, srcURI)
+	}
+	if exists, err := v.hasObjects(ctx, t.dst, t.dstPrefix); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("%w: %s", ErrPrefixExists, dstURI)
+		}
+		return err
+	}
+	for _, dir := range dirs {
+		if _, err := v.transferArchive(ctx, t, dir, false); err != nil {
+			return fmt.Errorf("%w; %s was kept", err, srcURI)
+		}
+	}
+	return v.Delete(ctx, srcURI)
+}
+
+// moveArchive moves the single archive at srcURI, which may have no
+// manifest, to dstURI.
+func (v *VFS) moveArchive(ctx context.Context, srcURI, dstURI string) error {
+	if err := v.Copy(ctx, srcURI, dstURI); err != nil {
+		return err
+	}
+	r, err := v.Verify(ctx, dstURI)
+	if err != nil {
+		return err
+	}
+	if !r.OK() {
+		return fmt.Errorf("%w: copy at %s: %s; %s was kept", ErrManifestMismatch, dstURI, strings.Join(r.Problems, "; "), srcURI)
+	}
+	if r.Manifest == nil {
+		// Without a manifest, compare the copy with the source itself.
+		sum, err := v.Checksum(ctx, srcURI)
+		if err != nil {
+			return err
+		}
+		if sum != r.SHA256 {
+			return fmt.Errorf("%w: copy at %s differs from %s, which was kept", ErrManifestMismatch, dstURI, srcURI)
+		}
+	}
+	return v.Delete(ctx, srcURI)
+}
@@ -0,0 +1,192 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestCopy_KeyOnlyAndSplit(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.splitLayout = true
+	ctx := context.Background()
+	data := randomBytes(5000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/src/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Copy(ctx, "s3://bucket/src/", "s3://other/dst/"); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if len(fake.keys("other")) != len(fake.keys("bucket")) {
+		t.Errorf("expected the same objects in both buckets, got %d and %d", len(fake.keys("other")), len(fake.keys("bucket")))
+	}
+	var out bytes.Buffer
+	if err := newTestVFS(fake).RestoreWriter(ctx, "s3://other/dst/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("expected the copy to restore, got %d bytes, %v", out.Len(), err)
+	}
+	if err := v.Copy(ctx, "s3://bucket/src/", "s3://other/dst/"); !errors.Is(err, ErrPrefixExists) {
+		t.Errorf("expected ErrPrefixExists copying onto an archive, got %v", err)
+	}
+}
+
+func TestCopy_BodyPartsServerSide(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	v.bodyThreshold = 1
+	ctx := context.Background()
+	data := randomBytes(bodyChunkSize + 10)
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/big/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Copy(ctx, "s3://bucket/big/", "s3://bucket/big-copy/"); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if fake.copies != 2 {
+		t.Errorf("expected both parts to be copied with CopyObject, got %d copies", fake.copies)
+	}
+	if r, err := v.Verify(ctx, "s3://bucket/big-copy/"); err != nil || !r.OK() {
+		t.Errorf("expected the copy to verify, got %+v, %v", r, err)
+	}
+}
+
+func TestCopy_ReencodesObfuscatedChunks(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.obfuscate = true
+	ctx := context.Background()
+	data := randomBytes(3000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/a/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Copy(ctx, "s3://bucket/a/", "s3://bucket/b/"); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/b/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the copy to restore, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestCopy_RefusesOverlap(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	if err := v.Copy(context.Background(), "s3://bucket/a/", "s3://bucket/a/b/"); err == nil {
+		t.Error("expected copying into the source to fail")
+	}
+}
+
+func TestCopy_FailsWhenKeysDoNotFit(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(2000)), "s3://bucket/s/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	before := len(fake.keys("bucket"))
+	long := "s3://bucket/" + strings.Repeat("x", 200) + "/"
+	if err := v.Copy(ctx, "s3://bucket/s/", long); err == nil {
+		t.Error("expected a copy under a longer prefix to fail")
+	}
+	if n := len(fake.keys("bucket")); n != before {
+		t.Errorf("expected nothing to be written, got %d keys", n)
+	}
+}
+
+func TestMove_DeletesSourceAfterVerifying(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	data := randomBytes(4000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/old/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Move(ctx, "s3://bucket/old/", "s3://bucket/new/"); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	if exists, _ := v.hasObjects(ctx, &s3Backend{client: fake, bucket: "bucket"}, "old/"); exists {
+		t.Error("expected the source to be deleted")
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/new/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the moved file back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestMove_KeepsSourceWhenCopyIsBad(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(4000)), "s3://bucket/old/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	// Drop one chunk as it is copied.
+	var dropped bool
+	fake.onPut = func(in *s3.PutObjectInput) {
+		if !dropped && strings.HasPrefix(*in.Key, "new/000002-") {
+			delete(fake.objects, "bucket/"+*in.Key)
+			dropped = true
+		}
+	}
+	if err := v.Move(ctx, "s3://bucket/old/", "s3://bucket/new/"); !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("expected ErrManifestMismatch, got %v", err)
+	}
+	if exists, _ := v.hasObjects(ctx, &s3Backend{client: fake, bucket: "bucket"}, "old/"); !exists {
+		t.Error("expected the source to be kept")
+	}
+}
+
+func TestMove_TakesNestedArchivesAndHistoryAlong(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.versioning = true
+	ctx := context.Background()
+	old, current, nested := randomBytes(300), randomBytes(4000), randomBytes(500)
+	for _, data := range [][]byte{old, current} {
+		if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/a/", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(nested), "s3://bucket/a/sub/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Move(ctx, "s3://bucket/a/", "s3://bucket/b/"); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	for uri, want := range map[string][]byte{"s3://bucket/b/": current, "s3://bucket/b/sub/": nested} {
+		var out bytes.Buffer
+		if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("expected %s moved, got %d bytes, %v", uri, out.Len(), err)
+		}
+	}
+	versions, err := v.Versions(ctx, "s3://bucket/b/")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected the version moved along, got %+v, %v", versions, err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, versions[0].URI, &out); err != nil || !bytes.Equal(out.Bytes(), old) {
+		t.Errorf("expected the old generation at %s, got %d bytes, %v", versions[0].URI, out.Len(), err)
+	}
+	if exists, _ := v.hasObjects(ctx, &s3Backend{client: fake, bucket: "bucket"}, "a/"); exists {
+		t.Error("expected the source to be deleted")
+	}
+}
+
+func TestMove_RefusesOccupiedDestinationForTrees(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	for _, uri := range []string{"s3://bucket/a/", "s3://bucket/a/sub/", "s3://bucket/b/"} {
+		if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(300)), uri, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Move(ctx, "s3://bucket/a/", "s3://bucket/b/"); !errors.Is(err, ErrPrefixExists) {
+		t.Fatalf("expected ErrPrefixExists, got %v", err)
+	}
+	if _, err := v.Stat(ctx, "s3://bucket/a/sub/"); err != nil {
+		t.Errorf("expected the source kept, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package vfs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Chunk data lives in key names, which anyone allowed to list the bucket
+// can read. An encrypted archive seals every chunk with AES-256-GCM under a
+// random per-file data key before it is base64-encoded into its key, and
+// seals its manifest the same way. The data key is stored wrapped in the
+// manifest (and in the staging marker, so an interrupted upload can be
+// resumed), wrapped either under a passphrase or by AWS KMS. An empty
+// marker object next to the manifest says the chunks are sealed, so an
+// archive that lost its manifest is not restored as ciphertext.
+const (
+	encryptionAlgorithm = "AES-256-GCM"
+	encryptedName       = ".vfs-encrypted"
+	dataKeyLen          = 32
+	// sealOverhead is what sealing adds to a chunk: nonce and tag.
+	sealOverhead = 12 + 16
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	ErrEncrypted = errors.New("archive is encrypted and no key was given")
+	ErrDecrypt   = errors.New("cannot decrypt: wrong key or damaged data")
+)
+
+// Encryption describes how an archive's data key is wrapped and how its
+// chunks are sealed with it.
+type Encryption struct {
+	Algorithm  string `json:"algorithm"`
+	KDF        string `json:"kdf,omitempty"` // "scrypt" for passphrases
+	Salt       []byte `json:"salt,omitempty"`
+	KMSKeyID   string `json:"kms_key_id,omitempty"`
+	WrappedKey []byte `json:"wrapped_key"`
+	// IndexAD is set for archives whose chunks are sealed with their index
+	// as associated data, so chunks moved to another index fail to decrypt.
+	// Archives encrypted before it was added are read without.
+	IndexAD bool `json:"index_ad,omitempty"`
+}
+
+// keyWrapper protects data keys with a secret the caller holds.
+type keyWrapper interface {
+	wrap(ctx context.Context, dataKey []byte) (*Encryption, error)
+	unwrap(ctx context.Context, e *Encryption) ([]byte, error)
+}
+
+// WithPassphrase encrypts new archives with a key derived from passphrase
+// (the contents of a key file work as well) and decrypts archives that
+// were encrypted with it. Archives stored without encryption still read as
+// before.
+func WithPassphrase(passphrase []byte) Option {
+	return func(o *options) {
+		o.keys = passphraseWrapper{passphrase: passphrase}
+	}
+}
+
+// passphraseWrapper wraps data keys under a key derived from a passphrase
+// with scrypt and a random salt per archive.
+type passphraseWrapper struct {
+	passphrase []byte
+}
+
+func (p passphraseWrapper) wrap(_ context.Context, dataKey []byte) (*Encryption, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	kek, err := p.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryption{Algorithm: encryptionAlgorithm, KDF: "scrypt", Salt: salt, WrappedKey: encrypt(kek, dataKey, nil)}, nil
+}
+
+func (p passphraseWrapper) unwrap(_ context.Context, e *Encryption) ([]byte, error) {
+	if e.KDF != "scrypt" {
+		return nil, fmt.Errorf("archive key is not protected by a passphrase (kdf %q)", e.KDF)
+	}
+	kek, err := p.derive(e.Salt)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := decrypt(kek, e.WrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: the passphrase does not unlock the archive key", ErrDecrypt)
+	}
+	return dataKey, nil
+}
+
+func (p passphraseWrapper) derive(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(p.passphrase, salt, scryptN, scryptR, scryptP, dataKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext under a fresh random nonce, which it prepends.
+// encrypt seals plaintext under a random nonce, authenticating ad along
+// with it. ad may be nil.
+func encrypt(aead cipher.AEAD, plaintext, ad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return aead.Seal(nonce, nonce, plaintext, ad)
+}
+
+func decrypt(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// archiveKey returns the data key to encrypt a new archive at prefix with,
+// or nil if the VFS does not encrypt. When resuming or delta encoding, the
+// key of the earlier upload is reused, so the chunks already stored still
+// match.
+func (v *VFS) archiveKey(ctx context.Context, b Backend, prefix, dataPrefix string) (cipher.AEAD, *Encryption, error) {
+	if v.keys == nil {
+		return nil, nil, nil
+	}
+	if v.resume || v.delta {
+		e, err := v.storedEncryption(ctx, b, prefix, dataPrefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		if e != nil {
+			aead, err := v.unwrapKey(ctx, e)
+			if err == nil || v.resume {
+				return aead, e, err
+			}
+			// The archive being replaced has another key, so none of
+			// its chunks can be reused anyway.
+		}
+	}
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	e, err := v.keys.wrap(ctx, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	e.IndexAD = true
+	aead, err := newAEAD(dataKey)
+	return aead, e, err
+}
+
+// checkPlainChunks fails with ErrNoManifest if the archive at prefix,
+// which has no manifest, marks its chunks as encrypted: without the
+// manifest they cannot be decrypted, only its import can bring it back.
+func (v *VFS) checkPlainChunks(ctx context.Context, b Backend, prefix, dataPrefix string) error {
+	encrypted, err := v.hasObjects(ctx, b, metaKey(prefix, dataPrefix, encryptedName))
+	if err != nil || !encrypted {
+		return err
+	}
+	return fmt.Errorf("%w at %s, whose chunks are encrypted: import it again (see ImportManifest)", ErrNoManifest, prefix)
+}
+
+// storedEncryption returns the wrapped key recorded by an earlier upload to
+// prefix, from its staging marker or else its manifest, or nil if there is
+// none.
+func (v *VFS) storedEncryption(ctx context.Context, b Backend, prefix, dataPrefix string) (*Encryption, error) {
+	for _, name := range []string{stagingName, manifestName} {
+		body, err := b.Get(ctx, metaKey(prefix, dataPrefix, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var header struct {
+			Encryption *Encryption `json:"encryption"`
+		}
+		if err := json.Unmarshal(body, &header); err != nil {
+			return nil, fmt.Errorf("invalid %s under %s: %w", name, prefix, err)
+		}
+		return header.Encryption, nil
+	}
+	return nil, nil
+}
+
+// unwrapKey returns the cipher for the data key e wraps. Keys wrapped by
+// KMS need no configured secret, only permission to use the KMS key.
+func (v *VFS) unwrapKey(ctx context.Context, e *Encryption) (cipher.AEAD, error) {
+	if e.Algorithm != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+	}
+	keys := v.keys
+	if e.KMSKeyID != "" {
+		keys = kmsWrapper{keyID: e.KMSKeyID, client: v.kms}
+	}
+	if keys == nil {
+		return nil, ErrEncrypted
+	}
+	dataKey, err := keys.unwrap(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(dataKey)
+}
@@ -0,0 +1,177 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newEncryptedTestVFS(fake *fakeS3, passphrase string) *VFS {
+	v := newTestVFS(fake)
+	v.keys = passphraseWrapper{passphrase: []byte(passphrase)}
+	return v
+}
+
+func TestEncrypt_RoundTrip(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "correct horse")
+	ctx := context.Background()
+	const uri = "s3://bucket/secret/"
+	data := bytes.Repeat([]byte("attack at dawn "), 200)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	plain := base64.RawURLEncoding.EncodeToString(data[:30])
+	for _, k := range fake.keys("bucket") {
+		if strings.Contains(k, plain) {
+			t.Errorf("expected no plaintext in key %s", k[:40])
+		}
+	}
+	manifest := string(fake.objects["bucket/secret/"+manifestName])
+	if !strings.Contains(manifest, `"sealed"`) || strings.Contains(manifest, hex.EncodeToString(sum[:])) {
+		t.Errorf("expected a sealed manifest, got %s", manifest)
+	}
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+	if got, err := v.Checksum(ctx, uri); err != nil || got != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the plaintext checksum, got %s, %v", got, err)
+	}
+	if r, err := v.Verify(ctx, uri); err != nil || !r.OK() {
+		t.Errorf("expected verify to pass, got %+v, %v", r, err)
+	}
+}
+
+func TestEncrypt_NeedsTheRightKey(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	const uri = "s3://bucket/locked/"
+	if err := newEncryptedTestVFS(fake, "right").EncodeReader(ctx, bytes.NewReader(randomBytes(2000)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := newTestVFS(fake).RestoreWriter(ctx, uri, io.Discard); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted without a key, got %v", err)
+	}
+	if err := newEncryptedTestVFS(fake, "wrong").RestoreWriter(ctx, uri, io.Discard); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt with the wrong key, got %v", err)
+	}
+}
+
+func TestEncrypt_ResumeReusesDataKey(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	ctx := context.Background()
+	const uri = "s3://bucket/resume/"
+	data := randomBytes(10 * calculateChunkSize("resume/"))
+	input := writeTempFile(t, data)
+
+	failAfter(fake, 5)
+	if err := v.Encode(ctx, input, uri, false); err == nil {
+		t.Fatal("expected the first encode to fail")
+	}
+	fake.putHook = nil
+	v.resume = true
+	if err := v.Encode(ctx, input, uri, false); err != nil {
+		t.Fatalf("resumed encode failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the file back after resuming, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestEncrypt_Append(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	ctx := context.Background()
+	const uri = "s3://bucket/log/"
+	first, second := randomBytes(1000), randomBytes(700)
+	if err := v.EncodeReader(ctx, bytes.NewReader(first), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(ctx, writeTempFile(t, second), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), append(first, second...)) {
+		t.Errorf("expected both parts back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestEncrypt_BindsChunksToTheirIndex(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	v.maxChunkSize = 1000
+	ctx := context.Background()
+	const uri = "s3://bucket/swapped/"
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	// Trade the indices of the first two chunks; their checksums still
+	// match their data.
+	var first, second string
+	for _, k := range fake.keys("bucket") {
+		if c, ok := parseChunkName(strings.TrimPrefix(k, "swapped/")); ok && c.index == 1 {
+			first = k
+		} else if ok && c.index == 2 {
+			second = k
+		}
+	}
+	if first == "" || second == "" {
+		t.Fatalf("expected two chunks, got %v", fake.keys("bucket"))
+	}
+	index := func(k string) string { i, _, _ := strings.Cut(k, "-"); return i }
+	rest := func(k string) string { _, r, _ := strings.Cut(k, "-"); return r }
+	delete(fake.objects, "bucket/"+first)
+	delete(fake.objects, "bucket/"+second)
+	fake.objects["bucket/"+index(second)+"-"+rest(first)] = nil
+	fake.objects["bucket/"+index(first)+"-"+rest(second)] = nil
+	if err := v.RestoreWriter(ctx, uri, io.Discard); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt for chunks at the wrong index, got %v", err)
+	}
+}
+
+func TestEncrypt_ReadsChunksSealedWithoutIndex(t *testing.T) {
+	aead, err := newAEAD(make([]byte, dataKeyLen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("sealed before chunks were bound to their index")
+	sealed := gcmCodec{aead: aead}.encode(2, data)
+	old := &Manifest{Encryption: &Encryption{Algorithm: encryptionAlgorithm}, aead: aead}
+	if got, err := old.codec("p/").decode(2, sealed); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected an older archive's chunk to decrypt, got %q, %v", got, err)
+	}
+	bound := &Manifest{Encryption: &Encryption{Algorithm: encryptionAlgorithm, IndexAD: true}, aead: aead}
+	if _, err := bound.codec("p/").decode(2, sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("expected a chunk sealed without its index to fail under IndexAD, got %v", err)
+	}
+}
+
+func TestEncrypt_LostManifestIsNotRestoredAsCiphertext(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	ctx := context.Background()
+	const uri = "s3://bucket/lost/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(2000)), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	delete(fake.objects, "bucket/lost/"+manifestName)
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); !errors.Is(err, ErrNoManifest) || out.Len() != 0 {
+		t.Errorf("expected ErrNoManifest and no output, got %d bytes, %v", out.Len(), err)
+	}
+	if err := v.Migrate(ctx, uri); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("expected migrate to refuse sealed chunks, got %v", err)
+	}
+}
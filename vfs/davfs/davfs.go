@@ -0,0 +1,429 @@
+// Package davfs serves the catalog of named files under a vfs URI (see
+// VFS.EncodeNamed) as a WebDAV share, which Finder, Windows Explorer, and
+// most file managers open without extra software:
+//
+//	s, err := davfs.New(v, "s3://bucket/prefix/")
+//	http.ListenAndServe(":8080", s)
+//
+// The share is a single directory holding the catalog's files. A file is
+// decoded into a local cache the first time it is read. An upload is
+// stored by name once it has all arrived, and deleting or renaming a file
+// updates the catalog. Folders cannot be created.
+package davfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/internal/filecache"
+)
+
+// catalogTTL is how long a catalog read is reused. Clients send a burst of
+// requests for every folder they show; changes made through the share
+// are seen at once.
+const catalogTTL = 2 * time.Second
+
+// Server is an http.Handler serving the catalog under its root.
+type Server struct {
+	token    string
+	cacheDir string
+	dav      *webdav.Handler
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithToken makes the server answer only requests that carry the token as
+// the password of HTTP basic authentication, with any user name: file
+// managers ask for credentials but cannot send bearer tokens.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithCacheDir keeps decoded files in dir instead of vfs/files in the
+// user's cache directory.
+func WithCacheDir(dir string) Option {
+	return func(s *Server) {
+		s.cacheDir = dir
+	}
+}
+
+// New returns a server for the catalog of v at root, a URI such as
+// s3://bucket/prefix/.
+func New(v *vfs.VFS, root string, opts ...Option) (*Server, error) {
+	if !strings.Contains(root, "://") {
+		return nil, fmt.Errorf("invalid root %q: must start with <scheme>://, e.g. s3://", root)
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	cache, err := filecache.New(v, s.cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	s.dav = &webdav.Handler{
+		FileSystem: &fileSystem{v: v, root: root, cache: cache},
+		LockSystem: webdav.NewMemLS(),
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		if _, password, ok := r.BasicAuth(); !ok || password != s.token {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vfs"`)
+			http.Error(w, "missing or wrong password", http.StatusUnauthorized)
+			return
+		}
+	}
+	s.dav.ServeHTTP(w, r)
+}
+
+// fileSystem is the catalog at root as a webdav.FileSystem.
+type fileSystem struct {
+	v     *vfs.VFS
+	root  string
+	cache *filecache.Cache
+
+	mu    sync.Mutex
+	files []vfs.CatalogEntry
+	read  time.Time // when files was read, zero to read it again
+}
+
+// catalog returns the files of the catalog, read at most catalogTTL ago.
+// The catalog is plain JSON anyone with write access can edit, so entries
+// whose checksum is not a SHA-256 sum are left out: it names their cached
+// copy and goes into the ETag header.
+func (fsys *fileSystem) catalog(ctx context.Context) ([]vfs.CatalogEntry, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if time.Since(fsys.read) < catalogTTL {
+		return fsys.files, nil
+	}
+	files, err := fsys.v.Catalog(ctx, fsys.root)
+	if err != nil {
+		return nil, err
+	}
+	files = slices.DeleteFunc(files, func(e vfs.CatalogEntry) bool {
+		return !filecache.ValidSum(e.SHA256)
+	})
+	fsys.files, fsys.read = files, time.Now()
+	return files, nil
+}
+
+// changed makes the next call to catalog read it again.
+func (fsys *fileSystem) changed() {
+	fsys.mu.Lock()
+	fsys.read = time.Time{}
+	fsys.mu.Unlock()
+}
+
+// fileName returns the catalog name for a path of the share, or "" for
+// the share itself. Paths in folders do not exist.
+func fileName(op, name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if strings.Contains(name, "/") {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return name, nil
+}
+
+// lookup returns the catalog entry called name.
+func (fsys *fileSystem) lookup(ctx context.Context, op, name string) (vfs.CatalogEntry, error) {
+	files, err := fsys.catalog(ctx)
+	if err != nil {
+		return vfs.CatalogEntry{}, err
+	}
+	for _, e := range files {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return vfs.CatalogEntry{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (fsys *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: errors.ErrUnsupported}
+}
+
+func (fsys *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name, err := fileName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		files, err := fsys.catalog(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return rootInfo(files), nil
+	}
+	e, err := fsys.lookup(ctx, "stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{e}, nil
+}
+
+// OpenFile opens a file for reading, the share for listing, or a new
+// upload when flag allows writing.
+func (fsys *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name, err := fileName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if name == "" {
+			return nil, &fs.PathError{Op: "open", Path: "/", Err: fs.ErrPermission}
+		}
+		tmp, err := os.CreateTemp("", "vfs-webdav-*")
+		if err != nil {
+			return nil, err
+		}
+		return &upload{fsys: fsys, ctx: ctx, name: name, tmp: tmp, sum: sha256.New()}, nil
+	}
+	if name == "" {
+		files, err := fsys.catalog(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &dir{files: files}, nil
+	}
+	e, err := fsys.lookup(ctx, "open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{fsys: fsys, ctx: ctx, e: e}, nil
+}
+
+func (fsys *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	name, err := fileName("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return &fs.PathError{Op: "remove", Path: "/", Err: fs.ErrPermission}
+	}
+	defer fsys.changed()
+	err = fsys.v.DeleteNamed(ctx, fsys.root, name)
+	if errors.Is(err, vfs.ErrNotInCatalog) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// Rename stores the file again under the new name, from its cached copy,
+// then deletes it under the old one.
+func (fsys *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, err := fileName("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = fileName("rename", newName)
+	if err != nil {
+		return err
+	}
+	if oldName == "" || newName == "" {
+		return &fs.PathError{Op: "rename", Path: "/", Err: fs.ErrPermission}
+	}
+	e, err := fsys.lookup(ctx, "rename", oldName)
+	if err != nil {
+		return err
+	}
+	local, err := fsys.cache.Path(ctx, fsys.root+oldName+"/", e.SHA256)
+	if err != nil {
+		return err
+	}
+	defer fsys.changed()
+	if err := fsys.v.EncodeNamed(ctx, local, fsys.root, newName, true); err != nil {
+		return err
+	}
+	return fsys.v.DeleteNamed(ctx, fsys.root, oldName)
+}
+
+// fileInfo describes a file of the catalog. It supplies the content type
+// and ETag, which webdav would otherwise read the file for.
+type fileInfo struct{ e vfs.CatalogEntry }
+
+func (fi fileInfo) Name() string       { return fi.e.Name }
+func (fi fileInfo) Size() int64        { return fi.e.Size }
+func (fi fileInfo) Mode() os.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return fi.e.Added }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) ContentType(ctx context.Context) (string, error) {
+	if t := mime.TypeByExtension(path.Ext(fi.e.Name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+func (fi fileInfo) ETag(ctx context.Context) (string, error) {
+	return `"` + fi.e.SHA256 + `"`, nil
+}
+
+// rootInfo describes the share, last modified when its newest file was
+// added.
+type rootInfo []vfs.CatalogEntry
+
+func (fi rootInfo) Name() string      { return "/" }
+func (fi rootInfo) Size() int64       { return 0 }
+func (fi rootInfo) Mode() os.FileMode { return os.ModeDir | 0755 }
+func (fi rootInfo) IsDir() bool       { return true }
+func (fi rootInfo) Sys() any          { return nil }
+
+func (fi rootInfo) ModTime() time.Time {
+	var t time.Time
+	for _, e := range fi {
+		if e.Added.After(t) {
+			t = e.Added
+		}
+	}
+	return t
+}
+
+// dir lists the share.
+type dir struct {
+	files []vfs.CatalogEntry
+	pos   int
+}
+
+func (d *dir) Readdir(count int) ([]fs.FileInfo, error) {
+	rest := d.files[d.pos:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		rest = rest[:min(count, len(rest))]
+	}
+	d.pos += len(rest)
+	out := make([]fs.FileInfo, len(rest))
+	for i, e := range rest {
+		out[i] = fileInfo{e}
+	}
+	return out, nil
+}
+
+func (d *dir) Stat() (fs.FileInfo, error)                   { return rootInfo(d.files), nil }
+func (d *dir) Read([]byte) (int, error)                     { return 0, errors.New("is a directory") }
+func (d *dir) Write([]byte) (int, error)                    { return 0, errors.New("is a directory") }
+func (d *dir) Seek(offset int64, whence int) (int64, error) { return 0, errors.New("is a directory") }
+func (d *dir) Close() error                                 { return nil }
+
+// file reads a file of the catalog. It is decoded into the cache on the
+// first read, not on open: webdav opens files to describe them too.
+type file struct {
+	fsys  *fileSystem
+	ctx   context.Context
+	e     vfs.CatalogEntry
+	pos   int64
+	local *os.File
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.local == nil {
+		path, err := f.fsys.cache.Path(f.ctx, f.fsys.root+f.e.Name+"/", f.e.SHA256)
+		if err != nil {
+			return 0, err
+		}
+		if f.local, err = os.Open(path); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.local.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.e.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.local == nil {
+		return nil
+	}
+	return f.local.Close()
+}
+
+func (f *file) Stat() (fs.FileInfo, error)         { return fileInfo{f.e}, nil }
+func (f *file) Readdir(int) ([]fs.FileInfo, error) { return nil, errors.New("not a directory") }
+func (f *file) Write([]byte) (int, error)          { return 0, fs.ErrPermission }
+
+// upload spools a file being written to a temporary file, and stores it
+// by name when it is closed.
+type upload struct {
+	fsys *fileSystem
+	ctx  context.Context
+	name string
+	tmp  *os.File
+	sum  hash.Hash
+	size int64
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	n, err := u.tmp.Write(p)
+	u.sum.Write(p[:n])
+	u.size += int64(n)
+	return n, err
+}
+
+// Stat describes the file as it will be stored, so the ETag of the upload
+// matches later ones.
+func (u *upload) Stat() (fs.FileInfo, error) {
+	return fileInfo{vfs.CatalogEntry{
+		Name:   u.name,
+		Size:   u.size,
+		SHA256: hex.EncodeToString(u.sum.Sum(nil)),
+		Added:  time.Now().UTC(),
+	}}, nil
+}
+
+func (u *upload) Close() error {
+	defer os.Remove(u.tmp.Name())
+	if err := u.tmp.Close(); err != nil {
+		return err
+	}
+	defer u.fsys.changed()
+	return u.fsys.v.EncodeNamed(u.ctx, u.tmp.Name(), u.fsys.root, u.name, true)
+}
+
+func (u *upload) Read([]byte) (int, error) { return 0, errors.New("file is open for writing") }
+func (u *upload) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("file is open for writing")
+}
+func (u *upload) Readdir(int) ([]fs.FileInfo, error) { return nil, errors.New("not a directory") }
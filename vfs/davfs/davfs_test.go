@@ -0,0 +1,171 @@
+package davfs
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/memback"
+)
+
+const root = "mem://bucket/share/"
+
+func newTestServer(t *testing.T, opts ...Option) (*httptest.Server, *vfs.VFS) {
+	t.Helper()
+	v, err := vfs.New(vfs.WithBackend("mem", memback.New().Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(v, root, append(opts, WithCacheDir(t.TempDir()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts, v
+}
+
+func do(t *testing.T, method, url, body string, header ...string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(got)
+}
+
+func TestServer_PutGetList(t *testing.T) {
+	ts, v := newTestServer(t)
+	if code, body := do(t, "PUT", ts.URL+"/notes.txt", "hello"); code != http.StatusCreated {
+		t.Fatalf("PUT: expected 201, got %d: %s", code, body)
+	}
+	files, err := v.Catalog(context.Background(), root)
+	if err != nil || len(files) != 1 || files[0].Name != "notes.txt" {
+		t.Fatalf("expected notes.txt in the catalog, got %v, %v", files, err)
+	}
+
+	if code, body := do(t, "GET", ts.URL+"/notes.txt", ""); code != http.StatusOK || body != "hello" {
+		t.Errorf("GET: expected the file, got %d: %q", code, body)
+	}
+	if code, body := do(t, "GET", ts.URL+"/notes.txt", "", "Range", "bytes=1-3"); code != http.StatusPartialContent || body != "ell" {
+		t.Errorf("GET with a range: expected ell, got %d: %q", code, body)
+	}
+	code, body := do(t, "PROPFIND", ts.URL+"/", "", "Depth", "1")
+	if code != http.StatusMultiStatus || !strings.Contains(body, "/notes.txt") || !strings.Contains(body, files[0].SHA256) {
+		t.Errorf("PROPFIND: expected notes.txt with its checksum as ETag, got %d: %s", code, body)
+	}
+}
+
+func TestServer_MoveAndDelete(t *testing.T) {
+	ts, v := newTestServer(t)
+	do(t, "PUT", ts.URL+"/a", "data")
+	if code, body := do(t, "MOVE", ts.URL+"/a", "", "Destination", ts.URL+"/b"); code != http.StatusCreated {
+		t.Fatalf("MOVE: expected 201, got %d: %s", code, body)
+	}
+	if code, _ := do(t, "GET", ts.URL+"/a", ""); code != http.StatusNotFound {
+		t.Errorf("expected a gone after the move, got %d", code)
+	}
+	if code, body := do(t, "GET", ts.URL+"/b", ""); body != "data" {
+		t.Errorf("expected b to hold the data, got %d: %q", code, body)
+	}
+
+	if code, _ := do(t, "DELETE", ts.URL+"/b", ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE: expected 204, got %d", code)
+	}
+	if files, _ := v.Catalog(context.Background(), root); len(files) != 0 {
+		t.Errorf("expected an empty catalog, got %v", files)
+	}
+}
+
+func TestServer_NoFolders(t *testing.T) {
+	ts, _ := newTestServer(t)
+	if code, _ := do(t, "MKCOL", ts.URL+"/dir", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("MKCOL: expected 405, got %d", code)
+	}
+	if code, _ := do(t, "PUT", ts.URL+"/dir/a", "x"); code != http.StatusConflict {
+		t.Errorf("PUT in a folder: expected 409, got %d", code)
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	ts, _ := newTestServer(t, WithToken("secret"))
+	if code, _ := do(t, "PROPFIND", ts.URL+"/", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the password, got %d", code)
+	}
+	req, _ := http.NewRequest("PROPFIND", ts.URL+"/", nil)
+	req.SetBasicAuth("anyone", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Errorf("expected the password to be accepted, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_IgnoresCraftedChecksums(t *testing.T) {
+	store := memback.New()
+	v, err := vfs.New(vfs.WithBackend("mem", store.Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	input := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EncodeNamed(ctx, input, root, "notes.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	// Point the entry's checksum out of the cache directory before the
+	// server first reads the catalog.
+	files, err := v.Catalog(ctx, root)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one file, got %v, %v", files, err)
+	}
+	b, err := store.Open(ctx, "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := b.Get(ctx, "share/.vfs-catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = []byte(strings.Replace(string(body), files[0].SHA256, "../escaped", 1))
+	if err := b.Put(ctx, "share/.vfs-catalog", body); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := t.TempDir()
+	s, err := New(v, root, WithCacheDir(filepath.Join(parent, "cache")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	if code, _ := do(t, "GET", ts.URL+"/notes.txt", ""); code != http.StatusNotFound {
+		t.Errorf("GET: expected 404 for the crafted entry, got %d", code)
+	}
+	if code, body := do(t, "PROPFIND", ts.URL+"/", "", "Depth", "1"); strings.Contains(body, "notes.txt") {
+		t.Errorf("PROPFIND: expected the crafted entry left out, got %d: %s", code, body)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped")); err == nil {
+		t.Error("expected nothing written outside the cache")
+	}
+}
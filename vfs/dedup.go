@@ -0,0 +1,525 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// storageDedup marks archives whose chunks live in the bucket's pool,
+	// listed by hash in the manifest.
+	storageDedup = "dedup"
+	// poolDir holds deduplicated chunks at the bucket root:
+	// chunks/<sha256> holds a chunk, and refs/<sha256>/<archive> marks
+	// an archive that uses it.
+	poolDir = ".vfs-pool/"
+	// dedupChunkSize is how much of a file a pooled chunk holds, unless
+	// WithChunkSize sets another size.
+	dedupChunkSize = 1 << 20
+)
+
+// WithDedup stores new archives content-addressed: the file is cut into
+// chunks of 1 MiB (or the WithChunkSize size), each stored once per bucket
+// under .vfs-pool/ by its SHA-256, and the manifest lists the hashes in
+// order. Identical data within a file or across files in the bucket is
+// uploaded once. Every archive marks the chunks it uses, and deleting it
+// removes its marks; GC on the bucket root deletes the chunks left
+// without marks once they are older than GCOptions.OlderThan.
+//
+// Encryption and obfuscation make equal data differ, so they cannot be
+// combined with it.
+func WithDedup() Option {
+	return func(o *options) {
+		o.dedup = true
+	}
+}
+
+func (m *Manifest) deduped() bool {
+	return m != nil && m.Storage == storageDedup
+}
+
+func poolChunkKey(hash string) string {
+	return poolDir + "chunks/" + hash
+}
+
+// poolRefKey returns the key marking that the archive whose manifest is at
+// manifestKey uses the chunk hash.
+func poolRefKey(hash, manifestKey string) string {
+	id := sha256.Sum256([]byte(manifestKey))
+	return poolDir + "refs/" + hash + "/" + hex.EncodeToString(id[:16])
+}
+
+// encodeDedup is encode for WithDedup. An archive left at prefix by a
+// delta overwrite is replaced once the new one is committed; if the encode
+// fails before that, the marks it made are released and the old archive
+// reads as it did.
+func (v *VFS) encodeDedup(ctx context.Context, b Backend, prefix string, r io.Reader, size int64, file Manifest) (err error) {
+	detected, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	old, err := v.readManifest(ctx, b, manifestKey(prefix, detected))
+	if err != nil {
+		return err
+	}
+
+	dataPrefix := v.encodePrefix(prefix)
+	created := time.Now().UTC()
+	m := &file
+	m.Version, m.Created = manifestVersion, &created
+	if m.Expires == nil {
+		m.Expires = v.expiresAt(created)
+	}
+	m.Compression, m.Storage, m.ChunkSize = v.compression, storageDedup, dedupChunkSize
+	if v.maxChunkSize > 0 {
+		m.ChunkSize = v.maxChunkSize
+	}
+	streamSize := size
+	if m.Compression != CompressionNone {
+		streamSize = -1
+	}
+	key := manifestKey(prefix, dataPrefix)
+	staging := metaKey(prefix, dataPrefix, stagingName)
+	// Only a finished archive is rolled back to; an unfinished one stays
+	// staged for Cleanup or a resume.
+	finished := old != nil && v.checkCommitted(ctx, b, prefix, detected) == nil
+	if err := v.beginStaging(ctx, b, staging, nil); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	committed := false
+	if finished {
+		defer func() {
+			if err != nil && !committed {
+				err = v.undoDedup(ctx, b, manifestKey(prefix, detected), key, staging, old, seen, err)
+			}
+		}()
+	}
+
+	sum := sha256.New()
+	h := &countingWriter{w: sum}
+	stream := io.TeeReader(r, h)
+	if v.compression != CompressionNone {
+		compressed := compressReader(stream, v.compression)
+		defer compressed.Close()
+		stream = compressed
+	}
+	src := readerChunks(stream, m.ChunkSize, streamSize)
+	v.emit(Event{Op: OpEncode, Kind: EventStart, Total: src.total, BytesTotal: src.totalBytes})
+	var stored int64
+	p := v.newParallel(ctx)
+	for !p.failed() {
+		data, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.fail(err)
+			break
+		}
+		digest := sha256.Sum256(data)
+		hash := hex.EncodeToString(digest[:])
+		m.Hashes = append(m.Hashes, hash)
+		stored += int64(len(data))
+		index, n := len(m.Hashes), len(data)
+		if seen[hash] {
+			p.done(Event{Op: OpEncode, Kind: EventChunk, Index: index, Bytes: int64(n)})
+			continue
+		}
+		seen[hash] = true
+		p.run(func() error {
+			if err := v.pool(ctx, b, hash, key, func() ([]byte, error) { return data, nil }); err != nil {
+				return err
+			}
+			p.done(Event{Op: OpEncode, Kind: EventChunk, Index: index, Bytes: int64(n)})
+			return nil
+		})
+	}
+	if err := p.wait(); err != nil {
+		return err
+	}
+	m.Chunks = len(m.Hashes)
+	v.emit(Event{Op: OpEncode, Kind: EventDone, Done: m.Chunks, Total: m.Chunks, BytesDone: stored, BytesTotal: stored})
+	m.Size, m.SHA256 = h.n, hex.EncodeToString(sum.Sum(nil))
+	if m.Compression != CompressionNone {
+		m.StoredSize = stored
+	}
+
+	if v.splitLayout {
+		if err := v.writeLayoutMarker(ctx, b, prefix); err != nil {
+			return err
+		}
+	}
+	if err := v.writeManifest(ctx, b, key, m); err != nil {
+		return err
+	}
+	committed = true
+	switch {
+	case old.deduped():
+		if err := v.replaceDeduped(ctx, b, prefix, manifestKey(prefix, detected), key, old.Hashes, seen); err != nil {
+			return err
+		}
+	case old != nil:
+		if err := v.dropReplaced(ctx, b, prefix, detected, key); err != nil {
+			return err
+		}
+	}
+	return b.Delete(ctx, []string{staging})
+}
+
+// dropReplaced deletes the objects of the archive with its chunks under
+// detected that the deduplicated one whose manifest is at key replaced,
+// leaving that manifest, the staging marker, and nested archives alone.
+func (v *VFS) dropReplaced(ctx context.Context, b Backend, prefix, detected, key string) error {
+	markerDir := metaKey(prefix, detected, "")
+	if dir, split := strings.CutSuffix(markerDir, metaDir); split {
+		if err := deleteKeys(ctx, b, dir+dataDir, func(string) bool { return true }); err != nil {
+			return err
+		}
+		if !v.splitLayout {
+			if err := b.Delete(ctx, []string{prefix + layoutMarker}); err != nil {
+				return err
+			}
+		}
+	}
+	keep := map[string]bool{key: true, metaKey(prefix, v.encodePrefix(prefix), stagingName): true, prefix + layoutMarker: true}
+	return deleteKeys(ctx, b, markerDir, func(k string) bool {
+		return !keep[k] && !strings.Contains(strings.TrimPrefix(k, markerDir), "/")
+	})
+}
+
+// undoDedup rolls back a deduplicated encode that failed before its
+// manifest at key replaced the archive old, whose manifest is at oldKey:
+// the marks it made that old does not share are released and the staging
+// marker goes, so old reads as it did. It returns cause with anything
+// that went wrong on the way.
+func (v *VFS) undoDedup(ctx context.Context, b Backend, oldKey, key, staging string, old *Manifest, marked map[string]bool, cause error) error {
+	var shared map[string]bool
+	if oldKey == key && old.deduped() {
+		shared = make(map[string]bool, len(old.Hashes))
+		for _, hash := range old.Hashes {
+			shared[hash] = true
+		}
+	}
+	var added []string
+	for hash := range marked {
+		if !shared[hash] {
+			added = append(added, hash)
+		}
+	}
+	if err := v.release(ctx, b, key, added); err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to undo the encode: %w", err))
+	}
+	if err := b.Delete(ctx, []string{staging}); err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to undo the encode: %w", err))
+	}
+	return cause
+}
+
+// replaceDeduped releases what the archive whose manifest was at oldKey
+// marked once the one at key replaced it. In the same layout both share
+// their marks, so only the chunks key does not use are released;
+// otherwise every mark of oldKey goes, with the old manifest and layout
+// marker.
+func (v *VFS) replaceDeduped(ctx context.Context, b Backend, prefix, oldKey, key string, hashes []string, used map[string]bool) error {
+	if oldKey == key {
+		var dropped []string
+		for _, hash := range hashes {
+			if !used[hash] {
+				dropped = append(dropped, hash)
+			}
+		}
+		return v.release(ctx, b, key, dropped)
+	}
+	if err := v.release(ctx, b, oldKey, hashes); err != nil {
+		return err
+	}
+	stale := []string{oldKey}
+	if !v.splitLayout {
+		stale = append(stale, prefix+layoutMarker)
+	}
+	return b.Delete(ctx, stale)
+}
+
+// pool marks the chunk hash as used by the archive whose manifest is at
+// manifestKey, storing the chunk, fetched with get, if the pool of b does
+// not hold it yet. The mark goes first, and GC checks for marks again
+// right before it deletes a chunk, so only a GC between that check and
+// its delete can still take a chunk found here.
+func (v *VFS) pool(ctx context.Context, b Backend, hash, manifestKey string, get func() ([]byte, error)) error {
+	v.limiter.acquire()
+	if err := v.putWithBackoff(ctx, b, poolRefKey(hash, manifestKey), nil); err != nil {
+		return err
+	}
+	exists, err := v.hasObjects(ctx, b, poolChunkKey(hash))
+	if err != nil || exists {
+		return err
+	}
+	data, err := get()
+	if err != nil {
+		return err
+	}
+	v.limiter.acquire()
+	return v.putWithBackoff(ctx, b, poolChunkKey(hash), data)
+}
+
+// markPooled marks the chunks hashes in the pool of b as used by the
+// archive at uri whose manifest is at manifestKey, and returns them as a
+// set. If the pool lacks one, the marks it made are released again, all
+// but those of hashes in kept, which were there before, and markPooled
+// fails with ErrManifestMismatch.
+func (v *VFS) markPooled(ctx context.Context, b Backend, uri, manifestKey string, hashes []string, kept map[string]bool) (map[string]bool, error) {
+	marked := make(map[string]bool, len(hashes))
+	p := v.newParallel(ctx)
+	for _, hash := range hashes {
+		if p.failed() {
+			break
+		}
+		if hash == "" || !validSum(hash) {
+			p.fail(fmt.Errorf("invalid manifest: bad chunk hash %q", hash))
+			break
+		}
+		if marked[hash] {
+			continue
+		}
+		marked[hash] = true
+		p.run(func() error {
+			return v.pool(ctx, b, hash, manifestKey, func() ([]byte, error) {
+				return nil, fmt.Errorf("%w: pool chunk %s of %s is missing", ErrManifestMismatch, hash, uri)
+			})
+		})
+	}
+	if err := p.wait(); err != nil {
+		var added []string
+		for hash := range marked {
+			if !kept[hash] {
+				added = append(added, hash)
+			}
+		}
+		if rerr := v.release(ctx, b, manifestKey, added); rerr != nil {
+			return nil, errors.Join(err, rerr)
+		}
+		return nil, err
+	}
+	return marked, nil
+}
+
+// release removes the marks of the archive whose manifest is at
+// manifestKey from the chunks hashes. Chunks left unmarked stay for GC:
+// an encode marking one at the same time may already have found it.
+func (v *VFS) release(ctx context.Context, b Backend, manifestKey string, hashes []string) error {
+	seen := make(map[string]bool, len(hashes))
+	var marks []string
+	for _, hash := range hashes {
+		if !seen[hash] {
+			seen[hash] = true
+			marks = append(marks, poolRefKey(hash, manifestKey))
+		}
+	}
+	for len(marks) > 0 {
+		batch := marks[:min(len(marks), mirrorListPageSize)]
+		marks = marks[len(batch):]
+		if err := v.deleteWithBackoff(ctx, b, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseAll releases the chunks of every deduplicated archive under
+// prefix whose manifest key is not kept, before deleteUnder removes their
+// manifests.
+func (v *VFS) releaseAll(ctx context.Context, b Backend, prefix string, kept func(string) bool) error {
+	if pooled, err := v.hasObjects(ctx, b, poolDir); err != nil || !pooled {
+		return err
+	}
+	var manifests []string
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			if _, ok := archiveDir(obj.Key, prefix, manifestName); ok && !kept(obj.Key) {
+				manifests = append(manifests, obj.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range manifests {
+		if err := v.releaseManifest(ctx, b, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseManifest releases the chunks of the archive whose manifest is at
+// key, if it is deduplicated.
+func (v *VFS) releaseManifest(ctx context.Context, b Backend, key string) error {
+	m, err := v.readManifest(ctx, b, key)
+	if err != nil && !errors.Is(err, ErrEncrypted) {
+		return err
+	}
+	if !m.deduped() {
+		return nil
+	}
+	return v.release(ctx, b, key, m.Hashes)
+}
+
+// pooledChunks returns the chunks of the deduplicated archive m describes,
+// each fetched from the pool of b and checked against its hash when
+// decoded.
+func (m *Manifest) pooledChunks(ctx context.Context, b Backend) []storedChunk {
+	total := m.Size
+	if m.Compression != CompressionNone {
+		total = m.StoredSize
+	}
+	chunks := make([]storedChunk, len(m.Hashes))
+	for i, hash := range m.Hashes {
+		size := min(int64(m.ChunkSize), total-int64(i)*int64(m.ChunkSize))
+		chunks[i] = storedChunk{index: i + 1, key: poolChunkKey(hash), size: size, load: func() ([]byte, error) {
+			data, err := b.Get(ctx, poolChunkKey(hash))
+			if err != nil {
+				return nil, err
+			}
+			if digest := sha256.Sum256(data); hex.EncodeToString(digest[:]) != hash {
+				return nil, fmt.Errorf("%w: pooled chunk %s", ErrChunkChecksum, hash)
+			}
+			return data, nil
+		}}
+	}
+	return chunks
+}
+
+// copyDeduped is copyArchive for deduplicated archives: their chunks are
+// marked for the copy, and fetched into the pool of dst only if it lacks
+// them, so a copy within a bucket writes no chunk at all.
+func (v *VFS) copyDeduped(ctx context.Context, src, dst Backend, srcPrefix, dstPrefix string, m *Manifest) error {
+	srcData, err := v.chunkPrefix(ctx, src, srcPrefix)
+	if err != nil {
+		return err
+	}
+	split := srcData != srcPrefix
+	dstData := dstPrefix
+	if split {
+		dstData = dstPrefix + dataDir
+	}
+	key := manifestKey(dstPrefix, dstData)
+	staging := metaKey(dstPrefix, dstData, stagingName)
+	if err := v.beginStaging(ctx, dst, staging, nil); err != nil {
+		return err
+	}
+	if err := v.copyPooled(ctx, src, dst, key, m); err != nil {
+		return err
+	}
+	if split {
+		if err := v.writeLayoutMarker(ctx, dst, dstPrefix); err != nil {
+			return err
+		}
+	}
+	if err := v.writeManifest(ctx, dst, key, m); err != nil {
+		return err
+	}
+	return dst.Delete(ctx, []string{staging})
+}
+
+// copyPooled makes the deduplicated archive m describes, whose chunks are
+// in the pool of src, usable from the manifest at dstKey on dst.
+func (v *VFS) copyPooled(ctx context.Context, src, dst Backend, dstKey string, m *Manifest) error {
+	seen := make(map[string]bool, len(m.Hashes))
+	p := v.newParallel(ctx)
+	v.emit(Event{Op: OpCopy, Kind: EventStart, Total: len(m.Hashes)})
+	for i, hash := range m.Hashes {
+		if p.failed() {
+			break
+		}
+		if seen[hash] {
+			p.done(Event{Op: OpCopy, Kind: EventChunk, Index: i + 1})
+			continue
+		}
+		seen[hash] = true
+		p.run(func() error {
+			get := func() ([]byte, error) { return src.Get(ctx, poolChunkKey(hash)) }
+			if err := v.pool(ctx, dst, hash, dstKey, get); err != nil {
+				return err
+			}
+			p.done(Event{Op: OpCopy, Kind: EventChunk, Index: i + 1})
+			return nil
+		})
+	}
+	if err := p.wait(); err != nil {
+		return err
+	}
+	v.emit(Event{Op: OpCopy, Kind: EventDone, Done: len(m.Hashes), Total: len(m.Hashes)})
+	return nil
+}
+
+// parallel runs up to the VFS concurrency of tasks at once, keeping the
+// first error and numbering progress events. Requests inside a task still
+// take their slot from the limiter.
+type parallel struct {
+	v     *VFS
+	ctx   context.Context
+	sem   chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error
+	count int
+	bytes int64
+}
+
+func (v *VFS) newParallel(ctx context.Context) *parallel {
+	return &parallel{v: v, ctx: ctx, sem: make(chan struct{}, v.concurrency)}
+}
+
+func (p *parallel) run(task func() error) {
+	p.sem <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+		if err := task(); err != nil {
+			p.fail(err)
+		}
+	}()
+}
+
+func (p *parallel) fail(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+}
+
+func (p *parallel) failed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err != nil || p.ctx.Err() != nil
+}
+
+// done emits e with the running totals filled in.
+func (p *parallel) done(e Event) {
+	p.mu.Lock()
+	p.count++
+	p.bytes += e.Bytes
+	e.Done, e.BytesDone = p.count, p.bytes
+	p.mu.Unlock()
+	p.v.emit(e)
+	opFrom(p.ctx).count(e.Op, 1, e.Bytes)
+}
+
+func (p *parallel) wait() error {
+	p.wg.Wait()
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	return p.err
+}
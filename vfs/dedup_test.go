@@ -0,0 +1,226 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestDedup_StoresSharedChunksOnce(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.dedup, v.maxChunkSize = true, 1000
+	ctx := context.Background()
+
+	shared, own := randomBytes(3000), randomBytes(1000)
+	a := append(bytes.Clone(shared), shared[:1000]...) // repeats its first chunk
+	b := append(bytes.Clone(shared), own...)
+	if err := v.EncodeReader(ctx, bytes.NewReader(a), "s3://bucket/a/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(b), "s3://bucket/b/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	pooled := func() int {
+		n := 0
+		for _, k := range fake.keys("bucket") {
+			if strings.HasPrefix(k, poolDir+"chunks/") {
+				n++
+			}
+		}
+		return n
+	}
+	if n := pooled(); n != 4 {
+		t.Fatalf("expected the three shared chunks and one of b's to be pooled, got %d", n)
+	}
+
+	restore := func(uri string, want []byte) {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "out")
+		if err := v.Restore(ctx, uri, out); err != nil {
+			t.Fatalf("restore of %s failed: %v", uri, err)
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, want) {
+			t.Errorf("%s does not restore to the original", uri)
+		}
+	}
+	restore("s3://bucket/a/", a)
+	restore("s3://bucket/b/", b)
+
+	// A copy within the bucket only marks the chunks.
+	var chunkPuts int
+	fake.onPut = func(in *s3.PutObjectInput) {
+		if strings.HasPrefix(*in.Key, poolDir+"chunks/") {
+			chunkPuts++
+		}
+	}
+	if err := v.Copy(ctx, "s3://bucket/a/", "s3://bucket/c/"); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if chunkPuts != 0 {
+		t.Errorf("expected the copy to upload no chunk, got %d", chunkPuts)
+	}
+
+	// Delete only removes the marks; GC deletes the chunks left without.
+	if err := v.Delete(ctx, "s3://bucket/b/"); err != nil {
+		t.Fatal(err)
+	}
+	if n := pooled(); n != 4 {
+		t.Errorf("expected Delete to leave the chunks to GC, got %d pooled", n)
+	}
+	if _, err := v.GC(ctx, "s3://bucket/", GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := pooled(); n != 3 {
+		t.Errorf("expected GC to delete b's own chunk, got %d pooled", n)
+	}
+	restore("s3://bucket/a/", a)
+	for _, uri := range []string{"s3://bucket/a/", "s3://bucket/c/"} {
+		if err := v.Delete(ctx, uri); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := v.GC(ctx, "s3://bucket/", GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected the pool to be empty once nothing uses it, got %v", keys)
+	}
+}
+
+func TestGC_KeepsPooledChunkMarkedSinceListing(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.dedup, v.maxChunkSize = true, 1000
+	ctx := context.Background()
+	data := randomBytes(1000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ctx, "s3://bucket/a/"); err != nil {
+		t.Fatal(err)
+	}
+	// An encode of the same data marks the chunk after GC listed it
+	// unmarked, and finds it stored: here while GC deletes a stale mark.
+	stale := "bucket/" + poolRefKey(strings.Repeat("0", 64), "gone/"+manifestName)
+	fake.objects[stale], fake.modified[stale] = nil, time.Now().Add(-time.Hour)
+	var encoded bool
+	fake.deleteHook = func(keys []string) error {
+		if !encoded {
+			encoded = true
+			if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/b/", false); err != nil {
+				t.Error(err)
+			}
+		}
+		return nil
+	}
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Pool) != 1 || r.Pool[0] != strings.TrimPrefix(stale, "bucket/") {
+		t.Errorf("expected GC to delete only the stale mark, got %v", r.Pool)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/b/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected b to restore, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestDedup_DeltaOverwriteReleasesMarksOfOldLayout(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.dedup, v.delta, v.maxChunkSize = true, true, 1000
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), "s3://bucket/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	v.splitLayout = true
+	data := randomBytes(3000)
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/a/", true); err != nil {
+		t.Fatal(err)
+	}
+	var marks int
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, poolDir+"refs/") {
+			marks++
+		}
+		if k == "a/"+manifestName {
+			t.Error("expected the manifest of the old layout to be deleted")
+		}
+	}
+	if marks != 3 {
+		t.Errorf("expected only the new archive's three marks, got %d", marks)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/a/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the new archive to restore, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestDedup_ResumeRefusesFinishedArchive(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	v.dedup, v.resume = true, true
+	ctx := context.Background()
+	const uri = "s3://bucket/a/"
+	data := randomBytes(3000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), uri, false); !errors.Is(err, ErrPrefixExists) {
+		t.Fatalf("expected ErrPrefixExists, got %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the archive untouched, got %d bytes, %v", out.Len(), err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), uri, true); err != nil {
+		t.Errorf("expected a forced resume to replace the archive, got %v", err)
+	}
+}
+
+func TestDedup_FailedOverwriteKeepsOldArchive(t *testing.T) {
+	for _, dedupBefore := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.dedup, v.delta, v.maxChunkSize = dedupBefore, true, 1000
+		ctx := context.Background()
+		const uri = "s3://bucket/a/"
+		data := randomBytes(3000)
+		if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+			t.Fatal(err)
+		}
+		before := len(fake.keys("bucket"))
+
+		// The staging marker, then one mark and its chunk.
+		v.dedup = true
+		failAfter(fake, 3)
+		if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), uri, true); err == nil {
+			t.Fatal("expected the overwrite to fail")
+		}
+		fake.putHook = nil
+		var out bytes.Buffer
+		if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("dedup before %v: expected the old archive back, got %d bytes, %v", dedupBefore, out.Len(), err)
+		}
+		if _, err := v.GC(ctx, "s3://bucket/", GCOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(fake.keys("bucket")); got != before {
+			t.Errorf("dedup before %v: expected the %d objects of the old archive, got %d", dedupBefore, before, got)
+		}
+	}
+}
+
+func TestDedup_RejectsEncryption(t *testing.T) {
+	if _, err := New(WithDedup(), WithPassphrase([]byte("secret")), WithS3Client(s3.New(s3.Options{}))); err == nil {
+		t.Error("expected dedup with encryption to be rejected")
+	}
+}
@@ -0,0 +1,109 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WithDelta makes overwriting an archive (Encode with force, or a changed
+// file in Sync) upload only the chunks that changed. Chunks of the
+// existing archive that hold the same data at the same index are kept, the
+// rest are replaced, and chunks past the end of the new file are deleted.
+// Readers see the archive as unfinished until the new manifest is written.
+// If the encode fails before it deletes any chunk of the old archive, the
+// chunks it added are deleted again and the old archive reads as before.
+//
+// Chunk boundaries are fixed offsets, so edits that keep the file's size
+// reuse everything but the edited chunks, while an insertion near the
+// start changes every chunk after it. A compressed stream likewise changes
+// from the first edit on.
+func WithDelta() Option {
+	return func(o *options) {
+		o.delta = true
+	}
+}
+
+// deltaChunks returns the keys of the chunks of the archive at prefix that
+// a delta encode may reuse, by index, and keys that must go whatever the
+// input: duplicates left by an interrupted delta encode. If the archive
+// uses another layout or storage mode, it is deleted and nothing is
+// reused.
+func (v *VFS) deltaChunks(ctx context.Context, b Backend, prefix, dataPrefix string, bodies bool) (map[int]string, []string, error) {
+	detected, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	old, err := v.readManifest(ctx, b, manifestKey(prefix, detected))
+	if err != nil && !errors.Is(err, ErrEncrypted) {
+		return nil, nil, err
+	}
+	if detected != dataPrefix || old.deduped() {
+		return nil, nil, v.deleteArchive(ctx, b, metaKey(prefix, detected, ""))
+	}
+	other, err := v.listStored(ctx, b, dataPrefix, !bodies)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(other) > 0 {
+		return nil, nil, v.deleteArchive(ctx, b, metaKey(prefix, detected, ""))
+	}
+	stored, err := v.listStored(ctx, b, dataPrefix, bodies)
+	if err != nil {
+		return nil, nil, err
+	}
+	existing := make(map[int]string, len(stored))
+	var stale []string
+	for _, c := range stored {
+		if _, dup := existing[c.index]; dup {
+			stale = append(stale, c.key)
+			continue
+		}
+		existing[c.index] = c.key
+	}
+	return existing, stale, nil
+}
+
+// deltaBase returns the keys of the objects directly under dataPrefix of
+// the finished archive at prefix that a delta encode is about to replace,
+// or nil if there is none to roll back to.
+func (v *VFS) deltaBase(ctx context.Context, b Backend, prefix, dataPrefix string) (map[string]bool, error) {
+	if err := v.checkCommitted(ctx, b, prefix, dataPrefix); err != nil {
+		if errors.Is(err, ErrIncompleteArchive) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if exists, err := v.hasObjects(ctx, b, manifestKey(prefix, dataPrefix)); err != nil || !exists {
+		return nil, err
+	}
+	base := make(map[string]bool)
+	err := b.List(ctx, dataPrefix, func(objs []Object) error {
+		for _, obj := range objs {
+			if !strings.Contains(strings.TrimPrefix(obj.Key, dataPrefix), "/") {
+				base[obj.Key] = true
+			}
+		}
+		return nil
+	})
+	return base, err
+}
+
+// undoDelta rolls back a delta encode that failed before it deleted any
+// of base, the objects of the archive it was replacing: what it added
+// under dataPrefix is deleted, and the staging marker last, so the archive
+// reads as it did rather than as an upload for Cleanup to delete. It
+// returns cause with anything that went wrong on the way.
+func (v *VFS) undoDelta(ctx context.Context, b Backend, dataPrefix, staging string, base map[string]bool, cause error) error {
+	err := deleteKeys(ctx, b, dataPrefix, func(key string) bool {
+		return !base[key] && key != staging && !strings.Contains(strings.TrimPrefix(key, dataPrefix), "/")
+	})
+	if err == nil {
+		err = b.Delete(ctx, []string{staging})
+	}
+	if err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to undo the delta encode: %w", err))
+	}
+	return cause
+}
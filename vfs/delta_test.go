@@ -0,0 +1,115 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestDelta_UploadsOnlyChangedChunks(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.delta = true
+		if encrypted {
+			v.keys = passphraseWrapper{passphrase: []byte("hunter2")}
+		}
+		ctx := context.Background()
+		const uri = "s3://bucket/disk/"
+		data := randomBytes(20000)
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		m, err := v.Stat(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var chunkPuts int
+		fake.onPut = func(in *s3.PutObjectInput) {
+			if name := strings.TrimPrefix(*in.Key, "disk/"); !strings.HasPrefix(name, ".vfs-") {
+				chunkPuts++
+			}
+		}
+		edited := bytes.Clone(data)
+		edited[m.ChunkSize*3+5] ^= 0xff
+		if err := v.Encode(ctx, writeTempFile(t, edited), uri, true); err != nil {
+			t.Fatalf("delta encode failed: %v", err)
+		}
+		if chunkPuts != 1 {
+			t.Errorf("encrypted=%v: expected one chunk to be uploaded, got %d", encrypted, chunkPuts)
+		}
+		restored := filepath.Join(t.TempDir(), "out")
+		if err := v.Restore(ctx, uri, restored); err != nil {
+			t.Fatalf("restore failed: %v", err)
+		}
+		if got, _ := os.ReadFile(restored); !bytes.Equal(got, edited) {
+			t.Errorf("encrypted=%v: restored file does not match the edit", encrypted)
+		}
+
+		// Shrinking drops the chunks past the new end.
+		short := edited[:m.ChunkSize*2]
+		if err := v.Encode(ctx, writeTempFile(t, short), uri, true); err != nil {
+			t.Fatalf("delta encode failed: %v", err)
+		}
+		want := 3
+		if encrypted {
+			want++ // the marker of encrypted chunks
+		}
+		if got := len(fake.keys("bucket")); got != want {
+			t.Errorf("encrypted=%v: expected two chunks and the manifest, got %d keys", encrypted, got)
+		}
+		if err := v.Restore(ctx, uri, restored); err != nil {
+			t.Fatalf("restore failed: %v", err)
+		}
+		if got, _ := os.ReadFile(restored); !bytes.Equal(got, short) {
+			t.Errorf("encrypted=%v: restored file does not match the shortened one", encrypted)
+		}
+	}
+}
+
+func TestDelta_FailedOverwriteRollsBack(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.delta, v.splitLayout = true, split
+		ctx := context.Background()
+		const uri = "s3://bucket/disk/"
+		data := randomBytes(20000)
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		m, err := v.Stat(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := fake.keys("bucket")
+
+		// The staging marker and the first changed chunk make it.
+		edited := bytes.Clone(data)
+		for _, i := range []int{1, 3, 5} {
+			edited[m.ChunkSize*i] ^= 0xff
+		}
+		failAfter(fake, 2)
+		if err := v.Encode(ctx, writeTempFile(t, edited), uri, true); err == nil {
+			t.Fatal("expected the delta encode to fail")
+		}
+		fake.putHook = nil
+		if got := fake.keys("bucket"); !slices.Equal(got, before) {
+			t.Errorf("split=%v: expected the objects of the old archive, got %d instead of %d", split, len(got), len(before))
+		}
+		var out bytes.Buffer
+		if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("split=%v: expected the old archive back, got %d bytes, %v", split, out.Len(), err)
+		}
+		if cleaned, err := v.Cleanup(ctx, "s3://bucket/", 0); err != nil || len(cleaned) != 0 {
+			t.Errorf("split=%v: expected nothing for Cleanup, got %v, %v", split, cleaned, err)
+		}
+	}
+}
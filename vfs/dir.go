@@ -0,0 +1,195 @@
+package vfs
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotDirArchive is returned by RestoreDir for archives of a single file.
+var ErrNotDirArchive = errors.New("archive does not hold a directory")
+
+// EncodeDir stores the directory tree at dir at uri as one tar stream,
+// compressed if the VFS compresses, keeping relative paths, permissions,
+// and modification times. Only directories and regular files are stored;
+// symlinks and other special files are skipped with a warning. The size of
+// the tar is not known up front, so it is chunked like EncodeReader input.
+// force works as for Encode.
+func (v *VFS) EncodeDir(ctx context.Context, dir, uri string, force bool) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if err := v.clearPrefix(ctx, b, prefix, uri, force); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(v.writeTar(pw, dir))
+	}()
+	defer pr.Close()
+	return v.encode(ctx, b, prefix, pr, -1, Manifest{Filename: filepath.Base(dir), Tar: true})
+}
+
+// writeTar writes the tree at dir to w as a tar stream.
+func (v *VFS) writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			v.warn(OpEncode, fmt.Sprintf("⚠️  skipping %s: not a regular file", p))
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// RestoreDir unpacks the directory archive at uri into dir, creating it if
+// needed, with the stored permissions and modification times. Entries whose
+// path would leave dir are rejected. It fails with ErrNotDirArchive for
+// archives made by Encode or EncodeReader.
+func (v *VFS) RestoreDir(ctx context.Context, uri, dir string) error {
+	m, err := v.Stat(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if !m.Tar {
+		return fmt.Errorf("%w: %s", ErrNotDirArchive, uri)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := v.RestoreWriter(ctx, uri, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	err = extractTar(pr, dir)
+	if err == nil {
+		_, err = io.Copy(io.Discard, pr) // the padding after the last entry
+	}
+	pr.CloseWithError(err) // stops the restore if extracting failed
+	if restoreErr := <-done; restoreErr != nil && err == nil {
+		err = restoreErr
+	}
+	return err
+}
+
+// extractTar writes the entries of the tar stream r below dir. Directory
+// permissions are applied last, so read-only directories can be filled.
+func extractTar(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	type dirMode struct {
+		path    string
+		mode    fs.FileMode
+		modTime time.Time
+	}
+	var dirs []dirMode
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !filepath.IsLocal(filepath.FromSlash(hdr.Name)) {
+			return fmt.Errorf("refusing to extract %q outside %s", hdr.Name, dir)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{target, mode, hdr.ModTime})
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.path, d.modTime, d.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode) // the umask applied to OpenFile
+}
@@ -0,0 +1,76 @@
+package vfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeDir_RoundTrip(t *testing.T) {
+	src := t.TempDir()
+	files := map[string][]byte{
+		"app.conf":          []byte("port = 8080\n"),
+		"conf.d/tls.conf":   randomBytes(3000),
+		"conf.d/empty.conf": nil,
+	}
+	for name, data := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "app.conf"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.compression = CompressionZstd
+	ctx := context.Background()
+	const uri = "s3://bucket/etc/"
+	if err := v.EncodeDir(ctx, src, uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	dst := filepath.Join(t.TempDir(), "restored")
+	if err := newTestVFS(fake).RestoreDir(ctx, uri, dst); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: expected the original content, got %d bytes, %v", name, len(got), err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dst, "app.conf")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected permissions 0600, got %v, %v", info.Mode(), err)
+	}
+
+	if err := v.Encode(ctx, writeTempFile(t, []byte("x")), "s3://bucket/file/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RestoreDir(ctx, "s3://bucket/file/", dst); !errors.Is(err, ErrNotDirArchive) {
+		t.Errorf("expected ErrNotDirArchive for a file archive, got %v", err)
+	}
+}
+
+func TestExtractTar_RejectsEscapingPaths(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+	dir := t.TempDir()
+	if err := extractTar(&buf, filepath.Join(dir, "out")); err == nil {
+		t.Error("expected a path outside the directory to be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+		t.Error("expected nothing to be written outside the directory")
+	}
+}
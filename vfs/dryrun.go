@@ -0,0 +1,137 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlannedWrite is a request that a dry run skipped (see WithDryRun).
+type PlannedWrite struct {
+	Delete bool     // a batch delete rather than a put
+	URIs   []string // the object put, or the objects deleted
+	Bytes  int64    // body size of a put
+}
+
+// WithDryRun makes operations write nothing. Every put and delete they
+// would send is passed to fn instead, one call per request, and later reads
+// in the same VFS see the store as if the writes had happened, so an encode
+// with force still deletes and then uploads. Reads go to the store as
+// usual. fn is called from the goroutines doing the transfers, one call at
+// a time.
+func WithDryRun(fn func(PlannedWrite)) Option {
+	return func(o *options) {
+		o.dryRun = fn
+	}
+}
+
+// dryRun records the writes of a dry run per bucket, so that separate
+// opens of one bucket see each other's writes.
+type dryRun struct {
+	fn       func(PlannedWrite)
+	mu       sync.Mutex
+	overlays map[string]*overlay // scheme://bucket/ -> writes
+}
+
+// overlay holds the writes a dry run made to one bucket.
+type overlay struct {
+	put     map[string][]byte
+	deleted map[string]bool
+}
+
+// wrap returns b, the bucket at root, with writes recorded instead of sent.
+func (d *dryRun) wrap(root string, b Backend) Backend {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.overlays == nil {
+		d.overlays = make(map[string]*overlay)
+	}
+	o, ok := d.overlays[root]
+	if !ok {
+		o = &overlay{put: make(map[string][]byte), deleted: make(map[string]bool)}
+		d.overlays[root] = o
+	}
+	return &dryRunBackend{Backend: b, d: d, o: o, root: root}
+}
+
+// dryRunBackend is a Backend whose writes go to an overlay.
+type dryRunBackend struct {
+	Backend
+	d    *dryRun
+	o    *overlay
+	root string
+}
+
+func (b *dryRunBackend) Put(ctx context.Context, key string, body []byte) error {
+	b.d.mu.Lock()
+	defer b.d.mu.Unlock()
+	b.o.put[key] = body
+	delete(b.o.deleted, key)
+	b.d.fn(PlannedWrite{URIs: []string{b.root + key}, Bytes: int64(len(body))})
+	return nil
+}
+
+func (b *dryRunBackend) Delete(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	b.d.mu.Lock()
+	defer b.d.mu.Unlock()
+	uris := make([]string, len(keys))
+	for i, key := range keys {
+		delete(b.o.put, key)
+		b.o.deleted[key] = true
+		uris[i] = b.root + key
+	}
+	b.d.fn(PlannedWrite{Delete: true, URIs: uris})
+	return nil
+}
+
+func (b *dryRunBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.d.mu.Lock()
+	body, put := b.o.put[key]
+	deleted := b.o.deleted[key]
+	b.d.mu.Unlock()
+	if put {
+		return body, nil
+	}
+	if deleted {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return b.Backend.Get(ctx, key)
+}
+
+// List lists the store with the overlay applied, in one page.
+func (b *dryRunBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	var objs []Object
+	err := b.Backend.List(ctx, prefix, func(page []Object) error {
+		objs = append(objs, page...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.d.mu.Lock()
+	merged := objs[:0]
+	for _, obj := range objs {
+		if _, put := b.o.put[obj.Key]; !put && !b.o.deleted[obj.Key] {
+			merged = append(merged, obj)
+		}
+	}
+	now := time.Now()
+	for key, body := range b.o.put {
+		if strings.HasPrefix(key, prefix) {
+			merged = append(merged, Object{Key: key, Size: int64(len(body)), LastModified: now})
+		}
+	}
+	b.d.mu.Unlock()
+	if len(merged) == 0 {
+		return nil
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Key < merged[j].Key })
+	return fn(merged)
+}
@@ -0,0 +1,97 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDryRun_EncodeWithForceWritesNothing(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	ctx := context.Background()
+	const uri = "s3://bucket/disk/"
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(450)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	before := fake.keys("bucket")
+
+	var puts, deletes []string
+	v.dryRun = &dryRun{fn: func(w PlannedWrite) {
+		if w.Delete {
+			deletes = append(deletes, w.URIs...)
+			return
+		}
+		puts = append(puts, w.URIs...)
+	}}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(250)), uri, true); err != nil {
+		t.Fatalf("dry-run encode failed: %v", err)
+	}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the store untouched, got %v", got)
+	}
+	// The old archive, and the staging marker when the encode commits.
+	want := []string{"s3://bucket/disk/" + stagingName}
+	for _, k := range before {
+		want = append(want, "s3://bucket/"+k)
+	}
+	sort.Strings(want)
+	sort.Strings(deletes)
+	if !reflect.DeepEqual(deletes, want) {
+		t.Errorf("expected the old archive deleted, got %v", deletes)
+	}
+	// A staging marker, three chunks and the manifest.
+	if len(puts) != 5 {
+		t.Errorf("expected 5 puts, got %d: %v", len(puts), puts)
+	}
+
+	// Later reads in the dry run see the planned state.
+	m, err := v.Stat(ctx, uri)
+	if err != nil || m.Size != 250 {
+		t.Errorf("expected the dry run's manifest, got %+v, %v", m, err)
+	}
+}
+
+func TestDryRun_DeleteListsKeys(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1000)), "s3://bucket/logs/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	keys := len(fake.keys("bucket"))
+	var deleted int
+	v.dryRun = &dryRun{fn: func(w PlannedWrite) {
+		if !w.Delete {
+			t.Errorf("unexpected put of %v", w.URIs)
+		}
+		deleted += len(w.URIs)
+	}}
+	if err := v.Delete(ctx, "s3://bucket/logs/"); err != nil {
+		t.Fatalf("dry-run delete failed: %v", err)
+	}
+	if deleted != keys || len(fake.keys("bucket")) != keys {
+		t.Errorf("expected %d keys planned for deletion and kept, got %d planned and %d kept", keys, deleted, len(fake.keys("bucket")))
+	}
+}
+
+func TestDryRun_EncodeReaderWithForceWritesNothing(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/stream/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(450)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	before := fake.keys("bucket")
+	v.dryRun = &dryRun{fn: func(PlannedWrite) {}}
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(250)), uri, true); err != nil {
+		t.Fatalf("dry-run encode failed: %v", err)
+	}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the store untouched, got %v", got)
+	}
+}
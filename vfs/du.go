@@ -0,0 +1,114 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Usage is what is stored at or below one prefix.
+type Usage struct {
+	URI         string
+	Archives    int
+	Bytes       int64 // decoded size of the files, from their manifests
+	Chunks      int
+	Objects     int   // objects stored, each written with one PUT
+	StoredBytes int64 // bytes held in object bodies: manifests, markers, body parts
+}
+
+// DiskUsage reports usage for uri and every prefix below it that holds
+// objects, sorted by URI. Each entry includes everything nested under it,
+// like du. Bytes and Chunks come from manifests, so archives that cannot
+// be read (unfinished, from before manifests, or encrypted without the
+// key) count only towards Objects and StoredBytes.
+func (v *VFS) DiskUsage(ctx context.Context, uri string) ([]Usage, error) {
+	scheme, bucket, _, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	root := fmt.Sprintf("%s://%s/", scheme, bucket)
+
+	type dirCount struct {
+		objects int
+		bytes   int64
+	}
+	raw := make(map[string]*dirCount)
+	entries, err := v.listArchives(ctx, b, root, prefix, func(obj Object) {
+		dir := objectDir(obj.Key)
+		c := raw[dir]
+		if c == nil {
+			c = &dirCount{}
+			raw[dir] = c
+		}
+		c.objects++
+		c.bytes += obj.Size
+	})
+	if err != nil {
+		return nil, err
+	}
+	archives := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		archives[strings.TrimPrefix(e.URI, root)] = true
+	}
+
+	usage := make(map[string]*Usage)
+	// add applies fn to dir and every prefix above it, up to prefix.
+	add := func(dir string, fn func(*Usage)) {
+		for d := dir; ; d = objectDir(strings.TrimSuffix(d, "/")) {
+			u := usage[d]
+			if u == nil {
+				u = &Usage{URI: root + d}
+				usage[d] = u
+			}
+			fn(u)
+			if len(d) <= len(prefix) {
+				return
+			}
+		}
+	}
+	for dir, c := range raw {
+		// The data/ and meta/ directories of a split archive belong to it.
+		for _, sub := range []string{dataDir, metaDir} {
+			if p, ok := strings.CutSuffix(dir, sub); ok && archives[p] {
+				dir = p
+			}
+		}
+		add(dir, func(u *Usage) {
+			u.Objects += c.objects
+			u.StoredBytes += c.bytes
+		})
+	}
+	for _, e := range entries {
+		if e.Manifest == nil {
+			continue
+		}
+		add(strings.TrimPrefix(e.URI, root), func(u *Usage) {
+			u.Archives++
+			u.Bytes += e.Manifest.Size
+			u.Chunks += e.Manifest.Chunks
+		})
+	}
+
+	out := make([]Usage, 0, len(usage))
+	for _, u := range usage {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URI < out[j].URI })
+	return out, nil
+}
+
+// objectDir returns the directory of key with a trailing slash, or "" for
+// a key at the top level.
+func objectDir(key string) string {
+	dir := path.Dir(key)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir + "/"
+}
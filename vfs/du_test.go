@@ -0,0 +1,50 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestDiskUsage_AggregatesPrefixes(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	split := newTestVFS(fake)
+	split.splitLayout = true
+	for uri, n := range map[string]int{"s3://bucket/logs/a/": 2000, "s3://bucket/logs/b/": 100} {
+		if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(n)), uri, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := split.EncodeReader(ctx, bytes.NewReader(randomBytes(50)), "s3://bucket/logs/old/c/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := v.DiskUsage(ctx, "s3://bucket/logs/")
+	if err != nil {
+		t.Fatalf("du failed: %v", err)
+	}
+	got := make(map[string]Usage)
+	for _, u := range usage {
+		got[u.URI] = u
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected logs/ and four prefixes below it, got %+v", usage)
+	}
+	total := got["s3://bucket/logs/"]
+	if total.Archives != 3 || total.Bytes != 2150 || total.Objects != len(fake.keys("bucket")) {
+		t.Errorf("unexpected total %+v", total)
+	}
+	a := got["s3://bucket/logs/a/"]
+	if a.Archives != 1 || a.Bytes != 2000 || a.Objects != a.Chunks+1 || a.StoredBytes == 0 {
+		t.Errorf("unexpected usage for a/ %+v", a)
+	}
+	// The split archive's data/ and meta/ count towards its own prefix.
+	if c := got["s3://bucket/logs/old/c/"]; c.Objects != 3 || c.Chunks != 1 {
+		t.Errorf("unexpected usage for the split archive %+v", c)
+	}
+	if old := got["s3://bucket/logs/old/"]; old.Archives != 1 || old.Bytes != 50 {
+		t.Errorf("unexpected usage for old/ %+v", old)
+	}
+}
@@ -0,0 +1,245 @@
+// Package dynamo stores vfs archives as DynamoDB items, which avoids S3
+// listing latency for small, config-sized files.
+//
+// The table needs a string partition key "pk" and a string sort key "sk".
+// A key's first path segment becomes the partition key and the rest the
+// sort key, so the chunks of one archive share a partition and are listed
+// with a single Query. Sort keys are limited to 1024 bytes, so chunks have
+// the same size as S3 keys.
+//
+//	v, err := vfs.New(vfs.WithBackend("dynamodb", dynamo.Open()))
+//	v.Encode(ctx, "app.conf", "dynamodb://my-table/app/", false)
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+const (
+	maxSortKeyBytes = 1024
+	maxBatchWrite   = 25
+	maxBatchRetries = 10
+
+	// rootPartition holds keys that have no directory, since DynamoDB does
+	// not allow empty key attributes.
+	rootPartition = "/"
+)
+
+var batchBackoff = 50 * time.Millisecond
+
+type dynamoAPI interface {
+	dynamodb.QueryAPIClient
+	dynamodb.ScanAPIClient
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// Open returns a factory for dynamodb://table/prefix/ URIs. The client is
+// created on first use from the default AWS config and opts.
+func Open(opts ...func(*config.LoadOptions) error) vfs.BackendFactory {
+	var once sync.Once
+	var client *dynamodb.Client
+	var err error
+	return func(ctx context.Context, table string) (vfs.Backend, error) {
+		once.Do(func() {
+			var cfg aws.Config
+			cfg, err = config.LoadDefaultConfig(context.Background(), opts...)
+			client = dynamodb.NewFromConfig(cfg)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if table == "" {
+			return nil, fmt.Errorf("dynamodb URI is missing a table name")
+		}
+		return New(client, table), nil
+	}
+}
+
+// New returns a backend for table that uses an existing client.
+func New(client *dynamodb.Client, table string) vfs.Backend {
+	return &backend{client: client, table: table}
+}
+
+type backend struct {
+	client dynamoAPI
+	table  string
+}
+
+// splitKey maps "dir/rest" to partition "dir/" and sort key "rest".
+func splitKey(key string) (pk, sk string) {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i+1], key[i+1:]
+	}
+	return rootPartition, key
+}
+
+func itemKey(key string) map[string]types.AttributeValue {
+	pk, sk := splitKey(key)
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: pk},
+		"sk": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+func (b *backend) Put(ctx context.Context, key string, body []byte) error {
+	item := itemKey(key)
+	if len(body) > 0 {
+		item["body"] = &types.AttributeValueMemberB{Value: body}
+	}
+	_, err := b.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &b.table, Item: item})
+	return err
+}
+
+func (b *backend) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &b.table,
+		Key:            itemKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("dynamodb://%s/%s: %w", b.table, key, fs.ErrNotExist)
+	}
+	if body, ok := out.Item["body"].(*types.AttributeValueMemberB); ok {
+		return body.Value, nil
+	}
+	return nil, nil
+}
+
+// List queries the partition of prefix. A prefix without a slash can match
+// keys in many partitions, so it falls back to a Scan whose results are
+// sorted before fn sees them.
+func (b *backend) List(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	if !strings.Contains(prefix, "/") {
+		return b.scan(ctx, prefix, fn)
+	}
+	pk, sk := splitKey(prefix)
+	input := &dynamodb.QueryInput{
+		TableName:              &b.table,
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: pk},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+	if sk != "" {
+		input.KeyConditionExpression = aws.String("pk = :pk AND begins_with(sk, :sk)")
+		input.ExpressionAttributeValues[":sk"] = &types.AttributeValueMemberS{Value: sk}
+	}
+	p := dynamodb.NewQueryPaginator(b.client, input)
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := fn(objects(page.Items)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backend) scan(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	var matched []vfs.Object
+	p := dynamodb.NewScanPaginator(b.client, &dynamodb.ScanInput{TableName: &b.table, ConsistentRead: aws.Bool(true)})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects(page.Items) {
+			if strings.HasPrefix(o.Key, prefix) {
+				matched = append(matched, o)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Key < matched[j].Key
+	})
+	return fn(matched)
+}
+
+func objects(items []map[string]types.AttributeValue) []vfs.Object {
+	objs := make([]vfs.Object, 0, len(items))
+	for _, item := range items {
+		pk, _ := item["pk"].(*types.AttributeValueMemberS)
+		sk, _ := item["sk"].(*types.AttributeValueMemberS)
+		if pk == nil || sk == nil {
+			continue
+		}
+		o := vfs.Object{Key: sk.Value}
+		if pk.Value != rootPartition {
+			o.Key = pk.Value + sk.Value
+		}
+		if body, ok := item["body"].(*types.AttributeValueMemberB); ok {
+			o.Size = int64(len(body.Value))
+		}
+		objs = append(objs, o)
+	}
+	return objs
+}
+
+func (b *backend) Delete(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), maxBatchWrite)]
+		keys = keys[len(batch):]
+		requests := make([]types.WriteRequest, len(batch))
+		for i, key := range batch {
+			requests[i] = types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: itemKey(key)}}
+		}
+		if err := b.batchWrite(ctx, requests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchWrite retries the items DynamoDB leaves unprocessed under load.
+func (b *backend) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	for attempt := 1; ; attempt++ {
+		out, err := b.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{b.table: requests},
+		})
+		if err != nil {
+			return err
+		}
+		requests = out.UnprocessedItems[b.table]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == maxBatchRetries {
+			return errors.New("dynamodb left items unprocessed after retries")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * batchBackoff):
+		}
+	}
+}
+
+// KeyLimit is bounded by the 1024-byte sort key.
+func (b *backend) KeyLimit(prefix string) int {
+	_, sk := splitKey(prefix)
+	return maxSortKeyBytes - len(sk)
+}
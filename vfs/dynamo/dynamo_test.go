@@ -0,0 +1,155 @@
+package dynamo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// fakeDynamo keeps items keyed by "pk|sk" and understands the queries the
+// backend issues.
+type fakeDynamo struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+
+	// unprocessed makes the next BatchWriteItem calls leave their last
+	// request unprocessed.
+	unprocessed int
+}
+
+func newFakeDynamo() *fakeDynamo {
+	return &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+}
+
+func str(av types.AttributeValue) string {
+	return av.(*types.AttributeValueMemberS).Value
+}
+
+func id(key map[string]types.AttributeValue) string {
+	return str(key["pk"]) + "|" + str(key["sk"])
+}
+
+func (f *fakeDynamo) sorted(match func(pk, sk string) bool) []map[string]types.AttributeValue {
+	var out []map[string]types.AttributeValue
+	for _, item := range f.items {
+		if match(str(item["pk"]), str(item["sk"])) {
+			out = append(out, item)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return id(out[i]) < id(out[j]) })
+	return out
+}
+
+func (f *fakeDynamo) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[id(in.Item)] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamo) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[id(in.Key)]}, nil
+}
+
+func (f *fakeDynamo) Query(ctx context.Context, in *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pk := str(in.ExpressionAttributeValues[":pk"])
+	sk := ""
+	if v, ok := in.ExpressionAttributeValues[":sk"]; ok {
+		sk = str(v)
+	}
+	items := f.sorted(func(p, s string) bool { return p == pk && strings.HasPrefix(s, sk) })
+	return &dynamodb.QueryOutput{Items: items}, nil
+}
+
+func (f *fakeDynamo) Scan(ctx context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.ScanOutput{Items: f.sorted(func(string, string) bool { return true })}, nil
+}
+
+func (f *fakeDynamo) BatchWriteItem(ctx context.Context, in *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &dynamodb.BatchWriteItemOutput{}
+	for table, requests := range in.RequestItems {
+		if f.unprocessed > 0 && len(requests) > 0 {
+			f.unprocessed--
+			out.UnprocessedItems = map[string][]types.WriteRequest{table: requests[len(requests)-1:]}
+			requests = requests[:len(requests)-1]
+		}
+		for _, r := range requests {
+			delete(f.items, id(r.DeleteRequest.Key))
+		}
+	}
+	return out, nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	batchBackoff = 0
+	fake := newFakeDynamo()
+	fake.unprocessed = 2
+	v, err := vfs.New(vfs.WithBackend("dynamodb", func(ctx context.Context, table string) (vfs.Backend, error) {
+		return &backend{client: fake, table: table}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := make([]byte, 20000)
+	rand.Read(data)
+
+	const uri = "dynamodb://table/app/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Migrate(ctx, uri); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("restored data does not match")
+	}
+
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if len(fake.items) != 0 {
+		t.Errorf("expected every item to be deleted, %d left", len(fake.items))
+	}
+}
+
+func TestGetMissing(t *testing.T) {
+	b := &backend{client: newFakeDynamo(), table: "table"}
+	if _, err := b.Get(context.Background(), "app/.vfs-manifest"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"app/1-abc":     {"app/", "1-abc"},
+		"app/data/1-ab": {"app/", "data/1-ab"},
+		"1-abc":         {rootPartition, "1-abc"},
+	} {
+		if pk, sk := splitKey(key); pk != want[0] || sk != want[1] {
+			t.Errorf("splitKey(%q) = %q, %q; want %q, %q", key, pk, sk, want[0], want[1])
+		}
+	}
+}
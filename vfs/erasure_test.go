@@ -0,0 +1,135 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErasureCode_RebuildsAnyLostShards(t *testing.T) {
+	code := newErasureCode(4, 2)
+	data := make([][]byte, 4)
+	for i := range data {
+		data[i] = randomBytes(50)
+	}
+	all := append(append([][]byte{}, data...), code.encode(data)...)
+	for a := range all {
+		for b := a; b < len(all); b++ {
+			shards := append([][]byte{}, all...)
+			shards[a], shards[b] = nil, nil
+			if err := code.reconstruct(shards); err != nil {
+				t.Fatalf("losing shards %d and %d: %v", a, b, err)
+			}
+			for i := range data {
+				if !bytes.Equal(shards[i], data[i]) {
+					t.Errorf("losing shards %d and %d: data shard %d rebuilt wrong", a, b, i)
+				}
+			}
+		}
+	}
+	shards := append([][]byte{}, all...)
+	shards[0], shards[1], shards[4] = nil, nil, nil
+	if err := code.reconstruct(shards); !errors.Is(err, errTooFewShards) {
+		t.Errorf("expected errTooFewShards with three shards lost, got %v", err)
+	}
+}
+
+// chunkKeyAt returns the key of chunk index under prefix in fake.
+func chunkKeyAt(t *testing.T, fake *fakeS3, prefix string, index int) string {
+	t.Helper()
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, prefix+fmt.Sprintf("%06d-", index)) {
+			return k
+		}
+	}
+	t.Fatalf("no chunk %d under %s", index, prefix)
+	return ""
+}
+
+func TestParity_RestoreRebuildsDamagedChunks(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.parityData, v.parityShards, v.maxChunkSize = 4, 2, 100
+		if encrypted {
+			v.keys = passphraseWrapper{passphrase: []byte("hunter2")}
+		}
+		ctx := context.Background()
+		const uri = "s3://bucket/disk/"
+		data := randomBytes(1050) // 11 chunks: two full stripes and one of three
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		m, err := v.Stat(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Parity == nil || *m.Parity != (Parity{Data: 4, Parity: 2}) {
+			t.Errorf("encrypted=%v: expected the stripe layout in the manifest, got %+v", encrypted, m.Parity)
+		}
+
+		// Lose two chunks of the first stripe, one of the last, and
+		// corrupt one of the second.
+		var lost []string
+		for _, index := range []int{2, 3, 10} {
+			lost = append(lost, chunkKeyAt(t, fake, "disk/", index))
+		}
+		for _, k := range lost {
+			delete(fake.objects, "bucket/"+k)
+		}
+		key := chunkKeyAt(t, fake, "disk/", 6)
+		delete(fake.objects, "bucket/"+key)
+		name := []byte(key)
+		i := strings.Index(key, "-") + 1
+		if name[i] = 'A'; key[i] == 'A' {
+			name[i] = 'B'
+		}
+		fake.objects["bucket/"+string(name)] = nil
+
+		restored := filepath.Join(t.TempDir(), "out")
+		if err := v.Restore(ctx, uri, restored); err != nil {
+			t.Fatalf("encrypted=%v: restore failed: %v", encrypted, err)
+		}
+		if got, _ := os.ReadFile(restored); !bytes.Equal(got, data) {
+			t.Errorf("encrypted=%v: restored file does not match", encrypted)
+		}
+		if r, err := v.Verify(ctx, uri); err != nil || r.OK() {
+			t.Errorf("encrypted=%v: expected Verify to report the damage, got %+v, %v", encrypted, r, err)
+		}
+
+		// A third chunk lost from the first stripe is one too many.
+		delete(fake.objects, "bucket/"+chunkKeyAt(t, fake, "disk/", 1))
+		if err := v.RestoreWriter(ctx, uri, &bytes.Buffer{}); !errors.Is(err, ErrChunkGap) {
+			t.Errorf("encrypted=%v: expected ErrChunkGap, got %v", encrypted, err)
+		}
+	}
+}
+
+func TestParity_EncodeReplacesShards(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.parityData, v.parityShards, v.maxChunkSize = 4, 2, 100
+	ctx := context.Background()
+	const uri = "s3://bucket/disk/"
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1050)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	v.delta = true
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(350)), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	shards := 0
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, "disk/p") {
+			shards++
+		}
+	}
+	if shards != 2 {
+		t.Errorf("expected the two shards of the one stripe left, got %d", shards)
+	}
+}
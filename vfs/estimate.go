@@ -0,0 +1,136 @@
+package vfs
+
+import (
+	"context"
+	"os"
+)
+
+// listPageSize is how many keys a LIST request returns, and how many a
+// multi-object DELETE request removes, at most.
+const listPageSize = 1000
+
+// requestPricing is what an object store charges per 1,000 requests of
+// each kind, in US dollars.
+type requestPricing struct {
+	Put    float64
+	Get    float64
+	List   float64
+	Delete float64
+}
+
+// S3 request prices in us-east-1 by storage class. LIST is charged at the
+// STANDARD PUT price whatever the class, and DELETE is free.
+var (
+	s3StandardPricing = requestPricing{Put: 0.005, Get: 0.0004, List: 0.005}
+	s3RequestPricing  = map[string]requestPricing{
+		"STANDARD_IA":  {Put: 0.01, Get: 0.001, List: 0.005},
+		"ONEZONE_IA":   {Put: 0.01, Get: 0.001, List: 0.005},
+		"GLACIER_IR":   {Put: 0.02, Get: 0.01, List: 0.005},
+		"GLACIER":      {Put: 0.03, Get: 0.0004, List: 0.005},
+		"DEEP_ARCHIVE": {Put: 0.05, Get: 0.0004, List: 0.005},
+	}
+)
+
+// RequestEstimate is how many requests of each kind an operation would
+// make, and what they would cost on S3.
+type RequestEstimate struct {
+	Puts    int64
+	Gets    int64
+	Lists   int64
+	Deletes int64
+	Cost    float64 // US dollars at us-east-1 prices for the storage class
+}
+
+// EstimateEncode counts the requests encoding the file at inputPath to uri
+// would make, from the same chunk-size math as the encode. The store is
+// opened for its key length limit, but sent no requests. Mirrors multiply
+// the count. Compressed input is counted at its full size, and
+// deduplicated input as if no chunk repeated, so both counts are upper
+// bounds.
+func (v *VFS) EstimateEncode(ctx context.Context, inputPath, uri string) (*RequestEstimate, error) {
+	stat, err := os.Stat(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if v.dedup {
+		chunkSize := dedupChunkSize
+		if v.maxChunkSize > 0 {
+			chunkSize = v.maxChunkSize
+		}
+		chunks := chunkCount(stat.Size(), chunkSize)
+		// Each chunk is marked as used, looked up in the pool, and
+		// stored if missing.
+		return v.priced(RequestEstimate{Puts: 2*chunks + 2, Gets: 1, Lists: chunks + 2, Deletes: 1}), nil
+	}
+
+	size, bodies := stat.Size(), v.storesBodies(b, v.encodePrefix(prefix), stat.Size())
+	formatSize := size
+	if v.compression != CompressionNone {
+		formatSize = compressBound(size)
+	}
+	limit := b.KeyLimit(v.encodePrefix(prefix))
+	if v.parityShards > 0 && !bodies {
+		limit -= parityNameExtra
+	}
+	base := keyFormat{body: bodies, maxChunk: v.maxChunkSize}
+	if v.keys != nil {
+		base.codec = gcmCodec{} // only its overhead counts
+	}
+	format, err := encodeKeyFormat(formatSize, limit, base)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := format.chunkSize(limit)
+	if chunkSize < 1 {
+		return nil, errChunkSizeTooSmall
+	}
+
+	// The prefix is checked for data, the staging marker is stored first
+	// and deleted last, and the manifest goes up after the chunks.
+	chunks := chunkCount(size, chunkSize)
+	e := RequestEstimate{Puts: chunks + 2, Lists: 1, Deletes: 1}
+	if v.parityShards > 0 {
+		shards := (chunks + int64(v.parityData) - 1) / int64(v.parityData) * int64(v.parityShards)
+		// Stale shards are looked for in two listings of the prefix.
+		e.Puts += shards
+		e.Lists += 2 * listPages(chunks+shards)
+	}
+	if v.splitLayout {
+		e.Puts++
+	}
+	if v.keys != nil {
+		e.Puts++ // the marker of encrypted chunks
+	}
+	return v.priced(e), nil
+}
+
+// EstimateDelete counts the requests deleting objects objects of one
+// archive would make: listing them for manifests and reading the manifest
+// for an Object Lock, listing them again in pages, and deleting each page
+// at once. Every nested archive, version, or snapshot adds a read, and
+// deleting an archive whose chunks are deduplicated takes more.
+func (v *VFS) EstimateDelete(objects int64) *RequestEstimate {
+	pages := listPages(objects)
+	return v.priced(RequestEstimate{Gets: 1, Lists: 1 + 2*pages, Deletes: pages})
+}
+
+// priced returns e for every copy of an archive, with its cost.
+func (v *VFS) priced(e RequestEstimate) *RequestEstimate {
+	copies := int64(1 + len(v.mirrors))
+	e.Puts, e.Gets, e.Lists, e.Deletes = e.Puts*copies, e.Gets*copies, e.Lists*copies, e.Deletes*copies
+	p, ok := s3RequestPricing[v.storageClass]
+	if !ok {
+		p = s3StandardPricing
+	}
+	e.Cost = (float64(e.Puts)*p.Put + float64(e.Gets)*p.Get + float64(e.Lists)*p.List + float64(e.Deletes)*p.Delete) / 1000
+	return &e
+}
+
+// listPages returns how many LIST requests it takes to list n keys.
+func listPages(n int64) int64 {
+	return max(1, (n+listPageSize-1)/listPageSize)
+}
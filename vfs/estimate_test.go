@@ -0,0 +1,124 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// countingBackend counts the requests made to the backend it wraps.
+type countingBackend struct {
+	Backend
+	mu  sync.Mutex
+	got RequestEstimate
+}
+
+func (b *countingBackend) Put(ctx context.Context, key string, body []byte) error {
+	b.mu.Lock()
+	b.got.Puts++
+	b.mu.Unlock()
+	return b.Backend.Put(ctx, key, body)
+}
+
+func (b *countingBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	b.got.Gets++
+	b.mu.Unlock()
+	return b.Backend.Get(ctx, key)
+}
+
+func (b *countingBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	return b.Backend.List(ctx, prefix, func(objs []Object) error {
+		b.mu.Lock()
+		b.got.Lists++
+		b.mu.Unlock()
+		return fn(objs)
+	})
+}
+
+func (b *countingBackend) Delete(ctx context.Context, keys []string) error {
+	b.mu.Lock()
+	b.got.Deletes++
+	b.mu.Unlock()
+	return b.Backend.Delete(ctx, keys)
+}
+
+func newCountingTestVFS(fake *fakeS3) (*VFS, *countingBackend) {
+	v := newTestVFS(fake)
+	cb := &countingBackend{Backend: &s3Backend{client: fake, bucket: "bucket"}}
+	v.backends["count"] = func(context.Context, string) (Backend, error) { return cb, nil }
+	return v, cb
+}
+
+func TestEstimateEncode_MatchesRequestsMade(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(v *VFS)
+	}{
+		{"plain", func(v *VFS) {}},
+		{"split layout", func(v *VFS) { v.splitLayout = true }},
+		{"parity", func(v *VFS) { v.parityData, v.parityShards = 4, 2 }},
+		{"bodies", func(v *VFS) { v.bodyThreshold = 1 }},
+		{"encrypted", func(v *VFS) { v.keys = passphraseWrapper{passphrase: []byte("hunter2")} }},
+		{"dedup", func(v *VFS) { v.dedup, v.maxChunkSize = true, 1000 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeS3()
+			v, cb := newCountingTestVFS(fake)
+			tc.setup(v)
+			path := writeTempFile(t, randomBytes(20000))
+			want, err := v.EstimateEncode(context.Background(), path, "count://bucket/f/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Encode(context.Background(), path, "count://bucket/f/", false); err != nil {
+				t.Fatal(err)
+			}
+			got := cb.got
+			if got.Puts != want.Puts || got.Gets != want.Gets || got.Lists != want.Lists || got.Deletes != want.Deletes {
+				t.Errorf("estimated %+v, made %+v", *want, got)
+			}
+			if want.Cost <= 0 {
+				t.Errorf("expected a cost, got %v", want.Cost)
+			}
+		})
+	}
+}
+
+func TestEstimateDelete_MatchesRequestsMade(t *testing.T) {
+	fake := newFakeS3()
+	for i := 0; i < 2500; i++ {
+		fake.objects[fmt.Sprintf("bucket/x/%06d-AAAA", i)] = nil
+	}
+	fake.objects["bucket/x/"+manifestName] = []byte(`{"version":1}`)
+	v, cb := newCountingTestVFS(fake)
+	want := v.EstimateDelete(2501)
+	if err := v.Delete(context.Background(), "count://bucket/x/"); err != nil {
+		t.Fatal(err)
+	}
+	if cb.got.Gets != want.Gets || cb.got.Lists != want.Lists || cb.got.Deletes != want.Deletes {
+		t.Errorf("estimated %+v, made %+v", *want, cb.got)
+	}
+}
+
+func TestEstimate_PricesByStorageClassAndMirrors(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	path := writeTempFile(t, randomBytes(20000))
+	standard, err := v.EstimateEncode(context.Background(), path, "s3://bucket/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.storageClass = "GLACIER_IR"
+	v.mirrors = []string{"s3://other/"}
+	glacier, err := v.EstimateEncode(context.Background(), path, "s3://bucket/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if glacier.Puts != 2*standard.Puts {
+		t.Errorf("expected a mirror to double the PUTs, got %d and %d", standard.Puts, glacier.Puts)
+	}
+	if glacier.Cost <= 2*standard.Cost {
+		t.Errorf("expected GLACIER_IR PUTs to cost more, got %v and %v", standard.Cost, glacier.Cost)
+	}
+}
@@ -0,0 +1,70 @@
+package vfs
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WithExpiry records in the manifest of every new archive that it expires
+// d after it is encoded. Expired archives still restore; GC with Expired
+// deletes them. Useful for artifacts passed between CI jobs.
+func WithExpiry(d time.Duration) Option {
+	return func(o *options) {
+		o.expiry = d
+	}
+}
+
+// expiresAt returns when an archive encoded at created expires, nil if
+// archives do not.
+func (v *VFS) expiresAt(created time.Time) *time.Time {
+	if v.expiry <= 0 {
+		return nil
+	}
+	t := created.Add(v.expiry)
+	return &t
+}
+
+// expired returns the directories of the archives among manifests, keys
+// of their manifests, whose expiry has passed at now, leaving out kept
+// versions and snapshots, which go with their archive, and archives that
+// are, or hold one that is, still under an Object Lock. split lists the
+// archives in the split layout.
+func (v *VFS) expired(ctx context.Context, b Backend, prefix string, manifests, split map[string]bool, now time.Time) ([]string, error) {
+	var expired, locked []string
+	for key := range manifests {
+		dir, _ := archiveDir(key, prefix, manifestName)
+		if p, ok := strings.CutSuffix(dir, metaDir); ok && split[p] {
+			dir = p
+		}
+		meta, err := readManifestMeta(ctx, b, key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := meta.Lock.holds(now); ok {
+			locked = append(locked, dir)
+			continue
+		}
+		if isKept(strings.TrimPrefix(dir, prefix)) {
+			continue
+		}
+		if meta.Expires != nil && !meta.Expires.After(now) {
+			expired = append(expired, dir)
+		}
+	}
+	sort.Strings(expired)
+	// An archive nested in another goes with it, so neither can go while
+	// the nested one is locked.
+	out := expired[:0]
+	for _, dir := range expired {
+		if len(out) > 0 && strings.HasPrefix(dir, out[len(out)-1]) {
+			continue
+		}
+		if !slices.ContainsFunc(locked, func(l string) bool { return strings.HasPrefix(l, dir) }) {
+			out = append(out, dir)
+		}
+	}
+	return out, nil
+}
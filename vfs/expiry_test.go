@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGC_DeletesExpiredArchives(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.expiry = time.Millisecond
+	v.keys = passphraseWrapper{passphrase: []byte("hunter2")}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/ci/build-1/", false); err != nil {
+		t.Fatal(err)
+	}
+	v.splitLayout = true
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/ci/build-2/", false); err != nil {
+		t.Fatal(err)
+	}
+	v.expiry = time.Hour
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/ci/build-3/", false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := v.Stat(ctx, "s3://bucket/ci/build-3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Expires == nil || m.Expires.Sub(*m.Created) != time.Hour {
+		t.Errorf("expected the archive to expire an hour after it was encoded, got %v", m.Expires)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// GC reads the expiry without the key, and only with Expired.
+	v = newTestVFS(fake)
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Expired) != 0 {
+		t.Errorf("expected nothing expired without Expired, got %v", r.Expired)
+	}
+	r, err = v.GC(ctx, "s3://bucket/ci/", GCOptions{Expired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"s3://bucket/ci/build-1/", "s3://bucket/ci/build-2/"}; !reflect.DeepEqual(r.Expired, want) {
+		t.Errorf("expected %v to expire, got %v", want, r.Expired)
+	}
+	for _, k := range fake.keys("bucket") {
+		if !strings.HasPrefix(k, "ci/build-3/") {
+			t.Errorf("expected only build-3 to be left, found %s", k)
+		}
+	}
+}
+
+func TestGC_KeepsExpiredArchiveUnderLock(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.expiry = time.Millisecond
+	v.lock = lockPolicy{mode: LockGovernance, period: time.Hour}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{Expired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Expired) != 0 {
+		t.Errorf("expected the locked archive to be kept, got %v", r.Expired)
+	}
+}
+
+func TestGC_KeepsExpiredArchiveHoldingLockedOne(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.expiry = time.Millisecond
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/dir/", false); err != nil {
+		t.Fatal(err)
+	}
+	v.lock = lockPolicy{mode: LockGovernance, period: time.Hour}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/dir/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{Expired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Expired) != 0 {
+		t.Errorf("expected the archive holding a locked one to be kept, got %v", r.Expired)
+	}
+	if _, err := v.Stat(ctx, "s3://bucket/dir/f/"); err != nil {
+		t.Errorf("expected the locked archive to be kept, got %v", err)
+	}
+}
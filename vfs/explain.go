@@ -0,0 +1,91 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	// S3 sustains roughly this many PUT requests per second per prefix
+	// before it starts answering with 503 SlowDown.
+	s3PutRatePerPrefix = 3500
+	assumedPutLatency  = 50 * time.Millisecond
+)
+
+// EncodePlan describes what encoding a file of a given size would cost,
+// without touching S3.
+type EncodePlan struct {
+	Size        int64
+	ChunkSize   int
+	Chunks      int64
+	Concurrency int
+	PutRate     float64 // projected PutObject requests per second
+	Duration    time.Duration
+	Shards      int // prefixes needed to stay under the per-prefix rate
+	Advice      []string
+}
+
+// Explain plans encoding the file at inputPath to uri. The store is opened
+// for its key length limit, but sent no requests.
+func (v *VFS) Explain(ctx context.Context, inputPath, uri string) (*EncodePlan, error) {
+	stat, err := os.Stat(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return planEncode(stat.Size(), b.KeyLimit(v.encodePrefix(prefix)), v.concurrency, v.maxChunkSize)
+}
+
+// PlanEncode plans encoding size bytes to uri on S3, whose key length
+// limit it assumes whatever the scheme; Explain asks the backend instead.
+func PlanEncode(size int64, uri string, concurrency int) (*EncodePlan, error) {
+	_, _, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return planEncode(size, s3MaxKeyLengthBytes-len(prefix), concurrency, 0)
+}
+
+// planEncode plans an encode into keys of limit bytes past their prefix,
+// with chunks of at most maxChunk bytes, if it is positive.
+func planEncode(size int64, limit, concurrency, maxChunk int) (*EncodePlan, error) {
+	format, err := encodeKeyFormat(size, limit, keyFormat{maxChunk: maxChunk})
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := format.chunkSize(limit)
+	if chunkSize < 1 {
+		return nil, errChunkSizeTooSmall
+	}
+
+	plan := &EncodePlan{
+		Size:        size,
+		ChunkSize:   chunkSize,
+		Chunks:      chunkCount(size, chunkSize),
+		Concurrency: concurrency,
+		Shards:      1,
+	}
+	if advice := storageModeAdvice(chunkSize, size); advice != "" {
+		plan.Advice = append(plan.Advice, advice)
+	}
+
+	// Uploads that finish within a second are bursts S3 absorbs; only a
+	// sustained rate counts against the per-prefix guidance.
+	workerRate := float64(concurrency) / assumedPutLatency.Seconds()
+	plan.PutRate = math.Min(workerRate, float64(plan.Chunks))
+	plan.Duration = time.Duration(float64(plan.Chunks) / workerRate * float64(time.Second))
+
+	if plan.PutRate > s3PutRatePerPrefix {
+		plan.Shards = int(math.Ceil(plan.PutRate / s3PutRatePerPrefix))
+		plan.Advice = append(plan.Advice, fmt.Sprintf(
+			"⚠️  Projected %.0f PUT/s exceeds S3's ~%d PUT/s per-prefix guidance; spread chunks across %d prefixes or lower S3_CONCURRENCY to %d.",
+			plan.PutRate, s3PutRatePerPrefix, plan.Shards, int(s3PutRatePerPrefix*assumedPutLatency.Seconds())))
+	}
+	return plan, nil
+}
@@ -0,0 +1,63 @@
+package vfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPlanEncode_HotSpotRecommendsShards(t *testing.T) {
+	plan, err := PlanEncode(10<<30, "s3://bucket/big/", 512)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.PutRate <= s3PutRatePerPrefix {
+		t.Fatalf("expected projected rate above %d, got %.0f", s3PutRatePerPrefix, plan.PutRate)
+	}
+	if plan.Shards != 3 {
+		t.Errorf("expected 3 shards for 512 workers, got %d", plan.Shards)
+	}
+	if len(plan.Advice) == 0 || !strings.Contains(plan.Advice[len(plan.Advice)-1], "3 prefixes") {
+		t.Errorf("expected shard recommendation in advice, got %q", plan.Advice)
+	}
+}
+
+func TestPlanEncode_ShortBurstNeedsNoShards(t *testing.T) {
+	plan, err := PlanEncode(64<<10, "s3://bucket/small/", 512)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Shards != 1 || len(plan.Advice) != 0 {
+		t.Errorf("expected no advice for %d chunks, got shards=%d advice=%q", plan.Chunks, plan.Shards, plan.Advice)
+	}
+}
+
+func TestPlanEncode_DefaultConcurrency(t *testing.T) {
+	plan, err := PlanEncode(10<<30, "s3://bucket/big/", defaultConcurrency)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Shards != 1 {
+		t.Errorf("expected default concurrency to stay under the prefix rate, got %d shards", plan.Shards)
+	}
+	if plan.Duration <= 0 {
+		t.Errorf("expected a positive duration estimate, got %v", plan.Duration)
+	}
+}
+
+func TestExplain_UsesBackendKeyLimit(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	input := writeTempFile(t, randomBytes(5000))
+	s3, err := v.Explain(ctx, input, "s3://bucket/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := v.Explain(ctx, input, "file://"+t.TempDir()+"/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ChunkSize >= s3.ChunkSize {
+		t.Errorf("expected smaller chunks in file names than in S3 keys, got %d and %d", file.ChunkSize, s3.ChunkSize)
+	}
+}
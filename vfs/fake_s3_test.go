@@ -0,0 +1,175 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 is an in-memory stand-in for the subset of the S3 API used by VFS.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte    // "bucket/key" -> body
+	modified map[string]time.Time // "bucket/key" -> last PutObject
+
+	// putHook, when set, runs before every PutObject and can fail it.
+	putHook func() error
+	// deleteHook, when set, runs before every DeleteObjects with its keys
+	// and can fail it.
+	deleteHook func(keys []string) error
+	// onPut, when set, sees the input of every successful PutObject.
+	onPut func(*s3.PutObjectInput)
+	// copies counts CopyObject calls.
+	copies int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), modified: make(map[string]time.Time)}
+}
+
+func newTestVFS(client s3API) *VFS {
+	v := &VFS{
+		client:      client,
+		concurrency: defaultConcurrency,
+		limiter:     newAIMDLimiter(defaultConcurrency),
+		retry:       DefaultRetryPolicy,
+		progressMu:  new(sync.Mutex),
+	}
+	v.backends = map[string]BackendFactory{"s3": v.openS3, "file": openFile}
+	return v
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.putHook != nil {
+		if err := f.putHook(); err != nil {
+			return nil, err
+		}
+	}
+	var body []byte
+	if in.Body != nil {
+		var err error
+		if body, err = io.ReadAll(in.Body); err != nil {
+			return nil, err
+		}
+	}
+	f.mu.Lock()
+	f.objects[*in.Bucket+"/"+*in.Key] = body
+	f.modified[*in.Bucket+"/"+*in.Key] = time.Now()
+	if f.onPut != nil {
+		f.onPut(in)
+	}
+	f.mu.Unlock()
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	body, ok := f.objects[*in.Bucket+"/"+*in.Key]
+	f.mu.Unlock()
+	if !ok {
+		return nil, &s3types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucketPrefix := *in.Bucket + "/"
+	prefix := ""
+	if in.Prefix != nil {
+		prefix = *in.Prefix
+	}
+	var keys []string
+	for k := range f.objects {
+		if key, ok := strings.CutPrefix(k, bucketPrefix); ok && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	if in.ContinuationToken != nil {
+		i := sort.SearchStrings(keys, *in.ContinuationToken)
+		keys = keys[i:]
+	}
+	maxKeys := 1000
+	if in.MaxKeys != nil {
+		maxKeys = int(*in.MaxKeys)
+	}
+
+	out := &s3.ListObjectsV2Output{}
+	if len(keys) > maxKeys {
+		next := keys[maxKeys]
+		out.NextContinuationToken = &next
+		out.IsTruncated = boolPtr(true)
+		keys = keys[:maxKeys]
+	}
+	for _, k := range keys {
+		key := k
+		size := int64(len(f.objects[bucketPrefix+k]))
+		modified := f.modified[bucketPrefix+k]
+		out.Contents = append(out.Contents, s3types.Object{Key: &key, Size: &size, LastModified: &modified})
+	}
+	out.KeyCount = int32Ptr(int32(len(keys)))
+	return out, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, in *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if f.deleteHook != nil {
+		keys := make([]string, len(in.Delete.Objects))
+		for i, obj := range in.Delete.Objects {
+			keys[i] = *obj.Key
+		}
+		if err := f.deleteHook(keys); err != nil {
+			return nil, err
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, obj := range in.Delete.Objects {
+		delete(f.objects, *in.Bucket+"/"+*obj.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (f *fakeS3) CopyObject(ctx context.Context, in *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	source, err := url.PathUnescape(*in.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.objects[source]
+	if !ok {
+		return nil, &s3types.NoSuchKey{}
+	}
+	f.objects[*in.Bucket+"/"+*in.Key] = body
+	f.modified[*in.Bucket+"/"+*in.Key] = time.Now()
+	f.copies++
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) keys(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		if key, ok := strings.CutPrefix(k, bucket+"/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolPtr(b bool) *bool    { return &b }
+func int32Ptr(n int32) *int32 { return &n }
@@ -0,0 +1,135 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// Most filesystems limit a single file name to 255 bytes.
+	fileMaxNameBytes = 255
+	fileListPageSize = 1000
+)
+
+// fileBackend stores archives in a local directory tree. Keys map to paths
+// below root, so chunks become empty files named "<index>-<data>", just like
+// S3 keys.
+type fileBackend struct {
+	root   string
+	prefix string // of the URI, which Delete stays within
+}
+
+// openFile serves file:///abs/dir/ (root "/") and file://dir/prefix/, where
+// dir is relative to the working directory.
+func openFile(ctx context.Context, bucket string) (Backend, error) {
+	root := bucket
+	if root == "" {
+		root = "/"
+	}
+	return &fileBackend{root: root}, nil
+}
+
+func (b *fileBackend) Scoped(prefix string) Backend {
+	return &fileBackend{root: b.root, prefix: prefix}
+}
+
+func (b *fileBackend) path(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(key))
+}
+
+func (b *fileBackend) Put(ctx context.Context, key string, body []byte) error {
+	p := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, body, 0644)
+}
+
+func (b *fileBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(b.path(key))
+}
+
+func (b *fileBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	var objs []Object
+	err := filepath.WalkDir(b.path(dir), func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objs = append(objs, Object{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].Key < objs[j].Key
+	})
+	for len(objs) > 0 {
+		page := objs[:min(len(objs), fileListPageSize)]
+		objs = objs[len(page):]
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the files for keys and then any directories left empty
+// between them and the directory of the URI prefix, that one included.
+// Directories above it are left alone, even if empty.
+func (b *fileBackend) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(b.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	root := filepath.Clean(b.root)
+	for _, key := range keys {
+		stop := root
+		if b.prefix != "" && strings.HasPrefix(key, b.prefix) {
+			stop = filepath.Dir(b.path(b.prefix))
+		}
+		for dir := filepath.Dir(b.path(key)); dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (b *fileBackend) KeyLimit(prefix string) int {
+	return fileMaxNameBytes
+}
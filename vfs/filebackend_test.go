@@ -0,0 +1,93 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFileBackend_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	uri := "file://" + dir + "/archive/"
+	v := newTestVFS(nil)
+
+	data := randomBytes(5000)
+	if err := v.Encode(context.Background(), writeTempFile(t, data), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkSize := keyChunkSize(fileMaxNameBytes)
+	if got, want := len(entries), int(chunkCount(int64(len(data)), chunkSize))+1; got != want {
+		t.Errorf("expected %d chunk files and a manifest, got %d", want-1, got)
+	}
+	for _, e := range entries {
+		if len(e.Name()) > fileMaxNameBytes {
+			t.Errorf("file name %q exceeds %d bytes", e.Name(), fileMaxNameBytes)
+		}
+	}
+
+	if err := v.Append(context.Background(), writeTempFile(t, []byte("tail")), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(context.Background(), uri, &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), append(data, "tail"...)) {
+		t.Error("restored data does not match")
+	}
+
+	if err := v.Delete(context.Background(), uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "archive")); !os.IsNotExist(err) {
+		t.Errorf("expected the archive directory to be removed, got %v", err)
+	}
+}
+
+func TestFileBackend_DeleteStaysWithinPrefix(t *testing.T) {
+	dir := t.TempDir()
+	v := newTestVFS(nil)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(500)), "file://"+dir+"/keep/archive/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ctx, "file://"+dir+"/keep/archive/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep", "archive")); !os.IsNotExist(err) {
+		t.Errorf("expected the archive directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep")); err != nil {
+		t.Errorf("expected the empty directory above the archive to be kept, got %v", err)
+	}
+}
+
+func TestFileBackend_ListFiltersByPrefix(t *testing.T) {
+	b := &fileBackend{root: t.TempDir()}
+	ctx := context.Background()
+	for _, key := range []string{"a/1-x", "a/2-y", "ab/1-z", "b/1-w"} {
+		if err := b.Put(ctx, key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	err := b.List(ctx, "a", func(objs []Object) error {
+		for _, o := range objs {
+			keys = append(keys, o.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/1-x", "a/2-y", "ab/1-z"}; !slices.Equal(keys, want) {
+		t.Errorf("expected %v, got %v", want, keys)
+	}
+}
@@ -0,0 +1,142 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// EncodeFiles stores each file at paths as its own archive under uri, at
+// "<uri><base name>/", encoding several files at once. Chunk uploads of all
+// files share the concurrency limit of the VFS, and progress is reported as
+// one encode over all of them. Unless force is set, it fails with
+// ErrPrefixExists before uploading anything if any of the archives exists.
+// A file that fails does not stop the others: EncodeFiles returns the URIs
+// of the archives it stored, in the order of paths, with the failures
+// joined in the error.
+func (v *VFS) EncodeFiles(ctx context.Context, paths []string, uri string, force bool) ([]string, error) {
+	scheme, bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s://%s/%s", scheme, bucket, prefix)
+	uris := make([]string, len(paths))
+	byName := make(map[string]string, len(paths))
+	var total int64
+	for i, path := range paths {
+		name := filepath.Base(path)
+		if other, ok := byName[name]; ok {
+			return nil, fmt.Errorf("%s and %s would both be stored at %s%s/", other, path, base, name)
+		}
+		byName[name] = path
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s is not a regular file", path)
+		}
+		uris[i] = base + name + "/"
+		total += info.Size()
+	}
+	if !force && !v.resume {
+		var taken []string
+		for _, u := range uris {
+			b, prefix, err := v.open(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+			exists, err := v.hasObjects(ctx, b, prefix)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				taken = append(taken, u)
+			}
+		}
+		if len(taken) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrPrefixExists, strings.Join(taken, ", "))
+		}
+	}
+
+	p := &filesProgress{v: v, files: len(paths), bytesTotal: total}
+	v.emit(Event{Op: OpEncode, Kind: EventStart, BytesTotal: total})
+	errs := make([]error, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	// Each encode holds a chunk while it waits for an upload slot, so the
+	// number of files in flight is capped too.
+	for range min(len(paths), v.concurrency) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fv := v.ProgressTo(p.event)
+				if err := fv.Encode(ctx, paths[i], uris[i], force); err != nil {
+					errs[i] = fmt.Errorf("%s: %w", paths[i], err)
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var stored []string
+	for i, err := range errs {
+		if err == nil {
+			stored = append(stored, uris[i])
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return stored, err
+	}
+	v.emit(Event{Op: OpEncode, Kind: EventDone, Done: p.done, Total: p.done, BytesDone: p.bytesDone, BytesTotal: total})
+	return stored, nil
+}
+
+// filesProgress merges the events of the encodes of EncodeFiles into one
+// encode. Its event method is called under the progress lock of the VFS.
+type filesProgress struct {
+	v          *VFS
+	files      int
+	started    int
+	total      int // chunks of the files started so far
+	done       int
+	bytesDone  int64
+	bytesTotal int64
+}
+
+func (p *filesProgress) event(e Event) {
+	if p.v.progress == nil {
+		return
+	}
+	switch e.Kind {
+	case EventStart:
+		p.started++
+		p.total += e.Total
+	case EventChunk:
+		p.done++
+		p.bytesDone += e.Bytes
+		total := 0 // unknown until every file has started
+		if p.started == p.files {
+			total = p.total
+		}
+		p.v.progress(Event{Op: OpEncode, Kind: EventChunk, Index: e.Index, Bytes: e.Bytes,
+			Done: p.done, Total: total, BytesDone: p.bytesDone, BytesTotal: p.bytesTotal})
+	case EventWarning:
+		p.v.progress(e)
+	}
+}
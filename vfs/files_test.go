@@ -0,0 +1,124 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeNamedFiles writes each of files, by base name, to one directory and
+// returns their paths in name order.
+func writeNamedFiles(t *testing.T, files map[string][]byte, names ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, files[name], 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func TestEncodeFiles_StoresEachFileUnderItsName(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	ctx := context.Background()
+	files := map[string][]byte{"a.sql": randomBytes(450), "b.sql": randomBytes(20), "c.sql": randomBytes(1000)}
+	paths := writeNamedFiles(t, files, "a.sql", "b.sql", "c.sql")
+
+	stored, err := v.EncodeFiles(ctx, paths, "s3://bucket/dumps/", false)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	want := []string{"s3://bucket/dumps/a.sql/", "s3://bucket/dumps/b.sql/", "s3://bucket/dumps/c.sql/"}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("expected %v, got %v", want, stored)
+	}
+	for _, uri := range want {
+		name := filepath.Base(uri)
+		out := filepath.Join(t.TempDir(), name)
+		if err := v.Restore(ctx, uri, out); err != nil {
+			t.Fatalf("restore of %s failed: %v", uri, err)
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, files[name]) {
+			t.Errorf("%s: restored data differs", uri)
+		}
+	}
+}
+
+func TestEncodeFiles_ExistingArchiveFailsBeforeUploading(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	files := map[string][]byte{"a.sql": randomBytes(100), "b.sql": randomBytes(100)}
+	paths := writeNamedFiles(t, files, "a.sql", "b.sql")
+	if err := v.Encode(ctx, paths[1], "s3://bucket/dumps/b.sql/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	before := fake.keys("bucket")
+
+	_, err := v.EncodeFiles(ctx, paths, "s3://bucket/dumps/", false)
+	if !errors.Is(err, ErrPrefixExists) {
+		t.Fatalf("expected ErrPrefixExists, got %v", err)
+	}
+	if got := fake.keys("bucket"); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected nothing uploaded, got %v", got)
+	}
+
+	if _, err := v.EncodeFiles(ctx, paths, "s3://bucket/dumps/", true); err != nil {
+		t.Fatalf("encode with force failed: %v", err)
+	}
+}
+
+func TestEncodeFiles_RejectsDuplicateNames(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	a, b := writeTempFile(t, randomBytes(10)), writeTempFile(t, randomBytes(10))
+	if _, err := v.EncodeFiles(context.Background(), []string{a, b}, "s3://bucket/dumps/", false); err == nil {
+		t.Fatal("expected two files called input.bin to be rejected")
+	}
+}
+
+func TestEncodeFiles_MergesProgress(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	files := map[string][]byte{"a": randomBytes(450), "b": randomBytes(250)}
+	paths := writeNamedFiles(t, files, "a", "b")
+
+	var events []Event
+	v.progress = func(e Event) { events = append(events, e) }
+	if _, err := v.EncodeFiles(context.Background(), paths, "s3://bucket/x/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	var starts, chunks int
+	var last int64
+	for _, e := range events {
+		switch e.Kind {
+		case EventStart:
+			starts++
+			if e.BytesTotal != 700 {
+				t.Errorf("expected a start for 700 bytes, got %+v", e)
+			}
+		case EventChunk:
+			chunks++
+			if e.BytesDone <= last || e.BytesTotal != 700 {
+				t.Errorf("expected growing progress out of 700 bytes, got %+v after %d", e, last)
+			}
+			last = e.BytesDone
+		}
+	}
+	if starts != 1 || chunks != 8 {
+		t.Errorf("expected one start and 8 chunks, got %d and %d", starts, chunks)
+	}
+	if done := events[len(events)-1]; done.Kind != EventDone || done.Done != 8 || done.BytesDone != 700 {
+		t.Errorf("expected a final done event for 8 chunks and 700 bytes, got %+v", done)
+	}
+}
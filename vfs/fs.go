@@ -0,0 +1,362 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultFilename names an archive without a filename in its manifest
+// that holds nested archives, and so is shown as a directory by FS.
+const defaultFilename = "data"
+
+// FS returns a read-only file system of the archives under uri, for code
+// written against io/fs: fs.WalkDir, http.FS, template.ParseFS. Every
+// archive is a file at its path below uri, unless other archives are
+// nested below it: that path is then a directory, holding the archive as
+// a file named by the manifest's filename (or "data").
+//
+// The archives are listed on first use, and that listing serves every
+// later call: call FS again to see archives stored since. Archives whose
+// manifest cannot be read, such as encrypted ones without the key, are
+// left out with a warning. Files are restored as they are read; seeking
+// backwards restores again from the start. The returned file system also
+// implements fs.ReadDirFS and fs.StatFS, and the Sys method of its
+// FileInfos returns the Entry of a file, or nil for a directory.
+func (v *VFS) FS(uri string) fs.FS {
+	if !strings.HasSuffix(uri, "/") {
+		uri += "/"
+	}
+	return &archiveFS{v: v, root: uri}
+}
+
+type archiveFS struct {
+	v    *VFS
+	root string
+
+	mu   sync.Mutex
+	tree *fsNode // nil until listed
+}
+
+// fsNode is a file or directory of an archiveFS.
+type fsNode struct {
+	name     string
+	entry    *Entry             // set for files
+	modified time.Time          // of the file, or latest below a directory
+	children map[string]*fsNode // set for directories
+}
+
+var (
+	_ fs.ReadDirFS = (*archiveFS)(nil)
+	_ fs.StatFS    = (*archiveFS)(nil)
+)
+
+// lookup returns the node at name, listing the archives on first use.
+func (f *archiveFS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	f.mu.Lock()
+	if f.tree == nil {
+		entries, err := f.v.List(context.Background(), f.root)
+		if err != nil {
+			f.mu.Unlock()
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		var skipped []Entry
+		f.tree, skipped = buildTree(f.root, entries)
+		for _, e := range skipped {
+			f.v.warn(OpRestore, fmt.Sprintf("leaving out %s: %v", e.URI, e.Err))
+		}
+	}
+	n := f.tree
+	f.mu.Unlock()
+	if name == "." {
+		return n, nil
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if n = n.children[seg]; n == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	return n, nil
+}
+
+func (f *archiveFS) Open(name string) (fs.File, error) {
+	n, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.children != nil {
+		return &fsDir{n: n, entries: n.dirEntries()}, nil
+	}
+	return &fsFile{v: f.v, n: n}, nil
+}
+
+func (f *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.children == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.dirEntries(), nil
+}
+
+func (f *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// buildTree arranges the archives of entries, listed at root, as a tree.
+// Archives whose manifest cannot be read, or whose name is taken, are
+// left out and returned.
+func buildTree(root string, entries []Entry) (*fsNode, []Entry) {
+	top := newFSDir(".")
+	var skipped []Entry
+	type archive struct {
+		segs []string
+		e    Entry
+	}
+	var archives []archive
+	for _, e := range entries {
+		if e.Err != nil {
+			skipped = append(skipped, e)
+			continue
+		}
+		rel := strings.Trim(strings.TrimPrefix(e.URI, root), "/")
+		var segs []string
+		if rel != "" {
+			segs = strings.Split(rel, "/")
+		}
+		archives = append(archives, archive{segs, e})
+	}
+	// Directories first, so that every archive knows whether it holds
+	// others.
+	for _, a := range archives {
+		dir := top
+		for _, seg := range a.segs[:max(len(a.segs)-1, 0)] {
+			dir = dir.child(seg)
+		}
+		dir.modified = latest(dir.modified, a.e.Modified)
+	}
+	for _, a := range archives {
+		dir, name := top, ""
+		for i, seg := range a.segs {
+			if i == len(a.segs)-1 {
+				name = seg
+				break
+			}
+			dir = dir.children[seg]
+		}
+		if n, ok := dir.children[name]; ok && n.children != nil || name == "" {
+			if name != "" {
+				dir = n // other archives are nested below this one
+			}
+			name = a.e.Manifest.Filename
+			if name == "" || !fs.ValidPath(name) || strings.Contains(name, "/") {
+				name = defaultFilename
+			}
+		}
+		if _, taken := dir.children[name]; taken {
+			skipped = append(skipped, Entry{URI: a.e.URI, Err: fmt.Errorf("the name %q is already taken", name)})
+			continue
+		}
+		e := a.e
+		dir.children[name] = &fsNode{name: name, entry: &e, modified: e.Modified}
+	}
+	return top, skipped
+}
+
+func newFSDir(name string) *fsNode {
+	return &fsNode{name: name, children: make(map[string]*fsNode)}
+}
+
+// child returns the directory called name in n, adding it if needed.
+func (n *fsNode) child(name string) *fsNode {
+	c, ok := n.children[name]
+	if !ok {
+		c = newFSDir(name)
+		n.children[name] = c
+	}
+	return c
+}
+
+// dirEntries returns the children of n sorted by name.
+func (n *fsNode) dirEntries() []fs.DirEntry {
+	out := make([]fs.DirEntry, 0, len(n.children))
+	for _, c := range n.children {
+		out = append(out, fs.FileInfoToDirEntry(c))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// fsNode is its own fs.FileInfo.
+
+func (n *fsNode) Name() string { return n.name }
+func (n *fsNode) IsDir() bool  { return n.children != nil }
+
+func (n *fsNode) Size() int64 {
+	if n.entry == nil {
+		return 0
+	}
+	return n.entry.Manifest.Size
+}
+
+func (n *fsNode) Mode() fs.FileMode {
+	if n.children != nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ModTime is the modification time of the encoded file, or when it was
+// first stored, if the manifest says, or else when the archive was last
+// written.
+func (n *fsNode) ModTime() time.Time {
+	if n.entry != nil && n.entry.Manifest.Attrs != nil {
+		return n.entry.Manifest.Attrs.Modified
+	}
+	if n.entry != nil && n.entry.Manifest.Created != nil {
+		return *n.entry.Manifest.Created
+	}
+	return n.modified
+}
+
+func (n *fsNode) Sys() any {
+	if n.entry == nil {
+		return nil
+	}
+	return n.entry
+}
+
+// fsDir is an open directory of an archiveFS.
+type fsDir struct {
+	n       *fsNode
+	entries []fs.DirEntry
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.n, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.n.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		out := d.entries
+		d.entries = nil
+		return out, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(d.entries))
+	out := d.entries[:count]
+	d.entries = d.entries[count:]
+	return out, nil
+}
+
+// fsFile is an open file of an archiveFS. It restores the archive into a
+// pipe as it is read, starting over when a read does not continue where
+// the last one stopped.
+type fsFile struct {
+	v *VFS
+	n *fsNode
+
+	pos    int64 // of the next read
+	r      *io.PipeReader
+	rpos   int64 // of the next byte from r
+	cancel context.CancelFunc
+	closed bool
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.n, nil }
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.pos >= f.n.Size() {
+		return 0, io.EOF
+	}
+	if f.r != nil && f.rpos > f.pos {
+		f.stop()
+	}
+	if f.r == nil {
+		f.start()
+	}
+	if f.rpos < f.pos {
+		n, err := io.CopyN(io.Discard, f.r, f.pos-f.rpos)
+		f.rpos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	f.rpos += int64(n)
+	return n, err
+}
+
+// Seek only moves the position: the next Read continues the restore, or
+// starts it over.
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.n.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.n.name, Err: fs.ErrInvalid}
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	f.stop()
+	return nil
+}
+
+func (f *fsFile) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(f.v.RestoreWriter(ctx, f.n.entry.URI, w))
+	}()
+	f.r, f.rpos, f.cancel = r, 0, cancel
+}
+
+func (f *fsFile) stop() {
+	if f.r == nil {
+		return
+	}
+	f.cancel()
+	f.r.Close()
+	f.r = nil
+}
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS_ReadsArchivesAsFiles(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	// An archive with others below it is shown as a file in a directory.
+	nested := randomBytes(200)
+	if err := v.Encode(ctx, writeTempFile(t, nested), "s3://bucket/p/logs/", false); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"s3://bucket/p/a/":         randomBytes(500),
+		"s3://bucket/p/logs/2024/": randomBytes(3000),
+		"s3://bucket/p/logs/2025/": randomBytes(10),
+	}
+	for uri, data := range files {
+		if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := v.FS("s3://bucket/p")
+	if err := fstest.TestFS(fsys, "a", "logs/2024", "logs/2025", "logs/input.bin"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "logs/input.bin")
+	if err != nil || !bytes.Equal(got, nested) {
+		t.Errorf("expected the nested archive's data, got %d bytes, %v", len(got), err)
+	}
+	info, err := fs.Stat(fsys, "logs/2024")
+	if err != nil || info.Size() != 3000 || info.Sys().(*Entry).URI != "s3://bucket/p/logs/2024/" {
+		t.Errorf("unexpected info %+v, %v", info, err)
+	}
+	if _, err := fsys.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestFS_SeekRestartsRestore(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	data := randomBytes(5000)
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(data), "s3://bucket/p/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	f, err := v.FS("s3://bucket/p/").Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rs := f.(io.ReadSeeker)
+	buf := make([]byte, 100)
+	for _, off := range []int64{4000, 10, 2500} {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(rs, buf); err != nil || !bytes.Equal(buf, data[off:off+100]) {
+			t.Errorf("at %d: expected the data there, got %v", off, err)
+		}
+	}
+	if size, _ := rs.Seek(0, io.SeekEnd); size != 5000 {
+		t.Errorf("expected the size from seeking to the end, got %d", size)
+	}
+}
+
+func TestFS_LeavesOutUnreadableArchives(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	if err := newEncryptedTestVFS(fake, "pass").EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/p/secret/", false); err != nil {
+		t.Fatal(err)
+	}
+	v := newTestVFS(fake)
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/p/plain/", false); err != nil {
+		t.Fatal(err)
+	}
+	var warnings []string
+	v.progress = func(e Event) {
+		if e.Kind == EventWarning {
+			warnings = append(warnings, e.Message)
+		}
+	}
+	entries, err := fs.ReadDir(v.FS("s3://bucket/p/"), ".")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "plain" {
+		t.Fatalf("expected only plain, got %v, %v", entries, err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected a warning about secret, got %v", warnings)
+	}
+}
+
+func TestBuildTree_NameClash(t *testing.T) {
+	// The archive at a/ is shown as a/x, where the archive at a/x/ would go.
+	entries := []Entry{
+		{URI: "s3://bucket/a/", Manifest: &Manifest{Filename: "x"}},
+		{URI: "s3://bucket/a/x/", Manifest: &Manifest{}},
+	}
+	tree, skipped := buildTree("s3://bucket/", entries)
+	var names []string
+	for _, e := range tree.children["a"].dirEntries() {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"x"}) || tree.children["a"].children["x"].entry.URI != "s3://bucket/a/" {
+		t.Errorf("expected a/x to be the archive at a/, got %v", names)
+	}
+	if len(skipped) != 1 || skipped[0].URI != "s3://bucket/a/x/" {
+		t.Errorf("expected the second archive left out, got %v", skipped)
+	}
+}
@@ -0,0 +1,144 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"context"
+	iofs "io/fs"
+	"log/slog"
+	"path"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/internal/filecache"
+)
+
+// Options tunes Mount.
+type Options struct {
+	// CacheDir holds decoded files. Empty means vfs/files in the user's
+	// cache directory (see os.UserCacheDir). Files stay there after
+	// unmounting, so a later mount can reuse them.
+	CacheDir string
+	// AllowOther lets users other than the one mounting read the files.
+	AllowOther bool
+	// Logger gets the files that cannot be decoded, which read as EIO.
+	// Nil means slog.Default().
+	Logger *slog.Logger
+}
+
+// Mount mounts the archives under uri read-only at dir and returns the
+// running filesystem. The tree is listed once, when mounting: archives
+// stored later appear after mounting again. Mounting needs FUSE
+// (fusermount on Linux, macFUSE on macOS).
+func Mount(v *vfs.VFS, uri, dir string, opts Options) (Server, error) {
+	fsys := v.FS(uri)
+	info, err := iofs.Stat(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	c, err := filecache.New(v, opts.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	root := &dirNode{fsys: fsys, path: ".", info: info, cache: c, logger: logger}
+	srv, err := fs.Mount(dir, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			AllowOther: opts.AllowOther,
+			FsName:     uri,
+			Name:       "vfs",
+			Options:    []string{"ro"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// dirNode is a directory of the mounted tree.
+type dirNode struct {
+	fs.Inode
+	fsys   iofs.FS
+	path   string
+	info   iofs.FileInfo
+	cache  *filecache.Cache
+	logger *slog.Logger
+}
+
+var (
+	_ fs.NodeOnAdder   = (*dirNode)(nil)
+	_ fs.NodeGetattrer = (*dirNode)(nil)
+)
+
+// OnAdd adds the whole tree below the directory when it is created. It
+// is read from the listing made when mounting, so it cannot fail.
+func (d *dirNode) OnAdd(ctx context.Context) {
+	entries, _ := iofs.ReadDir(d.fsys, d.path)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		var child fs.InodeEmbedder = &fileNode{info: info, cache: d.cache, logger: d.logger}
+		mode := uint32(fuse.S_IFREG)
+		if info.IsDir() {
+			p := path.Join(d.path, e.Name())
+			child, mode = &dirNode{fsys: d.fsys, path: p, info: info, cache: d.cache, logger: d.logger}, fuse.S_IFDIR
+		}
+		d.AddChild(e.Name(), d.NewPersistentInode(ctx, child, fs.StableAttr{Mode: mode}), false)
+	}
+}
+
+func (d *dirNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFDIR | 0555
+	modified := d.info.ModTime()
+	out.SetTimes(nil, &modified, nil)
+	return 0
+}
+
+// fileNode is a stored file, read from its copy in the cache.
+type fileNode struct {
+	fs.Inode
+	info   iofs.FileInfo // Sys is the *vfs.Entry of the archive
+	cache  *filecache.Cache
+	logger *slog.Logger
+}
+
+var (
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+	_ fs.NodeOpener    = (*fileNode)(nil)
+)
+
+func (f *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = fuse.S_IFREG | 0444
+	out.Size = uint64(f.info.Size())
+	modified := f.info.ModTime()
+	out.SetTimes(nil, &modified, nil)
+	return 0
+}
+
+// Open decodes the file into the cache on first use. Reads then go to the
+// cached copy, with the kernel's page cache kept across opens.
+func (f *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	e := f.info.Sys().(*vfs.Entry)
+	p, err := f.cache.Path(ctx, e.URI, e.Manifest.SHA256)
+	if err != nil {
+		f.logger.WarnContext(ctx, "cannot decode file", "uri", e.URI, "error", err)
+		return nil, 0, syscall.EIO
+	}
+	fd, err := syscall.Open(p, syscall.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	return fs.NewLoopbackFile(fd), fuse.FOPEN_KEEP_CACHE, 0
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package fusefs
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// Options tunes Mount.
+type Options struct {
+	CacheDir   string
+	AllowOther bool
+	Logger     *slog.Logger
+}
+
+// Mount fails: FUSE mounts are supported on Linux and macOS only.
+func Mount(v *vfs.VFS, uri, dir string, opts Options) (Server, error) {
+	return nil, errors.New("mounting is only supported on Linux and macOS")
+}
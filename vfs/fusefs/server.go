@@ -0,0 +1,18 @@
+// Package fusefs mounts the archives under a vfs URI as a read-only
+// filesystem (on Linux and macOS), so tools that only understand files can
+// read stored data:
+//
+//	srv, err := fusefs.Mount(v, "s3://bucket/prefix/", "/mnt/vfs", fusefs.Options{})
+//	srv.Wait()
+//
+// The tree is the one of VFS.FS. A file is decoded the first time it is
+// opened, into a local cache, and read from there.
+package fusefs
+
+// Server is a mounted filesystem.
+type Server interface {
+	// Wait blocks until the filesystem is unmounted.
+	Wait()
+	// Unmount unmounts the filesystem.
+	Unmount() error
+}
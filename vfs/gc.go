@@ -0,0 +1,324 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GCOptions tunes GC.
+type GCOptions struct {
+	// OlderThan protects unfinished uploads, and pooled chunks and marks,
+	// written more recently: they may belong to an encode still running.
+	OlderThan time.Duration
+	// KeepVersions, if positive, is how many of the newest versions of
+	// each archive to keep; older ones are deleted.
+	KeepVersions int
+	// Expired also deletes the archives whose expiry, recorded with
+	// WithExpiry, has passed, with their versions and snapshots.
+	Expired bool
+	// DryRun reports what GC would delete without deleting anything.
+	DryRun bool
+}
+
+// GCReport lists what GC deleted, or would delete in a dry run.
+type GCReport struct {
+	Abandoned []string // URIs of uploads that never finished
+	Expired   []string // URIs of archives past their expiry
+	Versions  []string // URIs of versions past retention
+	Orphans   []string // keys of chunk objects no manifest refers to
+	Pool      []string // keys of pooled chunks and marks no archive uses
+}
+
+// GC deletes the objects under uri that no archive needs: uploads that
+// started more than OlderThan ago and never finished, archives past their
+// expiry with Expired, versions beyond KeepVersions, and chunk objects
+// that the manifest of their archive does not cover, such as chunks past
+// its end, chunks or parity of another storage mode, and whatever an
+// interrupted overwrite left behind. Run on the root of a bucket, it also
+// deletes the marks of deduplicated archives that no longer exist and the
+// pooled chunks left without marks, which Delete leaves for it.
+//
+// Chunks of archives without a manifest, written before manifests, are
+// left alone, as are duplicate chunks of one index: run migrate and
+// verify for those.
+func (v *VFS) GC(ctx context.Context, uri string, opts GCOptions) (*GCReport, error) {
+	scheme, bucket, _, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	root := fmt.Sprintf("%s://%s/", scheme, bucket)
+	cutoff := time.Now().Add(-opts.OlderThan)
+
+	var objs []Object
+	if err := b.List(ctx, prefix, func(page []Object) error {
+		objs = append(objs, page...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	manifests := make(map[string]bool) // keys
+	staging := make(map[string]bool)   // directories of staging markers
+	split := make(map[string]bool)     // archives in the split layout
+	var markers []string
+	for _, obj := range objs {
+		if _, ok := archiveDir(obj.Key, prefix, manifestName); ok {
+			manifests[obj.Key] = true
+		}
+		if dir, ok := archiveDir(obj.Key, prefix, stagingName); ok {
+			staging[dir] = true
+			markers = append(markers, obj.Key)
+		}
+		if dir, ok := archiveDir(obj.Key, prefix, layoutMarker); ok {
+			split[dir] = true
+		}
+	}
+
+	r := &GCReport{}
+	abandoned, err := abandonedUploads(ctx, b, markers, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range abandoned {
+		r.Abandoned = append(r.Abandoned, root+strings.TrimSuffix(dir, metaDir))
+	}
+	var expiredArchives []string
+	if opts.Expired {
+		if expiredArchives, err = v.expired(ctx, b, prefix, manifests, split, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range expiredArchives {
+		r.Expired = append(r.Expired, root+dir)
+	}
+	// What expired archives hold goes with them.
+	expired := expiredVersions(prefix, manifests, opts.KeepVersions)
+	for _, dir := range expired {
+		if !under(dir, expiredArchives) {
+			r.Versions = append(r.Versions, root+dir)
+		}
+	}
+	orphans, err := v.orphanChunks(ctx, b, objs, manifests, staging, split)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range orphans {
+		if !under(key, expiredArchives) {
+			r.Orphans = append(r.Orphans, key)
+		}
+	}
+	if prefix == "" {
+		gone := func(key string) bool {
+			return under(key, expired) || under(key, expiredArchives)
+		}
+		if r.Pool, err = v.unusedPool(ctx, b, objs, manifests, gone, cutoff); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DryRun {
+		return r, nil
+	}
+
+	for _, dir := range abandoned {
+		if err := v.deleteArchive(ctx, b, dir); err != nil {
+			return nil, err
+		}
+	}
+	for _, uri := range r.Expired {
+		if err := v.Delete(ctx, uri); err != nil {
+			return nil, err
+		}
+	}
+	for _, version := range r.Versions {
+		if err := v.Delete(ctx, version); err != nil {
+			return nil, err
+		}
+	}
+	// Deleting versions already removed their marks, which are in r.Pool
+	// too. Chunks go last, each checked for marks again first: an encode
+	// may have marked one since the listing, and found it stored.
+	var marks, chunks []string
+	for _, key := range r.Pool {
+		if strings.HasPrefix(key, poolDir+"chunks/") {
+			chunks = append(chunks, key)
+		} else {
+			marks = append(marks, key)
+		}
+	}
+	for _, keys := range [][]string{r.Orphans, marks} {
+		for len(keys) > 0 {
+			batch := keys[:min(len(keys), mirrorListPageSize)]
+			keys = keys[len(batch):]
+			if err := v.deleteWithBackoff(ctx, b, batch); err != nil {
+				return nil, err
+			}
+		}
+	}
+	r.Pool = marks
+	for _, key := range chunks {
+		hash := strings.TrimPrefix(key, poolDir+"chunks/")
+		if used, err := v.hasObjects(ctx, b, poolDir+"refs/"+hash+"/"); err != nil {
+			return nil, err
+		} else if used {
+			continue
+		}
+		if err := v.deleteWithBackoff(ctx, b, []string{key}); err != nil {
+			return nil, err
+		}
+		r.Pool = append(r.Pool, key)
+	}
+	sort.Strings(r.Pool)
+	return r, nil
+}
+
+// under reports whether key is under one of dirs.
+func under(key string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(key, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredVersions returns the directories of the versions, among the
+// archives whose manifest keys are in manifests, that are older than the
+// newest keep of their archive. keep <= 0 keeps every version.
+func expiredVersions(prefix string, manifests map[string]bool, keep int) []string {
+	if keep <= 0 {
+		return nil
+	}
+	versions := make(map[string][]int) // archive -> version numbers
+	for key := range manifests {
+		archive, rest, ok := strings.Cut(strings.TrimPrefix(key, prefix), versionsDir)
+		if !ok || isKept(archive) {
+			continue // not a version, or a version of a version
+		}
+		dir, _, _ := strings.Cut(rest, "/")
+		if n, ok := versionNumber(dir + "/"); ok {
+			versions[prefix+archive] = append(versions[prefix+archive], n)
+		}
+	}
+	var expired []string
+	for archive, numbers := range versions {
+		sort.Ints(numbers)
+		for _, n := range numbers[:max(len(numbers)-keep, 0)] {
+			expired = append(expired, fmt.Sprintf("%s%sv%d/", archive, versionsDir, n))
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// orphanChunks returns the keys among objs of chunks, body parts and
+// parity shards that the manifest of their archive does not cover.
+// Archives being uploaded (with a staging marker) or without a manifest
+// are skipped.
+func (v *VFS) orphanChunks(ctx context.Context, b Backend, objs []Object, manifests, staging, split map[string]bool) ([]string, error) {
+	byDir := make(map[string][]string)
+	for _, obj := range objs {
+		i := strings.LastIndex(obj.Key, "/")
+		byDir[obj.Key[:i+1]] = append(byDir[obj.Key[:i+1]], obj.Key)
+	}
+	var orphans []string
+	for dir, keys := range byDir {
+		archive := dir
+		if p, ok := strings.CutSuffix(dir, dataDir); ok && split[p] {
+			archive = p
+		}
+		key := manifestKey(archive, dir)
+		if staging[metaKey(archive, dir, "")] || !manifests[key] {
+			continue
+		}
+		m, err := v.readManifest(ctx, b, key)
+		if errors.Is(err, ErrEncrypted) || m == nil {
+			continue // cannot tell, or deleted since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !m.covers(strings.TrimPrefix(key, dir)) {
+				orphans = append(orphans, key)
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// covers reports whether the object name, directly under the data prefix
+// of the archive m describes, is one of its chunks or parity shards, or
+// not chunk data at all.
+func (m *Manifest) covers(name string) bool {
+	if c, ok := parseChunkName(name); ok {
+		return m.Storage == "" && c.index >= 1 && c.index <= m.Chunks
+	}
+	if c, ok := parsePartName(name); ok {
+		return m.bodies() && c.index >= 1 && c.index <= m.Chunks
+	}
+	if c, ok := parseParityName(name); ok {
+		layout := m.parity()
+		if layout == nil || (c.encoded == "") != m.bodies() {
+			return false
+		}
+		stripe, shard := parityShard(c.index)
+		return stripe <= (m.Chunks+layout.Data-1)/layout.Data && shard <= layout.Parity
+	}
+	return true
+}
+
+// unusedPool returns the keys of the marks in the pool of b, listed in
+// objs, that no deduplicated archive in manifests makes, and of the pooled
+// chunks left without marks. Archives for which gone reports true count as
+// deleted. Objects written after cutoff are kept.
+func (v *VFS) unusedPool(ctx context.Context, b Backend, objs []Object, manifests map[string]bool, gone func(string) bool, cutoff time.Time) ([]string, error) {
+	live := make(map[string]bool)
+	for key := range manifests {
+		if gone(key) {
+			continue
+		}
+		m, err := v.readManifest(ctx, b, key)
+		if errors.Is(err, ErrEncrypted) {
+			continue // encrypted archives are never deduplicated
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m.deduped() {
+			for _, hash := range m.Hashes {
+				live[poolRefKey(hash, key)] = true
+			}
+		}
+	}
+	var unused []string
+	marked := make(map[string]bool) // hashes with a mark that stays
+	var chunks []Object
+	for _, obj := range objs {
+		if rest, ok := strings.CutPrefix(obj.Key, poolDir+"refs/"); ok {
+			if live[obj.Key] || obj.LastModified.After(cutoff) {
+				hash, _, _ := strings.Cut(rest, "/")
+				marked[hash] = true
+				continue
+			}
+			unused = append(unused, obj.Key)
+		}
+		if strings.HasPrefix(obj.Key, poolDir+"chunks/") {
+			chunks = append(chunks, obj)
+		}
+	}
+	for _, obj := range chunks {
+		if !marked[strings.TrimPrefix(obj.Key, poolDir+"chunks/")] && !obj.LastModified.After(cutoff) {
+			unused = append(unused, obj.Key)
+		}
+	}
+	sort.Strings(unused)
+	return unused, nil
+}
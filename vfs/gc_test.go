@@ -0,0 +1,133 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGC_DeletesOrphanedChunks(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1050)), "s3://bucket/disk/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	// Leftovers: a chunk past the end, a body part, and a parity shard.
+	stray := []string{
+		chunkKey("disk/", 20, []byte("past the end")),
+		"disk/000003." + chunkChecksum(nil) + partSuffix,
+		keyFormat{checksum: true, width: minIndexWidth}.key("disk/", parityIndex(1, 1), []byte("parity")),
+	}
+	for _, k := range stray {
+		fake.objects["bucket/"+k] = nil
+	}
+	// Archives from before manifests are left alone.
+	legacy := chunkKey("old/", 1, []byte("legacy"))
+	fake.objects["bucket/"+legacy] = nil
+	keys := len(fake.keys("bucket"))
+
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{OlderThan: time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	want := append([]string{}, stray...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(r.Orphans, want) {
+		t.Errorf("expected orphans %v, got %v", want, r.Orphans)
+	}
+	if got := len(fake.keys("bucket")); got != keys {
+		t.Errorf("expected a dry run to delete nothing, %d of %d keys left", got, keys)
+	}
+
+	if _, err := v.GC(ctx, "s3://bucket/", GCOptions{OlderThan: time.Hour}); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if got := len(fake.keys("bucket")); got != keys-len(stray) {
+		t.Errorf("expected the %d orphans deleted, %d of %d keys left", len(stray), got, keys)
+	}
+	if _, ok := fake.objects["bucket/"+legacy]; !ok {
+		t.Error("expected the archive without a manifest to be kept")
+	}
+	if err := v.RestoreWriter(ctx, "s3://bucket/disk/", io.Discard); err != nil {
+		t.Errorf("restore after gc failed: %v", err)
+	}
+}
+
+func TestGC_AbandonedUploadsAndVersions(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.versioning = true
+	ctx := context.Background()
+	const uri = "s3://bucket/db/"
+	for range 4 {
+		if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), uri, true); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+	}
+	failAfter(fake, 2)
+	v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), "s3://bucket/stuck/", false)
+	fake.putHook = nil
+
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{KeepVersions: 1})
+	if err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if !reflect.DeepEqual(r.Abandoned, []string{"s3://bucket/stuck/"}) {
+		t.Errorf("expected the stuck upload reported, got %v", r.Abandoned)
+	}
+	wantVersions := []string{"s3://bucket/db/.vfs-versions/v1/", "s3://bucket/db/.vfs-versions/v2/"}
+	if !reflect.DeepEqual(r.Versions, wantVersions) {
+		t.Errorf("expected versions %v, got %v", wantVersions, r.Versions)
+	}
+	versions, err := v.Versions(ctx, uri)
+	if err != nil || len(versions) != 1 || versions[0].Number != 3 {
+		t.Errorf("expected only version 3 left, got %+v, %v", versions, err)
+	}
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, "stuck/") {
+			t.Errorf("expected %s to be deleted", k)
+		}
+	}
+}
+
+func TestGC_DeletesUnusedPoolChunks(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.dedup, v.maxChunkSize = true, 1000
+	ctx := context.Background()
+	shared := randomBytes(2000)
+	for _, uri := range []string{"s3://bucket/a/", "s3://bucket/b/"} {
+		if err := v.Encode(ctx, writeTempFile(t, append(bytes.Clone(shared), randomBytes(1000)...)), uri, false); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+	}
+	// An archive whose manifest went missing without releasing its chunks.
+	delete(fake.objects, "bucket/b/"+manifestName)
+
+	r, err := v.GC(ctx, "s3://bucket/", GCOptions{})
+	if err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	var marks, chunks int
+	for _, k := range r.Pool {
+		switch {
+		case strings.HasPrefix(k, poolDir+"refs/"):
+			marks++
+		case strings.HasPrefix(k, poolDir+"chunks/"):
+			chunks++
+		}
+	}
+	if marks != 3 || chunks != 1 {
+		t.Errorf("expected b's three marks and its one unshared chunk, got %d and %d", marks, chunks)
+	}
+	if err := v.RestoreWriter(ctx, "s3://bucket/a/", io.Discard); err != nil {
+		t.Errorf("restore after gc failed: %v", err)
+	}
+}
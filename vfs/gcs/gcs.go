@@ -0,0 +1,134 @@
+// Package gcs stores vfs archives in Google Cloud Storage buckets. Chunk
+// data is encoded into object names exactly like S3 keys.
+//
+//	v, err := vfs.New(vfs.WithBackend("gs", gcs.Open()))
+//	v.Encode(ctx, "file.txt", "gs://my-bucket/path/", false)
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/vjeffz/vfs/vfs"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const (
+	maxObjectNameBytes = 1024
+	listPageSize       = 1000
+
+	// GCS has no batch delete in this client, so deletes run in parallel.
+	deleteConcurrency = 16
+)
+
+// Open returns a factory for gs:// URIs. The client is created on first use
+// from Application Default Credentials and opts.
+func Open(opts ...option.ClientOption) vfs.BackendFactory {
+	var once sync.Once
+	var client *storage.Client
+	var err error
+	return func(ctx context.Context, bucket string) (vfs.Backend, error) {
+		once.Do(func() {
+			client, err = storage.NewClient(context.Background(), opts...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("gs URI is missing a bucket name")
+		}
+		return New(client, bucket), nil
+	}
+}
+
+// New returns a backend for bucket that uses an existing client.
+func New(client *storage.Client, bucket string) vfs.Backend {
+	return &backend{bucket: client.Bucket(bucket), name: bucket}
+}
+
+type backend struct {
+	bucket *storage.BucketHandle
+	name   string
+}
+
+func (b *backend) Put(ctx context.Context, key string, body []byte) error {
+	w := b.bucket.Object(key).NewWriter(ctx)
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (b *backend) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := b.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("gs://%s/%s: %w", b.name, key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (b *backend) List(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	it := b.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	page := make([]vfs.Object, 0, listPageSize)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		page = append(page, vfs.Object{Key: attrs.Name, Size: attrs.Size, LastModified: attrs.Updated})
+		if len(page) == listPageSize {
+			if err := fn(page); err != nil {
+				return err
+			}
+			page = make([]vfs.Object, 0, listPageSize)
+		}
+	}
+	if len(page) > 0 {
+		return fn(page)
+	}
+	return nil
+}
+
+func (b *backend) Delete(ctx context.Context, keys []string) error {
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, deleteConcurrency)
+	for _, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := b.bucket.Object(key).Delete(ctx)
+			if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(key)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// KeyLimit reflects GCS's 1024-byte object name limit.
+func (b *backend) KeyLimit(prefix string) int {
+	return maxObjectNameBytes - len(prefix)
+}
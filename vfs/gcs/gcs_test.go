@@ -0,0 +1,93 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/vjeffz/vfs/vfs"
+	"google.golang.org/api/option"
+)
+
+// fakeGCS serves the JSON API calls used by List and Delete.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]bool
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const objPath = "/b/bucket/o"
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, objPath):
+		var items []map[string]string
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				items = append(items, map[string]string{"name": name, "bucket": "bucket", "size": "0"})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"kind": "storage#objects", "items": items})
+	case r.Method == http.MethodDelete:
+		name := r.URL.Path[strings.Index(r.URL.Path, objPath+"/")+len(objPath)+1:]
+		if !f.objects[name] {
+			http.Error(w, `{"error":{"code":404,"message":"No such object"}}`, http.StatusNotFound)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func newTestBackend(t *testing.T, f *fakeGCS) vfs.Backend {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client, err := storage.NewClient(context.Background(),
+		option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(client, "bucket")
+}
+
+func TestListAndDelete(t *testing.T) {
+	f := &fakeGCS{objects: map[string]bool{"a/1-x": true, "a/2-y": true, "b/1-z": true}}
+	b := newTestBackend(t, f)
+	ctx := context.Background()
+
+	var keys []string
+	err := b.List(ctx, "a/", func(objs []vfs.Object) error {
+		for _, o := range objs {
+			keys = append(keys, o.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys under a/, got %v", keys)
+	}
+
+	// Missing objects are ignored.
+	if err := b.Delete(ctx, append(keys, "a/3-missing")); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if len(f.objects) != 1 || !f.objects["b/1-z"] {
+		t.Errorf("expected only b/1-z to remain, got %v", f.objects)
+	}
+}
+
+func TestKeyLimit(t *testing.T) {
+	b := newTestBackend(t, &fakeGCS{})
+	if got := b.KeyLimit("path/"); got != maxObjectNameBytes-len("path/") {
+		t.Errorf("unexpected key limit %d", got)
+	}
+}
@@ -0,0 +1,321 @@
+// Package grpcapi implements the gRPC service of package vfspb for the
+// archives under one root URI of a vfs.VFS:
+//
+//	srv := grpc.NewServer()
+//	api, err := grpcapi.New(v, "s3://bucket/prefix/")
+//	vfspb.RegisterVFSServer(srv, api)
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/grpcapi/vfspb"
+)
+
+// maxDataMessage caps the data carried by one Restore response, well
+// below the default gRPC message limit of 4 MiB.
+const maxDataMessage = 1 << 20
+
+// progressBacklog is how many progress messages of an Encode may wait for
+// a slow client before newer ones are dropped.
+const progressBacklog = 64
+
+// Server serves the archives under its root.
+type Server struct {
+	vfspb.UnimplementedVFSServer
+	v    *vfs.VFS
+	root string
+}
+
+// New returns a server for the archives of v under root, a URI such as
+// s3://bucket/prefix/.
+func New(v *vfs.VFS, root string) (*Server, error) {
+	if !strings.Contains(root, "://") {
+		return nil, fmt.Errorf("invalid root %q: must start with <scheme>://, e.g. s3://", root)
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return &Server{v: v, root: root}, nil
+}
+
+// WithToken returns server options that reject calls without the metadata
+// "authorization: Bearer <token>".
+func WithToken(token string) []grpc.ServerOption {
+	check := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, v := range md.Get("authorization") {
+			if v == "Bearer "+token {
+				return nil
+			}
+		}
+		return status.Error(codes.Unauthenticated, "missing or wrong bearer token")
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// uri returns the archive URI for path, which must name a place below the
+// root, or the root itself if allowRoot is set.
+func (s *Server) uri(path string, allowRoot bool) (string, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		if allowRoot {
+			return s.root, nil
+		}
+		return "", status.Error(codes.InvalidArgument, "missing path")
+	}
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", status.Errorf(codes.InvalidArgument, "invalid path %q", path)
+		}
+	}
+	return s.root + path + "/", nil
+}
+
+func (s *Server) Encode(stream vfspb.VFS_EncodeServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	uri, err := s.uri(first.GetPath(), false)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	pr, pw := io.Pipe()
+	defer pr.Close() // stops the receiving if the encode fails early
+	go func() {
+		req := first
+		for {
+			if _, err := pw.Write(req.GetData()); err != nil {
+				return
+			}
+			var err error
+			if req, err = stream.Recv(); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	// Progress goes out from its own goroutine: the progress function must
+	// not block, and a slow client misses progress, not data.
+	events := make(chan *vfspb.EncodeResponse, progressBacklog)
+	v := s.v.ProgressTo(func(e vfs.Event) {
+		var resp *vfspb.EncodeResponse
+		switch e.Kind {
+		case vfs.EventChunk, vfs.EventDone:
+			resp = &vfspb.EncodeResponse{Event: &vfspb.EncodeResponse_Progress{Progress: &vfspb.Progress{
+				Done: int32(e.Done), Total: int32(e.Total), BytesDone: e.BytesDone, BytesTotal: e.BytesTotal,
+			}}}
+		case vfs.EventWarning:
+			resp = &vfspb.EncodeResponse{Event: &vfspb.EncodeResponse_Warning{Warning: strings.TrimSpace(e.Message)}}
+		default:
+			return
+		}
+		select {
+		case events <- resp:
+		default:
+		}
+	})
+	sent := make(chan error, 1)
+	go func() {
+		var err error
+		for resp := range events {
+			if err == nil {
+				err = stream.Send(resp)
+			}
+		}
+		sent <- err
+	}()
+	if first.GetForce() {
+		err = encodeForce(ctx, v, pr, uri)
+	} else {
+		err = v.EncodeReader(ctx, pr, uri, false)
+	}
+	close(events)
+	if sendErr := <-sent; err == nil {
+		err = sendErr
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	m, err := s.v.Stat(ctx, uri) // v reports to the closed channel
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.Send(&vfspb.EncodeResponse{Event: &vfspb.EncodeResponse_Manifest{Manifest: toManifest(m)}})
+}
+
+// encodeForce spools r to a file first, so that the old archive is
+// replaced (or kept as a version) only once all of the new one arrived.
+func encodeForce(ctx context.Context, v *vfs.VFS, r io.Reader, uri string) error {
+	f, err := os.CreateTemp("", "vfs-encode-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return v.Encode(ctx, f.Name(), uri, true)
+}
+
+func (s *Server) Restore(req *vfspb.RestoreRequest, stream vfspb.VFS_RestoreServer) error {
+	uri, err := s.uri(req.GetPath(), false)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	m, err := s.v.Stat(ctx, uri)
+	if err != nil {
+		return toStatus(err)
+	}
+	if err := stream.Send(&vfspb.RestoreResponse{Event: &vfspb.RestoreResponse_Manifest{Manifest: toManifest(m)}}); err != nil {
+		return err
+	}
+	return toStatus(s.v.RestoreWriter(ctx, uri, dataWriter{stream}))
+}
+
+// dataWriter sends what is written to it as Restore data messages.
+type dataWriter struct {
+	stream vfspb.VFS_RestoreServer
+}
+
+func (w dataWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		part := p[:min(len(p), maxDataMessage)]
+		if err := w.stream.Send(&vfspb.RestoreResponse{Event: &vfspb.RestoreResponse_Data{Data: part}}); err != nil {
+			return n, err
+		}
+		n += len(part)
+		p = p[len(part):]
+	}
+	return n, nil
+}
+
+func (s *Server) List(ctx context.Context, req *vfspb.ListRequest) (*vfspb.ListResponse, error) {
+	uri, err := s.uri(req.GetPath(), true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.v.List(ctx, uri)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &vfspb.ListResponse{Entries: make([]*vfspb.Entry, len(entries))}
+	for i, e := range entries {
+		entry := &vfspb.Entry{
+			Path:     strings.TrimSuffix(strings.TrimPrefix(e.URI, s.root), "/"),
+			Modified: timestamppb.New(e.Modified),
+		}
+		if e.Err != nil {
+			entry.Error = e.Err.Error()
+		} else {
+			entry.Manifest = toManifest(e.Manifest)
+		}
+		resp.Entries[i] = entry
+	}
+	return resp, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *vfspb.DeleteRequest) (*vfspb.DeleteResponse, error) {
+	uri, err := s.uri(req.GetPath(), false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.v.Delete(ctx, uri); err != nil {
+		return nil, toStatus(err)
+	}
+	return &vfspb.DeleteResponse{}, nil
+}
+
+func (s *Server) Verify(ctx context.Context, req *vfspb.VerifyRequest) (*vfspb.VerifyResponse, error) {
+	uri, err := s.uri(req.GetPath(), false)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.v.Verify(ctx, uri)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &vfspb.VerifyResponse{
+		Ok:          r.OK(),
+		Chunks:      int32(r.Chunks),
+		Checksummed: int32(r.Checksummed),
+		Size:        r.Size,
+		Sha256:      r.SHA256,
+		Problems:    r.Problems,
+	}, nil
+}
+
+func toManifest(m *vfs.Manifest) *vfspb.Manifest {
+	pm := &vfspb.Manifest{
+		Filename:    m.Filename,
+		Size:        m.Size,
+		Chunks:      int32(m.Chunks),
+		Sha256:      m.SHA256,
+		Compression: string(m.Compression),
+		Encrypted:   m.Encryption != nil,
+		Storage:     m.Storage,
+	}
+	if m.Created != nil {
+		pm.Created = timestamppb.New(*m.Created)
+	}
+	return pm
+}
+
+// toStatus returns err as a gRPC status error with a code that tells
+// failures apart, or nil.
+func toStatus(err error) error {
+	var code codes.Code
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, vfs.ErrPrefixExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, vfs.ErrNoChunks), errors.Is(err, vfs.ErrNoManifest):
+		code = codes.NotFound
+	case errors.Is(err, vfs.ErrEncrypted), errors.Is(err, vfs.ErrDecrypt):
+		code = codes.PermissionDenied
+	case errors.Is(err, vfs.ErrChunkChecksum), errors.Is(err, vfs.ErrManifestMismatch),
+		errors.Is(err, vfs.ErrChunkGap), errors.Is(err, vfs.ErrIncompleteArchive):
+		code = codes.DataLoss
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
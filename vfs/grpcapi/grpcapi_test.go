@@ -0,0 +1,186 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/grpcapi/vfspb"
+	"github.com/vjeffz/vfs/vfs/memback"
+)
+
+func newTestClient(t *testing.T, opts ...grpc.ServerOption) vfspb.VFSClient {
+	t.Helper()
+	v, err := vfs.New(vfs.WithBackend("mem", memback.New().Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := New(v, "mem://bucket/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	vfspb.RegisterVFSServer(srv, api)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return vfspb.NewVFSClient(conn)
+}
+
+// encode stores data at path in messages of up to 1000 bytes and returns
+// the responses.
+func encode(t *testing.T, c vfspb.VFSClient, path string, force bool, data []byte) ([]*vfspb.EncodeResponse, error) {
+	t.Helper()
+	stream, err := c.Encode(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &vfspb.EncodeRequest{Path: path, Force: force}
+	for {
+		n := min(len(data), 1000)
+		req.Data, data = data[:n], data[n:]
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		req = &vfspb.EncodeRequest{}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var resps []*vfspb.EncodeResponse
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return resps, nil
+		}
+		if err != nil {
+			return resps, err
+		}
+		resps = append(resps, resp)
+	}
+}
+
+func restore(t *testing.T, c vfspb.VFSClient, path string) (*vfspb.Manifest, []byte, error) {
+	t.Helper()
+	stream, err := c.Restore(context.Background(), &vfspb.RestoreRequest{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m *vfspb.Manifest
+	var data []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return m, data, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.GetManifest() != nil {
+			m = resp.GetManifest()
+		}
+		data = append(data, resp.GetData()...)
+	}
+}
+
+func TestServer_EncodeRestoreListVerifyDelete(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+	data := make([]byte, 20000)
+	rand.Read(data)
+
+	resps, err := encode(t, c, "db/dump", false, data)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	var progress int
+	for _, r := range resps {
+		if r.GetProgress() != nil {
+			progress++
+		}
+	}
+	last := resps[len(resps)-1].GetManifest()
+	if progress == 0 || last == nil || last.Size != int64(len(data)) {
+		t.Errorf("expected progress and then the manifest, got %v", resps)
+	}
+
+	m, got, err := restore(t, c, "db/dump")
+	if err != nil || !bytes.Equal(got, data) || m.GetSha256() != last.Sha256 {
+		t.Fatalf("expected the stored file, got %d bytes, %v", len(got), err)
+	}
+
+	list, err := c.List(ctx, &vfspb.ListRequest{})
+	if err != nil || len(list.Entries) != 1 || list.Entries[0].Path != "db/dump" {
+		t.Errorf("expected a listing of db/dump, got %v, %v", list, err)
+	}
+	report, err := c.Verify(ctx, &vfspb.VerifyRequest{Path: "db/dump"})
+	if err != nil || !report.Ok || report.Size != int64(len(data)) {
+		t.Errorf("expected a clean verify, got %v, %v", report, err)
+	}
+
+	if _, err := c.Delete(ctx, &vfspb.DeleteRequest{Path: "db/dump"}); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, _, err := restore(t, c, "db/dump"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound after delete, got %v", err)
+	}
+}
+
+func TestServer_EncodeOverwriteNeedsForce(t *testing.T) {
+	c := newTestClient(t)
+	if _, err := encode(t, c, "a", false, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encode(t, c, "a", false, []byte("second")); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got %v", err)
+	}
+	if _, err := encode(t, c, "a", true, []byte("second")); err != nil {
+		t.Fatalf("encode with force failed: %v", err)
+	}
+	if _, got, _ := restore(t, c, "a"); string(got) != "second" {
+		t.Errorf("expected the overwritten file, got %q", got)
+	}
+}
+
+func TestServer_RejectsPathsOutsideRoot(t *testing.T) {
+	c := newTestClient(t)
+	for _, path := range []string{"", "a/../b"} {
+		if _, err := c.Delete(context.Background(), &vfspb.DeleteRequest{Path: path}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%q: expected InvalidArgument, got %v", path, err)
+		}
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	c := newTestClient(t, WithToken("secret")...)
+	ctx := context.Background()
+	if _, err := c.List(ctx, &vfspb.ListRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without the token, got %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	if _, err := c.List(ctx, &vfspb.ListRequest{}); err != nil {
+		t.Errorf("expected the token to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+// Package vfspb holds the protocol buffer messages and the gRPC client and
+// server stubs of the vfs service defined in vfs.proto. Clients in other
+// languages can generate their own stubs from the same file.
+package vfspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative vfs.proto
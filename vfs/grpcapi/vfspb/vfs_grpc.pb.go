@@ -0,0 +1,293 @@
+// The vfs service stores and fetches files under one root URI of a
+// long-running vfs daemon (vfs serve --grpc-listen). Paths are relative to
+// that root, like "db/dump.sql".
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: vfs.proto
+
+package vfspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	VFS_Encode_FullMethodName  = "/vfs.v1.VFS/Encode"
+	VFS_Restore_FullMethodName = "/vfs.v1.VFS/Restore"
+	VFS_List_FullMethodName    = "/vfs.v1.VFS/List"
+	VFS_Delete_FullMethodName  = "/vfs.v1.VFS/Delete"
+	VFS_Verify_FullMethodName  = "/vfs.v1.VFS/Verify"
+)
+
+// VFSClient is the client API for VFS service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VFSClient interface {
+	// Encode stores the file sent in the request stream. The first message
+	// names the path; it and every later message may carry data. The
+	// response stream reports progress and ends with the manifest of the
+	// stored file. It fails with ALREADY_EXISTS if the path holds an archive
+	// and force is not set.
+	Encode(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EncodeRequest, EncodeResponse], error)
+	// Restore streams the stored file, starting with its manifest.
+	Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RestoreResponse], error)
+	// List returns the archives at or below a path.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Delete removes the archive at a path and everything below it.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Verify checks every chunk of an archive without restoring it.
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+}
+
+type vFSClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVFSClient(cc grpc.ClientConnInterface) VFSClient {
+	return &vFSClient{cc}
+}
+
+func (c *vFSClient) Encode(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[EncodeRequest, EncodeResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &VFS_ServiceDesc.Streams[0], VFS_Encode_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[EncodeRequest, EncodeResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VFS_EncodeClient = grpc.BidiStreamingClient[EncodeRequest, EncodeResponse]
+
+func (c *vFSClient) Restore(ctx context.Context, in *RestoreRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RestoreResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &VFS_ServiceDesc.Streams[1], VFS_Restore_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RestoreRequest, RestoreResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VFS_RestoreClient = grpc.ServerStreamingClient[RestoreResponse]
+
+func (c *vFSClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, VFS_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vFSClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, VFS_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vFSClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, VFS_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VFSServer is the server API for VFS service.
+// All implementations must embed UnimplementedVFSServer
+// for forward compatibility.
+type VFSServer interface {
+	// Encode stores the file sent in the request stream. The first message
+	// names the path; it and every later message may carry data. The
+	// response stream reports progress and ends with the manifest of the
+	// stored file. It fails with ALREADY_EXISTS if the path holds an archive
+	// and force is not set.
+	Encode(grpc.BidiStreamingServer[EncodeRequest, EncodeResponse]) error
+	// Restore streams the stored file, starting with its manifest.
+	Restore(*RestoreRequest, grpc.ServerStreamingServer[RestoreResponse]) error
+	// List returns the archives at or below a path.
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Delete removes the archive at a path and everything below it.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Verify checks every chunk of an archive without restoring it.
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	mustEmbedUnimplementedVFSServer()
+}
+
+// UnimplementedVFSServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVFSServer struct{}
+
+func (UnimplementedVFSServer) Encode(grpc.BidiStreamingServer[EncodeRequest, EncodeResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Encode not implemented")
+}
+func (UnimplementedVFSServer) Restore(*RestoreRequest, grpc.ServerStreamingServer[RestoreResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedVFSServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedVFSServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedVFSServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedVFSServer) mustEmbedUnimplementedVFSServer() {}
+func (UnimplementedVFSServer) testEmbeddedByValue()             {}
+
+// UnsafeVFSServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VFSServer will
+// result in compilation errors.
+type UnsafeVFSServer interface {
+	mustEmbedUnimplementedVFSServer()
+}
+
+func RegisterVFSServer(s grpc.ServiceRegistrar, srv VFSServer) {
+	// If the following call pancis, it indicates UnimplementedVFSServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&VFS_ServiceDesc, srv)
+}
+
+func _VFS_Encode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(VFSServer).Encode(&grpc.GenericServerStream[EncodeRequest, EncodeResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VFS_EncodeServer = grpc.BidiStreamingServer[EncodeRequest, EncodeResponse]
+
+func _VFS_Restore_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RestoreRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VFSServer).Restore(m, &grpc.GenericServerStream[RestoreRequest, RestoreResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VFS_RestoreServer = grpc.ServerStreamingServer[RestoreResponse]
+
+func _VFS_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VFSServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VFS_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VFSServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VFS_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VFSServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VFS_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VFSServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VFS_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VFSServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VFS_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VFSServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// VFS_ServiceDesc is the grpc.ServiceDesc for VFS service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VFS_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vfs.v1.VFS",
+	HandlerType: (*VFSServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "List",
+			Handler:    _VFS_List_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _VFS_Delete_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _VFS_Verify_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Encode",
+			Handler:       _VFS_Encode_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Restore",
+			Handler:       _VFS_Restore_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "vfs.proto",
+}
@@ -0,0 +1,228 @@
+// Package httpapi serves the archives under one root URI of a vfs.VFS over
+// HTTP, so services can store and fetch files without the CLI or the Go
+// library:
+//
+//	PUT    /files/<path>   store the request body at <root><path>/
+//	GET    /files/<path>   fetch the file stored there
+//	DELETE /files/<path>   delete it, and everything below it
+//	GET    /list/<path>    list the archives at or below it, as JSON
+//
+// PUT fails with 409 Conflict if the path already holds an archive, unless
+// the query has force=true. Errors are JSON objects with an "error" field.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// Server is an http.Handler serving the archives under its root.
+type Server struct {
+	v     *vfs.VFS
+	root  string
+	token string
+	mux   *http.ServeMux
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithToken makes the server answer only requests that carry
+// "Authorization: Bearer <token>".
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// New returns a server for the archives of v under root, a URI such as
+// s3://bucket/prefix/.
+func New(v *vfs.VFS, root string, opts ...Option) (*Server, error) {
+	if !strings.Contains(root, "://") {
+		return nil, fmt.Errorf("invalid root %q: must start with <scheme>://, e.g. s3://", root)
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	s := &Server{v: v, root: root, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux.HandleFunc("PUT /files/{path...}", s.put)
+	s.mux.HandleFunc("GET /files/{path...}", s.get)
+	s.mux.HandleFunc("DELETE /files/{path...}", s.delete)
+	s.mux.HandleFunc("GET /list/{path...}", s.list)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// uri returns the archive URI for the path of r, which must name a place
+// below the root.
+func (s *Server) uri(r *http.Request) (string, error) {
+	path := strings.Trim(r.PathValue("path"), "/")
+	if path == "" {
+		return "", errors.New("missing path")
+	}
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", fmt.Errorf("invalid path %q", path)
+		}
+	}
+	return s.root + path + "/", nil
+}
+
+// put stores the request body. Without force it streams the body into the
+// archive; with force the body is spooled to a file first, so that the old
+// archive is replaced (or kept as a version) only once it has all arrived.
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	uri, err := s.uri(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.Query().Get("force") != "true" {
+		err = s.v.EncodeReader(r.Context(), r.Body, uri, false)
+	} else {
+		err = s.encodeForce(r, uri)
+	}
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	m, err := s.v.Stat(r.Context(), uri)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (s *Server) encodeForce(r *http.Request, uri string) error {
+	f, err := os.CreateTemp("", "vfs-put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, r.Body); err != nil {
+		return err
+	}
+	return s.v.Encode(r.Context(), f.Name(), uri, true)
+}
+
+// get writes the stored file. Once the body has started, a failure can
+// only be reported by cutting the response short.
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	uri, err := s.uri(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	m, err := s.v.Stat(r.Context(), uri)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Length", strconv.FormatInt(m.Size, 10))
+	h.Set("ETag", `"`+m.SHA256+`"`)
+	if m.Filename != "" {
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", m.Filename))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := s.v.RestoreWriter(r.Context(), uri, w); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	uri, err := s.uri(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.v.Delete(r.Context(), uri); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listing is an archive in the response of list.
+type listing struct {
+	Path     string        `json:"path"` // relative to the root
+	Modified time.Time     `json:"modified"`
+	Manifest *vfs.Manifest `json:"manifest,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	uri := s.root
+	if strings.Trim(r.PathValue("path"), "/") != "" {
+		var err error
+		if uri, err = s.uri(r); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	entries, err := s.v.List(r.Context(), uri)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	out := make([]listing, len(entries))
+	for i, e := range entries {
+		out[i] = listing{
+			Path:     strings.TrimSuffix(strings.TrimPrefix(e.URI, s.root), "/"),
+			Modified: e.Modified,
+			Manifest: e.Manifest,
+		}
+		if e.Err != nil {
+			out[i].Error = e.Err.Error()
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// statusOf returns the HTTP status for a failed operation.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, vfs.ErrPrefixExists):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, vfs.ErrNoChunks), errors.Is(err, vfs.ErrNoManifest):
+		return http.StatusNotFound
+	case errors.Is(err, vfs.ErrEncrypted), errors.Is(err, vfs.ErrDecrypt):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
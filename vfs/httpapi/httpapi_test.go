@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/memback"
+)
+
+func newTestServer(t *testing.T, opts ...Option) *httptest.Server {
+	t.Helper()
+	v, err := vfs.New(vfs.WithBackend("mem", memback.New().Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(v, "mem://bucket/api/", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method, url string, body []byte) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, got
+}
+
+func TestServer_PutGetListDelete(t *testing.T) {
+	ts := newTestServer(t)
+	data := make([]byte, 5000)
+	rand.Read(data)
+
+	resp, body := do(t, "PUT", ts.URL+"/files/db/dump", data)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", resp.StatusCode, body)
+	}
+	var m vfs.Manifest
+	if err := json.Unmarshal(body, &m); err != nil || m.Size != int64(len(data)) {
+		t.Errorf("expected the manifest of %d bytes, got %s (%v)", len(data), body, err)
+	}
+
+	resp, body = do(t, "GET", ts.URL+"/files/db/dump", nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("expected the stored file, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+
+	resp, body = do(t, "GET", ts.URL+"/list/", nil)
+	var entries []listing
+	if err := json.Unmarshal(body, &entries); err != nil || len(entries) != 1 || entries[0].Path != "db/dump" {
+		t.Errorf("expected a listing of db/dump, got %d: %s", resp.StatusCode, body)
+	}
+
+	if resp, body = do(t, "DELETE", ts.URL+"/files/db/dump", nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ = do(t, "GET", ts.URL+"/files/db/dump", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", resp.StatusCode)
+	}
+}
+
+func TestServer_PutOverwriteNeedsForce(t *testing.T) {
+	ts := newTestServer(t)
+	do(t, "PUT", ts.URL+"/files/a", []byte("first"))
+	if resp, _ := do(t, "PUT", ts.URL+"/files/a", []byte("second")); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", resp.StatusCode)
+	}
+	if resp, body := do(t, "PUT", ts.URL+"/files/a?force=true", []byte("second")); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 with force, got %d: %s", resp.StatusCode, body)
+	}
+	if _, body := do(t, "GET", ts.URL+"/files/a", nil); string(body) != "second" {
+		t.Errorf("expected the overwritten file, got %q", body)
+	}
+}
+
+func TestServer_RejectsPathsOutsideRoot(t *testing.T) {
+	ts := newTestServer(t)
+	for _, path := range []string{"/files/", "/files/a/%2E%2E/b"} {
+		if resp, _ := do(t, "PUT", ts.URL+path, []byte("x")); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	ts := newTestServer(t, WithToken("secret"))
+	if resp, _ := do(t, "GET", ts.URL+"/list/", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the token, got %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("GET", ts.URL+"/list/", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with the token, got %d", resp.StatusCode)
+	}
+}
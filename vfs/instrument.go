@@ -0,0 +1,224 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithLogger logs what operations do to l, all at debug level: one record
+// per finished operation (Encode, Restore, Delete, ...) with its bucket,
+// prefix and duration, one per chunk with its chunk_index, and one per
+// request to the store with its method, object and duration. Warnings are
+// progress events, not log records (see WithProgress).
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// opStateKey holds, in the context of an operation, its *opState.
+type opStateKey struct{}
+
+// opState is what an operation keeps while it runs.
+type opState struct {
+	op     Op
+	logger *slog.Logger // v's logger with the fields of the operation
+	chunks atomic.Int64
+	bytes  atomic.Int64
+}
+
+// count adds chunks and their decoded bytes to the operation s, if they
+// are for its op rather than for a step of another kind, like the deletes
+// of an encode with force. s may be nil.
+func (s *opState) count(op Op, chunks int, bytes int64) {
+	if s != nil && s.op == op {
+		s.chunks.Add(int64(chunks))
+		s.bytes.Add(bytes)
+	}
+}
+
+// opFrom returns the state of the operation ctx belongs to, or nil.
+func opFrom(ctx context.Context) *opState {
+	s, _ := ctx.Value(opStateKey{}).(*opState)
+	return s
+}
+
+// debug logs msg at debug level, with the fields of the operation ctx
+// belongs to, if logging is on.
+func (v *VFS) debug(ctx context.Context, msg string, args ...any) {
+	if v.logger == nil {
+		return
+	}
+	l := v.logger
+	if s := opFrom(ctx); s != nil && s.logger != nil {
+		l = s.logger
+	}
+	l.DebugContext(ctx, msg, args...)
+}
+
+// startOp starts the operation name, of kind op, on uri: its span, if
+// tracing is on, and its state, carried by the returned context. The
+// returned function ends it, recording *err and writing its audit record.
+func (v *VFS) startOp(ctx context.Context, op Op, name, uri string) (context.Context, func(err *error)) {
+	if v.tracer == nil && v.logger == nil && v.audit == nil {
+		return ctx, func(*error) {}
+	}
+	start := time.Now()
+	var span trace.Span
+	if v.tracer != nil {
+		ctx, span = v.tracer.Start(ctx, "vfs."+name, trace.WithAttributes(attribute.String("vfs.uri", uri)))
+	}
+	s := &opState{op: op}
+	_, bucket, prefix, _ := parseURI(uri)
+	if v.logger != nil {
+		s.logger = v.logger.With("op", name, "bucket", bucket, "prefix", prefix)
+	}
+	ctx = context.WithValue(ctx, opStateKey{}, s)
+	return ctx, func(err *error) {
+		elapsed := time.Since(start)
+		v.debug(ctx, "operation finished", withError(*err, "duration", elapsed)...)
+		if v.audit != nil {
+			r := AuditRecord{
+				Time: start.UTC(), User: v.audit.user, Host: v.audit.host,
+				Op: op, URI: uri, Bucket: bucket, Prefix: prefix,
+				Chunks: s.chunks.Load(), Bytes: s.bytes.Load(), Duration: elapsed.Seconds(),
+			}
+			if *err != nil {
+				r.Error = (*err).Error()
+			}
+			v.writeAudit(ctx, r)
+		}
+		if span != nil {
+			endSpan(span, *err)
+		}
+	}
+}
+
+// withError returns the log fields args, followed by err if not nil.
+func withError(err error, args ...any) []any {
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	return args
+}
+
+// isFailure tells whether a request failed, rather than found nothing or
+// was stopped by its caller.
+func isFailure(err error) bool {
+	return err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errStopListing)
+}
+
+func endSpan(span trace.Span, err error) {
+	if isFailure(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// instrument returns b, the bucket at root reached with the URI scheme,
+// with its requests measured, traced and logged as configured.
+func (v *VFS) instrument(scheme, root string, b Backend) Backend {
+	if v.metrics == nil && v.tracer == nil && v.logger == nil {
+		return b
+	}
+	return &instrumentedBackend{Backend: b, v: v, scheme: scheme, root: root}
+}
+
+type instrumentedBackend struct {
+	Backend
+	v      *VFS
+	scheme string
+	root   string // scheme://bucket/
+}
+
+// request starts a request with method for key, which is a prefix for
+// List. The returned function ends it with err and the attributes learned
+// from the answer.
+func (b *instrumentedBackend) request(ctx context.Context, method, key string, attrs ...attribute.KeyValue) (context.Context, func(err error, more ...attribute.KeyValue)) {
+	start := time.Now()
+	var span trace.Span
+	if b.v.tracer != nil {
+		attrs = append(attrs, attribute.String("vfs.backend", b.scheme), attribute.String("vfs.key", key))
+		ctx, span = b.v.tracer.Start(ctx, "vfs.backend."+method,
+			trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	}
+	return ctx, func(err error, more ...attribute.KeyValue) {
+		elapsed := time.Since(start)
+		if m := b.v.metrics; m != nil {
+			m.duration.WithLabelValues(b.scheme, metricMethods[method]).Observe(elapsed.Seconds())
+			if isFailure(err) {
+				m.errors.WithLabelValues(b.scheme, metricMethods[method]).Inc()
+			}
+		}
+		b.v.debug(ctx, "request", withError(err, "method", method, "uri", b.root+key, "duration", elapsed)...)
+		if span != nil {
+			span.SetAttributes(more...)
+			endSpan(span, err)
+		}
+	}
+}
+
+// metricMethods are the method labels of the metrics.
+var metricMethods = map[string]string{"Put": "put", "Get": "get", "List": "list", "Delete": "delete", "Copy": "copy"}
+
+func (b *instrumentedBackend) Put(ctx context.Context, key string, body []byte) error {
+	ctx, end := b.request(ctx, "Put", key, attribute.Int("vfs.bytes", len(body)))
+	err := b.Backend.Put(ctx, key, body)
+	end(err)
+	return err
+}
+
+func (b *instrumentedBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, end := b.request(ctx, "Get", key)
+	body, err := b.Backend.Get(ctx, key)
+	end(err, attribute.Int("vfs.bytes", len(body)))
+	return body, err
+}
+
+// List is measured as a whole, including the time spent in fn.
+func (b *instrumentedBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	ctx, end := b.request(ctx, "List", prefix)
+	objects := 0
+	err := b.Backend.List(ctx, prefix, func(objs []Object) error {
+		objects += len(objs)
+		return fn(objs)
+	})
+	end(err, attribute.Int("vfs.objects", objects))
+	return err
+}
+
+func (b *instrumentedBackend) Delete(ctx context.Context, keys []string) error {
+	first := ""
+	if len(keys) > 0 {
+		first = keys[0]
+	}
+	ctx, end := b.request(ctx, "Delete", first, attribute.Int("vfs.keys", len(keys)))
+	err := b.Backend.Delete(ctx, keys)
+	end(err)
+	return err
+}
+
+// copyObject lets the wrapped backend copy server-side, from the backend
+// behind src if that is instrumented too.
+func (b *instrumentedBackend) copyObject(ctx context.Context, src Backend, srcKey, dstKey string) (bool, error) {
+	c, ok := b.Backend.(objectCopier)
+	if !ok {
+		return false, nil
+	}
+	if s, ok := src.(*instrumentedBackend); ok {
+		src = s.Backend
+	}
+	ctx, end := b.request(ctx, "Copy", dstKey)
+	copied, err := c.copyObject(ctx, src, srcKey, dstKey)
+	end(err)
+	return copied, err
+}
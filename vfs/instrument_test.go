@@ -0,0 +1,82 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func newLoggedTestVFS(fake *fakeS3) (*VFS, *bytes.Buffer) {
+	var buf bytes.Buffer
+	v := newTestVFS(fake)
+	v.logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return v, &buf
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLogger_StructuredFields(t *testing.T) {
+	fake := newFakeS3()
+	v, buf := newLoggedTestVFS(fake)
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(5000)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, requests, finished := 0, 0, 0
+	for _, r := range logRecords(t, buf) {
+		if r["bucket"] != "bucket" || r["prefix"] != "f/" || r["op"] != "EncodeReader" {
+			t.Errorf("expected the fields of the operation, got %v", r)
+		}
+		switch r["msg"] {
+		case "chunk stored":
+			if _, ok := r["chunk_index"]; !ok {
+				t.Errorf("expected a chunk_index, got %v", r)
+			}
+			chunks++
+		case "request":
+			if r["method"] == nil || r["duration"] == nil {
+				t.Errorf("expected a method and a duration, got %v", r)
+			}
+			requests++
+		case "operation finished":
+			if r["duration"] == nil || r["error"] != nil {
+				t.Errorf("expected a duration and no error, got %v", r)
+			}
+			finished++
+		}
+	}
+	if chunks < 2 || requests < chunks || finished != 1 {
+		t.Errorf("expected records per chunk, request and operation, got %d, %d and %d", chunks, requests, finished)
+	}
+}
+
+func TestLogger_RecordsErrors(t *testing.T) {
+	fake := newFakeS3()
+	v, buf := newLoggedTestVFS(fake)
+	if err := v.RestoreWriter(context.Background(), "s3://bucket/missing/", &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, r := range logRecords(t, buf) {
+		if r["msg"] == "operation finished" {
+			if r["error"] == nil {
+				t.Errorf("expected the error to be logged, got %v", r)
+			}
+			return
+		}
+	}
+	t.Error("expected an operation finished record")
+}
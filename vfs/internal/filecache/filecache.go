@@ -0,0 +1,94 @@
+// Package filecache keeps decoded copies of archives on local disk, for
+// servers that need random access to stored files.
+package filecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// Cache holds decoded files by the SHA-256 of their contents, so a file
+// is decoded once however often it is opened, and again only if it
+// changes. Files are never removed from it.
+type Cache struct {
+	v   *vfs.VFS
+	dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // by SHA-256, held while decoding
+}
+
+// New returns a cache of archives decoded with v into dir. Empty means
+// vfs/files in the user's cache directory (see os.UserCacheDir).
+func New(v *vfs.VFS, dir string) (*Cache, error) {
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(base, "vfs", "files")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Cache{v: v, dir: dir, locks: make(map[string]*sync.Mutex)}, nil
+}
+
+// Path returns the local copy of the archive at uri, whose contents have
+// the SHA-256 sum, decoding it first if it is not cached yet. The sum
+// names the file, so it must be 64 lowercase hex digits.
+func (c *Cache) Path(ctx context.Context, uri, sum string) (string, error) {
+	if sum == "" {
+		return "", fmt.Errorf("%s: manifest has no checksum to cache the file by", uri)
+	}
+	if !ValidSum(sum) {
+		return "", fmt.Errorf("%s: invalid checksum %q", uri, sum)
+	}
+	p := filepath.Join(c.dir, sum)
+	c.mu.Lock()
+	lock, ok := c.locks[sum]
+	if !ok {
+		lock = new(sync.Mutex)
+		c.locks[sum] = lock
+	}
+	c.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+	tmp, err := os.CreateTemp(c.dir, sum+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = c.v.RestoreWriter(ctx, uri, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return p, os.Rename(tmp.Name(), p)
+}
+
+// ValidSum reports whether sum is 64 lowercase hex digits, as written by
+// hex.EncodeToString, and so safe to use as a file name.
+func ValidSum(sum string) bool {
+	if len(sum) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range sum {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
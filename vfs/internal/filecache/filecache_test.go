@@ -0,0 +1,78 @@
+package filecache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vjeffz/vfs/vfs"
+	"github.com/vjeffz/vfs/vfs/memback"
+)
+
+func TestPath_DecodesOnce(t *testing.T) {
+	store := memback.New()
+	v, err := vfs.New(vfs.WithBackend("mem", store.Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := make([]byte, 5000)
+	rand.Read(data)
+	const uri = "mem://bucket/f/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := v.Stat(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(v, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := c.Path(ctx, uri, m.SHA256)
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	if got, _ := os.ReadFile(p); !bytes.Equal(got, data) {
+		t.Error("expected the cached copy to hold the file")
+	}
+	// With the archive gone, the cached copy is still served.
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := c.Path(ctx, uri, m.SHA256); err != nil || again != p {
+		t.Errorf("expected the cached copy again, got %q, %v", again, err)
+	}
+}
+
+func TestPath_RejectsBadChecksums(t *testing.T) {
+	store := memback.New()
+	v, err := vfs.New(vfs.WithBackend("mem", store.Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	const uri = "mem://bucket/f/"
+	if err := v.EncodeReader(ctx, bytes.NewReader([]byte("data")), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	parent := t.TempDir()
+	c, err := New(v, filepath.Join(parent, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sum := range []string{"../escaped", strings.Repeat("A", 64), strings.Repeat("0", 63)} {
+		if p, err := c.Path(ctx, uri, sum); err == nil {
+			t.Errorf("expected %q to be refused, got %s", sum, p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped")); err == nil {
+		t.Error("expected nothing written outside the cache")
+	}
+}
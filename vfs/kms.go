@@ -0,0 +1,67 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+type kmsAPI interface {
+	Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// WithKMSKey encrypts new archives like WithPassphrase, but wraps each
+// file's data key with the AWS KMS key keyID (a key ID, ARN, or alias), so
+// access follows the key policy and rotation is handled by KMS. Archives
+// wrapped with KMS decrypt with or without this option, as long as the
+// caller may use the key they were wrapped with.
+func WithKMSKey(keyID string) Option {
+	return func(o *options) {
+		o.kmsKeyID = keyID
+	}
+}
+
+func (o *options) newKMSClient() (kmsAPI, error) {
+	cfg, err := o.loadAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kms.NewFromConfig(cfg), nil
+}
+
+// kmsWrapper wraps data keys with a KMS key. The client is only built when
+// a key is first wrapped or unwrapped.
+type kmsWrapper struct {
+	keyID  string
+	client func() (kmsAPI, error)
+}
+
+func (k kmsWrapper) wrap(ctx context.Context, dataKey []byte) (*Encryption, error) {
+	client, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.Encrypt(ctx, &kms.EncryptInput{KeyId: &k.keyID, Plaintext: dataKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key with %s: %w", k.keyID, err)
+	}
+	keyID := k.keyID
+	if out.KeyId != nil {
+		keyID = *out.KeyId
+	}
+	return &Encryption{Algorithm: encryptionAlgorithm, KMSKeyID: keyID, WrappedKey: out.CiphertextBlob}, nil
+}
+
+func (k kmsWrapper) unwrap(ctx context.Context, e *Encryption) ([]byte, error) {
+	client, err := k.client()
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.Decrypt(ctx, &kms.DecryptInput{KeyId: &e.KMSKeyID, CiphertextBlob: e.WrappedKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with %s: %w", e.KMSKeyID, err)
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,73 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeKMS wraps keys by prefixing the key ID, which is enough to check
+// that the right key unwraps them.
+type fakeKMS struct {
+	encrypts, decrypts int
+}
+
+func (f *fakeKMS) Encrypt(_ context.Context, in *kms.EncryptInput, _ ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	f.encrypts++
+	arn := "arn:aws:kms:us-east-1:111122223333:key/" + strings.TrimPrefix(*in.KeyId, "alias/")
+	return &kms.EncryptOutput{KeyId: &arn, CiphertextBlob: append([]byte(arn+"|"), in.Plaintext...)}, nil
+}
+
+func (f *fakeKMS) Decrypt(_ context.Context, in *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	f.decrypts++
+	key, ok := bytes.CutPrefix(in.CiphertextBlob, []byte(*in.KeyId+"|"))
+	if !ok {
+		return nil, errors.New("IncorrectKeyException")
+	}
+	return &kms.DecryptOutput{Plaintext: key}, nil
+}
+
+func withFakeKMS(v *VFS, client *fakeKMS) {
+	v.kms = func() (kmsAPI, error) { return client, nil }
+}
+
+func TestKMS_WrapsDataKey(t *testing.T) {
+	fake, keys := newFakeS3(), &fakeKMS{}
+	v := newTestVFS(fake)
+	withFakeKMS(v, keys)
+	v.keys = kmsWrapper{keyID: "alias/backups", client: v.kms}
+	ctx := context.Background()
+	const uri = "s3://bucket/kms/"
+	data := randomBytes(3000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if keys.encrypts != 1 {
+		t.Errorf("expected one data key wrapped, got %d", keys.encrypts)
+	}
+	manifest := string(fake.objects["bucket/kms/"+manifestName])
+	if !strings.Contains(manifest, `"kms_key_id": "arn:aws:kms:us-east-1:111122223333:key/backups"`) {
+		t.Errorf("expected the key ARN in the manifest, got %s", manifest)
+	}
+
+	// Reading needs no configured key, only access to KMS.
+	reader := newTestVFS(fake)
+	withFakeKMS(reader, keys)
+	var out bytes.Buffer
+	if err := reader.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("expected the file back, got %d bytes, %v", out.Len(), err)
+	}
+	if keys.decrypts == 0 {
+		t.Error("expected the data key to be unwrapped by KMS")
+	}
+}
+
+func TestKMS_PassphraseDoesNotMix(t *testing.T) {
+	if _, err := New(WithPassphrase([]byte("p")), WithKMSKey("alias/k")); err == nil || !strings.Contains(err.Error(), "KMS") {
+		t.Errorf("expected a passphrase and a KMS key together to be rejected, got %v", err)
+	}
+}
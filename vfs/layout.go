@@ -0,0 +1,48 @@
+package vfs
+
+import (
+	"context"
+)
+
+// In the split layout an archive keeps its chunks under "<prefix>data/" and
+// its metadata under "<prefix>meta/", so lifecycle rules can target them
+// separately. The layout marker lives at a fixed meta location because
+// readers have to find it before they know where anything else is.
+const (
+	dataDir      = "data/"
+	metaDir      = "meta/"
+	layoutMarker = metaDir + "layout"
+)
+
+// WithSplitLayout stores new archives with chunks under data/ and metadata
+// under meta/ within the archive prefix.
+func WithSplitLayout() Option {
+	return func(o *options) {
+		o.splitLayout = true
+	}
+}
+
+// encodePrefix returns the prefix new chunks are written under.
+func (v *VFS) encodePrefix(prefix string) string {
+	if v.splitLayout {
+		return prefix + dataDir
+	}
+	return prefix
+}
+
+// chunkPrefix returns the prefix the chunks of an existing archive live
+// under, detecting the split layout from its meta/ marker.
+func (v *VFS) chunkPrefix(ctx context.Context, b Backend, prefix string) (string, error) {
+	split, err := v.hasObjects(ctx, b, prefix+metaDir)
+	if err != nil {
+		return "", err
+	}
+	if split {
+		return prefix + dataDir, nil
+	}
+	return prefix, nil
+}
+
+func (v *VFS) writeLayoutMarker(ctx context.Context, b Backend, prefix string) error {
+	return b.Put(ctx, prefix+layoutMarker, []byte("split\n"))
+}
@@ -0,0 +1,95 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitLayout_RoundTrip(t *testing.T) {
+	const uri = "s3://bucket/archive/"
+	data := randomBytes(3000)
+
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.splitLayout = true
+	if err := v.Encode(context.Background(), writeTempFile(t, data), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	var sawMarker bool
+	for _, k := range fake.keys("bucket") {
+		switch {
+		case k == "archive/"+layoutMarker:
+			sawMarker = true
+		case k == "archive/"+metaDir+manifestName:
+		case !strings.HasPrefix(k, "archive/"+dataDir):
+			t.Errorf("unexpected key outside data/: %s", k)
+		}
+		if len(k) > s3MaxKeyLengthBytes {
+			t.Errorf("key exceeds S3 limit: %d bytes", len(k))
+		}
+	}
+	if !sawMarker {
+		t.Fatal("expected layout marker under meta/")
+	}
+
+	// A reader without the option must still detect the layout.
+	reader := newTestVFS(fake)
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := reader.Restore(context.Background(), uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, data) {
+		t.Error("restored data does not match the original")
+	}
+
+	sum, err := reader.Checksum(context.Background(), uri)
+	if err != nil {
+		t.Fatalf("checksum failed: %v", err)
+	}
+	if want := sha256.Sum256(data); sum != hex.EncodeToString(want[:]) {
+		t.Error("checksum does not match the original")
+	}
+
+	if err := reader.Delete(context.Background(), uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if keys := fake.keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected delete to remove meta/ and data/, left %v", keys)
+	}
+}
+
+func TestSplitLayout_AppendAndChunkSize(t *testing.T) {
+	const uri = "s3://bucket/logs/"
+	chunkSize := calculateChunkSize("logs/" + dataDir)
+	if chunkSize >= calculateChunkSize("logs/") {
+		t.Fatal("expected data/ segment to reduce the chunk size")
+	}
+
+	first := randomBytes(chunkSize + 10)
+	second := randomBytes(chunkSize)
+	v := newTestVFS(newFakeS3())
+	v.splitLayout = true
+	if err := v.Encode(context.Background(), writeTempFile(t, first), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(context.Background(), writeTempFile(t, second), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(context.Background(), uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, append(first, second...)) {
+		t.Error("restored data does not match the concatenation")
+	}
+}
@@ -0,0 +1,152 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	// initialLimit is where the limiter starts before ramping up towards
+	// the configured concurrency.
+	initialLimit = 4
+	// slowLatencyFactor is how far the average request latency may rise
+	// above the best recently seen before the limiter stops growing.
+	slowLatencyFactor = 3
+)
+
+// aimdLimiter bounds the number of in-flight requests. It starts small and
+// doubles the limit after every window of limit fast requests (slow start)
+// until the first sign of congestion, then grows by one per window. The
+// limit is halved when a request is throttled or times out (at most once
+// per window), and holds while the average latency is well above the best
+// recently seen, which is how overload shows before the store throttles.
+type aimdLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	max       int
+	limit     int
+	inFlight  int
+	okCount   int
+	sinceCut  int
+	slowStart bool
+	best      time.Duration // baseline latency, drifting towards avg
+	avg       time.Duration // moving average latency
+}
+
+func newAIMDLimiter(max int) *aimdLimiter {
+	if max < 1 {
+		max = 1
+	}
+	l := &aimdLimiter{max: max, limit: min(max, initialLimit), slowStart: true}
+	l.sinceCut = l.limit
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *aimdLimiter) acquire() {
+	l.mu.Lock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.mu.Unlock()
+}
+
+// release gives back a slot held by a request that took latency and
+// reports whether it hit congestion.
+func (l *aimdLimiter) release(latency time.Duration, congested bool) {
+	l.mu.Lock()
+	l.inFlight--
+	l.sinceCut++
+	switch {
+	case congested:
+		l.okCount = 0
+		l.slowStart = false
+		if l.sinceCut >= l.limit {
+			l.limit = max(1, l.limit/2)
+			l.sinceCut = 0
+		}
+	case l.slow(latency):
+		l.okCount = 0
+	default:
+		l.okCount++
+		if l.okCount >= l.limit && l.limit < l.max {
+			if l.slowStart {
+				l.limit = min(l.max, 2*l.limit)
+			} else {
+				l.limit++
+			}
+			l.okCount = 0
+		}
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// slow records a successful request's latency and reports whether the
+// average has risen too far above the baseline to keep growing.
+func (l *aimdLimiter) slow(latency time.Duration) bool {
+	if l.avg == 0 {
+		l.avg, l.best = latency, latency
+		return false
+	}
+	l.avg += (latency - l.avg) / 8
+	if latency < l.best {
+		l.best = latency
+	} else {
+		// Let the baseline follow a lasting change in latency.
+		l.best += (l.avg - l.best) / 1024
+	}
+	return l.avg > slowLatencyFactor*l.best
+}
+
+// abandon gives back a slot whose request was never sent.
+func (l *aimdLimiter) abandon() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *aimdLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// EffectiveConcurrency reports how many requests may currently be in
+// flight. It starts low, ramps up towards the configured concurrency while
+// requests are fast, and drops while the store throttles or times out.
+func (v *VFS) EffectiveConcurrency() int {
+	return v.limiter.current()
+}
+
+// isCongestion reports whether err means the store is overloaded: it
+// throttled the request or the request timed out.
+func isCongestion(err error) bool {
+	return isThrottle(err) || isTimeout(err)
+}
+
+func isThrottle(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+			return true
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusServiceUnavailable
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -0,0 +1,127 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// run passes n requests of the given latency through l one at a time.
+func run(l *aimdLimiter, n int, latency time.Duration, congested bool) {
+	for i := 0; i < n; i++ {
+		l.acquire()
+		l.release(latency, congested)
+	}
+}
+
+func TestAIMDLimiter_SlowStartRampsUp(t *testing.T) {
+	l := newAIMDLimiter(64)
+	if got := l.current(); got != initialLimit {
+		t.Fatalf("expected to start at %d, got %d", initialLimit, got)
+	}
+	// Doubling needs about 4+8+16+32 fast requests to reach 64.
+	run(l, 60, time.Millisecond, false)
+	if got := l.current(); got != 64 {
+		t.Errorf("expected slow start to reach 64, got %d", got)
+	}
+}
+
+func TestAIMDLimiter_BacksOffAndRecovers(t *testing.T) {
+	l := newAIMDLimiter(16)
+	run(l, 100, time.Millisecond, false)
+	run(l, 16, time.Millisecond, true)
+	if got := l.current(); got >= 16 {
+		t.Fatalf("expected limit to shrink after throttling, got %d", got)
+	}
+
+	backedOff := l.current()
+	run(l, 200, time.Millisecond, false)
+	if got := l.current(); got <= backedOff || got > 16 {
+		t.Errorf("expected limit to recover towards 16 (from %d), got %d", backedOff, got)
+	}
+}
+
+func TestAIMDLimiter_HoldsWhileLatencyIsHigh(t *testing.T) {
+	l := newAIMDLimiter(64)
+	run(l, 8, time.Millisecond, false)
+	held := l.current()
+	run(l, 200, 20*time.Millisecond, false)
+	if got := l.current(); got > held {
+		t.Errorf("expected the limit to hold at %d while latency is high, got %d", held, got)
+	}
+}
+
+func TestAIMDLimiter_NeverBelowOne(t *testing.T) {
+	l := newAIMDLimiter(4)
+	run(l, 50, time.Millisecond, true)
+	if got := l.current(); got != 1 {
+		t.Errorf("expected limit floor of 1, got %d", got)
+	}
+}
+
+func TestIsCongestion(t *testing.T) {
+	if !isCongestion(context.DeadlineExceeded) {
+		t.Error("expected a timeout to count as congestion")
+	}
+	if isCongestion(errors.New("access denied")) {
+		t.Error("expected other errors not to count as congestion")
+	}
+}
+
+func TestIsThrottle(t *testing.T) {
+	if !isThrottle(&smithy.GenericAPIError{Code: "SlowDown"}) {
+		t.Error("expected SlowDown to be treated as throttling")
+	}
+	if isThrottle(&smithy.GenericAPIError{Code: "AccessDenied"}) {
+		t.Error("expected AccessDenied not to be treated as throttling")
+	}
+}
+
+func TestEncode_AdaptiveConcurrencyUnderThrottling(t *testing.T) {
+	const capacity = 3
+	var inFlight, throttled int32
+	fake := newFakeS3()
+	fake.putHook = func() error {
+		defer atomic.AddInt32(&inFlight, -1)
+		if atomic.AddInt32(&inFlight, 1) > capacity {
+			atomic.AddInt32(&throttled, 1)
+			return &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	v := newTestVFS(fake)
+	v.concurrency = 16
+	v.limiter = newAIMDLimiter(16)
+	v.retry = RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	data := randomBytes(200 * 700)
+	input := writeTempFile(t, data)
+
+	if err := v.Encode(context.Background(), input, "s3://bucket/throttled/", true); err != nil {
+		t.Fatalf("encode failed under throttling: %v", err)
+	}
+	if atomic.LoadInt32(&throttled) == 0 {
+		t.Fatal("expected the fake client to throttle some requests")
+	}
+	if got := v.EffectiveConcurrency(); got >= 16 {
+		t.Errorf("expected effective concurrency to back off below 16, got %d", got)
+	}
+
+	output := filepath.Join(t.TempDir(), "output.bin")
+	if err := v.Restore(context.Background(), "s3://bucket/throttled/", output); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(output)
+	if !bytes.Equal(got, data) {
+		t.Error("restored data does not match the original")
+	}
+}
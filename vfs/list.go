@@ -0,0 +1,92 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is an archive found by List.
+type Entry struct {
+	URI      string
+	Manifest *Manifest // nil if Err is set
+	Modified time.Time // when the manifest was last written
+	// Err is set if the manifest could not be read, for example
+	// ErrEncrypted for an encrypted archive when the VFS has no key.
+	Err error
+}
+
+// List returns the archives stored at or below uri, sorted by URI, leaving
+// out kept versions and snapshots. Archives are found by their manifests,
+// so uploads that have not finished and archives written before manifests
+// (see Migrate) are not listed. Finding them takes a listing of every key
+// under uri.
+func (v *VFS) List(ctx context.Context, uri string) ([]Entry, error) {
+	scheme, bucket, _, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return v.listArchives(ctx, b, fmt.Sprintf("%s://%s/", scheme, bucket), prefix, nil)
+}
+
+// listArchives finds the archives under prefix of b, whose URIs start with
+// root, calling visit, if not nil, with every object on the way.
+func (v *VFS) listArchives(ctx context.Context, b Backend, root, prefix string, visit func(Object)) ([]Entry, error) {
+	manifests := make(map[string]time.Time)
+	split := make(map[string]bool)
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			if visit != nil {
+				visit(obj)
+			}
+			if dir, ok := archiveDir(obj.Key, prefix, manifestName); ok {
+				manifests[dir] = obj.LastModified
+			}
+			if dir, ok := archiveDir(obj.Key, prefix, layoutMarker); ok {
+				split[dir] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for dir, modified := range manifests {
+		if isKept(strings.TrimPrefix(dir, prefix)) {
+			continue // see Versions and Snapshots
+		}
+		archive, dataPrefix := dir, dir
+		if p, ok := strings.CutSuffix(dir, metaDir); ok && split[p] {
+			archive, dataPrefix = p, p+dataDir
+		}
+		e := Entry{URI: root + archive, Modified: modified}
+		e.Manifest, e.Err = v.readManifest(ctx, b, manifestKey(archive, dataPrefix))
+		if e.Err == nil && e.Manifest == nil {
+			continue // deleted since it was listed
+		}
+		if e.Err != nil {
+			e.Manifest = nil
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URI < entries[j].URI })
+	return entries, nil
+}
+
+// archiveDir returns the directory of key under prefix if key names the
+// object name there.
+func archiveDir(key, prefix, name string) (string, bool) {
+	dir, ok := strings.CutSuffix(key, name)
+	if !ok || !strings.HasPrefix(dir, prefix) || (dir != "" && !strings.HasSuffix(dir, "/")) {
+		return "", false
+	}
+	return dir, true
+}
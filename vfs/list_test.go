@@ -0,0 +1,66 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestList_FindsArchivesByManifest(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(500)), "s3://bucket/backups/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	split := newTestVFS(fake)
+	split.splitLayout = true
+	if err := split.EncodeReader(ctx, bytes.NewReader(randomBytes(300)), "s3://bucket/backups/b/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := newEncryptedTestVFS(fake, "pass").EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/backups/c/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/other/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := v.List(ctx, "s3://bucket/backups/")
+	if err != nil {
+		t.Fatalf("list failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 archives, got %+v", entries)
+	}
+	a, b, c := entries[0], entries[1], entries[2]
+	if a.URI != "s3://bucket/backups/a/" || a.Manifest == nil || a.Manifest.Size != 500 || a.Modified.IsZero() {
+		t.Errorf("unexpected entry %+v", a)
+	}
+	if b.URI != "s3://bucket/backups/b/" || b.Manifest == nil || b.Manifest.Size != 300 {
+		t.Errorf("expected the split archive under its own prefix, got %+v", b)
+	}
+	if c.URI != "s3://bucket/backups/c/" || !errors.Is(c.Err, ErrEncrypted) {
+		t.Errorf("expected the encrypted archive to be listed with ErrEncrypted, got %+v", c)
+	}
+}
+
+func TestArchiveDir(t *testing.T) {
+	for _, tc := range []struct {
+		key, dir string
+		ok       bool
+	}{
+		{"x/" + manifestName, "x/", true},
+		{manifestName, "", true},
+		{"x/y" + manifestName, "", false},
+		{"other/" + manifestName, "", false},
+	} {
+		prefix := ""
+		if tc.key != manifestName {
+			prefix = "x/"
+		}
+		if dir, ok := archiveDir(tc.key, prefix, manifestName); dir != tc.dir || ok != tc.ok {
+			t.Errorf("archiveDir(%q) = %q, %v", tc.key, dir, ok)
+		}
+	}
+}
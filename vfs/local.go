@@ -0,0 +1,80 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// ReassembleLocal rebuilds a file from a directory of exported chunks.
+// Chunk files are either named "<index>" and hold the raw chunk bytes, or
+// named "<index>-<base64>" like the S3 keys, in which case the data is
+// decoded from the name and the file content is ignored.
+func ReassembleLocal(chunkDir, outputPath string) error {
+	entries, err := os.ReadDir(chunkDir)
+	if err != nil {
+		return err
+	}
+
+	type localChunk struct {
+		storedChunk
+		name  string
+		keyed bool
+	}
+
+	var chunks []localChunk
+	seen := make(map[int]string)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		c := localChunk{name: name}
+		if parsed, ok := parseChunkName(name); ok {
+			c.storedChunk, c.keyed = parsed, true
+		} else if index, err := strconv.Atoi(name); err == nil {
+			c.index = index
+		} else {
+			continue
+		}
+		if prev, ok := seen[c.index]; ok {
+			return fmt.Errorf("duplicate chunk index %d: %s and %s", c.index, prev, name)
+		}
+		seen[c.index] = name
+		chunks = append(chunks, c)
+	}
+
+	if len(chunks) == 0 {
+		return fmt.Errorf("no chunks found in %s", chunkDir)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].index < chunks[j].index
+	})
+
+	if err := prepareOutputPath(outputPath); err != nil {
+		return err
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, c := range chunks {
+		var data []byte
+		if c.keyed {
+			data, err = c.decode()
+		} else {
+			data, err = os.ReadFile(filepath.Join(chunkDir, c.name))
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", c.name, err)
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
@@ -0,0 +1,67 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReassembleLocal_KeyedNames(t *testing.T) {
+	dir := t.TempDir()
+	parts := [][]byte{[]byte("hello, "), []byte("offline "), []byte("world")}
+	for i, p := range parts {
+		name := fmt.Sprintf("%d-%s", i+1, base64.RawURLEncoding.EncodeToString(p))
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "nested", "out.txt")
+	if err := ReassembleLocal(dir, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if string(got) != "hello, offline world" {
+		t.Errorf("unexpected reassembled content %q", got)
+	}
+}
+
+func TestReassembleLocal_RawFilesSortedNumerically(t *testing.T) {
+	dir := t.TempDir()
+	var want []byte
+	for i := 1; i <= 12; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, i)
+		want = append(want, data...)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprint(i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := ReassembleLocal(dir, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, want) {
+		t.Errorf("chunks were not reassembled in index order")
+	}
+}
+
+func TestReassembleLocal_DuplicateIndex(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "1"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "1-Yg"), nil, 0644)
+
+	if err := ReassembleLocal(dir, filepath.Join(t.TempDir(), "out")); err == nil {
+		t.Error("expected error for duplicate chunk index")
+	}
+}
+
+func TestReassembleLocal_Empty(t *testing.T) {
+	if err := ReassembleLocal(t.TempDir(), filepath.Join(t.TempDir(), "out")); err == nil {
+		t.Error("expected error for directory without chunks")
+	}
+}
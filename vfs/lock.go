@@ -0,0 +1,167 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+var (
+	// ErrObjectLocked is returned by Delete, and by Encode with force, for
+	// an archive whose objects are still under the Object Lock they were
+	// written with.
+	ErrObjectLocked = errors.New("archive is under Object Lock")
+	// ErrObjectLockUnsupported is returned with WithRetention or
+	// WithLegalHold for URIs of backends other than S3.
+	ErrObjectLockUnsupported = errors.New("Object Lock needs an S3 bucket")
+)
+
+// LockMode is an S3 Object Lock retention mode.
+type LockMode string
+
+const (
+	// LockGovernance lets users with s3:BypassGovernanceRetention remove
+	// objects early.
+	LockGovernance LockMode = "GOVERNANCE"
+	// LockCompliance keeps objects until their retention ends, whoever
+	// asks.
+	LockCompliance LockMode = "COMPLIANCE"
+)
+
+// ParseLockMode accepts "governance" or "compliance", in any case.
+func ParseLockMode(s string) (LockMode, error) {
+	switch m := LockMode(strings.ToUpper(s)); m {
+	case LockGovernance, LockCompliance:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown lock mode %q (want governance or compliance)", s)
+}
+
+// WithRetention writes every new S3 object, chunks and manifest alike,
+// with an Object Lock retention of period in mode, counted from when the
+// object is written. The bucket must have Object Lock enabled, and URIs of
+// other backends fail with ErrObjectLockUnsupported. The lock is recorded
+// in the manifest, so Delete can refuse the archive while it holds.
+func WithRetention(mode LockMode, period time.Duration) Option {
+	return func(o *options) {
+		o.lock.mode, o.lock.period = mode, period
+	}
+}
+
+// WithLegalHold puts every new S3 object under an Object Lock legal hold,
+// which keeps it until the hold is removed, as for WithRetention.
+func WithLegalHold() Option {
+	return func(o *options) {
+		o.lock.legalHold = true
+	}
+}
+
+// lockPolicy is the Object Lock new objects are written with.
+type lockPolicy struct {
+	mode      LockMode
+	period    time.Duration
+	legalHold bool
+}
+
+func (p lockPolicy) enabled() bool {
+	return p.mode != "" || p.legalHold
+}
+
+func (p lockPolicy) check() error {
+	if p.mode == "" {
+		return nil
+	}
+	if _, err := ParseLockMode(string(p.mode)); err != nil {
+		return err
+	}
+	if p.period <= 0 {
+		return errors.New("a retention period must be positive")
+	}
+	return nil
+}
+
+// s3Params returns the Object Lock parameters of an object written now.
+func (p lockPolicy) s3Params() (mode s3types.ObjectLockMode, until *time.Time, hold s3types.ObjectLockLegalHoldStatus) {
+	if p.mode != "" {
+		t := time.Now().Add(p.period).UTC()
+		mode, until = s3types.ObjectLockMode(p.mode), &t
+	}
+	if p.legalHold {
+		hold = s3types.ObjectLockLegalHoldStatusOn
+	}
+	return mode, until, hold
+}
+
+// ObjectLock is the Object Lock the objects of an archive were written
+// with, as recorded in its manifest.
+type ObjectLock struct {
+	Mode LockMode `json:"mode,omitempty"`
+	// RetainUntil is when the retention of the manifest, the last object
+	// written, ends; earlier objects are free a little sooner.
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+	LegalHold   bool       `json:"legal_hold,omitempty"`
+}
+
+// manifestLock returns the lock of a manifest written now, nil without
+// one.
+func (v *VFS) manifestLock() *ObjectLock {
+	if !v.lock.enabled() {
+		return nil
+	}
+	mode, until, hold := v.lock.s3Params()
+	return &ObjectLock{Mode: LockMode(mode), RetainUntil: until, LegalHold: hold == s3types.ObjectLockLegalHoldStatusOn}
+}
+
+// holds reports whether l still keeps objects at now, and why.
+func (l *ObjectLock) holds(now time.Time) (string, bool) {
+	switch {
+	case l == nil:
+		return "", false
+	case l.LegalHold:
+		return "a legal hold", true
+	case l.RetainUntil != nil && l.RetainUntil.After(now):
+		return fmt.Sprintf("%s retention until %s", l.Mode, l.RetainUntil.Format(time.RFC3339)), true
+	}
+	return "", false
+}
+
+// checkUnlocked fails with ErrObjectLocked if the manifest of an archive
+// at or below prefix records a lock that still holds: deleting its objects
+// would only hide them behind delete markers. The versions and snapshots
+// of the archive at prefix are checked too, unless keepHistory is set
+// because they are not going to be deleted. Locks are read without
+// decrypting the manifests, and archives without one are not locked.
+func (v *VFS) checkUnlocked(ctx context.Context, b Backend, prefix, uri string, keepHistory bool) error {
+	var manifests []string
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			dir, ok := archiveDir(obj.Key, prefix, manifestName)
+			if ok && !(keepHistory && isHistory(strings.TrimPrefix(dir, prefix))) {
+				manifests = append(manifests, obj.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, key := range manifests {
+		meta, err := readManifestMeta(ctx, b, key)
+		if err != nil {
+			return err
+		}
+		if why, ok := meta.Lock.holds(now); ok {
+			where := uri
+			if rel := strings.TrimPrefix(strings.TrimSuffix(key, manifestName), prefix); rel != "" && rel != metaDir {
+				where = strings.TrimSuffix(uri, "/") + "/" + strings.TrimSuffix(rel, metaDir)
+			}
+			return fmt.Errorf("%w: %s is under %s, and deleting it now would only hide its objects behind delete markers", ErrObjectLocked, where, why)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestWithRetention_LocksEveryObject(t *testing.T) {
+	fake := newFakeS3()
+	var mu sync.Mutex
+	var puts []*s3.PutObjectInput
+	fake.onPut = func(in *s3.PutObjectInput) {
+		mu.Lock()
+		puts = append(puts, in)
+		mu.Unlock()
+	}
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.lock = lockPolicy{mode: LockCompliance, period: 24 * time.Hour, legalHold: true}
+	before := time.Now()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, in := range puts {
+		if in.ObjectLockMode != s3types.ObjectLockModeCompliance || in.ObjectLockLegalHoldStatus != s3types.ObjectLockLegalHoldStatusOn ||
+			in.ObjectLockRetainUntilDate == nil || in.ObjectLockRetainUntilDate.Before(before.Add(24*time.Hour)) {
+			t.Errorf("expected %s to be locked for a day under a legal hold, got %s until %v, hold %q",
+				*in.Key, in.ObjectLockMode, in.ObjectLockRetainUntilDate, in.ObjectLockLegalHoldStatus)
+		}
+	}
+	m, err := v.Stat(ctx, "s3://bucket/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Lock == nil || m.Lock.Mode != LockCompliance || !m.Lock.LegalHold || m.Lock.RetainUntil == nil {
+		t.Errorf("expected the lock in the manifest, got %+v", m.Lock)
+	}
+}
+
+func TestDelete_RefusesLockedArchive(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.keys = passphraseWrapper{passphrase: []byte("hunter2")}
+	v.lock = lockPolicy{mode: LockGovernance, period: time.Hour}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	stored := len(fake.keys("bucket"))
+
+	// The lock is read without the key.
+	v = newTestVFS(fake)
+	if err := v.Delete(ctx, "s3://bucket/f/"); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("expected ErrObjectLocked, got %v", err)
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/f/", false); !errors.Is(err, ErrPrefixExists) {
+		t.Errorf("expected ErrPrefixExists, got %v", err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://bucket/f/", true); !errors.Is(err, ErrObjectLocked) {
+		t.Errorf("expected ErrObjectLocked when overwriting, got %v", err)
+	}
+	if n := len(fake.keys("bucket")); n != stored {
+		t.Errorf("expected all %d objects kept, found %d", stored, n)
+	}
+}
+
+func TestDelete_RefusesLockedNestedArchive(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.lock = lockPolicy{mode: LockCompliance, period: time.Hour}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/dir/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	stored := len(fake.keys("bucket"))
+
+	v = newTestVFS(fake)
+	err := v.Delete(ctx, "s3://bucket/dir/")
+	if !errors.Is(err, ErrObjectLocked) || !strings.Contains(err.Error(), "s3://bucket/dir/f/") {
+		t.Errorf("expected ErrObjectLocked naming the nested archive, got %v", err)
+	}
+	if n := len(fake.keys("bucket")); n != stored {
+		t.Errorf("expected all %d objects kept, found %d", stored, n)
+	}
+}
+
+func TestDelete_RefusesLockedVersion(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.versioning = true
+	for range 2 {
+		if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://bucket/f/", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the version is locked, as if retention had lapsed for the rest.
+	vuri, err := VersionURI("s3://bucket/f/", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := v.Stat(ctx, vuri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, prefix, _ := parseURI(vuri)
+	locked := newTestVFS(fake)
+	locked.lock = lockPolicy{mode: LockGovernance, period: time.Hour}
+	if err := locked.writeManifest(ctx, &s3Backend{client: fake, bucket: "bucket"}, prefix+manifestName, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Delete(ctx, "s3://bucket/f/"); !errors.Is(err, ErrObjectLocked) || !strings.Contains(err.Error(), vuri) {
+		t.Errorf("expected ErrObjectLocked naming the version, got %v", err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://bucket/f/", true); err != nil {
+		t.Errorf("expected an overwrite, which keeps the versions, to go ahead, got %v", err)
+	}
+}
+
+func TestDelete_AllowsExpiredRetention(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.lock = lockPolicy{mode: LockCompliance, period: time.Millisecond}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := v.Delete(ctx, "s3://bucket/f/"); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected everything deleted, found %v", keys)
+	}
+}
+
+func TestWithRetention_NeedsS3(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	v.lock = lockPolicy{legalHold: true}
+	err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(100)), "file://"+t.TempDir()+"/f/", false)
+	if !errors.Is(err, ErrObjectLockUnsupported) {
+		t.Errorf("expected ErrObjectLockUnsupported, got %v", err)
+	}
+	if _, err := New(WithRetention("FOREVER", time.Hour)); err == nil {
+		t.Error("expected an unknown mode to be refused")
+	}
+}
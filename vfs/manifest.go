@@ -0,0 +1,429 @@
+package vfs
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+const (
+	manifestName    = ".vfs-manifest"
+	manifestVersion = 1
+)
+
+// Manifest describes a stored file. It lives next to the chunks at
+// <prefix>.vfs-manifest, or at <prefix>meta/.vfs-manifest in the split
+// layout.
+type Manifest struct {
+	Version    int    `json:"version"`
+	Filename   string `json:"filename,omitempty"`
+	Size       int64  `json:"size"`
+	Chunks     int    `json:"chunks"`
+	ChunkSize  int    `json:"chunk_size"`
+	IndexWidth int    `json:"index_width,omitempty"` // 0 for unpadded indices
+	SHA256     string `json:"sha256"`
+	// Created is when the file was encoded, nil if the manifest was added
+	// by Migrate.
+	Created *time.Time `json:"created,omitempty"`
+	// Expires is when the archive may be deleted by GC (see WithExpiry).
+	// Like Lock, it is kept outside the sealed manifest of an encrypted
+	// archive too.
+	Expires *time.Time `json:"expires,omitempty"`
+	// SHA256State is the state of the hash after the last byte of the
+	// file, so Append can extend SHA256 without reading the file back.
+	SHA256State []byte `json:"sha256_state,omitempty"`
+
+	// Compression is set if the chunks hold a compressed stream of
+	// StoredSize bytes. Size and SHA256 always describe the file itself.
+	Compression Compression `json:"compression,omitempty"`
+	StoredSize  int64       `json:"stored_size,omitempty"`
+	// Obfuscated is set if chunks are scrambled with a key derived from
+	// the archive prefix (see WithObfuscation).
+	Obfuscated bool `json:"obfuscated,omitempty"`
+	// Tar is set if the file is a tar stream of a directory (see
+	// EncodeDir).
+	Tar bool `json:"tar,omitempty"`
+	// Attrs are the attributes of the encoded file, nil for streams and
+	// with WithNoPreserve.
+	Attrs *FileAttrs `json:"attrs,omitempty"`
+	// Lock is the Object Lock the archive's objects were written with (see
+	// WithRetention). It is also kept outside the sealed manifest of an
+	// encrypted archive, so Delete can read it without the key.
+	Lock *ObjectLock `json:"lock,omitempty"`
+	// Storage is "body" if the data is stored in object bodies of
+	// ChunkSize bytes rather than in keys (see WithBodyThreshold), or
+	// "dedup" if it is stored in the bucket's chunk pool (see WithDedup).
+	Storage string `json:"storage,omitempty"`
+	// Hashes lists the SHA-256 of each pooled chunk of a deduplicated
+	// archive, in order.
+	Hashes []string `json:"hashes,omitempty"`
+	// Parity is the stripe layout of an archive with parity chunks (see
+	// WithParity).
+	Parity *Parity `json:"parity,omitempty"`
+
+	// Encryption is set for encrypted archives, whose manifest is stored
+	// sealed next to the wrapped data key.
+	Encryption *Encryption `json:"encryption,omitempty"`
+	aead       cipher.AEAD
+}
+
+// sealedManifest is how the manifest of an encrypted archive is stored:
+// only what is needed to unwrap the data key stays readable.
+type sealedManifest struct {
+	Version    int         `json:"version"`
+	Encryption *Encryption `json:"encryption,omitempty"`
+	Lock       *ObjectLock `json:"lock,omitempty"`
+	Expires    *time.Time  `json:"expires,omitempty"`
+	Sealed     []byte      `json:"sealed,omitempty"`
+}
+
+// manifestMeta is what can be read from a manifest without its key.
+type manifestMeta struct {
+	Lock    *ObjectLock `json:"lock"`
+	Expires *time.Time  `json:"expires"`
+}
+
+// readManifestMeta reads the manifest at key, which may be sealed, for
+// what it says without its key. A missing or unreadable manifest says
+// nothing.
+func readManifestMeta(ctx context.Context, b Backend, key string) (manifestMeta, error) {
+	var meta manifestMeta
+	body, err := b.Get(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+	json.Unmarshal(body, &meta)
+	return meta, nil
+}
+
+// compression returns how the chunks of the archive m describes are
+// compressed; archives without a manifest are not.
+func (m *Manifest) compression() Compression {
+	if m == nil {
+		return CompressionNone
+	}
+	return m.Compression
+}
+
+// codec returns the chunk codec of the archive at prefix that m
+// describes, or nil if its chunks are stored as they are.
+func (m *Manifest) codec(prefix string) chunkCodec {
+	var obfuscation, encryption chunkCodec
+	if m.Obfuscated {
+		obfuscation = newObfuscator(prefix)
+	}
+	if m.aead != nil {
+		encryption = gcmCodec{aead: m.aead, indexAD: m.Encryption != nil && m.Encryption.IndexAD}
+	}
+	return newCodecPipeline(obfuscation, encryption)
+}
+
+// seal makes the chunks of the archive at prefix that m describes undo
+// their codec as they decode.
+func (m *Manifest) seal(prefix string, chunks []storedChunk) {
+	codec := m.codec(prefix)
+	for i := range chunks {
+		chunks[i].codec = codec
+	}
+}
+
+func manifestKey(prefix, dataPrefix string) string {
+	return metaKey(prefix, dataPrefix, manifestName)
+}
+
+// readManifest returns the manifest at key, or nil if there is none. The
+// manifest of an encrypted archive is decrypted, failing with ErrEncrypted
+// if the VFS has no key.
+func (v *VFS) readManifest(ctx context.Context, b Backend, key string) (*Manifest, error) {
+	body, err := b.Get(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sealed sealedManifest
+	if err := json.Unmarshal(body, &sealed); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", key, err)
+	}
+	var aead cipher.AEAD
+	if sealed.Encryption != nil {
+		if aead, err = v.unwrapKey(ctx, sealed.Encryption); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if body, err = decrypt(aead, sealed.Sealed, nil); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", key, err)
+		}
+	}
+	var m Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", key, err)
+	}
+	if !validSum(m.SHA256) {
+		return nil, fmt.Errorf("invalid manifest %s: bad sha256 %q", key, m.SHA256)
+	}
+	m.Encryption, m.aead = sealed.Encryption, aead
+	return &m, nil
+}
+
+// validSum reports whether s is a SHA-256 sum as manifests record it,
+// 64 lowercase hex digits, or empty. Sums name files on local disk (see
+// internal/filecache), so anything else is refused.
+func validSum(s string) bool {
+	if s == "" {
+		return true
+	}
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *VFS) writeManifest(ctx context.Context, b Backend, key string, m *Manifest) error {
+	if lock := v.manifestLock(); lock != nil {
+		m.Lock = lock
+	}
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if m.Encryption != nil {
+		if err := b.Put(ctx, strings.TrimSuffix(key, manifestName)+encryptedName, nil); err != nil {
+			return err
+		}
+	}
+	if m.aead != nil {
+		sealed := sealedManifest{Version: m.Version, Encryption: m.Encryption, Lock: m.Lock, Expires: m.Expires, Sealed: encrypt(m.aead, body, nil)}
+		if body, err = json.MarshalIndent(sealed, "", "  "); err != nil {
+			return err
+		}
+	}
+	return b.Put(ctx, key, body)
+}
+
+// buildManifest derives a manifest from the chunk listing alone. It checks
+// that indices run from 1 without gaps or duplicates and that every chunk
+// but the last is full-sized. compression is how the chunks are compressed.
+func buildManifest(chunks []storedChunk, chunkSize int, compression Compression) (*Manifest, error) {
+	if len(chunks) == 0 {
+		return nil, ErrNoChunks
+	}
+	m := &Manifest{Version: manifestVersion, Chunks: len(chunks), ChunkSize: chunkSize, IndexWidth: chunks[0].width, Compression: compression}
+	h := sha256.New()
+	file := &countingWriter{w: h}
+	out := decompressTo(file, compression)
+	defer out.Close()
+	for i, c := range chunks {
+		if c.index != i+1 {
+			return nil, fmt.Errorf("chunk indices are not contiguous: expected %d, found %d", i+1, c.index)
+		}
+		data, err := c.decode()
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 && len(chunks) > 1 {
+			// Archives written before checksums have larger chunks, so
+			// the first chunk sets the size.
+			chunkSize = len(data)
+			m.ChunkSize = chunkSize
+		}
+		if len(data) != chunkSize && i != len(chunks)-1 {
+			return nil, fmt.Errorf("chunk %d holds %d bytes, expected %d", c.index, len(data), chunkSize)
+		}
+		if _, err := out.Write(data); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", c.index, err)
+		}
+		m.StoredSize += int64(len(data))
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	m.Size, m.SHA256, m.SHA256State = file.n, hex.EncodeToString(h.Sum(nil)), hashState(h)
+	if compression == CompressionNone {
+		m.StoredSize = 0
+	}
+	return m, nil
+}
+
+// Migrate upgrades an archive written before manifests existed by
+// validating its chunks and writing a manifest derived from the listing.
+// No chunk is re-uploaded. Archives that already have a manifest are left
+// untouched.
+func (v *VFS) Migrate(ctx context.Context, uri string) error {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	if err := v.checkCommitted(ctx, b, prefix, dataPrefix); err != nil {
+		return err
+	}
+	key := manifestKey(prefix, dataPrefix)
+	existing, err := v.readManifest(ctx, b, key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+	if err := v.checkPlainChunks(ctx, b, prefix, dataPrefix); err != nil {
+		return err
+	}
+	if err := v.rebuildManifest(ctx, b, prefix, dataPrefix, nil); err != nil {
+		return fmt.Errorf("%s: %w", uri, err)
+	}
+	return nil
+}
+
+// ExportManifest writes the manifest of the archive at uri to w as
+// indented JSON, to be inspected, diffed, or given back to ImportManifest.
+// The manifest of an encrypted archive is written decrypted, with its data
+// key still wrapped.
+func (v *VFS) ExportManifest(ctx context.Context, uri string, w io.Writer) error {
+	m, err := v.Stat(ctx, uri)
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// ImportManifest stores the manifest read from r, as written by
+// ExportManifest, for the archive at uri, for example one that lost its
+// manifest. The manifest must list as many chunks as are stored there;
+// that they hold what it describes is only checked by Verify. A
+// deduplicated manifest instead needs every chunk it lists in the pool,
+// and marks them for the archive so GC keeps them. Unless force is set, an
+// archive that has a manifest fails with ErrPrefixExists; with force, the
+// chunks a deduplicated manifest replaced marked are released. Manifests
+// of encrypted archives are sealed again, which needs the key.
+func (v *VFS) ImportManifest(ctx context.Context, uri string, r io.Reader, force bool) error {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Version < 1 || m.Version > manifestVersion {
+		return fmt.Errorf("invalid manifest: unknown version %d", m.Version)
+	}
+	if !validSum(m.SHA256) {
+		return fmt.Errorf("invalid manifest: bad sha256 %q", m.SHA256)
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	if err := v.checkCommitted(ctx, b, prefix, dataPrefix); err != nil {
+		return err
+	}
+	key := manifestKey(prefix, dataPrefix)
+	if !force {
+		if _, err := b.Get(ctx, key); err == nil {
+			return fmt.Errorf("%w: %s already has a manifest", ErrPrefixExists, uri)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	old, err := v.readManifest(ctx, b, key)
+	if err != nil && !errors.Is(err, ErrEncrypted) {
+		return err
+	}
+	if m.Encryption != nil {
+		if m.aead, err = v.unwrapKey(ctx, m.Encryption); err != nil {
+			return err
+		}
+	}
+
+	stored := len(m.Hashes)
+	if !m.deduped() {
+		chunks, err := v.listStored(ctx, b, dataPrefix, m.bodies())
+		if err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return fmt.Errorf("%w at %s", ErrNoChunks, uri)
+		}
+		if missing, duplicate := indexGaps(chunks); len(missing) > 0 || len(duplicate) > 0 {
+			return fmt.Errorf("%w: %s", ErrChunkGap, uri)
+		}
+		stored = len(chunks)
+	}
+	if stored != m.Chunks {
+		return fmt.Errorf("%w: %s holds %d chunks, the manifest lists %d", ErrManifestMismatch, uri, stored, m.Chunks)
+	}
+	var used, kept map[string]bool
+	if old.deduped() {
+		kept = make(map[string]bool, len(old.Hashes))
+		for _, hash := range old.Hashes {
+			kept[hash] = true
+		}
+	}
+	if m.deduped() {
+		if used, err = v.markPooled(ctx, b, uri, key, m.Hashes, kept); err != nil {
+			return err
+		}
+	}
+	if err := v.writeManifest(ctx, b, key, &m); err != nil {
+		return err
+	}
+	if old.deduped() {
+		return v.replaceDeduped(ctx, b, prefix, key, key, old.Hashes, used)
+	}
+	return nil
+}
+
+// rebuildManifest rewrites the manifest of the archive at prefix from its
+// chunk listing, keeping what the listing cannot tell (filename, encode
+// time, format, storage and encryption settings) from old, the current manifest,
+// if it is not nil.
+func (v *VFS) rebuildManifest(ctx context.Context, b Backend, prefix, dataPrefix string, old *Manifest) error {
+	chunks, err := v.listStored(ctx, b, dataPrefix, old.bodies())
+	if err != nil {
+		return err
+	}
+	chunkSize := backendChunkSize(b, dataPrefix)
+	if old != nil && old.ChunkSize > 0 {
+		// Body parts and capped chunks are smaller or larger than keys allow.
+		chunkSize = old.ChunkSize
+	}
+	if old != nil {
+		old.seal(prefix, chunks)
+	}
+	m, err := buildManifest(chunks, chunkSize, old.compression())
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		m.Filename, m.Created, m.Tar, m.Attrs = old.Filename, old.Created, old.Tar, old.Attrs
+		m.Expires, m.Lock = old.Expires, old.Lock
+		m.Obfuscated, m.Storage = old.Obfuscated, old.Storage
+		m.Encryption, m.aead = old.Encryption, old.aead
+	}
+	return v.writeManifest(ctx, b, manifestKey(prefix, dataPrefix), m)
+}
@@ -0,0 +1,284 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrate_LegacyArchive(t *testing.T) {
+	const uri = "s3://bucket/legacy/"
+	chunkSize := calculateChunkSize("legacy/")
+	data := randomBytes(3*chunkSize + 17)
+
+	fake := newFakeS3()
+	for i, c := range splitChunks(data, chunkSize) {
+		fake.objects["bucket/"+chunkKey("legacy/", i+1, c)] = nil
+	}
+
+	v := newTestVFS(fake)
+	if err := v.Migrate(context.Background(), uri); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	m, err := v.readManifest(context.Background(), &s3Backend{client: fake, bucket: "bucket"}, "legacy/"+manifestName)
+	if err != nil || m == nil {
+		t.Fatalf("expected a manifest to be written, got %v, %v", m, err)
+	}
+	sum := sha256.Sum256(data)
+	if m.Version != manifestVersion || m.Size != int64(len(data)) || m.Chunks != 4 ||
+		m.ChunkSize != chunkSize || m.SHA256 != hex.EncodeToString(sum[:]) || m.Filename != "" {
+		t.Errorf("unexpected manifest: %+v", m)
+	}
+
+	// Migrating again must leave the manifest alone.
+	fake.objects["bucket/legacy/"+manifestName] = []byte(`{"version":1,"size":1}`)
+	if err := v.Migrate(context.Background(), uri); err != nil {
+		t.Fatalf("second migrate failed: %v", err)
+	}
+	if m, _ := v.readManifest(context.Background(), &s3Backend{client: fake, bucket: "bucket"}, "legacy/"+manifestName); m.Size != 1 {
+		t.Error("expected migrate to be idempotent when a manifest exists")
+	}
+}
+
+func TestMigrate_RejectsGaps(t *testing.T) {
+	fake := newFakeS3()
+	chunkSize := calculateChunkSize("gaps/")
+	fake.objects["bucket/"+chunkKey("gaps/", 1, randomBytes(chunkSize))] = nil
+	fake.objects["bucket/"+chunkKey("gaps/", 3, randomBytes(10))] = nil
+
+	if err := newTestVFS(fake).Migrate(context.Background(), "s3://bucket/gaps/"); err == nil {
+		t.Error("expected migrate to reject an archive with a missing chunk")
+	}
+	if _, ok := fake.objects["bucket/gaps/"+manifestName]; ok {
+		t.Error("expected no manifest for an invalid archive")
+	}
+}
+
+func TestMigrate_SplitLayoutAndAppend(t *testing.T) {
+	const uri = "s3://bucket/split/"
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.splitLayout = true
+	first := randomBytes(1000)
+	if err := v.Encode(context.Background(), writeTempFile(t, first), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Migrate(context.Background(), uri); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	second := randomBytes(500)
+	if err := v.Append(context.Background(), writeTempFile(t, second), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	m, err := v.readManifest(context.Background(), &s3Backend{client: fake, bucket: "bucket"}, "split/"+metaDir+manifestName)
+	if err != nil || m == nil {
+		t.Fatalf("expected manifest under meta/, got %v, %v", m, err)
+	}
+	sum := sha256.Sum256(append(first, second...))
+	if m.Size != 1500 || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected append to refresh the manifest, got %+v", m)
+	}
+}
+
+func TestEncode_WritesManifest(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	data := randomBytes(3000)
+	input := writeTempFile(t, data)
+	if err := v.Encode(context.Background(), input, "s3://bucket/new/", true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	m, err := v.readManifest(context.Background(), &s3Backend{client: fake, bucket: "bucket"}, "new/"+manifestName)
+	if err != nil || m == nil {
+		t.Fatalf("expected a manifest, got %v, %v", m, err)
+	}
+	sum := sha256.Sum256(data)
+	chunkSize := calculateChunkSize("new/")
+	if m.Size != int64(len(data)) || m.SHA256 != hex.EncodeToString(sum[:]) || m.ChunkSize != chunkSize ||
+		m.Chunks != int(chunkCount(int64(len(data)), chunkSize)) || m.Filename != filepath.Base(input) {
+		t.Errorf("unexpected manifest: %+v", m)
+	}
+}
+
+func TestRestore_ChecksManifest(t *testing.T) {
+	const uri = "s3://bucket/checked/"
+	for name, damage := range map[string]func(f *fakeS3){
+		"missing last chunk": func(f *fakeS3) {
+			keys := f.keys("bucket")
+			delete(f.objects, "bucket/"+keys[len(keys)-1])
+		},
+		"wrong hash": func(f *fakeS3) {
+			chunks := chunkCount(3000, calculateChunkSize("checked/"))
+			f.objects["bucket/checked/"+manifestName] = []byte(fmt.Sprintf(`{"version":1,"size":3000,"chunks":%d,"sha256":"%s"}`, chunks, strings.Repeat("0", 64)))
+		},
+	} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(3000)), uri, false); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		damage(fake)
+		if err := v.RestoreWriter(context.Background(), uri, io.Discard); !errors.Is(err, ErrManifestMismatch) {
+			t.Errorf("%s: expected ErrManifestMismatch, got %v", name, err)
+		}
+	}
+}
+
+func TestImportManifest_ReattachesExport(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	ctx := context.Background()
+	data := randomBytes(4000)
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	if err := v.ExportManifest(ctx, "s3://bucket/f/", &exported); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(exported.Bytes(), []byte(`"filename": "input.bin"`)) {
+		t.Errorf("expected the export to be readable, got %s", exported.Bytes())
+	}
+
+	if err := v.ImportManifest(ctx, "s3://bucket/f/", bytes.NewReader(exported.Bytes()), false); !errors.Is(err, ErrPrefixExists) {
+		t.Errorf("expected ErrPrefixExists over an existing manifest, got %v", err)
+	}
+	delete(fake.objects, "bucket/f/"+manifestName)
+	if err := v.ImportManifest(ctx, "s3://bucket/f/", bytes.NewReader(exported.Bytes()), false); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(fake.objects["bucket/f/"+manifestName], []byte("input.bin")) {
+		t.Error("expected the imported manifest to be sealed again")
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/f/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the archive to restore, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestImportManifest_RejectsOtherArchives(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	v.maxChunkSize = 100
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1000)), "s3://bucket/big/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(300)), "s3://bucket/small/", false); err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	if err := v.ExportManifest(ctx, "s3://bucket/big/", &exported); err != nil {
+		t.Fatal(err)
+	}
+	err := v.ImportManifest(ctx, "s3://bucket/small/", &exported, true)
+	if !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("expected ErrManifestMismatch for another archive's manifest, got %v", err)
+	}
+	if err := v.ImportManifest(ctx, "s3://bucket/small/", bytes.NewReader([]byte(`{"version":7}`)), true); err == nil {
+		t.Error("expected an unknown manifest version to be refused")
+	}
+}
+
+func TestImportManifest_MarksPooledChunks(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.dedup, v.maxChunkSize = true, 1000
+	ctx := context.Background()
+	a, b := randomBytes(3000), randomBytes(2000)
+	for uri, data := range map[string][]byte{"s3://bucket/a/": a, "s3://bucket/b/": b} {
+		if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export := func(uri string) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := v.ExportManifest(ctx, uri, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	marks := func() int {
+		n := 0
+		for _, k := range fake.keys("bucket") {
+			if strings.HasPrefix(k, poolDir+"refs/") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Under a new path, the import marks the chunks, so they outlive a/.
+	if err := v.ImportManifest(ctx, "s3://bucket/c/", bytes.NewReader(export("s3://bucket/a/")), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ctx, "s3://bucket/a/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.GC(ctx, "s3://bucket/", GCOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/c/", &out); err != nil || !bytes.Equal(out.Bytes(), a) {
+		t.Fatalf("expected the imported archive to survive GC, got %d bytes, %v", out.Len(), err)
+	}
+
+	// Forced over c/, the import releases what c/ marked.
+	if err := v.ImportManifest(ctx, "s3://bucket/c/", bytes.NewReader(export("s3://bucket/b/")), true); err != nil {
+		t.Fatal(err)
+	}
+	if n := marks(); n != 4 {
+		t.Errorf("expected b's two marks for each of b/ and c/, got %d", n)
+	}
+
+	// A manifest listing chunks the pool lacks is refused and marks nothing.
+	missing := bytes.Replace(export("s3://bucket/b/"), []byte(`"hashes": [`), []byte(`"hashes": [
+    "`+strings.Repeat("0", 64)+`",`), 1)
+	missing = bytes.Replace(missing, []byte(`"chunks": 2`), []byte(`"chunks": 3`), 1)
+	if err := v.ImportManifest(ctx, "s3://bucket/d/", bytes.NewReader(missing), false); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("expected ErrManifestMismatch for a missing pool chunk, got %v", err)
+	} else if !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected the missing chunk named, got %v", err)
+	}
+	if n := marks(); n != 4 {
+		t.Errorf("expected the marks of the refused import released, got %d", n)
+	}
+}
+
+func TestManifest_RejectsBadChecksums(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(300)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	if err := v.ExportManifest(ctx, "s3://bucket/f/", &exported); err != nil {
+		t.Fatal(err)
+	}
+	m, err := v.Stat(ctx, "s3://bucket/f/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sum := range []string{"../../../../tmp/evil", strings.ToUpper(m.SHA256), m.SHA256[:63]} {
+		crafted := bytes.Replace(exported.Bytes(), []byte(m.SHA256), []byte(sum), 1)
+		if err := v.ImportManifest(ctx, "s3://bucket/f/", bytes.NewReader(crafted), true); err == nil {
+			t.Errorf("expected ImportManifest to refuse sha256 %q", sum)
+		}
+		fake.objects["bucket/f/"+manifestName] = crafted
+		if _, err := v.Stat(ctx, "s3://bucket/f/"); err == nil {
+			t.Errorf("expected a stored manifest with sha256 %q to be refused", sum)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package memback provides an in-memory vfs.Backend so applications that
+// embed vfs can test encode/restore round trips without credentials or
+// network access:
+//
+//	store := memback.New()
+//	v, err := vfs.New(vfs.WithBackend("mem", store.Open))
+//	v.Encode(ctx, "file.txt", "mem://bucket/path/", false)
+package memback
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// DefaultMaxKeyLength matches S3, so chunks have the same size as in S3.
+const DefaultMaxKeyLength = 1024
+
+const listPageSize = 1000
+
+// Store holds the objects of any number of buckets. It is safe for
+// concurrent use.
+type Store struct {
+	// MaxKeyLength limits the length of a full key. Zero means
+	// DefaultMaxKeyLength.
+	MaxKeyLength int
+
+	mu      sync.Mutex
+	buckets map[string]map[string]object
+}
+
+type object struct {
+	body     []byte
+	modified time.Time
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{buckets: make(map[string]map[string]object)}
+}
+
+// Open returns the backend for bucket. Its signature matches
+// vfs.BackendFactory.
+func (s *Store) Open(ctx context.Context, bucket string) (vfs.Backend, error) {
+	return &backend{store: s, bucket: bucket}, nil
+}
+
+// Keys returns the keys stored in bucket in lexical order.
+func (s *Store) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type backend struct {
+	store  *Store
+	bucket string
+}
+
+func (b *backend) Put(ctx context.Context, key string, body []byte) error {
+	if len(key) > b.maxKeyLength() {
+		return fmt.Errorf("key of %d bytes exceeds the %d byte limit", len(key), b.maxKeyLength())
+	}
+	s := b.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[b.bucket] == nil {
+		s.buckets[b.bucket] = make(map[string]object)
+	}
+	s.buckets[b.bucket][key] = object{body: append([]byte(nil), body...), modified: time.Now()}
+	return nil
+}
+
+func (b *backend) Get(ctx context.Context, key string) ([]byte, error) {
+	s := b.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[b.bucket][key]
+	if !ok {
+		return nil, fmt.Errorf("mem://%s/%s: %w", b.bucket, key, fs.ErrNotExist)
+	}
+	return append([]byte(nil), obj.body...), nil
+}
+
+func (b *backend) List(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	s := b.store
+	s.mu.Lock()
+	var objs []vfs.Object
+	for k, obj := range s.buckets[b.bucket] {
+		if strings.HasPrefix(k, prefix) {
+			objs = append(objs, vfs.Object{Key: k, Size: int64(len(obj.body)), LastModified: obj.modified})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].Key < objs[j].Key
+	})
+	for len(objs) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page := objs[:min(len(objs), listPageSize)]
+		objs = objs[len(page):]
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backend) Delete(ctx context.Context, keys []string) error {
+	s := b.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.buckets[b.bucket], k)
+	}
+	return nil
+}
+
+func (b *backend) KeyLimit(prefix string) int {
+	return b.maxKeyLength() - len(prefix)
+}
+
+func (b *backend) maxKeyLength() int {
+	if b.store.MaxKeyLength > 0 {
+		return b.store.MaxKeyLength
+	}
+	return DefaultMaxKeyLength
+}
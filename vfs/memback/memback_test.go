@@ -0,0 +1,61 @@
+package memback
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/vjeffz/vfs/vfs"
+)
+
+func TestRoundTrip(t *testing.T) {
+	store := New()
+	v, err := vfs.New(vfs.WithBackend("mem", store.Open))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := make([]byte, 10000)
+	rand.Read(data)
+
+	const uri = "mem://bucket/path/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(store.Keys("bucket")) == 0 {
+		t.Fatal("expected chunks in the store")
+	}
+	for _, k := range store.Keys("bucket") {
+		if len(k) > DefaultMaxKeyLength {
+			t.Errorf("key of %d bytes exceeds the limit", len(k))
+		}
+	}
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("restored data does not match")
+	}
+
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if keys := store.Keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected an empty bucket, got %d keys", len(keys))
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	store := New()
+	store.MaxKeyLength = 100
+	b, _ := store.Open(context.Background(), "bucket")
+	if err := b.Put(context.Background(), string(make([]byte, 101)), nil); err == nil {
+		t.Error("expected an error for a key over MaxKeyLength")
+	}
+	if got := b.KeyLimit("p/"); got != 98 {
+		t.Errorf("expected key limit 98, got %d", got)
+	}
+}
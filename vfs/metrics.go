@@ -0,0 +1,80 @@
+package vfs
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithMetrics registers Prometheus metrics with reg and keeps them up to
+// date. vfs_chunks_total and vfs_bytes_total count the chunks processed
+// and their decoded bytes, by op (the Op of the progress events).
+// vfs_backend_request_duration_seconds times the requests to the store,
+// by backend (the URI scheme) and method (put, get, list, delete, copy),
+// and vfs_backend_errors_total counts those that failed, other than for
+// missing keys. vfs_retries_total counts requests retried after
+// throttling or a timeout. New fails if the metrics are already registered
+// with reg, so give each VFS its own registry.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(o *options) {
+		o.metrics = reg
+	}
+}
+
+type metrics struct {
+	chunks   *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	retries  prometheus.Counter
+}
+
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		chunks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "vfs",
+			Name:      "chunks_total",
+			Help:      "Chunks processed, by operation.",
+		}, []string{"op"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "vfs",
+			Name:      "bytes_total",
+			Help:      "Decoded bytes of the chunks processed, by operation.",
+		}, []string{"op"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "vfs",
+			Name:      "backend_request_duration_seconds",
+			Help:      "Latency of requests to the object store.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"backend", "method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "vfs",
+			Name:      "backend_errors_total",
+			Help:      "Failed requests to the object store, not counting missing keys.",
+		}, []string{"backend", "method"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "vfs",
+			Name:      "retries_total",
+			Help:      "Requests retried after throttling or a timeout.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{m.chunks, m.bytes, m.duration, m.errors, m.retries} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// observe counts the chunks and bytes of a progress event.
+func (m *metrics) observe(e Event) {
+	if m == nil || e.Kind != EventChunk || e.Op == OpDelete {
+		return
+	}
+	m.chunks.WithLabelValues(string(e.Op)).Inc()
+	m.bytes.WithLabelValues(string(e.Op)).Add(float64(e.Bytes))
+}
+
+func (m *metrics) retried() {
+	if m != nil {
+		m.retries.Inc()
+	}
+}
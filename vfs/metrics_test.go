@@ -0,0 +1,82 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newMeteredTestVFS(t *testing.T, fake *fakeS3) (*VFS, *metrics) {
+	t.Helper()
+	m, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newTestVFS(fake)
+	v.metrics = m
+	return v, m
+}
+
+func TestMetrics_CountChunksAndRequests(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v, m := newMeteredTestVFS(t, fake)
+	data := randomBytes(5000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/f/", &out); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := testutil.ToFloat64(m.chunks.WithLabelValues("encode"))
+	if chunks < 2 || testutil.ToFloat64(m.chunks.WithLabelValues("restore")) != chunks {
+		t.Errorf("expected the same chunks encoded and restored, got %v and %v",
+			chunks, testutil.ToFloat64(m.chunks.WithLabelValues("restore")))
+	}
+	for _, op := range []string{"encode", "restore"} {
+		if got := testutil.ToFloat64(m.bytes.WithLabelValues(op)); got != float64(len(data)) {
+			t.Errorf("%s: expected %d bytes, got %v", op, len(data), got)
+		}
+	}
+	if n := testutil.CollectAndCount(m.duration); n < 3 {
+		t.Errorf("expected put, get and list requests to be timed, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(m.errors); n != 0 {
+		t.Errorf("expected no errors, got %d series", n)
+	}
+}
+
+func TestMetrics_CountRetries(t *testing.T) {
+	fake := newFakeS3()
+	fake.objects["bucket/x/1-AAAA"] = nil
+	v, m := newMeteredTestVFS(t, fake)
+	tb := &throttlingBackend{Backend: &s3Backend{client: fake, bucket: "bucket"}, failures: 2}
+	v.backends["slow"] = func(context.Context, string) (Backend, error) { return tb, nil }
+	v.retry = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	if err := v.Delete(context.Background(), "slow://bucket/x/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.retries); got != 2 {
+		t.Errorf("expected 2 retries, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.errors.WithLabelValues("slow", "delete")); got != 2 {
+		t.Errorf("expected 2 failed deletes, got %v", got)
+	}
+}
+
+func TestWithMetrics_RejectsRegisteringTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := newMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newMetrics(reg); err == nil {
+		t.Error("expected an error registering the metrics again")
+	}
+}
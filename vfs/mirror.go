@@ -0,0 +1,238 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const mirrorListPageSize = 1000
+
+// WithMirrors writes every archive to each of the given roots as well as to
+// its own URI: with the mirror "s3://backup-eu/", encoding to
+// s3://main/logs/ also stores s3://backup-eu/logs/. Reads merge the copies
+// that answer, so an object missing from one copy is taken from another.
+func WithMirrors(roots ...string) Option {
+	return func(o *options) {
+		o.mirrors = append(o.mirrors, roots...)
+	}
+}
+
+// WithReadRepair makes Restore re-upload objects that are missing from some
+// copies of a mirrored archive, taking them from a copy that has them.
+func WithReadRepair() Option {
+	return func(o *options) {
+		o.readRepair = true
+	}
+}
+
+// mirrorTarget is one copy of an archive: keys under the primary prefix are
+// stored under prefix in b.
+type mirrorTarget struct {
+	uri    string
+	b      Backend
+	prefix string
+}
+
+// mirrorBackend fans writes out to every target. Keys use the prefix of the
+// first target, the primary.
+type mirrorBackend struct {
+	targets []mirrorTarget
+}
+
+// openMirrors wraps the primary backend with the configured mirrors.
+func (v *VFS) openMirrors(ctx context.Context, uri string, primary Backend, prefix string) (Backend, error) {
+	targets := []mirrorTarget{{uri: uri, b: primary, prefix: prefix}}
+	for _, root := range v.mirrors {
+		b, base, err := v.openURI(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		loc := strings.TrimSuffix(root, "/") + "/" + prefix
+		targets = append(targets, mirrorTarget{uri: loc, b: b, prefix: base + prefix})
+	}
+	return &mirrorBackend{targets: targets}, nil
+}
+
+// key translates a primary key to target t.
+func (m *mirrorBackend) key(t mirrorTarget, key string) string {
+	return t.prefix + strings.TrimPrefix(key, m.targets[0].prefix)
+}
+
+func (m *mirrorBackend) Put(ctx context.Context, key string, body []byte) error {
+	for _, t := range m.targets {
+		if err := t.b.Put(ctx, m.key(t, key), body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mirrorBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	var errs []error
+	for _, t := range m.targets {
+		body, err := t.b.Get(ctx, m.key(t, key))
+		if err == nil {
+			return body, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// List merges the listings of every target that answers, with keys
+// translated back to the primary prefix. It fails only if no target can be
+// listed.
+func (m *mirrorBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	listings, err := m.listAll(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	merged := make(map[string]Object)
+	for _, objs := range listings {
+		for _, o := range objs {
+			if _, ok := merged[o.Key]; !ok {
+				merged[o.Key] = o
+			}
+		}
+	}
+	all := make([]Object, 0, len(merged))
+	for _, o := range merged {
+		all = append(all, o)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Key < all[j].Key
+	})
+	for len(all) > 0 {
+		page := all[:min(len(all), mirrorListPageSize)]
+		all = all[len(page):]
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listAll lists prefix in every target. The listing of a target that
+// failed is nil.
+func (m *mirrorBackend) listAll(ctx context.Context, prefix string) ([][]Object, error) {
+	listings := make([][]Object, len(m.targets))
+	var errs []error
+	for i, t := range m.targets {
+		objs := []Object{}
+		err := t.b.List(ctx, m.key(t, prefix), func(page []Object) error {
+			for _, o := range page {
+				o.Key = m.targets[0].prefix + strings.TrimPrefix(o.Key, t.prefix)
+				objs = append(objs, o)
+			}
+			return nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", t.uri, err))
+			continue
+		}
+		listings[i] = objs
+	}
+	if len(errs) == len(m.targets) {
+		return nil, errors.Join(errs...)
+	}
+	return listings, nil
+}
+
+// Delete removes keys from every target, even if some of them fail.
+func (m *mirrorBackend) Delete(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, t := range m.targets {
+		translated := make([]string, len(keys))
+		for i, k := range keys {
+			translated[i] = m.key(t, k)
+		}
+		if err := t.b.Delete(ctx, translated); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// KeyLimit is the tightest limit of all targets, so every chunk fits
+// everywhere.
+func (m *mirrorBackend) KeyLimit(prefix string) int {
+	limit := -1
+	for _, t := range m.targets {
+		if l := t.b.KeyLimit(m.key(t, prefix)); limit < 0 || l < limit {
+			limit = l
+		}
+	}
+	return limit
+}
+
+// checkMirrors warns about objects of the archive at prefix that some
+// copies are missing, and with read-repair copies them over from a copy
+// that has them. Copies that cannot be listed are skipped.
+func (v *VFS) checkMirrors(ctx context.Context, b Backend, prefix string) error {
+	_, err := v.repairMirrors(ctx, b, prefix, OpRestore, v.readRepair)
+	return err
+}
+
+// repairMirrors warns about objects under prefix that some copies are
+// missing and, if repair is set, copies them over from a copy that has
+// them, returning how many it copied.
+func (v *VFS) repairMirrors(ctx context.Context, b Backend, prefix string, op Op, repair bool) (int, error) {
+	m, ok := b.(*mirrorBackend)
+	if !ok {
+		return 0, nil
+	}
+	listings, err := m.listAll(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	union := make(map[string]Object)
+	for _, objs := range listings {
+		for _, o := range objs {
+			union[o.Key] = o
+		}
+	}
+	copied := 0
+	for i, t := range m.targets {
+		if listings[i] == nil {
+			v.warn(op, fmt.Sprintf("⚠️  %s is unavailable, reading from the other copies.", t.uri))
+			continue
+		}
+		have := make(map[string]bool, len(listings[i]))
+		for _, o := range listings[i] {
+			have[o.Key] = true
+		}
+		var missing []Object
+		for key, o := range union {
+			if !have[key] {
+				missing = append(missing, o)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if !repair {
+			v.warn(op, fmt.Sprintf("⚠️  %s is missing %d object(s), reading them from another copy.", t.uri, len(missing)))
+			continue
+		}
+		for _, o := range missing {
+			var body []byte
+			if o.Size > 0 {
+				if body, err = m.Get(ctx, o.Key); err != nil {
+					return copied, fmt.Errorf("read-repair of %s: %w", t.uri, err)
+				}
+			}
+			if err := t.b.Put(ctx, m.key(t, o.Key), body); err != nil {
+				return copied, fmt.Errorf("read-repair of %s: %w", t.uri, err)
+			}
+			copied++
+		}
+		v.warn(op, fmt.Sprintf("⚠️  Repaired %d missing object(s) in %s.", len(missing), t.uri))
+	}
+	return copied, nil
+}
@@ -0,0 +1,116 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// flakyBackend fails listings while down is set.
+type flakyBackend struct {
+	Backend
+	down bool
+}
+
+func (f *flakyBackend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	if f.down {
+		return errors.New("service unavailable")
+	}
+	return f.Backend.List(ctx, prefix, fn)
+}
+
+func TestMirrors_WriteEverywhereReadFirstHealthy(t *testing.T) {
+	fake := newFakeS3()
+	primary := &flakyBackend{Backend: &s3Backend{client: fake, bucket: "main"}}
+	v := newTestVFS(fake)
+	v.backends["flaky"] = func(ctx context.Context, bucket string) (Backend, error) {
+		return primary, nil
+	}
+	v.mirrors = []string{"s3://backup/eu/"}
+
+	data := randomBytes(4000)
+	const uri = "flaky://main/logs/"
+	if err := v.Encode(context.Background(), writeTempFile(t, data), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	mainKeys, backupKeys := fake.keys("main"), fake.keys("backup")
+	if len(mainKeys) == 0 || len(mainKeys) != len(backupKeys) {
+		t.Fatalf("expected the same chunks in both buckets, got %d and %d", len(mainKeys), len(backupKeys))
+	}
+	for _, k := range backupKeys {
+		if !strings.HasPrefix(k, "eu/logs/") {
+			t.Errorf("mirror key %q is not under eu/logs/", k)
+		}
+	}
+
+	primary.down = true
+	var out bytes.Buffer
+	if err := v.RestoreWriter(context.Background(), uri, &out); err != nil {
+		t.Fatalf("restore with the primary down failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("restored data does not match")
+	}
+
+	primary.down = false
+	if err := v.Delete(context.Background(), uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if n := len(fake.keys("main")) + len(fake.keys("backup")); n != 0 {
+		t.Errorf("expected both copies to be deleted, %d keys left", n)
+	}
+}
+
+func TestMirrors_KeyLimitIsTightest(t *testing.T) {
+	m := &mirrorBackend{targets: []mirrorTarget{
+		{b: &s3Backend{}, prefix: "a/"},
+		{b: &s3Backend{}, prefix: "longer/base/a/"},
+	}}
+	if got, want := m.KeyLimit("a/data/"), s3MaxKeyLengthBytes-len("longer/base/a/data/"); got != want {
+		t.Errorf("expected key limit %d, got %d", want, got)
+	}
+}
+
+func TestMirrors_FallbackAndReadRepair(t *testing.T) {
+	for _, repair := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.mirrors = []string{"s3://backup/"}
+		v.readRepair = repair
+		var events []Event
+		v.progress = func(e Event) { events = append(events, e) }
+
+		data := randomBytes(4000)
+		const uri = "s3://main/logs/"
+		if err := v.Encode(context.Background(), writeTempFile(t, data), uri, true); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		mainKeys, backupKeys := fake.keys("main"), fake.keys("backup")
+		delete(fake.objects, "main/"+mainKeys[1])
+		delete(fake.objects, "backup/"+backupKeys[3])
+
+		var out bytes.Buffer
+		if err := v.RestoreWriter(context.Background(), uri, &out); err != nil {
+			t.Fatalf("restore failed: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("repair=%v: restored data does not match", repair)
+		}
+
+		var warnings int
+		for _, e := range events {
+			if e.Kind == EventWarning {
+				warnings++
+			}
+		}
+		if warnings != 2 {
+			t.Errorf("repair=%v: expected a warning per damaged copy, got %d", repair, warnings)
+		}
+		repaired := len(fake.keys("main")) == len(mainKeys) && len(fake.keys("backup")) == len(backupKeys)
+		if repaired != repair {
+			t.Errorf("repair=%v: copies repaired = %v", repair, repaired)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package vfs
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Option func(*options)
+
+type options struct {
+	concurrency     int
+	region          string
+	profile         string
+	endpoint        string
+	pathStyle       bool
+	client          *s3.Client
+	userAgentSuffix string
+	headers         [][2]string
+	splitLayout     bool
+	resume          bool
+	retry           *RetryPolicy
+	rateLimit       float64
+	bestEffort      bool
+	allowGaps       bool
+	connPool        ConnPool
+	progress        ProgressFunc
+	backends        map[string]BackendFactory
+	mirrors         []string
+	readRepair      bool
+	keys            keyWrapper
+	compression     Compression
+	obfuscate       bool
+	storageClass    string
+	tags            map[string]string
+	kmsKeyID        string
+	bodyThreshold   int64
+	autoMode        bool
+	maxChunkSize    int
+	versioning      bool
+	delta           bool
+	dedup           bool
+	parityData      int
+	parityShards    int
+	dryRun          func(PlannedWrite)
+	metrics         prometheus.Registerer
+	tracerProvider  trace.TracerProvider
+	logger          *slog.Logger
+	auditLog        string
+	noPreserve      bool
+	preserveOwner   bool
+	asOf            time.Time
+	lock            lockPolicy
+	expiry          time.Duration
+}
+
+// WithConcurrency sets how many chunks are transferred in parallel,
+// overriding S3_CONCURRENCY.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithRegion sets the AWS region instead of resolving it from the
+// environment and shared config.
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithProfile selects a named profile from the shared AWS config files.
+func WithProfile(profile string) Option {
+	return func(o *options) {
+		o.profile = profile
+	}
+}
+
+// WithEndpoint sends S3 requests to a custom endpoint URL.
+func WithEndpoint(url string) Option {
+	return func(o *options) {
+		o.endpoint = url
+	}
+}
+
+// WithPathStyle addresses buckets as https://endpoint/bucket/key instead
+// of https://bucket.endpoint/key, as most S3-compatible servers (MinIO, Ceph
+// RGW) expect. Setting S3_FORCE_PATH_STYLE=true has the same effect.
+func WithPathStyle() Option {
+	return func(o *options) {
+		o.pathStyle = true
+	}
+}
+
+// WithS3Client uses an existing client instead of building one from the
+// AWS config. Region, profile, endpoint, path-style, User-Agent, header and
+// connection pool options do not apply to it.
+func WithS3Client(client *s3.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithUserAgentSuffix appends s to the User-Agent of every S3 request, after
+// the default "vfs/<version>" entry, so traffic can be attributed in S3
+// access logs and CloudTrail.
+func WithUserAgentSuffix(s string) Option {
+	return func(o *options) {
+		o.userAgentSuffix = s
+	}
+}
+
+// WithRequestHeader sets a fixed header on every S3 request.
+func WithRequestHeader(key, value string) Option {
+	return func(o *options) {
+		o.headers = append(o.headers, [2]string{key, value})
+	}
+}
+
+// WithBestEffort makes Restore write every chunk it can decode, skipping
+// the ones it cannot, and report the skipped indices as a *DecodeError
+// instead of aborting without output.
+func WithBestEffort() Option {
+	return func(o *options) {
+		o.bestEffort = true
+	}
+}
+
+// WithAllowGaps makes Restore write archives whose chunk indices have gaps
+// or duplicates, keeping the first chunk of each index, with a warning
+// instead of failing with ErrChunkGap.
+func WithAllowGaps() Option {
+	return func(o *options) {
+		o.allowGaps = true
+	}
+}
+
+// WithChunkSize caps key-only chunks of new archives at n bytes, below
+// what the key length limit allows, for proxies and S3-compatible stores
+// that reject keys near 1024 bytes. Appends keep the chunk size recorded
+// in the archive's manifest. n <= 0 means no cap.
+func WithChunkSize(n int) Option {
+	return func(o *options) {
+		o.maxChunkSize = n
+	}
+}
+
+// WithStorageClass stores new S3 objects in class, for example
+// STANDARD_IA or GLACIER_IR. Chunk data lives in the listing, so chunks can
+// even go to GLACIER or DEEP_ARCHIVE and still restore at once; manifests
+// and markers then stay in STANDARD so they remain readable.
+func WithStorageClass(class string) Option {
+	return func(o *options) {
+		o.storageClass = class
+	}
+}
+
+// WithObjectTags attaches tags to every new S3 object, chunks and
+// manifest alike, for cost allocation and lifecycle rules. Repeated calls
+// add to the set.
+func WithObjectTags(tags map[string]string) Option {
+	return func(o *options) {
+		if o.tags == nil {
+			o.tags = make(map[string]string)
+		}
+		for k, v := range tags {
+			o.tags[k] = v
+		}
+	}
+}
+
+func (o *options) apiOptions() []func(*middleware.Stack) error {
+	fns := []func(*middleware.Stack) error{
+		awsmiddleware.AddUserAgentKeyValue("vfs", Version().Version),
+	}
+	if o.userAgentSuffix != "" {
+		fns = append(fns, awsmiddleware.AddUserAgentKey(o.userAgentSuffix))
+	}
+	for _, h := range o.headers {
+		fns = append(fns, smithyhttp.SetHeaderValue(h[0], h[1]))
+	}
+	return fns
+}
+
+func (o *options) newS3Client(concurrency int) (*s3.Client, error) {
+	if o.client != nil {
+		return o.client, nil
+	}
+	cfg, err := o.loadAWSConfig(
+		config.WithAPIOptions(o.apiOptions()),
+		config.WithHTTPClient(newHTTPClient(o.connPool.withDefaults(concurrency))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(so *s3.Options) {
+		if o.endpoint != "" {
+			so.BaseEndpoint = &o.endpoint
+		}
+		if o.pathStyle || forcePathStyle() {
+			so.UsePathStyle = true
+		}
+	}), nil
+}
+
+// loadAWSConfig loads the shared AWS config for the configured region and
+// profile.
+func (o *options) loadAWSConfig(loadOpts ...func(*config.LoadOptions) error) (aws.Config, error) {
+	if o.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(o.region))
+	}
+	if o.profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(o.profile))
+	}
+	return config.LoadDefaultConfig(context.TODO(), loadOpts...)
+}
+
+func forcePathStyle() bool {
+	force, _ := strconv.ParseBool(os.Getenv("S3_FORCE_PATH_STYLE"))
+	return force
+}
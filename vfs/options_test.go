@@ -0,0 +1,183 @@
+package vfs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestAPIOptions_UserAgentAndHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></ListBucketResult>`))
+	}))
+	defer srv.Close()
+
+	var o options
+	WithUserAgentSuffix("nightly-backup")(&o)
+	WithRequestHeader("X-Vfs-Team", "storage")(&o)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		APIOptions:   o.apiOptions(),
+	})
+	if _, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("bucket")}); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	ua := got.Get("User-Agent")
+	if !strings.Contains(ua, "vfs/"+version) {
+		t.Errorf("expected User-Agent to contain vfs/%s, got %q", version, ua)
+	}
+	if !strings.Contains(ua, "nightly-backup") {
+		t.Errorf("expected User-Agent to contain suffix, got %q", ua)
+	}
+	if h := got.Get("X-Vfs-Team"); h != "storage" {
+		t.Errorf("expected X-Vfs-Team header 'storage', got %q", h)
+	}
+}
+
+func TestNew_WithS3ClientAndConcurrency(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-east-1"})
+	v, err := New(WithS3Client(client), WithConcurrency(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.client != client {
+		t.Error("expected the provided client to be used")
+	}
+	if v.concurrency != 3 || v.EffectiveConcurrency() != 3 {
+		t.Errorf("expected concurrency 3, got %d (effective %d)", v.concurrency, v.EffectiveConcurrency())
+	}
+}
+
+func TestNew_ConcurrencyFallsBackToEnv(t *testing.T) {
+	t.Setenv("S3_CONCURRENCY", "5")
+	v, err := New(WithS3Client(s3.New(s3.Options{})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.concurrency != 5 {
+		t.Errorf("expected concurrency from S3_CONCURRENCY, got %d", v.concurrency)
+	}
+}
+
+func TestNew_WithEndpointRegionAndProfile(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></ListBucketResult>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	credsFile := filepath.Join(dir, "credentials")
+	os.WriteFile(credsFile, []byte("[vfs-test]\naws_access_key_id = AKID\naws_secret_access_key = SECRET\n"), 0600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credsFile)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	v, err := New(WithEndpoint(srv.URL), WithRegion("eu-west-1"), WithProfile("vfs-test"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := v.client.(*s3.Client)
+	if got := client.Options().Region; got != "eu-west-1" {
+		t.Errorf("expected region eu-west-1, got %s", got)
+	}
+	if _, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("bucket")},
+		func(o *s3.Options) { o.UsePathStyle = true }); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if hits != 1 {
+		t.Errorf("expected request to reach the custom endpoint, got %d hits", hits)
+	}
+}
+
+func TestNew_PathStyle(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		env  string
+	}{
+		{"option", []Option{WithPathStyle()}, ""},
+		{"env", nil, "true"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.Header().Set("Content-Type", "application/xml")
+				w.Write([]byte(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></ListBucketResult>`))
+			}))
+			defer srv.Close()
+			t.Setenv("S3_FORCE_PATH_STYLE", tc.env)
+			t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+
+			v, err := New(append(tc.opts, WithEndpoint(srv.URL), WithRegion("us-east-1"))...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := v.Delete(context.Background(), "s3://minio-bucket/prefix/"); err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if path != "/minio-bucket" {
+				t.Errorf("expected a path-style request to /minio-bucket, got %q", path)
+			}
+		})
+	}
+}
+
+func TestNew_UnknownProfile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	if _, err := New(WithProfile("does-not-exist")); err == nil {
+		t.Error("expected error for an unknown profile")
+	}
+}
+
+func TestStorageClassAndTags(t *testing.T) {
+	fake := newFakeS3()
+	var o options
+	WithStorageClass("DEEP_ARCHIVE")(&o)
+	WithObjectTags(map[string]string{"team": "data"})(&o)
+	WithObjectTags(map[string]string{"cost center": "42"})(&o)
+	v := newTestVFS(fake)
+	v.storageClass, v.tagging = o.storageClass, encodeTags(o.tags)
+
+	classes := make(map[string]string)
+	fake.onPut = func(in *s3.PutObjectInput) {
+		classes[*in.Key] = string(in.StorageClass)
+		if in.Tagging == nil || *in.Tagging != "cost+center=42&team=data" {
+			t.Errorf("unexpected tagging on %s: %v", *in.Key, in.Tagging)
+		}
+	}
+	if err := v.EncodeReader(context.Background(), strings.NewReader(strings.Repeat("x", 2000)), "s3://bucket/cold/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	for key, class := range classes {
+		want := "DEEP_ARCHIVE"
+		if strings.HasSuffix(key, manifestName) || strings.HasSuffix(key, stagingName) {
+			want = "" // must stay readable
+		}
+		if class != want {
+			t.Errorf("expected %s in class %q, got %q", key, want, class)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package vfs
+
+import "io/fs"
+
+// fileOwner reports that files have no numeric owner here.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
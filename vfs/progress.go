@@ -0,0 +1,77 @@
+package vfs
+
+type Op string
+
+const (
+	OpEncode  Op = "encode"
+	OpAppend  Op = "append"
+	OpRestore Op = "restore"
+	OpDelete  Op = "delete"
+	OpCopy    Op = "copy"
+	OpRepair  Op = "repair"
+)
+
+type EventKind int
+
+const (
+	// EventStart is sent once the amount of work is known.
+	EventStart EventKind = iota
+	// EventChunk is sent after each chunk (or, for delete, each batch of
+	// objects) completes.
+	EventChunk
+	// EventDone is sent when all chunks have completed.
+	EventDone
+	// EventWarning carries an advisory Message; the operation continues.
+	EventWarning
+)
+
+// Event reports the progress of an operation. Index and Bytes describe the
+// chunk that just completed; Done/Total count chunks and
+// BytesDone/BytesTotal count decoded bytes. Totals are zero when unknown.
+type Event struct {
+	Op         Op
+	Kind       EventKind
+	Index      int
+	Bytes      int64
+	Done       int
+	Total      int
+	BytesDone  int64
+	BytesTotal int64
+	Message    string
+}
+
+// ProgressFunc receives progress events. Calls are serialized, so it does
+// not need to be safe for concurrent use, but it should return quickly.
+type ProgressFunc func(Event)
+
+// WithProgress registers fn to receive progress events for every operation.
+func WithProgress(fn ProgressFunc) Option {
+	return func(o *options) {
+		o.progress = fn
+	}
+}
+
+// ProgressTo returns a VFS that shares everything with v, including its
+// stores and limits, but sends the progress events of the operations run
+// on it to fn instead, so a server can report each request to its own
+// client. fn is called under the same lock as v's ProgressFunc, so it must
+// not block.
+func (v *VFS) ProgressTo(fn ProgressFunc) *VFS {
+	c := *v
+	c.progress = fn
+	return &c
+}
+
+func (v *VFS) emit(e Event) {
+	v.metrics.observe(e)
+	if v.progress == nil {
+		return
+	}
+	v.progressMu.Lock()
+	defer v.progressMu.Unlock()
+	v.progress(e)
+}
+
+func (v *VFS) warn(op Op, msg string) {
+	v.emit(Event{Op: op, Kind: EventWarning, Message: msg})
+}
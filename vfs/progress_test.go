@@ -0,0 +1,141 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func collectEvents(v *VFS) *[]Event {
+	var events []Event
+	v.progress = func(e Event) { events = append(events, e) }
+	return &events
+}
+
+func checkTransferEvents(t *testing.T, events []Event, op Op, chunks int, size int64) {
+	t.Helper()
+	var start, done *Event
+	var chunkEvents int
+	var bytes int64
+	seen := make(map[int]bool)
+	for i := range events {
+		e := &events[i]
+		if e.Op != op {
+			t.Fatalf("unexpected op %q in %q events", e.Op, op)
+		}
+		switch e.Kind {
+		case EventStart:
+			start = e
+		case EventChunk:
+			chunkEvents++
+			bytes += e.Bytes
+			if e.Done != chunkEvents || e.BytesDone != bytes {
+				t.Errorf("expected running totals %d/%d, got %d/%d", chunkEvents, bytes, e.Done, e.BytesDone)
+			}
+			if seen[e.Index] {
+				t.Errorf("chunk %d reported twice", e.Index)
+			}
+			seen[e.Index] = true
+		case EventDone:
+			done = e
+		}
+	}
+	if start == nil || start.Total != chunks || start.BytesTotal != size {
+		t.Errorf("expected start event with %d chunks and %d bytes, got %+v", chunks, size, start)
+	}
+	if chunkEvents != chunks || bytes != size {
+		t.Errorf("expected %d chunk events covering %d bytes, got %d covering %d", chunks, size, chunkEvents, bytes)
+	}
+	if done == nil || done.Done != chunks || done.BytesDone != size {
+		t.Errorf("expected done event with %d chunks, got %+v", chunks, done)
+	}
+}
+
+func TestProgress_EncodeRestoreDelete(t *testing.T) {
+	const uri = "s3://bucket/progress/"
+	chunkSize := calculateChunkSize("progress/")
+	data := randomBytes(4*chunkSize + 9)
+	ctx := context.Background()
+	v := newTestVFS(newFakeS3())
+
+	events := collectEvents(v)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	checkTransferEvents(t, *events, OpEncode, 5, int64(len(data)))
+
+	events = collectEvents(v)
+	if err := v.RestoreWriter(ctx, uri, io.Discard); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	checkTransferEvents(t, *events, OpRestore, 5, int64(len(data)))
+
+	events = collectEvents(v)
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	last := (*events)[len(*events)-1]
+	if last.Op != OpDelete || last.Kind != EventDone || last.Done != 6 {
+		t.Errorf("expected delete done event for 5 chunks and the manifest, got %+v", last)
+	}
+}
+
+func TestProgress_LongPrefixWarning(t *testing.T) {
+	prefix := string(bytes.Repeat([]byte("p"), 950))
+	v := newTestVFS(newFakeS3())
+	events := collectEvents(v)
+	if err := v.Encode(context.Background(), writeTempFile(t, randomBytes(100)), "s3://bucket/"+prefix, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if len(*events) == 0 || (*events)[0].Kind != EventWarning || (*events)[0].Message == "" {
+		t.Errorf("expected a storage-mode warning first, got %+v", *events)
+	}
+}
+
+func TestProgress_AutoModeExplainsSwitch(t *testing.T) {
+	prefix := string(bytes.Repeat([]byte("p"), 950))
+	v := newTestVFS(newFakeS3())
+	v.autoMode = true
+	events := collectEvents(v)
+	if err := v.Encode(context.Background(), writeTempFile(t, randomBytes(10000)), "s3://bucket/"+prefix, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	const want = "object bodies (--auto-mode): 1 object(s) instead of "
+	if len(*events) == 0 || (*events)[0].Kind != EventWarning || !strings.Contains((*events)[0].Message, want) {
+		t.Errorf("expected a warning with %q first, got %+v", want, *events)
+	}
+}
+
+func TestLibraryDoesNotPrint(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	const uri = "s3://bucket/quiet/"
+	ctx := context.Background()
+	v := newTestVFS(newFakeS3())
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(3000)), uri, true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Restore(ctx, uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	w.Close()
+	printed, _ := io.ReadAll(r)
+	if len(printed) != 0 {
+		t.Errorf("expected no output on stdout, got %q", printed)
+	}
+}
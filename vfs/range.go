@@ -0,0 +1,96 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidRange is returned by RestoreRange for a range that does not
+// start within the file.
+var ErrInvalidRange = errors.New("invalid byte range")
+
+// RestoreRange writes the n bytes of the file stored at uri starting at
+// offset off to w, fetching and decoding only the chunks that hold them. A
+// range that runs past the end of the file stops there. The chunk size
+// comes from the manifest, so archives written before manifests need
+// Migrate first, and compressed archives cannot be read in part, since
+// their chunks hold one compressed stream. Unlike Restore, the bytes
+// written are only checked against the checksums of their chunks.
+func (v *VFS) RestoreRange(ctx context.Context, uri string, w io.Writer, off, n int64) (err error) {
+	ctx, end := v.startOp(ctx, OpRestore, "RestoreRange", uri)
+	defer end(&err)
+	chunks, m, err := v.openArchive(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("%w at %s (run migrate to add one)", ErrNoManifest, uri)
+	}
+	if m.compression() != CompressionNone {
+		return fmt.Errorf("cannot restore part of %s: it is compressed", uri)
+	}
+	if off < 0 || n < 0 || off >= m.Size && n > 0 {
+		return fmt.Errorf("%w: offset %d of %s, which holds %d bytes", ErrInvalidRange, off, uri, m.Size)
+	}
+	n = min(n, m.Size-off)
+	if n == 0 {
+		return nil
+	}
+
+	first, last := rangeChunks(off, n, m.ChunkSize)
+	var picked []storedChunk
+	var missing []int
+	next := first
+	for _, c := range chunks {
+		if c.index < next || c.index > last {
+			continue
+		}
+		for ; next < c.index; next++ {
+			missing = append(missing, next)
+		}
+		picked = append(picked, c)
+		next++
+	}
+	for ; next <= last; next++ {
+		missing = append(missing, next)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s has missing chunks %s", ErrChunkGap, uri, formatIndices(missing))
+	}
+	v.debug(ctx, "restoring range", "offset", off, "bytes", n, "first_chunk", first, "last_chunk", last)
+
+	skip := off - int64(first-1)*int64(m.ChunkSize)
+	return v.writeChunks(ctx, picked, &sectionWriter{w: w, skip: skip, left: n}, nil)
+}
+
+// rangeChunks returns the indices of the first and last chunks of
+// chunkSize bytes that hold the n bytes at offset off.
+func rangeChunks(off, n int64, chunkSize int) (first, last int) {
+	size := int64(chunkSize)
+	return int(off/size) + 1, int((off+n-1)/size) + 1
+}
+
+// sectionWriter passes on what is written to it after dropping the first
+// skip bytes, until left bytes have been passed on.
+type sectionWriter struct {
+	w          io.Writer
+	skip, left int64
+}
+
+func (s *sectionWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	drop := min(s.skip, int64(len(p)))
+	p, s.skip = p[drop:], s.skip-drop
+	p = p[:min(s.left, int64(len(p)))]
+	if len(p) == 0 {
+		return written, nil
+	}
+	n, err := s.w.Write(p)
+	s.left -= int64(n)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return written, err
+}
@@ -0,0 +1,91 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRestoreRange_WritesBytesAcrossChunks(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	data := randomBytes(1050)
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		off, n int64
+		want   []byte
+	}{
+		{0, 100, data[:100]},
+		{250, 200, data[250:450]},
+		{99, 2, data[99:101]},
+		{1000, 1000, data[1000:]},
+		{1049, 1, data[1049:]},
+		{500, 0, nil},
+	} {
+		var buf bytes.Buffer
+		if err := v.RestoreRange(ctx, "s3://bucket/f/", &buf, tc.off, tc.n); err != nil {
+			t.Fatalf("%d bytes at %d: %v", tc.n, tc.off, err)
+		}
+		if !bytes.Equal(buf.Bytes(), tc.want) {
+			t.Errorf("%d bytes at %d: got %d bytes that differ from the file", tc.n, tc.off, buf.Len())
+		}
+	}
+}
+
+func TestRestoreRange_FetchesOnlyCoveringChunks(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v, cb := newCountingTestVFS(fake)
+	v.dedup, v.maxChunkSize = true, 100
+	data := randomBytes(2000)
+	if err := v.Encode(ctx, writeTempFile(t, data), "count://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	cb.got = RequestEstimate{}
+	if err := v.RestoreWriter(ctx, "count://bucket/f/", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	whole := cb.got.Gets
+	cb.got = RequestEstimate{}
+	var buf bytes.Buffer
+	if err := v.RestoreRange(ctx, "count://bucket/f/", &buf, 250, 200); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data[250:450]) {
+		t.Error("restored range differs from the file")
+	}
+	// Chunks 3 to 5 hold the range.
+	if cb.got.Gets != whole-17 {
+		t.Errorf("expected 3 of 20 chunks to be fetched, got %d GETs against %d for the whole file", cb.got.Gets, whole)
+	}
+}
+
+func TestRestoreRange_Rejects(t *testing.T) {
+	fake := newFakeS3()
+	ctx := context.Background()
+	v := newTestVFS(fake)
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(500)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RestoreRange(ctx, "s3://bucket/f/", &bytes.Buffer{}, 500, 1); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("expected ErrInvalidRange past the end, got %v", err)
+	}
+	if err := v.RestoreRange(ctx, "s3://bucket/f/", &bytes.Buffer{}, -1, 1); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("expected ErrInvalidRange for a negative offset, got %v", err)
+	}
+
+	v.compression = CompressionGzip
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(500)), "s3://bucket/gz/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RestoreRange(ctx, "s3://bucket/gz/", &bytes.Buffer{}, 0, 10); err == nil {
+		t.Error("expected a compressed archive to be refused")
+	}
+}
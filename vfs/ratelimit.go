@@ -0,0 +1,22 @@
+package vfs
+
+import (
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit caps uploads and deletes at requestsPerSecond requests per
+// second (retries included), for batch jobs that share a bucket or prefix
+// with production traffic. The adaptive concurrency limit still applies
+// below the cap.
+func WithRateLimit(requestsPerSecond float64) Option {
+	return func(o *options) {
+		o.rateLimit = requestsPerSecond
+	}
+}
+
+func newRateLimiter(requestsPerSecond float64) *rate.Limiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(requestsPerSecond), 1)
+}
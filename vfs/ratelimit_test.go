@@ -0,0 +1,44 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_CapsRequestRate(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.rate = newRateLimiter(200)
+
+	// 20 chunks at 200 requests/s take at least ~95ms.
+	data := randomBytes(20 * calculateChunkSize("rate/"))
+	start := time.Now()
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(data), "s3://bucket/rate/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the rate limit to slow the encode down, took %v", elapsed)
+	}
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Error("expected no limiter for a zero rate")
+	}
+}
+
+func TestRateLimit_CanceledWhileWaiting(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	v.rate = newRateLimiter(0.001)
+	v.rate.Allow() // use up the burst
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(100)), "s3://bucket/slow/", false); err == nil {
+		t.Fatal("expected the encode to give up with its context")
+	}
+	if got := v.limiter.inFlight; got != 0 {
+		t.Errorf("expected every limiter slot back, %d still held", got)
+	}
+}
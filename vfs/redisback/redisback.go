@@ -0,0 +1,136 @@
+// Package redisback stores vfs archives in Redis, for short-lived secrets or
+// artifacts passed between jobs. Each chunk is a Redis key carrying the
+// encoded data in its name, optionally expiring after a TTL.
+//
+//	v, err := vfs.New(vfs.WithBackend("redis", redisback.Open(redisback.Options{TTL: time.Hour})))
+//	v.Encode(ctx, "build.tar", "redis://localhost:6379/job-42/", false)
+package redisback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// maxKeyBytes keeps chunks the size of S3 keys. Redis itself allows far
+// longer keys, but every key is held in memory.
+const (
+	maxKeyBytes  = 1024
+	listPageSize = 1000
+	scanCount    = 1000
+)
+
+// Options configures the clients created by Open.
+type Options struct {
+	// TTL expires every key written, if positive.
+	TTL time.Duration
+	// Password authenticates to the server. It defaults to
+	// REDIS_PASSWORD.
+	Password string
+	// DB selects the logical database.
+	DB int
+}
+
+// Open returns a factory for redis://host:port/prefix/ URIs. One client is
+// kept per server address.
+func Open(opts Options) vfs.BackendFactory {
+	if opts.Password == "" {
+		opts.Password = os.Getenv("REDIS_PASSWORD")
+	}
+	var mu sync.Mutex
+	clients := make(map[string]*redis.Client)
+	return func(ctx context.Context, addr string) (vfs.Backend, error) {
+		if addr == "" {
+			return nil, fmt.Errorf("redis URI is missing a host")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		client, ok := clients[addr]
+		if !ok {
+			client = redis.NewClient(&redis.Options{Addr: addr, Password: opts.Password, DB: opts.DB})
+			clients[addr] = client
+		}
+		return New(client, opts.TTL), nil
+	}
+}
+
+// New returns a backend that uses an existing client. Keys expire after
+// ttl if it is positive.
+func New(client redis.UniversalClient, ttl time.Duration) vfs.Backend {
+	return &backend{client: client, ttl: ttl}
+}
+
+type backend struct {
+	client redis.UniversalClient
+	ttl    time.Duration
+}
+
+func (b *backend) Put(ctx context.Context, key string, body []byte) error {
+	return b.client.Set(ctx, key, body, b.ttl).Err()
+}
+
+func (b *backend) Get(ctx context.Context, key string) ([]byte, error) {
+	body, err := b.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("redis key %s: %w", key, fs.ErrNotExist)
+	}
+	return body, err
+}
+
+// List scans for keys under prefix. SCAN returns keys in no particular
+// order, so all of them are collected and sorted first.
+func (b *backend) List(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	var keys []string
+	iter := b.client.Scan(ctx, 0, escapePattern(prefix)+"*", scanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	for len(keys) > 0 {
+		page := keys[:min(len(keys), listPageSize)]
+		keys = keys[len(page):]
+		objs := make([]vfs.Object, len(page))
+		for i, k := range page {
+			objs[i] = vfs.Object{Key: k}
+		}
+		if err := fn(objs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backend) Delete(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return b.client.Del(ctx, keys...).Err()
+}
+
+func (b *backend) KeyLimit(prefix string) int {
+	return maxKeyBytes - len(prefix)
+}
+
+// escapePattern quotes the glob metacharacters SCAN MATCH understands.
+func escapePattern(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
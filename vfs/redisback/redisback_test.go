@@ -0,0 +1,55 @@
+package redisback
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+func TestRoundTripWithTTL(t *testing.T) {
+	srv := miniredis.RunT(t)
+	v, err := vfs.New(vfs.WithBackend("redis", Open(Options{TTL: time.Minute})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := make([]byte, 5000)
+	rand.Read(data)
+
+	uri := "redis://" + srv.Addr() + "/job[1]/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	srv.Set("job1/unrelated", "x")
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("restored data does not match")
+	}
+
+	srv.FastForward(2 * time.Minute)
+	if err := v.RestoreWriter(ctx, uri, &out); !errors.Is(err, vfs.ErrNoChunks) {
+		t.Errorf("expected chunks to expire, got %v", err)
+	}
+	if !srv.Exists("job1/unrelated") {
+		t.Error("expected keys outside the prefix to be left alone")
+	}
+}
+
+func TestGetMissing(t *testing.T) {
+	srv := miniredis.RunT(t)
+	b, _ := Open(Options{})(context.Background(), srv.Addr())
+	if _, err := b.Get(context.Background(), "nope"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
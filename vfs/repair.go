@@ -0,0 +1,160 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// RepairReport is the result of repairing an archive.
+type RepairReport struct {
+	Copied  int   // objects copied to mirror copies that lacked them
+	Rebuilt []int // chunks rebuilt from parity and stored again
+	Parity  int   // parity shards computed and stored again
+	Lost    []int // damaged chunks that could not be rebuilt
+}
+
+// OK reports whether the archive is whole again.
+func (r *RepairReport) OK() bool {
+	return len(r.Lost) == 0
+}
+
+// Repair restores the redundancy of the archive at uri in place, without
+// restoring and encoding it again. Objects missing from some of its mirror
+// copies (see WithMirrors) are copied over from another, and chunks that
+// are missing or do not decode are rebuilt from the archive's parity (see
+// WithParity) and stored again, as are damaged parity shards. Every chunk
+// is read once to find the damaged ones, and the chunks of a stripe that
+// needs rebuilding are read again. Mirror copies are only compared by
+// their listings: an object a mirror holds but that is damaged there is
+// not replaced, and only shows up when it is read. Damaged
+// chunks that cannot be rebuilt are listed in the report; the error is
+// only for failures to read or write the archive.
+func (v *VFS) Repair(ctx context.Context, uri string) (*RepairReport, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	r := &RepairReport{}
+	if r.Copied, err = v.repairMirrors(ctx, b, prefix, OpRepair, true); err != nil {
+		return nil, err
+	}
+	chunks, m, err := v.archiveChunks(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%w at %s", ErrNoChunks, uri)
+	}
+
+	// Chunks that do not decode are damaged, as are the indices no chunk
+	// has, up to the last one.
+	damaged := make(map[int][]string) // index -> keys to delete once rebuilt
+	missing, _ := indexGaps(chunks)
+	last := chunks[len(chunks)-1].index
+	if m != nil {
+		last = max(last, m.Chunks)
+	}
+	for index := chunks[len(chunks)-1].index + 1; index <= last; index++ {
+		missing = append(missing, index)
+	}
+	for _, index := range missing {
+		damaged[index] = nil
+	}
+	for _, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := c.decodeStored(); err != nil {
+			damaged[c.index] = append(damaged[c.index], c.key)
+		}
+	}
+	if m.parity() == nil {
+		for index := range damaged {
+			r.Lost = append(r.Lost, index)
+		}
+		sort.Ints(r.Lost)
+		return r, nil
+	}
+
+	rb, err := v.newRebuilder(ctx, b, prefix, chunks, m)
+	if err != nil {
+		return nil, err
+	}
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	format := keyFormat{checksum: true, width: m.IndexWidth, codec: m.codec(prefix), body: m.bodies()}
+	var stale []string
+	written := make(map[string]bool)
+	put := func(index int, data []byte) error {
+		key, body := format.object(dataPrefix, index, data)
+		written[key] = true
+		v.limiter.acquire()
+		return v.putWithBackoff(ctx, b, key, body)
+	}
+	for index, keys := range damaged {
+		if index > m.Chunks {
+			continue // not part of the file
+		}
+		data, err := rb.chunk(index)
+		if err != nil {
+			r.Lost = append(r.Lost, index)
+			continue
+		}
+		if err := put(index, data); err != nil {
+			return nil, err
+		}
+		r.Rebuilt = append(r.Rebuilt, index)
+		stale = append(stale, keys...)
+	}
+	sort.Ints(r.Rebuilt)
+	sort.Ints(r.Lost)
+
+	for stripe := 1; stripe <= rb.stripeCount(); stripe++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		width := int(rb.length((stripe-1)*rb.code.data + 1))
+		var bad []int
+		for shard := 1; shard <= rb.code.parity; shard++ {
+			c, ok := rb.shards[parityIndex(stripe, shard)]
+			if !ok {
+				bad = append(bad, shard)
+				continue
+			}
+			if data, err := c.decodeStored(); err != nil || len(data) != width {
+				bad = append(bad, shard)
+				stale = append(stale, c.key)
+			}
+		}
+		if len(bad) == 0 {
+			continue
+		}
+		data, err := rb.stripe(stripe)
+		if err != nil {
+			continue // its chunks are reported as lost
+		}
+		parity := rb.code.encode(padStripe(data, rb.code.data))
+		for _, shard := range bad {
+			if err := put(parityIndex(stripe, shard), parity[shard-1]); err != nil {
+				return nil, err
+			}
+			r.Parity++
+		}
+	}
+	// A body part that was corrupt in place has just been overwritten.
+	var keys []string
+	for _, key := range stale {
+		if !written[key] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		if err := v.deleteWithBackoff(ctx, b, keys); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
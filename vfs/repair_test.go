@@ -0,0 +1,93 @@
+package vfs
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepair_RebuildsChunksAndParity(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.parityData, v.parityShards, v.maxChunkSize = 4, 2, 100
+	ctx := context.Background()
+	const uri = "s3://bucket/disk/"
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1050)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	keys := len(fake.keys("bucket"))
+
+	delete(fake.objects, "bucket/"+chunkKeyAt(t, fake, "disk/", 2))
+	key := chunkKeyAt(t, fake, "disk/", 6)
+	delete(fake.objects, "bucket/"+key)
+	name := []byte(key)
+	i := strings.Index(key, "-") + 1
+	if name[i] = 'A'; key[i] == 'A' {
+		name[i] = 'B'
+	}
+	fake.objects["bucket/"+string(name)] = nil
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, "disk/p000003.1-") {
+			delete(fake.objects, "bucket/"+k)
+		}
+	}
+
+	r, err := v.Repair(ctx, uri)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if !r.OK() || !reflect.DeepEqual(r.Rebuilt, []int{2, 6}) || r.Parity != 1 {
+		t.Errorf("expected chunks 2 and 6 and one parity shard rebuilt, got %+v", r)
+	}
+	if got := len(fake.keys("bucket")); got != keys {
+		t.Errorf("expected %d keys after repair, got %d", keys, got)
+	}
+	if vr, err := v.Verify(ctx, uri); err != nil || !vr.OK() {
+		t.Errorf("expected the repaired archive to verify, got %+v, %v", vr, err)
+	}
+}
+
+func TestRepair_ReportsLostChunksWithoutParity(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	ctx := context.Background()
+	const uri = "s3://bucket/disk/"
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(450)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	delete(fake.objects, "bucket/"+chunkKeyAt(t, fake, "disk/", 5))
+
+	r, err := v.Repair(ctx, uri)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if r.OK() || !reflect.DeepEqual(r.Lost, []int{5}) {
+		t.Errorf("expected chunk 5 reported lost, got %+v", r)
+	}
+}
+
+func TestRepair_CopiesToMirrors(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.mirrors = []string{"s3://backup/"}
+	ctx := context.Background()
+	const uri = "s3://main/logs/"
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(4000)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	backupKeys := fake.keys("backup")
+	delete(fake.objects, "backup/"+backupKeys[0])
+
+	r, err := v.Repair(ctx, uri)
+	if err != nil {
+		t.Fatalf("repair failed: %v", err)
+	}
+	if !r.OK() || r.Copied != 1 {
+		t.Errorf("expected one object copied, got %+v", r)
+	}
+	if got := len(fake.keys("backup")); got != len(backupKeys) {
+		t.Errorf("expected the backup whole again, got %d of %d keys", got, len(backupKeys))
+	}
+}
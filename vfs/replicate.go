@@ -0,0 +1,115 @@
+package vfs
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// defaultReplicateInterval is how long ReplicateContinuously waits
+// between passes.
+const defaultReplicateInterval = 5 * time.Minute
+
+// ReplicateReport is what a pass of Replicate did.
+type ReplicateReport struct {
+	Copied    []string // source URIs of archives new or changed since the last pass
+	Unchanged []string
+	Deleted   []string // destination URIs of archives gone from the source, if deleting
+}
+
+// Replicate brings the archives at or below dstURI up to date with those
+// at or below srcURI, which may be in another bucket, region, or backend.
+// Archives, with their versions and snapshots, are copied and verified as
+// by Transfer; those whose manifest holds the same checksum at both ends
+// are skipped, and those that changed replace the copy at the
+// destination. With deleteRemoved, archives at the destination that are no
+// longer at the source are deleted too. The source is only read.
+func (v *VFS) Replicate(ctx context.Context, srcURI, dstURI string, deleteRemoved bool) (*ReplicateReport, error) {
+	t, err := v.openTransfer(ctx, srcURI, dstURI)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := transferDirs(ctx, t.src, t.srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ReplicateReport{}
+	if deleteRemoved {
+		// Deleting first, since an archive deleted at the destination
+		// takes those nested in it along, which the copies below restore.
+		present := make(map[string]bool, len(dirs))
+		for _, dir := range dirs {
+			present[dir] = true
+		}
+		old, err := transferDirs(ctx, t.dst, t.dstPrefix)
+		if err != nil {
+			return nil, err
+		}
+		var gone string
+		for _, dir := range old {
+			if present[dir] || gone != "" && strings.HasPrefix(dir, gone) {
+				continue
+			}
+			if err := v.Delete(ctx, t.to(dir)); err != nil {
+				return r, err
+			}
+			gone = dir
+			r.Deleted = append(r.Deleted, t.to(dir))
+		}
+	}
+	for _, dir := range dirs {
+		copied, err := v.transferArchive(ctx, t, dir, true)
+		if err != nil {
+			return r, err
+		}
+		if copied {
+			r.Copied = append(r.Copied, t.from(dir))
+		} else {
+			r.Unchanged = append(r.Unchanged, t.from(dir))
+		}
+	}
+	return r, nil
+}
+
+// ReplicateOptions tunes ReplicateContinuously.
+type ReplicateOptions struct {
+	// Interval is how long to wait after a pass before the next one
+	// starts. Zero means 5 minutes.
+	Interval time.Duration
+	// DeleteRemoved deletes archives at the destination that are no
+	// longer at the source, as for Replicate.
+	DeleteRemoved bool
+	// OnPass, if not nil, is called after every pass with what it
+	// replicated, or the error that stopped it.
+	OnPass func(*ReplicateReport, error)
+}
+
+// ReplicateContinuously runs Replicate from srcURI to dstURI at once and
+// then every opts.Interval until ctx is done, for disaster recovery where
+// the store's own replication is not available. A pass that fails is
+// reported to opts.OnPass and tried again at the next interval.
+// ReplicateContinuously returns nil when ctx is done, or an error if the
+// URIs cannot be replicated at all.
+func (v *VFS) ReplicateContinuously(ctx context.Context, srcURI, dstURI string, opts ReplicateOptions) error {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultReplicateInterval
+	}
+	if _, err := v.openTransfer(ctx, srcURI, dstURI); err != nil {
+		return err
+	}
+	for {
+		report, err := v.Replicate(ctx, srcURI, dstURI, opts.DeleteRemoved)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if opts.OnPass != nil {
+			opts.OnPass(report, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(opts.Interval):
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReplicate_CopiesChangesAndDeletes(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	for _, name := range []string{"a", "b"} {
+		if err := v.Encode(ctx, writeTempFile(t, randomBytes(800)), "s3://src/"+name+"/", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := v.Replicate(ctx, "s3://src/", "s3://dst/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Copied) != 2 || len(r.Deleted) != 0 {
+		t.Errorf("expected both archives copied, got %+v", r)
+	}
+
+	changed := randomBytes(1200)
+	if err := v.Encode(ctx, writeTempFile(t, changed), "s3://src/a/", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ctx, "s3://src/b/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(300)), "s3://src/c/", false); err != nil {
+		t.Fatal(err)
+	}
+	r, err = v.Replicate(ctx, "s3://src/", "s3://dst/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Copied) != 2 || len(r.Unchanged) != 0 || len(r.Deleted) != 1 || r.Deleted[0] != "s3://dst/b/" {
+		t.Errorf("expected a and c copied and b deleted, got %+v", r)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://dst/a/", &out); err != nil || !bytes.Equal(out.Bytes(), changed) {
+		t.Errorf("expected the changed archive at the destination, got %d bytes, %v", out.Len(), err)
+	}
+	if len(fake.keys("dst")) != len(fake.keys("src")) {
+		t.Errorf("expected the same objects in both buckets, got %d and %d", len(fake.keys("dst")), len(fake.keys("src")))
+	}
+
+	r, err = v.Replicate(ctx, "s3://src/", "s3://dst/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Copied) != 0 || len(r.Unchanged) != 2 {
+		t.Errorf("expected nothing to do, got %+v", r)
+	}
+}
+
+func TestReplicateContinuously_PicksUpNewArchives(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://src/a/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var passes []*ReplicateReport
+	err := v.ReplicateContinuously(ctx, "s3://src/", "s3://dst/", ReplicateOptions{
+		Interval: 10 * time.Millisecond,
+		OnPass: func(r *ReplicateReport, err error) {
+			if err != nil {
+				t.Errorf("pass %d: %v", len(passes)+1, err)
+			}
+			passes = append(passes, r)
+			if len(passes) == 1 {
+				if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://src/b/", false); err != nil {
+					t.Error(err)
+				}
+			} else {
+				cancel()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passes) != 2 || len(passes[1].Copied) != 1 || passes[1].Copied[0] != "s3://src/b/" {
+		t.Errorf("expected the second pass to copy b, got %+v", passes)
+	}
+}
+
+func TestReplicate_ReencodesKeyOnlyChunksThatDoNotFit(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const dst = "s3://dst/replica/of/the/source/bucket/"
+	data := randomBytes(5000)
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(5000)), "s3://src/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Replicate(ctx, "s3://src/", dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://src/a/", true); err != nil {
+		t.Fatal(err)
+	}
+	r, err := v.Replicate(ctx, "s3://src/", dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Copied) != 1 {
+		t.Errorf("expected the changed archive copied, got %+v", r)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, dst+"a/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the changed archive at the destination, got %d bytes, %v", out.Len(), err)
+	}
+	if r, err := v.Replicate(ctx, "s3://src/", dst, false); err != nil || len(r.Unchanged) != 1 {
+		t.Errorf("expected nothing to do, got %+v, %v", r, err)
+	}
+}
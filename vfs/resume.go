@@ -0,0 +1,188 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// WithResume makes Encode and EncodeReader pick up an interrupted upload
+// of the same file: chunks already stored under the prefix are kept and
+// only the missing ones are uploaded. Chunk keys are derived from the data,
+// so an existing chunk that differs from the one the input would produce
+// means the prefix holds another file, and the encode fails. With
+// WithDedup chunks live in the pool, where that comparison is not
+// possible, so only an unfinished upload is resumed and a finished
+// archive still needs force.
+//
+// Restore likewise continues from the state file an interrupted restore
+// left next to its output, if it was made from the same chunk listing.
+func WithResume() Option {
+	return func(o *options) {
+		o.resume = true
+	}
+}
+
+// resumableChunks returns the keys of the chunks already stored for the
+// archive at prefix, by index, for encode to compare with its input. bodies
+// is set if the archive stores its data in object bodies.
+func (v *VFS) resumableChunks(ctx context.Context, b Backend, prefix, dataPrefix string, bodies bool) (map[int]string, error) {
+	detected, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if detected != dataPrefix {
+		return nil, fmt.Errorf("cannot resume %s: it was started with a different layout", prefix)
+	}
+	stored, err := v.listStored(ctx, b, dataPrefix, bodies)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[int]string, len(stored))
+	for _, c := range stored {
+		if _, dup := existing[c.index]; dup {
+			return nil, fmt.Errorf("cannot resume %s: chunk %d is stored twice", prefix, c.index)
+		}
+		existing[c.index] = c.key
+	}
+	return existing, nil
+}
+
+// restoreStateInterval is how many written chunks Restore records at a
+// time in its state file.
+const restoreStateInterval = 256
+
+// restoreState records how far Restore got writing its output file, in
+// "<output>.vfs-state", so WithResume can continue an interrupted restore.
+// The output is written to "<output>.vfs-partial" and only renamed to its
+// name once it is complete, so a failed restore never leaves a truncated
+// file where the real one is expected. Chunks are written in index order,
+// so the partial file holds every chunk up to index Last in its first
+// Bytes bytes. Last is an index rather than a count, since chunks that
+// allow-gaps or best-effort mode skip leave no trace in the file, and it
+// stops advancing at the first chunk a best-effort restore skips. The
+// listing fingerprint ties the state to the exact set of chunk keys it was
+// computed from.
+type restoreState struct {
+	URI     string `json:"uri"`
+	Listing string `json:"listing"`
+	Last    int    `json:"last"`
+	Bytes   int64  `json:"bytes"`
+
+	output  string
+	partial string
+	path    string
+	file    *os.File
+	unsaved int
+}
+
+func newRestoreState(outputPath, uri string, chunks []storedChunk) *restoreState {
+	h := sha256.New()
+	for _, c := range chunks {
+		io.WriteString(h, c.key)
+		h.Write([]byte{'\n'})
+	}
+	return &restoreState{
+		URI:     uri,
+		Listing: hex.EncodeToString(h.Sum(nil)),
+		output:  outputPath,
+		partial: outputPath + ".vfs-partial",
+		path:    outputPath + ".vfs-state",
+	}
+}
+
+// openRestoreOutput opens the partial output file for st. When resuming
+// from a state file that matches the archive, it is truncated to what was
+// recorded as written; otherwise it starts empty.
+func (v *VFS) openRestoreOutput(st *restoreState) (*os.File, error) {
+	if v.resume {
+		if prev, err := loadRestoreState(st.path); err == nil && prev.URI == st.URI && prev.Listing == st.Listing {
+			f, err := os.OpenFile(st.partial, os.O_RDWR, 0)
+			if err == nil {
+				info, err := f.Stat()
+				if err == nil && info.Size() >= prev.Bytes {
+					if err := f.Truncate(prev.Bytes); err != nil {
+						f.Close()
+						return nil, err
+					}
+					if _, err := f.Seek(prev.Bytes, io.SeekStart); err != nil {
+						f.Close()
+						return nil, err
+					}
+					st.Last, st.Bytes, st.file = prev.Last, prev.Bytes, f
+					v.warn(OpRestore, fmt.Sprintf("Resuming restore after chunk %d (%d bytes).", prev.Last, prev.Bytes))
+					return f, nil
+				}
+				f.Close()
+			}
+		}
+	}
+	f, err := os.Create(st.partial)
+	if err != nil {
+		return nil, err
+	}
+	st.file = f
+	return f, nil
+}
+
+func loadRestoreState(path string) (*restoreState, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st restoreState
+	if err := json.Unmarshal(body, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// seed feeds the part of the output written before resuming into h.
+func (st *restoreState) seed(h io.Writer) error {
+	_, err := io.Copy(h, io.NewSectionReader(st.file, 0, st.Bytes))
+	return err
+}
+
+// advance records that the chunk at index, n bytes long, was written.
+func (st *restoreState) advance(index, n int) error {
+	if st == nil {
+		return nil
+	}
+	st.Last = index
+	st.Bytes += int64(n)
+	if st.unsaved++; st.unsaved < restoreStateInterval {
+		return nil
+	}
+	return st.save()
+}
+
+func (st *restoreState) save() error {
+	body, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	st.unsaved = 0
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
+
+// finish renames the complete partial file to the output and deletes the
+// state file. The partial file must be closed.
+func (st *restoreState) finish() error {
+	if err := os.Rename(st.partial, st.output); err != nil {
+		return err
+	}
+	if err := os.Remove(st.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEncode_ResumeUploadsOnlyMissingChunks(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/resume/"
+	data := randomBytes(10 * calculateChunkSize("resume/"))
+	input := writeTempFile(t, data)
+
+	// The staging marker and four chunks make it.
+	failAfter(fake, 5)
+	if err := v.Encode(ctx, input, uri, false); err == nil {
+		t.Fatal("expected the first encode to fail")
+	}
+	fake.putHook = nil
+	stored := len(fake.keys("bucket")) - 1
+
+	var puts atomic.Int32
+	fake.putHook = func() error {
+		puts.Add(1)
+		return nil
+	}
+	v.resume = true
+	if err := v.Encode(ctx, input, uri, false); err != nil {
+		t.Fatalf("resumed encode failed: %v", err)
+	}
+	// The missing chunks, the marker, and the manifest.
+	if got, want := int(puts.Load()), 10-stored+2; got != want {
+		t.Errorf("expected %d puts on resume (%d chunks were stored), got %d", want, stored, got)
+	}
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the file back after resuming, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestEncode_ResumeRejectsOtherFile(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	const uri = "s3://bucket/other/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	v.resume = true
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), uri, false); err == nil {
+		t.Error("expected resuming with a different file to fail")
+	}
+}
+
+func TestRestore_ResumesFromStateFile(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/big/"
+	chunkSize := calculateChunkSize("big/")
+	data := randomBytes(6*chunkSize + 17)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	// Pretend an earlier restore wrote four chunks, and a bit of a fifth
+	// before it was killed.
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := os.WriteFile(out+".vfs-partial", append(data[:4*chunkSize:4*chunkSize], "partial"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunks, _, err := v.openArchive(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newRestoreState(out, uri, chunks)
+	st.Last, st.Bytes = 4, int64(4*chunkSize)
+	if err := st.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	v.resume = true
+	events := collectEvents(v)
+	if err := v.Restore(ctx, uri, out); err != nil {
+		t.Fatalf("resumed restore failed: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, data) {
+		t.Errorf("expected the whole file after resuming, got %d bytes", len(got))
+	}
+	for _, e := range *events {
+		if e.Kind == EventStart && e.Total != 3 {
+			t.Errorf("expected only the last 3 chunks to be restored, got %d", e.Total)
+		}
+	}
+	for _, path := range []string{st.path, st.partial} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", path, err)
+		}
+	}
+}
+
+func TestRestore_IgnoresStateForOtherArchive(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	data := randomBytes(3000)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/a/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.bin")
+	os.WriteFile(out, []byte("unrelated"), 0644)
+	st := &restoreState{URI: "s3://bucket/a/", Listing: "stale", Last: 1, Bytes: 9, path: out + ".vfs-state"}
+	if err := st.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	v.resume = true
+	if err := v.Restore(ctx, "s3://bucket/a/", out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, data) {
+		t.Error("expected a stale state file to be ignored")
+	}
+}
+
+func TestRestore_FailureLeavesOutputAlone(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/gap/"
+	data := randomBytes(4 * calculateChunkSize("gap/"))
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	chunks, _, err := v.openArchive(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(fake.objects, "bucket/"+chunks[2].key)
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := os.WriteFile(out, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Restore(ctx, uri, out); err == nil {
+		t.Fatal("expected the restore to fail")
+	}
+	if got, _ := os.ReadFile(out); string(got) != "previous" {
+		t.Errorf("expected the existing output untouched, got %d bytes", len(got))
+	}
+	if got, _ := os.ReadFile(out + ".vfs-partial"); !bytes.Equal(got, data[:len(got)]) {
+		t.Errorf("expected the partial file to hold the chunks restored so far, got %d bytes", len(got))
+	}
+}
+
+func TestRestoreState_StopsAtFirstSkippedChunk(t *testing.T) {
+	fake, first, _ := seedCorruptArchive(t)
+	v := newTestVFS(fake)
+	v.bestEffort = true
+	ctx := context.Background()
+	chunks, _, err := v.openArchive(ctx, "s3://bucket/salvage/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newRestoreState(filepath.Join(t.TempDir(), "out.bin"), "s3://bucket/salvage/", chunks)
+	var out bytes.Buffer
+	var decodeErr *DecodeError
+	if err := v.writeChunks(ctx, chunks, &out, st); !errors.As(err, &decodeErr) {
+		t.Fatalf("expected *DecodeError, got %v", err)
+	}
+	// Chunk 3 was written after the gap, but the state must not claim it.
+	if st.Last != 1 || st.Bytes != int64(len(first)) {
+		t.Errorf("expected the state to stop at chunk 1 (%d bytes), got chunk %d (%d bytes)", len(first), st.Last, st.Bytes)
+	}
+}
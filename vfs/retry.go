@@ -0,0 +1,90 @@
+package vfs
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how requests that S3 throttles (SlowDown, 503) or
+// that time out are retried on top of the SDK's own retries. Attempt n
+// waits a random delay between zero and min(MaxDelay, BaseDelay·2ⁿ⁻¹)
+// ("full jitter"), so workers that were throttled together do not retry
+// together.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, including
+	// the first. 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: 20 * time.Second}
+
+// WithRetryPolicy sets how throttled uploads and deletes are retried.
+// Zero fields take their value from DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = &p
+	}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = max(DefaultRetryPolicy.MaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+// delay returns how long to wait after the given failed attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 32 {
+		ceiling = min(ceiling, p.BaseDelay<<(attempt-1))
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// withBackoff runs req, for which the caller holds a limiter slot, and
+// retries it while it is throttled or times out according to the retry
+// policy. The slot is released when withBackoff returns.
+func (v *VFS) withBackoff(ctx context.Context, req func() error) error {
+	for attempt := 1; ; attempt++ {
+		if v.rate != nil {
+			if err := v.rate.Wait(ctx); err != nil {
+				v.limiter.abandon()
+				return err
+			}
+		}
+		start := time.Now()
+		err := req()
+		congested := err != nil && isCongestion(err)
+		v.limiter.release(time.Since(start), congested)
+		if !congested || attempt >= v.retry.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		v.metrics.retried()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(v.retry.delay(attempt)):
+		}
+		v.limiter.acquire()
+	}
+}
+
+// deleteWithBackoff deletes keys, retrying while the backend throttles.
+func (v *VFS) deleteWithBackoff(ctx context.Context, b Backend, keys []string) error {
+	v.limiter.acquire()
+	return v.withBackoff(ctx, func() error { return b.Delete(ctx, keys) })
+}
@@ -0,0 +1,71 @@
+package vfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestRetryPolicy_DelayIsJitteredAndCapped(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for attempt := 1; attempt <= 40; attempt++ {
+		ceiling := min(p.MaxDelay, p.BaseDelay<<min(attempt-1, 20))
+		for i := 0; i < 20; i++ {
+			if d := p.delay(attempt); d < 0 || d > ceiling {
+				t.Fatalf("attempt %d: delay %v outside [0, %v]", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_Defaults(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}.withDefaults()
+	if p.MaxAttempts != 3 || p.BaseDelay != DefaultRetryPolicy.BaseDelay || p.MaxDelay != DefaultRetryPolicy.MaxDelay {
+		t.Errorf("expected defaults to fill only zero fields, got %+v", p)
+	}
+}
+
+// throttlingBackend answers the first `failures` deletes with SlowDown.
+type throttlingBackend struct {
+	Backend
+	failures int
+	deletes  int
+}
+
+func (b *throttlingBackend) Delete(ctx context.Context, keys []string) error {
+	if b.deletes++; b.deletes <= b.failures {
+		return &smithy.GenericAPIError{Code: "SlowDown", Message: "Please reduce your request rate."}
+	}
+	return b.Backend.Delete(ctx, keys)
+}
+
+func TestDelete_RetriesThrottledRequests(t *testing.T) {
+	for _, tc := range []struct {
+		attempts, failures int
+		ok                 bool
+	}{
+		{attempts: 5, failures: 3, ok: true},
+		{attempts: 2, failures: 3, ok: false},
+	} {
+		fake := newFakeS3()
+		fake.objects["bucket/x/1-AAAA"] = nil
+		tb := &throttlingBackend{Backend: &s3Backend{client: fake, bucket: "bucket"}, failures: tc.failures}
+		v := newTestVFS(fake)
+		v.backends["slow"] = func(context.Context, string) (Backend, error) { return tb, nil }
+		v.retry = RetryPolicy{MaxAttempts: tc.attempts, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+		err := v.Delete(context.Background(), "slow://bucket/x/")
+		if tc.ok && (err != nil || len(fake.keys("bucket")) != 0) {
+			t.Errorf("%+v: expected the delete to succeed after retries, got %v", tc, err)
+		}
+		if !tc.ok && (err == nil || !strings.Contains(err.Error(), "SlowDown")) {
+			t.Errorf("%+v: expected the SlowDown error once attempts ran out, got %v", tc, err)
+		}
+		if want := min(tc.attempts, tc.failures+1); tb.deletes != want {
+			t.Errorf("%+v: expected %d delete attempts, got %d", tc, want, tb.deletes)
+		}
+	}
+}
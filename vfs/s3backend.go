@@ -0,0 +1,232 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	s3MaxKeyLengthBytes = 1024
+	s3MaxDeleteBatch    = 1000
+)
+
+type s3API interface {
+	s3.ListObjectsV2APIClient
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+}
+
+// s3VersionsAPI is the part of the S3 API that lists object versions, which
+// WithAsOf needs.
+type s3VersionsAPI interface {
+	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+}
+
+// s3Backend stores archives in one S3 bucket.
+type s3Backend struct {
+	client       s3API
+	bucket       string
+	storageClass s3types.StorageClass
+	tagging      string // URL-encoded tag set
+	lock         lockPolicy
+}
+
+func (v *VFS) openS3(ctx context.Context, bucket string) (Backend, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 URI is missing a bucket name")
+	}
+	return &s3Backend{client: v.client, bucket: bucket, storageClass: s3types.StorageClass(v.storageClass), tagging: v.tagging, lock: v.lock}, nil
+}
+
+func (b *s3Backend) Put(ctx context.Context, key string, body []byte) error {
+	input := &s3.PutObjectInput{Bucket: &b.bucket, Key: &key, StorageClass: b.storageClass}
+	if len(body) > 0 {
+		input.Body = bytes.NewReader(body)
+		// Chunks are read from the listing, but manifests and markers
+		// must stay readable without a restore from an archive tier.
+		if isArchiveClass(b.storageClass) {
+			input.StorageClass = ""
+		}
+	}
+	if b.tagging != "" {
+		input.Tagging = &b.tagging
+	}
+	input.ObjectLockMode, input.ObjectLockRetainUntilDate, input.ObjectLockLegalHoldStatus = b.lock.s3Params()
+	_, err := b.client.PutObject(ctx, input)
+	return err
+}
+
+// copyObject copies with CopyObject when src is a bucket reached through
+// the same client.
+func (b *s3Backend) copyObject(ctx context.Context, src Backend, srcKey, dstKey string) (bool, error) {
+	s, ok := src.(*s3Backend)
+	if !ok || s.client != b.client {
+		return false, nil
+	}
+	source := s.bucket + "/" + url.PathEscape(srcKey)
+	input := &s3.CopyObjectInput{Bucket: &b.bucket, Key: &dstKey, CopySource: &source}
+	if !isArchiveClass(b.storageClass) {
+		input.StorageClass = b.storageClass
+	}
+	if b.tagging != "" {
+		input.Tagging = &b.tagging
+		input.TaggingDirective = s3types.TaggingDirectiveReplace
+	}
+	input.ObjectLockMode, input.ObjectLockRetainUntilDate, input.ObjectLockLegalHoldStatus = b.lock.s3Params()
+	_, err := b.client.CopyObject(ctx, input)
+	return true, err
+}
+
+func (b *s3Backend) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &key})
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, fmt.Errorf("s3://%s/%s: %w", b.bucket, key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+func (b *s3Backend) List(ctx context.Context, prefix string, fn func([]Object) error) error {
+	p := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: &b.bucket,
+		Prefix: &prefix,
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		objs := make([]Object, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			o := Object{Key: *obj.Key}
+			if obj.Size != nil {
+				o.Size = *obj.Size
+			}
+			if obj.LastModified != nil {
+				o.LastModified = *obj.LastModified
+			}
+			objs = append(objs, o)
+		}
+		if err := fn(objs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *s3Backend) Delete(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), s3MaxDeleteBatch)]
+		keys = keys[len(batch):]
+		ids := make([]s3types.ObjectIdentifier, len(batch))
+		for i := range batch {
+			ids[i] = s3types.ObjectIdentifier{Key: &batch[i]}
+		}
+		out, err := b.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &b.bucket,
+			Delete: &s3types.Delete{Objects: ids},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			// Keep the error code, so a SlowDown on some keys is retried.
+			return fmt.Errorf("failed to delete %d object(s), first s3://%s/%s: %w", len(out.Errors), b.bucket, deref(e.Key),
+				&smithy.GenericAPIError{Code: deref(e.Code), Message: deref(e.Message)})
+		}
+	}
+	return nil
+}
+
+func (b *s3Backend) KeyLimit(prefix string) int {
+	return s3MaxKeyLengthBytes - len(prefix)
+}
+
+// listVersions lists the versions under prefix with ListObjectVersions.
+// Buckets that never had versioning enabled list each object once, with
+// the version ID "null".
+func (b *s3Backend) listVersions(ctx context.Context, prefix string, fn func([]objectVersion) error) error {
+	client, ok := b.client.(s3VersionsAPI)
+	if !ok {
+		return ErrNotVersioned
+	}
+	input := &s3.ListObjectVersionsInput{Bucket: &b.bucket, Prefix: &prefix}
+	for {
+		page, err := client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return err
+		}
+		versions := make([]objectVersion, 0, len(page.Versions)+len(page.DeleteMarkers))
+		for _, obj := range page.Versions {
+			ver := objectVersion{Object: Object{Key: deref(obj.Key)}, ID: deref(obj.VersionId)}
+			if obj.Size != nil {
+				ver.Size = *obj.Size
+			}
+			if obj.LastModified != nil {
+				ver.LastModified = *obj.LastModified
+			}
+			versions = append(versions, ver)
+		}
+		for _, marker := range page.DeleteMarkers {
+			ver := objectVersion{Object: Object{Key: deref(marker.Key)}, ID: deref(marker.VersionId), Deleted: true}
+			if marker.LastModified != nil {
+				ver.LastModified = *marker.LastModified
+			}
+			versions = append(versions, ver)
+		}
+		if err := fn(versions); err != nil {
+			return err
+		}
+		if page.IsTruncated == nil || !*page.IsTruncated {
+			return nil
+		}
+		input.KeyMarker, input.VersionIdMarker = page.NextKeyMarker, page.NextVersionIdMarker
+	}
+}
+
+func (b *s3Backend) getVersion(ctx context.Context, key, id string) ([]byte, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &b.bucket, Key: &key, VersionId: &id})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// encodeTags returns tags as the URL query an S3 Tagging header expects.
+func encodeTags(tags map[string]string) string {
+	q := make(url.Values, len(tags))
+	for k, v := range tags {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+// isArchiveClass reports whether objects of class c must be restored
+// before they can be read.
+func isArchiveClass(c s3types.StorageClass) bool {
+	return c == s3types.StorageClassGlacier || c == s3types.StorageClassDeepArchive
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
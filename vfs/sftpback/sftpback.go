@@ -0,0 +1,248 @@
+// Package sftpback stores vfs archives on SFTP servers. Like the S3 keys,
+// chunks become empty files whose names carry the encoded data.
+//
+//	v, err := vfs.New(vfs.WithBackend("sftp", sftpback.Open(sftpback.Options{})))
+//	v.Encode(ctx, "report.pdf", "sftp://deploy@files.example.com/srv/drop/", false)
+//
+// URI paths are absolute on the server.
+package sftpback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"github.com/vjeffz/vfs/vfs"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// Most filesystems limit a single file name to 255 bytes.
+	maxNameBytes = 255
+	listPageSize = 1000
+	defaultPort  = "22"
+)
+
+// Options configures the SSH connections made by Open.
+type Options struct {
+	// Auth defaults to the keys offered by the SSH agent at SSH_AUTH_SOCK
+	// and the unencrypted ~/.ssh/id_ed25519, id_ecdsa and id_rsa keys.
+	Auth []ssh.AuthMethod
+	// HostKeyCallback defaults to checking ~/.ssh/known_hosts.
+	HostKeyCallback ssh.HostKeyCallback
+}
+
+// Open returns a factory for sftp://[user@]host[:port]/path/ URIs. The user
+// defaults to the current user. One connection is kept per authority.
+func Open(opts Options) vfs.BackendFactory {
+	var mu sync.Mutex
+	clients := make(map[string]*sftp.Client)
+	return func(ctx context.Context, authority string) (vfs.Backend, error) {
+		if authority == "" {
+			return nil, fmt.Errorf("sftp URI is missing a host")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		client, ok := clients[authority]
+		if !ok {
+			var err error
+			if client, err = dial(ctx, authority, opts); err != nil {
+				return nil, err
+			}
+			clients[authority] = client
+		}
+		return New(client), nil
+	}
+}
+
+func dial(ctx context.Context, authority string, opts Options) (*sftp.Client, error) {
+	userName, host, ok := strings.Cut(authority, "@")
+	if !ok {
+		host = userName
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		userName = u.Username
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, defaultPort)
+	}
+
+	auth := opts.Auth
+	if auth == nil {
+		auth = defaultAuth()
+	}
+	hostKeyCallback := opts.HostKeyCallback
+	if hostKeyCallback == nil {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		if hostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts")); err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, &ssh.ClientConfig{
+		User:            userName,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sftp.NewClient(ssh.NewClient(c, chans, reqs))
+}
+
+func defaultAuth() []ssh.AuthMethod {
+	var auth []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	home, _ := os.UserHomeDir()
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(key); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	return auth
+}
+
+// New returns a backend that uses an existing client, with keys relative
+// to the server's root directory.
+func New(client *sftp.Client) vfs.Backend {
+	return &backend{client: client}
+}
+
+type backend struct {
+	client *sftp.Client
+	prefix string // of the URI, which Delete stays within
+}
+
+// Scoped implements vfs.ScopedBackend.
+func (b *backend) Scoped(prefix string) vfs.Backend {
+	return &backend{client: b.client, prefix: prefix}
+}
+
+func remotePath(key string) string {
+	return "/" + key
+}
+
+func (b *backend) Put(ctx context.Context, key string, body []byte) error {
+	p := remotePath(key)
+	if err := b.client.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	f, err := b.client.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *backend) Get(ctx context.Context, key string) ([]byte, error) {
+	f, err := b.client.Open(remotePath(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (b *backend) List(ctx context.Context, prefix string, fn func([]vfs.Object) error) error {
+	dir := prefix
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	var objs []vfs.Object
+	walker := b.client.Walk(remotePath(dir))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info := walker.Stat()
+		key := strings.TrimPrefix(walker.Path(), "/")
+		if info.IsDir() || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objs = append(objs, vfs.Object{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+	}
+
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].Key < objs[j].Key
+	})
+	for len(objs) > 0 {
+		page := objs[:min(len(objs), listPageSize)]
+		objs = objs[len(page):]
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the files for keys and then any directories left empty
+// between them and the directory of the URI prefix, that one included.
+func (b *backend) Delete(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := b.client.Remove(remotePath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	for _, key := range keys {
+		stop := "/"
+		if b.prefix != "" && strings.HasPrefix(key, b.prefix) {
+			stop = path.Dir(path.Clean(remotePath(b.prefix)))
+		}
+		for dir := path.Dir(remotePath(key)); dir != stop && dir != "/"; dir = path.Dir(dir) {
+			if b.client.RemoveDirectory(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (b *backend) KeyLimit(prefix string) int {
+	return maxNameBytes
+}
@@ -0,0 +1,103 @@
+package sftpback
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"net"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/vjeffz/vfs/vfs"
+)
+
+// newTestClient connects to an in-memory SFTP server.
+func newTestClient(t *testing.T) *sftp.Client {
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestRoundTrip(t *testing.T) {
+	client := newTestClient(t)
+	v, err := vfs.New(vfs.WithBackend("sftp", func(ctx context.Context, authority string) (vfs.Backend, error) {
+		return New(client), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	data := make([]byte, 3000)
+	rand.Read(data)
+
+	const uri = "sftp://files.example.com/srv/drop/"
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	entries, err := client.ReadDir("/srv/drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == ".vfs-manifest" {
+			continue
+		}
+		if e.Size() != 0 || len(e.Name()) > maxNameBytes {
+			t.Errorf("unexpected chunk file %q of %d bytes", e.Name(), e.Size())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("restored data does not match")
+	}
+
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := client.Stat("/srv/drop"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the archive directory to be removed, got %v", err)
+	}
+}
+
+func TestDeleteStaysWithinPrefix(t *testing.T) {
+	client := newTestClient(t)
+	v, err := vfs.New(vfs.WithBackend("sftp", func(ctx context.Context, authority string) (vfs.Backend, error) {
+		return New(client), nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	const uri = "sftp://files.example.com/srv/drop/"
+	if err := v.EncodeReader(ctx, bytes.NewReader([]byte("report")), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stat("/srv"); err != nil {
+		t.Errorf("expected the empty directory above the archive to be kept, got %v", err)
+	}
+}
+
+func TestGetMissing(t *testing.T) {
+	b := New(newTestClient(t))
+	if _, err := b.Get(context.Background(), "srv/.vfs-manifest"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
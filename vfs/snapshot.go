@@ -0,0 +1,136 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// snapshotsDir holds the named snapshots of an archive, at
+// <prefix>.vfs-snapshots/<name>/.
+const snapshotsDir = ".vfs-snapshots/"
+
+// Snapshot is a named copy of an archive, taken by CreateSnapshot.
+type Snapshot struct {
+	Name string
+	Entry
+}
+
+// SnapshotURI returns the URI of the snapshot name of the archive at uri,
+// which works with Restore, Stat, Verify, and the other read commands.
+func SnapshotURI(uri, name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	scheme, bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s/%s%s%s/", scheme, bucket, prefix, snapshotsDir, name), nil
+}
+
+// CreateSnapshot freezes the archive at uri as it is now under name, so
+// later encodes to uri cannot take that state away. The snapshot is a copy
+// of the archive: deduplicated archives (see WithDedup) only have their
+// manifest written again, other archives have every chunk copied, and
+// key-only chunks too long for the snapshot prefix are encoded again
+// there. It fails with ErrPrefixExists if the name is taken.
+func (v *VFS) CreateSnapshot(ctx context.Context, uri, name string) error {
+	dst, err := SnapshotURI(uri, name)
+	if err != nil {
+		return err
+	}
+	b, prefix, err := v.open(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if err := v.clearPrefix(ctx, b, prefix, dst, false); err != nil {
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return v.keepCopy(ctx, uri, dst)
+}
+
+// Snapshots returns the snapshots of the archive at uri, sorted by name.
+func (v *VFS) Snapshots(ctx context.Context, uri string) ([]Snapshot, error) {
+	scheme, bucket, _, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	root := fmt.Sprintf("%s://%s/", scheme, bucket)
+	entries, err := v.listArchives(ctx, b, root, prefix+snapshotsDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []Snapshot
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(e.URI, root+prefix+snapshotsDir), "/")
+		if ok && !strings.Contains(name, "/") {
+			snapshots = append(snapshots, Snapshot{Name: name, Entry: e})
+		}
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot makes the snapshot name of the archive at uri current
+// again. The archive it replaces is kept as a version with WithVersioning
+// and deleted otherwise; the snapshot stays.
+func (v *VFS) RestoreSnapshot(ctx context.Context, uri, name string) error {
+	src, err := SnapshotURI(uri, name)
+	if err != nil {
+		return err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	_, srcPrefix, err := v.open(ctx, src)
+	if err != nil {
+		return err
+	}
+	exists, err := v.hasObjects(ctx, b, srcPrefix)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: no snapshot %s of %s", ErrNoChunks, name, uri)
+	}
+	if v.versioning {
+		err = v.keepVersion(ctx, b, prefix, uri)
+	} else {
+		err = v.deleteOne(ctx, uri)
+	}
+	if err != nil {
+		return err
+	}
+	return v.keepCopy(ctx, src, uri)
+}
+
+// DeleteSnapshot deletes the snapshot name of the archive at uri.
+func (v *VFS) DeleteSnapshot(ctx context.Context, uri, name string) error {
+	dst, err := SnapshotURI(uri, name)
+	if err != nil {
+		return err
+	}
+	return v.Delete(ctx, dst)
+}
+
+// isHistory reports whether key, relative to an archive's prefix, lies in
+// its versions or snapshots.
+func isHistory(key string) bool {
+	return strings.HasPrefix(key, versionsDir) || strings.HasPrefix(key, snapshotsDir)
+}
+
+// isKept reports whether dir, relative to a listed prefix, lies inside
+// the versions or snapshots of an archive.
+func isKept(dir string) bool {
+	for _, kept := range []string{versionsDir, snapshotsDir} {
+		if strings.HasPrefix(dir, kept) || strings.Contains(dir, "/"+kept) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot_SurvivesLaterEncodes(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.dedup, v.maxChunkSize = dedup, 1000
+		ctx := context.Background()
+		const uri = "s3://bucket/db/"
+		monday, tuesday := randomBytes(3000), randomBytes(3000)
+		if err := v.Encode(ctx, writeTempFile(t, monday), uri, false); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		if err := v.CreateSnapshot(ctx, uri, "monday"); err != nil {
+			t.Fatalf("snapshot failed: %v", err)
+		}
+		if err := v.CreateSnapshot(ctx, uri, "monday"); !errors.Is(err, ErrPrefixExists) {
+			t.Errorf("dedup=%v: expected a taken name to fail with ErrPrefixExists, got %v", dedup, err)
+		}
+		if dedup {
+			// The snapshot shares the archive's chunks.
+			pooled := 0
+			for _, k := range fake.keys("bucket") {
+				if strings.HasPrefix(k, poolDir+"chunks/") {
+					pooled++
+				}
+			}
+			if pooled != 3 {
+				t.Errorf("expected three pooled chunks, got %d", pooled)
+			}
+		}
+		if err := v.Encode(ctx, writeTempFile(t, tuesday), uri, true); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		snapshots, err := v.Snapshots(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != 1 || snapshots[0].Name != "monday" || snapshots[0].Manifest.Size != 3000 {
+			t.Fatalf("dedup=%v: unexpected snapshots %+v", dedup, snapshots)
+		}
+		out := filepath.Join(t.TempDir(), "out")
+		if err := v.Restore(ctx, snapshots[0].URI, out); err != nil {
+			t.Fatalf("dedup=%v: restore of the snapshot failed: %v", dedup, err)
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, monday) {
+			t.Errorf("dedup=%v: snapshot does not hold the earlier state", dedup)
+		}
+
+		if err := v.RestoreSnapshot(ctx, uri, "monday"); err != nil {
+			t.Fatalf("dedup=%v: restoring the snapshot failed: %v", dedup, err)
+		}
+		if err := v.Restore(ctx, uri, out); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(out); !bytes.Equal(got, monday) {
+			t.Errorf("dedup=%v: expected the snapshot to be current", dedup)
+		}
+		if err := v.DeleteSnapshot(ctx, uri, "monday"); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Delete(ctx, uri); err != nil {
+			t.Fatal(err)
+		}
+		// Pooled chunks are left to GC.
+		if _, err := v.GC(ctx, "s3://bucket/", GCOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if keys := fake.keys("bucket"); len(keys) != 0 {
+			t.Errorf("dedup=%v: expected nothing left, got %v", dedup, keys)
+		}
+	}
+}
+
+func TestSnapshot_KeepsMultiChunkKeyOnlyArchive(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/db/"
+	// Key-only chunks sized for uri do not fit under the longer snapshot
+	// prefix, so the snapshot is encoded again there.
+	data := randomBytes(5000)
+	if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if m, err := v.Stat(ctx, uri); err != nil || m.Chunks < 2 {
+		t.Fatalf("expected several chunks, got %+v, %v", m, err)
+	}
+	if err := v.CreateSnapshot(ctx, uri, "before-upgrade"); err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(5000)), uri, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.RestoreSnapshot(ctx, uri, "before-upgrade"); err != nil {
+		t.Fatalf("restoring the snapshot failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the snapshot to be current, got %d bytes, %v", out.Len(), err)
+	}
+}
@@ -0,0 +1,169 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Chunk data lives in the key names, so an upload cannot be staged under a
+// temporary prefix and renamed into place without paying for every PUT
+// twice. Encode instead writes a staging marker where the manifest will go
+// before the first chunk, and commits by writing the manifest and then
+// deleting the marker. Readers refuse an archive while its marker exists,
+// so a half-failed upload never looks like a stored file.
+const stagingName = ".vfs-staging"
+
+// The marker of an encrypted upload also carries its wrapped data key, so
+// the upload can be resumed before the manifest exists.
+type stagingMarker struct {
+	Started    time.Time   `json:"started"`
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// metaKey returns the key of the metadata object name for the archive at
+// prefix whose chunks live under dataPrefix.
+func metaKey(prefix, dataPrefix, name string) string {
+	if dataPrefix != prefix {
+		return prefix + metaDir + name
+	}
+	return prefix + name
+}
+
+func (v *VFS) beginStaging(ctx context.Context, b Backend, key string, e *Encryption) error {
+	body, err := json.Marshal(stagingMarker{Started: time.Now().UTC(), Encryption: e})
+	if err != nil {
+		return err
+	}
+	return b.Put(ctx, key, body)
+}
+
+// checkCommitted fails with ErrIncompleteArchive if an upload to the
+// archive at prefix has not finished.
+func (v *VFS) checkCommitted(ctx context.Context, b Backend, prefix, dataPrefix string) error {
+	key := metaKey(prefix, dataPrefix, stagingName)
+	_, err := b.Get(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s exists", ErrIncompleteArchive, key)
+}
+
+// unfinished reports whether the archive at prefix has an upload that has
+// not finished.
+func (v *VFS) unfinished(ctx context.Context, b Backend, prefix string) (bool, error) {
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return false, err
+	}
+	err = v.checkCommitted(ctx, b, prefix, dataPrefix)
+	if errors.Is(err, ErrIncompleteArchive) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Cleanup deletes the archives under uri whose upload started more than
+// olderThan ago and never finished, and returns their prefixes. Uploads
+// that are younger may still be running and are left alone.
+func (v *VFS) Cleanup(ctx context.Context, uri string, olderThan time.Duration) ([]string, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var markers []string
+	err = b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			if obj.Key == prefix+stagingName || strings.HasSuffix(obj.Key, "/"+stagingName) {
+				markers = append(markers, obj.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	archives, err := abandonedUploads(ctx, b, markers, time.Now().Add(-olderThan))
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, archive := range archives {
+		if err := v.deleteArchive(ctx, b, archive); err != nil {
+			return removed, err
+		}
+		removed = append(removed, strings.TrimSuffix(archive, metaDir))
+	}
+	return removed, nil
+}
+
+// abandonedUploads returns the directories of the staging markers that
+// were written before cutoff, as deleteArchive takes them.
+func abandonedUploads(ctx context.Context, b Backend, markers []string, cutoff time.Time) ([]string, error) {
+	var archives []string
+	for _, key := range markers {
+		body, err := b.Get(ctx, key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // committed since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		var marker stagingMarker
+		if err := json.Unmarshal(body, &marker); err != nil {
+			return nil, fmt.Errorf("invalid staging marker %s: %w", key, err)
+		}
+		if marker.Started.Before(cutoff) {
+			archives = append(archives, strings.TrimSuffix(key, stagingName))
+		}
+	}
+	return archives, nil
+}
+
+// deleteArchive removes the objects of the archive whose staging marker
+// lives under markerDir: data/ and meta/ in the split layout, otherwise the
+// objects directly under the prefix, leaving nested archives alone. The
+// marker goes last, so an interrupted cleanup can be retried.
+func (v *VFS) deleteArchive(ctx context.Context, b Backend, markerDir string) error {
+	marker := markerDir + stagingName
+	if err := v.releaseManifest(ctx, b, markerDir+manifestName); err != nil {
+		return err
+	}
+	if prefix, split := strings.CutSuffix(markerDir, metaDir); split {
+		if err := deleteKeys(ctx, b, prefix+dataDir, func(string) bool { return true }); err != nil {
+			return err
+		}
+	}
+	err := deleteKeys(ctx, b, markerDir, func(key string) bool {
+		return key != marker && !strings.Contains(strings.TrimPrefix(key, markerDir), "/")
+	})
+	if err != nil {
+		return err
+	}
+	return b.Delete(ctx, []string{marker})
+}
+
+// deleteKeys deletes the objects under prefix for which match reports true.
+func deleteKeys(ctx context.Context, b Backend, prefix string, match func(key string) bool) error {
+	return b.List(ctx, prefix, func(objs []Object) error {
+		var keys []string
+		for _, obj := range objs {
+			if match(obj.Key) {
+				keys = append(keys, obj.Key)
+			}
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		return b.Delete(ctx, keys)
+	})
+}
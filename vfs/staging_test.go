@@ -0,0 +1,97 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failAfter makes every PutObject after the first n fail.
+func failAfter(fake *fakeS3, n int32) {
+	var puts atomic.Int32
+	fake.putHook = func() error {
+		if puts.Add(1) > n {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+}
+
+func TestEncode_FailedUploadIsNotRestorable(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		fake := newFakeS3()
+		v := newTestVFS(fake)
+		v.splitLayout = split
+		failAfter(fake, 3)
+
+		const uri = "s3://bucket/half/"
+		if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(5000)), uri, false); err == nil {
+			t.Fatal("expected the encode to fail")
+		}
+		fake.putHook = nil
+		if err := v.RestoreWriter(context.Background(), uri, io.Discard); !errors.Is(err, ErrIncompleteArchive) {
+			t.Errorf("split=%v: expected ErrIncompleteArchive, got %v", split, err)
+		}
+		if err := v.Migrate(context.Background(), uri); !errors.Is(err, ErrIncompleteArchive) {
+			t.Errorf("split=%v: expected migrate to refuse, got %v", split, err)
+		}
+	}
+}
+
+func TestEncode_CommitRemovesStagingMarker(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(3000)), "s3://bucket/done/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	for _, k := range fake.keys("bucket") {
+		if strings.HasSuffix(k, stagingName) {
+			t.Errorf("expected no staging marker after commit, found %s", k)
+		}
+	}
+}
+
+func TestCleanup_RemovesAbandonedUploads(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), "s3://bucket/jobs/ok/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	// An abandoned upload with a nested archive below it.
+	failAfter(fake, 2)
+	v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), "s3://bucket/jobs/old/", false)
+	fake.putHook = nil
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(3000)), "s3://bucket/jobs/old/nested/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	removed, err := v.Cleanup(ctx, "s3://bucket/jobs/", time.Hour)
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("expected a fresh upload to be kept, got %v, %v", removed, err)
+	}
+	removed, err = v.Cleanup(ctx, "s3://bucket/jobs/", 0)
+	if err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != "jobs/old/" {
+		t.Errorf("expected jobs/old/ removed, got %v", removed)
+	}
+	for _, k := range fake.keys("bucket") {
+		rest, ok := strings.CutPrefix(k, "jobs/old/")
+		if ok && !strings.HasPrefix(rest, "nested/") {
+			t.Errorf("expected %s to be deleted", k)
+		}
+	}
+	for _, uri := range []string{"s3://bucket/jobs/ok/", "s3://bucket/jobs/old/nested/"} {
+		if err := v.RestoreWriter(ctx, uri, io.Discard); err != nil {
+			t.Errorf("expected %s to survive cleanup, got %v", uri, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNoManifest is returned by Stat for archives written before manifests.
+var ErrNoManifest = errors.New("archive has no manifest")
+
+// Stat returns the manifest of the archive at uri without reading any
+// chunk. Archives written before manifests fail with ErrNoManifest until
+// Migrate adds one.
+func (v *VFS) Stat(ctx context.Context, uri string) (*Manifest, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkCommitted(ctx, b, prefix, dataPrefix); err != nil {
+		return nil, err
+	}
+	m, err := v.readManifest(ctx, b, manifestKey(prefix, dataPrefix))
+	if err != nil || m != nil {
+		return m, err
+	}
+	exists, err := v.hasObjects(ctx, b, dataPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("%w at %s (run migrate to add one)", ErrNoManifest, uri)
+	}
+	return nil, fmt.Errorf("%w at %s", ErrNoChunks, uri)
+}
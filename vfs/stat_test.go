@@ -0,0 +1,46 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStat_ReadsManifest(t *testing.T) {
+	fake := newFakeS3()
+	v := newEncryptedTestVFS(fake, "pass")
+	v.compression = CompressionGzip
+	ctx := context.Background()
+	const uri = "s3://bucket/stat/"
+	data := textPayload(4000)
+	before := time.Now().Add(-time.Second)
+	if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(ctx, writeTempFile(t, data[:10]), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	m, err := v.Stat(ctx, uri)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if m.Size != int64(len(data)+10) || m.Filename != "input.bin" || m.Compression != CompressionGzip || m.Encryption == nil {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+	if m.Created == nil || m.Created.Before(before) {
+		t.Errorf("expected the encode time to survive the append, got %v", m.Created)
+	}
+}
+
+func TestStat_MissingManifest(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	fake.objects["bucket/"+chunkKey("legacy/", 1, []byte("x"))] = nil
+	if _, err := v.Stat(context.Background(), "s3://bucket/legacy/"); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("expected ErrNoManifest, got %v", err)
+	}
+	if _, err := v.Stat(context.Background(), "s3://bucket/empty/"); !errors.Is(err, ErrNoChunks) {
+		t.Errorf("expected ErrNoChunks, got %v", err)
+	}
+}
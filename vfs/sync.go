@@ -0,0 +1,115 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SyncReport lists what Sync did, by path relative to the directory.
+type SyncReport struct {
+	Uploaded  []string
+	Unchanged []string
+	Deleted   []string // archives with no local file left, if deleting
+}
+
+// Sync stores every regular file below dir as its own archive under uri,
+// at "<uri><relative path>/". Files whose archive's manifest already holds
+// the same SHA-256 are skipped; changed files are encoded again, replacing
+// their archive. If deleteRemoved is set, archives under uri without a
+// local file are deleted. Archives that cannot be read, for example
+// encrypted ones without the key, count as changed.
+func (v *VFS) Sync(ctx context.Context, dir, uri string, deleteRemoved bool) (*SyncReport, error) {
+	scheme, bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	base := fmt.Sprintf("%s://%s/%s", scheme, bucket, prefix)
+	entries, err := v.List(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		remote[strings.TrimSuffix(strings.TrimPrefix(e.URI, base), "/")] = e
+	}
+
+	report := &SyncReport{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		e, exists := remote[rel]
+		delete(remote, rel)
+		if exists && e.Manifest != nil {
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			if sum == e.Manifest.SHA256 {
+				report.Unchanged = append(report.Unchanged, rel)
+				return nil
+			}
+		}
+		if err := v.Encode(ctx, path, base+rel+"/", true); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		report.Uploaded = append(report.Uploaded, rel)
+		return nil
+	})
+	if err != nil || !deleteRemoved {
+		return report, err
+	}
+	for _, e := range entries {
+		rel := strings.TrimSuffix(strings.TrimPrefix(e.URI, base), "/")
+		if _, gone := remote[rel]; !gone {
+			continue
+		}
+		if err := v.deleteOne(ctx, e.URI); err != nil {
+			return report, err
+		}
+		report.Deleted = append(report.Deleted, rel)
+	}
+	return report, nil
+}
+
+// deleteOne deletes the archive at uri but not archives nested below it,
+// which Delete would remove too.
+func (v *VFS) deleteOne(ctx context.Context, uri string) error {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	return v.deleteArchive(ctx, b, metaKey(prefix, dataPrefix, ""))
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,78 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSync_SkipsUnchangedAndDeletesRemoved(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", randomBytes(100))
+	write("sub/b.bin", randomBytes(2000))
+	write("sub/c.bin", randomBytes(10))
+
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/backup/"
+	r, err := v.Sync(ctx, dir, uri, false)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if !slices.Equal(r.Uploaded, []string{"a.txt", "sub/b.bin", "sub/c.bin"}) || len(r.Unchanged) != 0 {
+		t.Fatalf("expected every file to be uploaded, got %+v", r)
+	}
+
+	changed := randomBytes(300)
+	write("sub/b.bin", changed)
+	if err := os.Remove(filepath.Join(dir, "sub/c.bin")); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = v.Sync(ctx, dir, uri, true); err != nil {
+		t.Fatalf("second sync failed: %v", err)
+	}
+	if !slices.Equal(r.Uploaded, []string{"sub/b.bin"}) || !slices.Equal(r.Unchanged, []string{"a.txt"}) || !slices.Equal(r.Deleted, []string{"sub/c.bin"}) {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	entries, err := v.List(ctx, uri)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected two archives left, got %+v, %v", entries, err)
+	}
+	m, err := v.Stat(ctx, uri+"sub/b.bin/")
+	if err != nil || m.Size != int64(len(changed)) {
+		t.Errorf("expected the changed file's archive, got %+v, %v", m, err)
+	}
+}
+
+func TestDeleteOne_KeepsNestedArchives(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1000)), "s3://bucket/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(1000)), "s3://bucket/a/b/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.deleteOne(ctx, "s3://bucket/a/"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	entries, err := v.List(ctx, "s3://bucket/")
+	if err != nil || len(entries) != 1 || entries[0].URI != "s3://bucket/a/b/" {
+		t.Errorf("expected only the nested archive to remain, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,19 @@
+package vfs
+
+import (
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans of this package.
+const tracerName = "github.com/vjeffz/vfs/vfs"
+
+// WithTracerProvider records OpenTelemetry spans with tp: one for each
+// Encode, EncodeReader, Restore, RestoreWriter and Delete, and below it
+// one for each request to the store (vfs.backend.Put, Get, List, Delete),
+// so a slow chunk shows up as a slow Put or Get with its key. Spans are
+// children of the span in the context passed to the operation.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
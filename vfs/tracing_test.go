@@ -0,0 +1,69 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTracedTestVFS(fake *fakeS3) (*VFS, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	v := newTestVFS(fake)
+	v.tracer = tp.Tracer(tracerName)
+	return v, rec
+}
+
+func TestTracing_SpansPerOperationAndRequest(t *testing.T) {
+	fake := newFakeS3()
+	v, rec := newTracedTestVFS(fake)
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, bytes.NewReader(randomBytes(5000)), "s3://bucket/f/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var op sdktrace.ReadOnlySpan
+	puts := 0
+	for _, s := range rec.Ended() {
+		switch s.Name() {
+		case "vfs.EncodeReader":
+			op = s
+		case "vfs.backend.Put":
+			puts++
+		}
+	}
+	if op == nil {
+		t.Fatal("expected a vfs.EncodeReader span")
+	}
+	if puts < 2 {
+		t.Errorf("expected a span per chunk put, got %d", puts)
+	}
+	for _, s := range rec.Ended() {
+		if s != op && s.Parent().SpanID() != op.SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of the operation", s.Name())
+		}
+	}
+}
+
+func TestTracing_RecordsErrors(t *testing.T) {
+	fake := newFakeS3()
+	v, rec := newTracedTestVFS(fake)
+	err := v.RestoreWriter(context.Background(), "s3://bucket/missing/", &bytes.Buffer{})
+	if !errors.Is(err, ErrNoChunks) {
+		t.Fatalf("expected ErrNoChunks, got %v", err)
+	}
+	for _, s := range rec.Ended() {
+		if s.Name() == "vfs.RestoreWriter" {
+			if s.Status().Code != codes.Error {
+				t.Errorf("expected an error status, got %v", s.Status())
+			}
+			return
+		}
+	}
+	t.Error("expected a vfs.RestoreWriter span")
+}
@@ -0,0 +1,187 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TransferReport lists the archives Transfer found, by their source URIs.
+type TransferReport struct {
+	Copied  []string
+	Skipped []string // already at the destination with the same checksum
+}
+
+// Transfer copies every archive stored at or below srcURI, with its
+// versions and snapshots, to the same place below dstURI, which may be in
+// another bucket or on another backend. Each archive is copied like Copy
+// does, streaming chunks from one store to the other, and then verified
+// like Verify does before the next one starts, so nothing is written to
+// local disk and a copy that does not match its manifest stops the
+// transfer with ErrManifestMismatch. Key-only chunks too long for the
+// destination prefix or its backend's key limit are restored and encoded
+// again there instead, streamed through memory. Archives already at the
+// destination with the same checksum are skipped, so an interrupted
+// transfer can be run again; any other data in the way fails with
+// ErrPrefixExists. Archives are found by their manifests, so those written
+// before manifests need Migrate first, and an unfinished upload fails with
+// ErrIncompleteArchive (see Cleanup). The source is left as it is.
+func (v *VFS) Transfer(ctx context.Context, srcURI, dstURI string) (*TransferReport, error) {
+	t, err := v.openTransfer(ctx, srcURI, dstURI)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := transferDirs(ctx, t.src, t.srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("%w at or below %s", ErrNoManifest, srcURI)
+	}
+
+	r := &TransferReport{}
+	for _, dir := range dirs {
+		copied, err := v.transferArchive(ctx, t, dir, false)
+		if err != nil {
+			return r, err
+		}
+		if copied {
+			r.Copied = append(r.Copied, t.from(dir))
+		} else {
+			r.Skipped = append(r.Skipped, t.from(dir))
+		}
+	}
+	return r, nil
+}
+
+// transfer is a source and a destination tree of archives.
+type transfer struct {
+	src, dst             Backend
+	srcPrefix, dstPrefix string
+	srcRoot, dstRoot     string // URIs of the buckets
+}
+
+func (v *VFS) openTransfer(ctx context.Context, srcURI, dstURI string) (*transfer, error) {
+	if err := checkDistinct(srcURI, dstURI); err != nil {
+		return nil, err
+	}
+	srcScheme, srcBucket, _, err := parseURI(srcURI)
+	if err != nil {
+		return nil, err
+	}
+	dstScheme, dstBucket, _, err := parseURI(dstURI)
+	if err != nil {
+		return nil, err
+	}
+	t := &transfer{
+		srcRoot: fmt.Sprintf("%s://%s/", srcScheme, srcBucket),
+		dstRoot: fmt.Sprintf("%s://%s/", dstScheme, dstBucket),
+	}
+	if t.src, t.srcPrefix, err = v.open(ctx, srcURI); err != nil {
+		return nil, err
+	}
+	if t.dst, t.dstPrefix, err = v.open(ctx, dstURI); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// from and to return the URIs of the archive at dir, relative to the
+// prefixes of t, in the source and the destination.
+func (t *transfer) from(dir string) string { return t.srcRoot + t.srcPrefix + dir }
+func (t *transfer) to(dir string) string   { return t.dstRoot + t.dstPrefix + dir }
+
+// transferArchive copies the archive at dir, relative to the prefixes of
+// t, to the destination and verifies the copy. It reports false if the
+// destination already held the same archive. With replace, whatever else
+// is at the destination is deleted first, except the versions and
+// snapshots there, which are archives of their own.
+func (v *VFS) transferArchive(ctx context.Context, t *transfer, dir string, replace bool) (bool, error) {
+	src, dst := t.src, t.dst
+	srcPrefix, dstPrefix := t.srcPrefix+dir, t.dstPrefix+dir
+	from, to := t.from(dir), t.to(dir)
+	srcData, err := v.chunkPrefix(ctx, src, srcPrefix)
+	if err != nil {
+		return false, err
+	}
+	if err := v.checkCommitted(ctx, src, srcPrefix, srcData); err != nil {
+		return false, fmt.Errorf("%s: %w", from, err)
+	}
+	m, err := v.readManifest(ctx, src, manifestKey(srcPrefix, srcData))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", from, err)
+	}
+	dstData, err := v.chunkPrefix(ctx, dst, dstPrefix)
+	if err != nil {
+		return false, err
+	}
+	existing, err := v.readManifest(ctx, dst, manifestKey(dstPrefix, dstData))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", to, err)
+	}
+	if existing != nil && m != nil && existing.SHA256 == m.SHA256 && existing.Size == m.Size {
+		v.debug(ctx, "already transferred", "uri", to)
+		return false, nil
+	}
+
+	if replace {
+		if err := v.checkUnlocked(ctx, dst, dstPrefix, to, true); err != nil {
+			return false, err
+		}
+		if err := v.deleteUnder(ctx, dst, dstPrefix, true); err != nil {
+			return false, err
+		}
+		err = v.copyArchive(ctx, from, to)
+	} else {
+		err = v.Copy(ctx, from, to)
+	}
+	if errors.Is(err, errChunkTooLong) {
+		// Nothing was written: key-only chunks sized for the source
+		// prefix do not fit under the destination one.
+		err = v.reencode(ctx, from, to)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", from, err)
+	}
+	r, err := v.Verify(ctx, to)
+	if err != nil {
+		return false, err
+	}
+	if !r.OK() {
+		return false, fmt.Errorf("%w: copy at %s: %s", ErrManifestMismatch, to, strings.Join(r.Problems, "; "))
+	}
+	return true, nil
+}
+
+// transferDirs returns the directories, relative to prefix, of the
+// archives at or below prefix of b, their versions and snapshots
+// included, sorted so that an archive comes before those nested in it.
+func transferDirs(ctx context.Context, b Backend, prefix string) ([]string, error) {
+	manifests := make(map[string]bool)
+	split := make(map[string]bool)
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			if dir, ok := archiveDir(obj.Key, prefix, manifestName); ok {
+				manifests[dir] = true
+			}
+			if dir, ok := archiveDir(obj.Key, prefix, layoutMarker); ok {
+				split[dir] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(manifests))
+	for dir := range manifests {
+		if p, ok := strings.CutSuffix(dir, metaDir); ok && split[p] {
+			dir = p
+		}
+		dirs = append(dirs, strings.TrimPrefix(dir, prefix))
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
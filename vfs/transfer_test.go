@@ -0,0 +1,115 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransfer_CopiesArchivesToAnotherBackend(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	// Key-only chunks would not fit in file names.
+	v.versioning, v.bodyThreshold = true, 1
+	ctx := context.Background()
+	files := map[string][]byte{
+		"s3://bucket/data/a/":   randomBytes(3000),
+		"s3://bucket/data/b/c/": randomBytes(500),
+	}
+	for uri, data := range files {
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v.splitLayout = true
+	if err := v.Encode(ctx, writeTempFile(t, files["s3://bucket/data/a/"]), "s3://bucket/data/a/", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.CreateSnapshot(ctx, "s3://bucket/data/b/c/", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := "file://" + t.TempDir() + "/moved/"
+	r, err := v.Transfer(ctx, "s3://bucket/data/", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both archives, the version of a and the snapshot of c.
+	if len(r.Copied) != 4 || len(r.Skipped) != 0 {
+		t.Errorf("expected 4 archives copied, got %+v", r)
+	}
+	for uri, data := range files {
+		var out bytes.Buffer
+		to := dst + uri[len("s3://bucket/data/"):]
+		if err := v.RestoreWriter(ctx, to, &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("expected %s to restore, got %d bytes, %v", to, out.Len(), err)
+		}
+	}
+	if snaps, err := v.Snapshots(ctx, dst+"b/c/"); err != nil || len(snaps) != 1 {
+		t.Errorf("expected the snapshot to be copied, got %v, %v", snaps, err)
+	}
+
+	r, err = v.Transfer(ctx, "s3://bucket/data/", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Copied) != 0 || len(r.Skipped) != 4 {
+		t.Errorf("expected a second transfer to skip everything, got %+v", r)
+	}
+}
+
+func TestTransfer_RefusesOtherDataAndOverlap(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://bucket/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(100)), "s3://other/a/", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Transfer(ctx, "s3://bucket/", "s3://other/"); !errors.Is(err, ErrPrefixExists) {
+		t.Errorf("expected ErrPrefixExists for a different archive in the way, got %v", err)
+	}
+	if _, err := v.Transfer(ctx, "s3://bucket/", "s3://bucket/copy/"); err == nil {
+		t.Error("expected overlapping URIs to be refused")
+	}
+	if _, err := v.Transfer(ctx, "s3://bucket/none/", "s3://other/none/"); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("expected ErrNoManifest for an empty prefix, got %v", err)
+	}
+}
+
+func TestTransfer_ReencodesKeyOnlyChunksThatDoNotFit(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.versioning = true
+	ctx := context.Background()
+	data := randomBytes(5000)
+	for _, gen := range [][]byte{randomBytes(3000), data} {
+		if err := v.Encode(ctx, writeTempFile(t, gen), "s3://bucket/a/", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m, err := v.Stat(ctx, "s3://bucket/a/"); err != nil || m.Chunks < 2 {
+		t.Fatalf("expected several chunks, got %+v, %v", m, err)
+	}
+
+	// A longer prefix in the same store, and file names of 255 bytes.
+	for _, dst := range []string{"s3://other/backups/2026/a-much-longer-prefix/", "file://" + t.TempDir() + "/"} {
+		r, err := v.Transfer(ctx, "s3://bucket/", dst)
+		if err != nil {
+			t.Fatalf("transfer to %s failed: %v", dst, err)
+		}
+		if len(r.Copied) != 2 {
+			t.Errorf("expected the archive and its version copied to %s, got %+v", dst, r)
+		}
+		var out bytes.Buffer
+		if err := v.RestoreWriter(ctx, dst+"a/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("expected %sa/ to restore, got %d bytes, %v", dst, out.Len(), err)
+		}
+		if r, err := v.Transfer(ctx, "s3://bucket/", dst); err != nil || len(r.Skipped) != 2 {
+			t.Errorf("expected a second transfer to %s to skip everything, got %+v, %v", dst, r, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package vfs
+
+import (
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// ConnPool sizes the HTTP connection pool used for S3 requests. The SDK
+// default keeps only 10 idle connections per host, so with more workers
+// than that connections are constantly torn down and re-dialed. Zero fields
+// are derived from the concurrency:
+//
+//	MaxIdleConns        max(100, 2 × concurrency)
+//	MaxIdleConnsPerHost concurrency (at least 10)
+//	MaxConnsPerHost     unlimited
+type ConnPool struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+}
+
+// WithConnPool overrides the HTTP connection pool sizing.
+func WithConnPool(p ConnPool) Option {
+	return func(o *options) {
+		o.connPool = p
+	}
+}
+
+func (p ConnPool) withDefaults(concurrency int) ConnPool {
+	if p.MaxIdleConns == 0 {
+		p.MaxIdleConns = max(awshttp.DefaultHTTPTransportMaxIdleConns, 2*concurrency)
+	}
+	if p.MaxIdleConnsPerHost == 0 {
+		p.MaxIdleConnsPerHost = max(awshttp.DefaultHTTPTransportMaxIdleConnsPerHost, concurrency)
+	}
+	return p
+}
+
+func newHTTPClient(p ConnPool) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		tr.MaxIdleConns = p.MaxIdleConns
+		tr.MaxIdleConnsPerHost = p.MaxIdleConnsPerHost
+		tr.MaxConnsPerHost = p.MaxConnsPerHost
+	})
+}
@@ -0,0 +1,74 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestConnPool_Defaults(t *testing.T) {
+	p := ConnPool{}.withDefaults(64)
+	if p.MaxIdleConns != 128 || p.MaxIdleConnsPerHost != 64 || p.MaxConnsPerHost != 0 {
+		t.Errorf("unexpected defaults for concurrency 64: %+v", p)
+	}
+	p = ConnPool{}.withDefaults(4)
+	if p.MaxIdleConns != 100 || p.MaxIdleConnsPerHost != 10 {
+		t.Errorf("expected SDK defaults as a floor, got %+v", p)
+	}
+	p = ConnPool{MaxConnsPerHost: 32}.withDefaults(64)
+	if p.MaxConnsPerHost != 32 {
+		t.Errorf("expected explicit MaxConnsPerHost to be kept, got %+v", p)
+	}
+}
+
+// benchmarkPutObject issues empty PutObject calls from 64 workers against a
+// local server, the request pattern of a key-only encode.
+func benchmarkPutObject(b *testing.B, pool ConnPool) {
+	const workers = 64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   newHTTPClient(pool.withDefaults(workers)),
+	})
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				key := fmt.Sprintf("bench/%d-AAAA", i)
+				if _, err := client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: &key}); err != nil {
+					b.Error(err)
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func BenchmarkPutObject_SDKDefaultPool(b *testing.B) {
+	benchmarkPutObject(b, ConnPool{MaxIdleConns: 100, MaxIdleConnsPerHost: 10})
+}
+
+func BenchmarkPutObject_SizedPool(b *testing.B) {
+	benchmarkPutObject(b, ConnPool{})
+}
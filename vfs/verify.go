@@ -0,0 +1,126 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// maxListedIndices caps how many indices a problem message spells out.
+const maxListedIndices = 20
+
+// VerifyReport is the result of checking a stored archive.
+type VerifyReport struct {
+	Chunks      int
+	Size        int64  // of the file the chunks decode (and decompress) to
+	SHA256      string // of the same, in index order
+	Checksummed int    // chunks whose key carries a checksum
+	Manifest    *Manifest
+	Problems    []string
+}
+
+// OK reports whether the archive passed every check.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify audits the archive at uri without writing it anywhere: it checks
+// that chunk indices run from 1 without gaps or duplicates, that every
+// chunk decodes and matches its key checksum, and that the result matches
+// the manifest. Problems with the data are listed in the report; the error
+// is only for failures to read the archive.
+func (v *VFS) Verify(ctx context.Context, uri string) (*VerifyReport, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	chunks, m, err := v.archiveChunks(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%w at %s", ErrNoChunks, uri)
+	}
+
+	r := &VerifyReport{Chunks: len(chunks), Manifest: m}
+	missing, duplicate := indexGaps(chunks)
+	if len(missing) > 0 {
+		r.Problems = append(r.Problems, fmt.Sprintf("missing chunk indices %s", formatIndices(missing)))
+	}
+	if len(duplicate) > 0 {
+		r.Problems = append(r.Problems, fmt.Sprintf("duplicate chunk indices %s", formatIndices(duplicate)))
+	}
+
+	h := sha256.New()
+	file := &countingWriter{w: h}
+	out := decompressTo(file, m.compression())
+	defer out.Close()
+	var bad []int
+	for _, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := c.decode()
+		if err != nil {
+			bad = append(bad, c.index)
+			continue
+		}
+		if c.checksum != "" {
+			r.Checksummed++
+		}
+		out.Write(data) // a broken stream is reported by Close
+	}
+	if len(bad) > 0 {
+		r.Problems = append(r.Problems, fmt.Sprintf("chunks that fail to decode or match their checksum: %s", formatIndices(bad)))
+	}
+	if err := out.Close(); err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("%s stream does not decompress: %v", m.Compression, err))
+	}
+	r.Size, r.SHA256 = file.n, hex.EncodeToString(h.Sum(nil))
+
+	if m != nil {
+		if m.Chunks != r.Chunks {
+			r.Problems = append(r.Problems, fmt.Sprintf("manifest lists %d chunks, found %d", m.Chunks, r.Chunks))
+		}
+		if m.Size != r.Size {
+			r.Problems = append(r.Problems, fmt.Sprintf("manifest lists %d bytes, chunks hold %d", m.Size, r.Size))
+		}
+		if m.SHA256 != r.SHA256 {
+			r.Problems = append(r.Problems, fmt.Sprintf("manifest SHA-256 %s does not match %s", m.SHA256, r.SHA256))
+		}
+	}
+	return r, nil
+}
+
+// indexGaps returns the indices missing from 1 to the highest index of the
+// sorted chunks, and the indices that occur more than once.
+func indexGaps(chunks []storedChunk) (missing, duplicate []int) {
+	next := 1
+	for i, c := range chunks {
+		if i > 0 && c.index == chunks[i-1].index {
+			if len(duplicate) == 0 || duplicate[len(duplicate)-1] != c.index {
+				duplicate = append(duplicate, c.index)
+			}
+			continue
+		}
+		for ; next < c.index; next++ {
+			missing = append(missing, next)
+			if len(missing) > maxListedIndices {
+				// Skip ahead; a bogus index would otherwise
+				// make this list enormous.
+				next = c.index
+				break
+			}
+		}
+		next = c.index + 1
+	}
+	return missing, duplicate
+}
+
+func formatIndices(indices []int) string {
+	if len(indices) > maxListedIndices {
+		return fmt.Sprintf("%v and more", indices[:maxListedIndices])
+	}
+	return fmt.Sprint(indices)
+}
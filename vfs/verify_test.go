@@ -0,0 +1,60 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVerify_HealthyArchive(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	data := randomBytes(4000)
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(data), "s3://bucket/ok/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	r, err := v.Verify(context.Background(), "s3://bucket/ok/")
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !r.OK() || r.Manifest == nil || r.Size != int64(len(data)) || r.Checksummed != r.Chunks {
+		t.Errorf("unexpected report: %+v", r)
+	}
+}
+
+func TestVerify_ReportsProblems(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	chunkSize := calculateChunkSize("bad/")
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(5*chunkSize)), "s3://bucket/bad/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	for _, k := range fake.keys("bucket") {
+		if strings.HasPrefix(k, "bad/000002-") {
+			delete(fake.objects, "bucket/"+k)
+		}
+	}
+	fake.objects["bucket/"+chunkKey("bad/", 4, []byte("again"))] = nil
+	fake.objects["bucket/bad/5-AAAA.00000000"] = nil
+
+	r, err := v.Verify(context.Background(), "s3://bucket/bad/")
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	problems := strings.Join(r.Problems, "\n")
+	for _, want := range []string{"missing chunk indices [2]", "duplicate chunk indices [4 5]", "checksum: [5]", "manifest lists 5 chunks, found 6"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("expected problem %q in:\n%s", want, problems)
+		}
+	}
+}
+
+func TestIndexGaps(t *testing.T) {
+	chunks := []storedChunk{{index: 1}, {index: 3}, {index: 3}, {index: 6}}
+	missing, duplicate := indexGaps(chunks)
+	if !slices.Equal(missing, []int{2, 4, 5}) || !slices.Equal(duplicate, []int{3}) {
+		t.Errorf("got missing %v, duplicate %v", missing, duplicate)
+	}
+}
@@ -0,0 +1,83 @@
+package vfs
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X github.com/vjeffz/vfs/vfs.version=v1.4.0
+//	    -X github.com/vjeffz/vfs/vfs.commit=$(git rev-parse HEAD)
+//	    -X github.com/vjeffz/vfs/vfs.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo describes the build of vfs in use.
+type BuildInfo struct {
+	Version   string `json:"version"`            // release tag, or "dev"
+	Commit    string `json:"commit,omitempty"`   // VCS revision
+	Date      string `json:"date,omitempty"`     // build or commit time, RFC 3339
+	GoVersion string `json:"go_version"`         // Go release it was built with
+	Platform  string `json:"platform"`           // GOOS/GOARCH
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+}
+
+// Version returns the version, commit and build date of vfs. Values not set
+// at link time come from the build information Go embeds in binaries: the
+// module version when vfs was installed as a dependency or with go install,
+// and the revision and commit time when built from a checkout.
+func Version() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" {
+		if v := moduleVersion(bi); v != "" && v != "(devel)" {
+			info.Version = v
+		}
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true" && commit == ""
+		}
+	}
+	return info
+}
+
+// moduleVersion returns the version of the vfs module in bi, which is the
+// main module for the CLI and a dependency for programs using the library.
+func moduleVersion(bi *debug.BuildInfo) string {
+	const path = "github.com/vjeffz/vfs"
+	if bi.Main.Path == path {
+		return bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
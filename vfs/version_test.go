@@ -0,0 +1,28 @@
+package vfs
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestVersion_LinkerValues(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "v1.4.0", "0123abc", "2026-01-02T03:04:05Z"
+
+	info := Version()
+	if info.Version != "v1.4.0" || info.Commit != "0123abc" || info.Date != "2026-01-02T03:04:05Z" {
+		t.Errorf("expected the linked values, got %+v", info)
+	}
+	if info.Modified {
+		t.Error("expected a linked commit not to be reported as modified")
+	}
+	if info.GoVersion != runtime.Version() || info.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("expected the Go toolchain and platform, got %+v", info)
+	}
+}
+
+func TestVersion_DefaultsToDev(t *testing.T) {
+	if got := Version().Version; got != "dev" {
+		t.Errorf("expected dev without linker values, got %q", got)
+	}
+}
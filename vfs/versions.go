@@ -0,0 +1,164 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// versionsDir holds the earlier generations of an archive, at
+// <prefix>.vfs-versions/v<N>/.
+const versionsDir = ".vfs-versions/"
+
+// ArchiveVersion is an earlier generation of an archive, kept by WithVersioning.
+type ArchiveVersion struct {
+	Number int
+	Entry
+}
+
+// WithVersioning makes overwriting an archive (Encode with force, or a
+// changed file in Sync) keep the previous generation instead of deleting
+// it. Generations are numbered v1, v2, … in the order they were replaced
+// and stay readable at VersionURI; Rollback makes one current again.
+// Each version is an archive with its own manifest under
+// <prefix>.vfs-versions/, not an entry in the catalog of EncodeNamed.
+// Delete removes an archive together with all its versions.
+func WithVersioning() Option {
+	return func(o *options) {
+		o.versioning = true
+	}
+}
+
+// VersionURI returns the URI of version n of the archive at uri, which
+// works with Restore, Stat, Verify, and the other read commands.
+func VersionURI(uri string, n int) (string, error) {
+	if n <= 0 {
+		return "", fmt.Errorf("invalid version %d", n)
+	}
+	scheme, bucket, prefix, err := parseURI(uri)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s/%s%sv%d/", scheme, bucket, prefix, versionsDir, n), nil
+}
+
+// Versions returns the kept generations of the archive at uri, oldest
+// first.
+func (v *VFS) Versions(ctx context.Context, uri string) ([]ArchiveVersion, error) {
+	scheme, bucket, _, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	root := fmt.Sprintf("%s://%s/", scheme, bucket)
+	entries, err := v.listArchives(ctx, b, root, prefix+versionsDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	var versions []ArchiveVersion
+	for _, e := range entries {
+		if n, ok := versionNumber(strings.TrimPrefix(e.URI, root+prefix+versionsDir)); ok {
+			versions = append(versions, ArchiveVersion{Number: n, Entry: e})
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	return versions, nil
+}
+
+// Rollback makes version n of the archive at uri current again. The
+// archive it replaces is kept as the newest version, so a rollback can
+// itself be rolled back.
+func (v *VFS) Rollback(ctx context.Context, uri string, n int) error {
+	src, err := VersionURI(uri, n)
+	if err != nil {
+		return err
+	}
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	_, srcPrefix, err := v.open(ctx, src)
+	if err != nil {
+		return err
+	}
+	exists, err := v.hasObjects(ctx, b, srcPrefix)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: no version %d of %s", ErrNoChunks, n, uri)
+	}
+	if err := v.keepVersion(ctx, b, prefix, uri); err != nil {
+		return err
+	}
+	return v.keepCopy(ctx, src, uri)
+}
+
+// keepVersion moves the current archive at prefix, if there is one, to
+// the next free version number.
+func (v *VFS) keepVersion(ctx context.Context, b Backend, prefix, uri string) error {
+	chunks, _, err := v.archiveChunks(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return nil // only earlier versions are left
+	}
+	n, err := nextVersion(ctx, b, prefix)
+	if err != nil {
+		return err
+	}
+	dst, err := VersionURI(uri, n)
+	if err != nil {
+		return err
+	}
+	if err := v.keepCopy(ctx, uri, dst); err != nil {
+		return fmt.Errorf("failed to keep version %d: %w", n, err)
+	}
+	return v.deleteOne(ctx, uri)
+}
+
+// nextVersion returns one more than the highest version kept at prefix.
+func nextVersion(ctx context.Context, b Backend, prefix string) (int, error) {
+	highest := 0
+	err := b.List(ctx, prefix+versionsDir, func(objs []Object) error {
+		for _, obj := range objs {
+			dir, _, _ := strings.Cut(strings.TrimPrefix(obj.Key, prefix+versionsDir), "/")
+			if n, ok := versionNumber(dir + "/"); ok && n > highest {
+				highest = n
+			}
+		}
+		return nil
+	})
+	return highest + 1, err
+}
+
+// versionNumber parses "v<N>/".
+func versionNumber(dir string) (int, bool) {
+	s, ok := strings.CutPrefix(dir, "v")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "/"))
+	if err != nil || n <= 0 || !strings.HasSuffix(s, "/") {
+		return 0, false
+	}
+	return n, true
+}
+
+// keepCopy copies the archive at src to dst for a version, snapshot, or
+// rollback. Key-only chunks sized for src may not fit under a longer dst;
+// the archive is then encoded again there (see reencode).
+func (v *VFS) keepCopy(ctx context.Context, src, dst string) error {
+	err := v.copyArchive(ctx, src, dst)
+	if errors.Is(err, errChunkTooLong) {
+		return v.reencode(ctx, src, dst)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVersioning_KeepsGenerationsAndRollsBack(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.versioning = true
+	ctx := context.Background()
+	const uri = "s3://bucket/config/"
+	gens := [][]byte{[]byte("port = 8080\n"), []byte("port = 9090\n"), randomBytes(500)}
+	for _, data := range gens {
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, true); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+	}
+
+	restore := func(uri string) []byte {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "out")
+		if err := v.Restore(ctx, uri, out); err != nil {
+			t.Fatalf("restore of %s failed: %v", uri, err)
+		}
+		data, _ := os.ReadFile(out)
+		return data
+	}
+	if !bytes.Equal(restore(uri), gens[2]) {
+		t.Error("expected the newest generation to be current")
+	}
+	versions, err := v.Versions(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Number != 1 || versions[1].Number != 2 {
+		t.Fatalf("expected versions 1 and 2, got %+v", versions)
+	}
+	for i, ver := range versions {
+		if !bytes.Equal(restore(ver.URI), gens[i]) {
+			t.Errorf("version %d does not hold generation %d", ver.Number, i+1)
+		}
+	}
+	if entries, _ := v.List(ctx, "s3://bucket/"); len(entries) != 1 {
+		t.Errorf("expected List to leave out versions, got %+v", entries)
+	}
+
+	if err := v.Rollback(ctx, uri, 1); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	if !bytes.Equal(restore(uri), gens[0]) {
+		t.Error("expected version 1 to be current after the rollback")
+	}
+	v3, _ := VersionURI(uri, 3)
+	if !bytes.Equal(restore(v3), gens[2]) {
+		t.Error("expected the replaced generation to be kept as version 3")
+	}
+	if err := v.Rollback(ctx, uri, 7); err == nil {
+		t.Error("expected rolling back to a missing version to fail")
+	}
+
+	if err := v.Delete(ctx, uri); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fake.keys("bucket"); len(keys) != 0 {
+		t.Errorf("expected Delete to remove the versions too, got %v", keys)
+	}
+}
+
+func TestVersioning_KeepsMultiChunkKeyOnlyArchive(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.versioning = true
+	ctx := context.Background()
+	const uri = "s3://bucket/db/"
+	// Key-only chunks sized for uri do not fit under the longer version
+	// prefix, so the version is encoded again there.
+	old := randomBytes(5000)
+	for _, data := range [][]byte{old, randomBytes(5000)} {
+		if err := v.Encode(ctx, writeTempFile(t, data), uri, true); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+	}
+	if m, err := v.Stat(ctx, uri); err != nil || m.Chunks < 2 {
+		t.Fatalf("expected several chunks, got %+v, %v", m, err)
+	}
+	v1, _ := VersionURI(uri, 1)
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, v1, &out); err != nil || !bytes.Equal(out.Bytes(), old) {
+		t.Errorf("expected version 1 to hold the replaced generation, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestVersioning_EncodeReaderWithForceKeepsVersion(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	v.versioning = true
+	ctx := context.Background()
+	const uri = "s3://bucket/stream/"
+	old, current := randomBytes(500), randomBytes(700)
+	if err := v.EncodeReader(ctx, bytes.NewReader(old), uri, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(current), uri, false); !errors.Is(err, ErrPrefixExists) {
+		t.Fatalf("expected ErrPrefixExists without force, got %v", err)
+	}
+	if err := v.EncodeReader(ctx, bytes.NewReader(current), uri, true); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := v.Versions(ctx, uri)
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("expected the old stream kept as a version, got %+v, %v", versions, err)
+	}
+	for u, want := range map[string][]byte{uri: current, versions[0].URI: old} {
+		var out bytes.Buffer
+		if err := v.RestoreWriter(ctx, u, &out); err != nil || !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: expected %d bytes, got %d, %v", u, len(want), out.Len(), err)
+		}
+	}
+}
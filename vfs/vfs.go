@@ -1,318 +1,1327 @@
 package vfs
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"net/url"
+	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrOutputPathInvalid = errors.New("invalid output path")
+	ErrNoChunks          = errors.New("no chunks found")
+	ErrChunkChecksum     = errors.New("chunk checksum mismatch")
+	ErrManifestMismatch  = errors.New("archive does not match its manifest")
+	ErrChunkGap          = errors.New("chunk indices are not contiguous")
+	ErrIncompleteArchive = errors.New("archive upload did not finish")
+	ErrPrefixExists      = errors.New("prefix already contains data")
+	ErrTooManyChunks     = errors.New("file needs more chunks than an index can number")
+	ErrNoFilename        = errors.New("archive records no filename")
+
+	errChunkSizeTooSmall = errors.New("calculated chunk size is too small for the key length limit")
 )
 
 const (
-	s3MaxKeyLengthBytes = 1024
-	maxIndexLen         = 6
-	defaultConcurrency  = 8
+	maxIndexLen        = 6 // index room reserved by unpadded keys
+	minIndexWidth      = 6 // digits of a zero-padded index
+	maxIndexWidth      = 9
+	defaultConcurrency = 8
+
+	// Below this key-only chunk size the object count explodes, so storing
+	// data in object bodies (bodyChunkSize per object) is recommended.
+	minKeyChunkSize = 64
+	bodyChunkSize   = 8 << 20
 )
 
 type VFS struct {
-	client      *s3.Client
+	client      s3API
+	backends    map[string]BackendFactory
+	mirrors     []string
+	readRepair  bool
 	concurrency int
+	limiter     *aimdLimiter
+	retry       RetryPolicy
+	rate        *rate.Limiter
+	splitLayout bool
+	resume      bool
+	bestEffort  bool
+	allowGaps   bool
+	keys        keyWrapper
+	compression Compression
+	obfuscate   bool
+	// Files of at least bodyThreshold bytes are stored in object bodies,
+	// and with autoMode so are those whose key-only chunks would be tiny.
+	bodyThreshold int64
+	autoMode      bool
+	maxChunkSize  int // caps key-only chunk size when positive
+	versioning    bool
+	delta         bool
+	dedup         bool
+	// noPreserve and preserveOwner choose the file attributes recorded
+	// and restored.
+	noPreserve    bool
+	preserveOwner bool
+	// parityData and parityShards are the stripe layout of new archives,
+	// zero for none.
+	parityData   int
+	parityShards int
+	// storageClass and tagging apply to objects written to S3.
+	storageClass string
+	tagging      string
+	kms          func() (kmsAPI, error)
+	dryRun       *dryRun      // nil unless WithDryRun
+	metrics      *metrics     // nil unless WithMetrics
+	tracer       trace.Tracer // nil unless WithTracerProvider
+	logger       *slog.Logger // nil unless WithLogger
+	audit        *auditLog    // nil unless WithAuditLog
+	asOf         time.Time    // zero unless WithAsOf
+	lock         lockPolicy   // of new S3 objects
+	expiry       time.Duration
+	progress     ProgressFunc
+	progressMu   *sync.Mutex // shared by the copies made by ProgressTo
 }
 
-func New() (*VFS, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func New(opts ...Option) (*VFS, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	concurrency := o.concurrency
+	if concurrency <= 0 {
+		concurrency = getConcurrency()
+	}
+	client, err := o.newS3Client(concurrency)
 	if err != nil {
 		return nil, err
 	}
-	return &VFS{
-		client:      s3.NewFromConfig(cfg),
-		concurrency: getConcurrency(),
-	}, nil
+	v := &VFS{
+		client:        client,
+		concurrency:   concurrency,
+		limiter:       newAIMDLimiter(concurrency),
+		retry:         DefaultRetryPolicy,
+		rate:          newRateLimiter(o.rateLimit),
+		splitLayout:   o.splitLayout,
+		resume:        o.resume,
+		bestEffort:    o.bestEffort,
+		allowGaps:     o.allowGaps,
+		noPreserve:    o.noPreserve,
+		preserveOwner: o.preserveOwner,
+		keys:          o.keys,
+		compression:   o.compression,
+		obfuscate:     o.obfuscate,
+		bodyThreshold: o.bodyThreshold,
+		autoMode:      o.autoMode,
+		maxChunkSize:  o.maxChunkSize,
+		versioning:    o.versioning,
+		delta:         o.delta,
+		dedup:         o.dedup,
+		parityData:    o.parityData,
+		parityShards:  o.parityShards,
+		storageClass:  o.storageClass,
+		tagging:       encodeTags(o.tags),
+		progress:      o.progress,
+		progressMu:    new(sync.Mutex),
+		mirrors:       o.mirrors,
+		readRepair:    o.readRepair,
+	}
+	if o.retry != nil {
+		v.retry = o.retry.withDefaults()
+	}
+	if o.dryRun != nil {
+		v.dryRun = &dryRun{fn: o.dryRun}
+	}
+	if o.metrics != nil {
+		if v.metrics, err = newMetrics(o.metrics); err != nil {
+			return nil, err
+		}
+	}
+	v.logger, v.asOf, v.expiry = o.logger, o.asOf, o.expiry
+	if err := o.lock.check(); err != nil {
+		return nil, err
+	}
+	v.lock = o.lock
+	if o.auditLog != "" {
+		v.audit = newAuditLog(o.auditLog)
+	}
+	if o.tracerProvider != nil {
+		v.tracer = o.tracerProvider.Tracer(tracerName, trace.WithInstrumentationVersion(Version().Version))
+	}
+	v.kms = sync.OnceValues(o.newKMSClient)
+	if o.kmsKeyID != "" {
+		if o.keys != nil {
+			return nil, errors.New("a passphrase and a KMS key cannot both be used")
+		}
+		v.keys = kmsWrapper{keyID: o.kmsKeyID, client: v.kms}
+	}
+	if o.dedup && (v.keys != nil || o.obfuscate) {
+		return nil, errors.New("deduplication cannot be combined with encryption or obfuscation")
+	}
+	if o.parityData != 0 || o.parityShards != 0 {
+		if err := checkParity(o.parityData, o.parityShards); err != nil {
+			return nil, err
+		}
+		if o.dedup {
+			return nil, errors.New("parity chunks cannot be combined with deduplication")
+		}
+	}
+	v.backends = map[string]BackendFactory{"s3": v.openS3, "file": openFile}
+	for scheme, f := range o.backends {
+		v.backends[scheme] = f
+	}
+	return v, nil
 }
 
-func (v *VFS) Encode(inputPath, s3URI string, force bool) error {
-	bucket, prefix, err := parseS3Path(s3URI)
+// Encode stores the file at inputPath at uri. If the prefix already
+// contains data it fails with ErrPrefixExists, unless force is set, in which
+// case everything under the prefix but the archive's versions and snapshots
+// is deleted first so chunks of the old file cannot mingle with the new
+// ones.
+func (v *VFS) Encode(ctx context.Context, inputPath, uri string, force bool) (err error) {
+	ctx, end := v.startOp(ctx, OpEncode, "Encode", uri)
+	defer end(&err)
+	b, prefix, err := v.open(ctx, uri)
 	if err != nil {
 		return err
 	}
-
-	exists, err := v.hasObjects(bucket, prefix)
+	file, err := os.Open(inputPath)
 	if err != nil {
 		return err
 	}
-	if exists && !force {
-		fmt.Printf("⚠️  S3 path s3://%s/%s already contains data. Overwrite? [y/N]: ", bucket, prefix)
-		reader := bufio.NewReader(os.Stdin)
-		resp, _ := reader.ReadString('\n')
-		resp = strings.ToLower(strings.TrimSpace(resp))
-		if resp != "y" {
-			fmt.Println("✋ Upload canceled.")
-			return nil
+	defer file.Close()
+
+	stat, _ := file.Stat()
+	bodies := v.storesBodies(b, v.encodePrefix(prefix), stat.Size())
+	// Fail before force deletes anything if the file cannot be numbered.
+	if _, err := encodeKeyFormat(stat.Size(), b.KeyLimit(v.encodePrefix(prefix)), keyFormat{body: bodies, maxChunk: v.maxChunkSize}); err != nil {
+		return fmt.Errorf("%s: %w", inputPath, err)
+	}
+	if err := v.clearPrefix(ctx, b, prefix, uri, force); err != nil {
+		return err
+	}
+	if chunkSize := backendChunkSize(b, v.encodePrefix(prefix)); bodies && v.autoBodies(b, v.encodePrefix(prefix), stat.Size()) {
+		v.warn(OpEncode, storageModeSwitch(chunkSize, stat.Size()))
+	} else if !bodies && !v.dedup {
+		if advice := storageModeAdvice(chunkSize, stat.Size()); advice != "" {
+			v.warn(OpEncode, advice)
 		}
 	}
-	if exists && force {
-		if err := v.Delete(fmt.Sprintf("s3://%s/%s", bucket, prefix)); err != nil {
-			return fmt.Errorf("failed to delete existing prefix: %w", err)
+	return v.encode(ctx, b, prefix, file, stat.Size(), Manifest{Filename: filepath.Base(inputPath), Attrs: v.fileAttrs(stat)})
+}
+
+// EncodeReader stores everything read from r at uri. Like Encode, it fails
+// with ErrPrefixExists if the prefix already contains data, unless force is
+// set.
+func (v *VFS) EncodeReader(ctx context.Context, r io.Reader, uri string, force bool) (err error) {
+	ctx, end := v.startOp(ctx, OpEncode, "EncodeReader", uri)
+	defer end(&err)
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if err := v.clearPrefix(ctx, b, prefix, uri, force); err != nil {
+		return err
+	}
+	return v.encode(ctx, b, prefix, r, readerSize(r), Manifest{})
+}
+
+// readerSize returns how many bytes r will yield if r can tell, or -1.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		if info, err := r.Stat(); err == nil && info.Mode().IsRegular() {
+			if pos, err := r.Seek(0, io.SeekCurrent); err == nil {
+				return info.Size() - pos
+			}
 		}
 	}
+	return -1
+}
 
-	chunkSize := calculateChunkSize(prefix)
-	if chunkSize < 1 {
-		return fmt.Errorf("calculated chunk size is too small for S3 key constraint")
+// clearPrefix makes sure nothing is stored under prefix before an encode,
+// deleting existing objects if force is set. When resuming, or overwriting
+// with WithDelta, existing objects are kept for encode to pick up.
+func (v *VFS) clearPrefix(ctx context.Context, b Backend, prefix, uri string, force bool) error {
+	exists, err := v.hasObjects(ctx, b, prefix)
+	if err != nil || !exists || v.resume && !v.dedup {
+		return err
+	}
+	if v.resume {
+		// Nothing tells a deduplicated archive of the same file from
+		// another, so only an unfinished upload is resumed.
+		if unfinished, err := v.unfinished(ctx, b, prefix); err != nil || unfinished {
+			return err
+		}
+	}
+	if !force {
+		return fmt.Errorf("%w: %s", ErrPrefixExists, uri)
 	}
+	if err := v.checkUnlocked(ctx, b, prefix, uri, true); err != nil {
+		return err
+	}
+	if v.versioning {
+		return v.keepVersion(ctx, b, prefix, uri)
+	}
+	if v.delta {
+		return nil // encode replaces only the chunks that changed
+	}
+	if err := v.deleteUnder(ctx, b, prefix, true); err != nil {
+		return fmt.Errorf("failed to delete existing prefix: %w", err)
+	}
+	return nil
+}
 
-	file, err := os.Open(inputPath)
+// encode uploads the chunks of r between writing a staging marker and
+// committing the archive, so readers never see a partial upload as a file.
+// Chunks are read as they are uploaded, so memory stays proportional to
+// the concurrency rather than the input. size is -1 if unknown. file holds
+// what the caller knows about the input, such as its name; encode fills in
+// the rest of the manifest.
+func (v *VFS) encode(ctx context.Context, b Backend, prefix string, r io.Reader, size int64, file Manifest) (err error) {
+	if v.dedup {
+		return v.encodeDedup(ctx, b, prefix, r, size, file)
+	}
+	dataPrefix := v.encodePrefix(prefix)
+	aead, enc, err := v.archiveKey(ctx, b, prefix, dataPrefix)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	created := time.Now().UTC()
+	m := &file
+	m.Version, m.Created = manifestVersion, &created
+	if m.Expires == nil {
+		m.Expires = v.expiresAt(created)
+	}
+	m.Compression, m.Obfuscated, m.Encryption, m.aead = v.compression, v.obfuscate, enc, aead
+	bodies := v.storesBodies(b, dataPrefix, size)
+	if bodies {
+		m.Storage = storageBody
+	}
+	streamSize := size
+	if m.Compression != CompressionNone {
+		streamSize = -1
+		size = compressBound(size)
+	}
+	limit := b.KeyLimit(dataPrefix)
+	if v.parityShards > 0 && !bodies {
+		limit -= parityNameExtra
+	}
+	format, err := encodeKeyFormat(size, limit, keyFormat{codec: m.codec(prefix), body: bodies, maxChunk: v.maxChunkSize})
+	if err != nil {
+		return err
+	}
+	chunkSize := format.chunkSize(limit)
+	if chunkSize < 1 {
+		return errChunkSizeTooSmall
+	}
+	m.ChunkSize, m.IndexWidth = chunkSize, format.width
 
-	stat, _ := file.Stat()
-	totalChunks := int(stat.Size()) / chunkSize
-	if stat.Size()%int64(chunkSize) != 0 {
-		totalChunks++
+	var existing map[int]string
+	var stale []string
+	var base map[string]bool // what a delta encode can roll back to
+	switch {
+	case v.resume:
+		if existing, err = v.resumableChunks(ctx, b, prefix, dataPrefix, bodies); err != nil {
+			return err
+		}
+	case v.delta:
+		if existing, stale, err = v.deltaChunks(ctx, b, prefix, dataPrefix, bodies); err != nil {
+			return err
+		}
+		if base, err = v.deltaBase(ctx, b, prefix, dataPrefix); err != nil {
+			return err
+		}
 	}
 
-	var chunks [][]byte
-	buf := make([]byte, chunkSize)
-	for {
-		n, err := file.Read(buf)
-		if n > 0 {
-			copyBuf := make([]byte, n)
-			copy(copyBuf, buf[:n])
-			chunks = append(chunks, copyBuf)
+	sum := sha256.New()
+	h := &countingWriter{w: sum}
+	stream := io.TeeReader(r, h)
+	if v.compression != CompressionNone {
+		compressed := compressReader(stream, v.compression)
+		defer compressed.Close()
+		stream = compressed
+	}
+	src := readerChunks(stream, chunkSize, streamSize)
+	var stored int64
+	next := src.next
+	src.next = func() ([]byte, error) {
+		data, err := next()
+		stored += int64(len(data))
+		if len(data) > 0 {
+			m.Chunks++
 		}
-		if err == io.EOF {
-			break
+		return data, err
+	}
+
+	// The marker goes up with the first new chunk: resuming an archive
+	// that turns out to hold another file must leave it untouched.
+	staging := metaKey(prefix, dataPrefix, stagingName)
+	began := false
+	begin := func() error {
+		began = true
+		return v.beginStaging(ctx, b, staging, enc)
+	}
+	replaced := false
+	if base != nil {
+		defer func() {
+			if err != nil && began && !replaced {
+				err = v.undoDelta(ctx, b, dataPrefix, staging, base, err)
+			}
+		}()
+	}
+	parity := make(map[string]bool) // keys of the parity shards stored
+	if v.parityShards > 0 {
+		m.Parity = &Parity{Data: v.parityData, Parity: v.parityShards}
+		p := &parityWriter{v: v, b: b, prefix: dataPrefix, format: format,
+			code: newErasureCode(v.parityData, v.parityShards), written: parity}
+		p.begin = func() error {
+			if began {
+				return nil
+			}
+			return begin()
 		}
+		chunk := src.next
+		src.next = func() ([]byte, error) {
+			data, err := chunk()
+			if len(data) > 0 {
+				if perr := p.add(ctx, data); perr != nil {
+					return nil, perr
+				}
+			}
+			if err == io.EOF {
+				if perr := p.flush(ctx); perr != nil {
+					return nil, perr
+				}
+			}
+			return data, err
+		}
+	}
+	if err := v.uploadChunks(ctx, OpEncode, b, dataPrefix, src, 1, format, existing, begin); err != nil {
+		return err
+	}
+	// What is left of existing was not reused: replaced chunks when
+	// delta encoding, and chunks past the end of the input.
+	for index, key := range existing {
+		if !v.delta {
+			return fmt.Errorf("cannot resume %s: chunk %d belongs to a different file", prefix, index)
+		}
+		stale = append(stale, key)
+	}
+	if len(parity) > 0 || v.resume || v.delta {
+		keys, err := staleParity(ctx, b, dataPrefix, parity)
 		if err != nil {
 			return err
 		}
+		stale = append(stale, keys...)
 	}
+	if len(stale) > 0 {
+		if !began {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		replaced = true
+		if err := b.Delete(ctx, stale); err != nil {
+			return err
+		}
+	}
+	m.Size, m.SHA256, m.SHA256State = h.n, hex.EncodeToString(sum.Sum(nil)), hashState(sum)
+	if m.Compression != CompressionNone {
+		m.StoredSize = stored
+	}
+
+	if v.splitLayout {
+		if err := v.writeLayoutMarker(ctx, b, prefix); err != nil {
+			return err
+		}
+	}
+	if err := v.writeManifest(ctx, b, manifestKey(prefix, dataPrefix), m); err != nil {
+		return err
+	}
+	return b.Delete(ctx, []string{staging})
+}
+
+// chunkSource yields the chunks to upload one at a time.
+type chunkSource struct {
+	next       func() ([]byte, error) // io.EOF after the last chunk
+	total      int                    // number of chunks, 0 if unknown
+	totalBytes int64
+}
+
+func sliceChunks(chunks [][]byte) chunkSource {
+	src := chunkSource{total: len(chunks)}
+	for _, c := range chunks {
+		src.totalBytes += int64(len(c))
+	}
+	src.next = func() ([]byte, error) {
+		if len(chunks) == 0 {
+			return nil, io.EOF
+		}
+		c := chunks[0]
+		chunks = chunks[1:]
+		return c, nil
+	}
+	return src
+}
+
+// readerChunks splits r into chunks of chunkSize bytes. size is -1 if
+// unknown.
+func readerChunks(r io.Reader, chunkSize int, size int64) chunkSource {
+	src := chunkSource{next: func() ([]byte, error) {
+		buf := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return buf[:n], err
+	}}
+	if size >= 0 {
+		src.total, src.totalBytes = int(chunkCount(size, chunkSize)), size
+	}
+	return src
+}
+
+// uploadChunks stores the chunks from src as keys or body parts in the
+// given format under prefix, numbering them from firstIndex. Chunks whose
+// key is already in existing (by index) are counted as done without being
+// uploaded again and removed from existing; a chunk holding different data
+// there fails the upload, unless delta encoding, as does an index too wide
+// for the format. begin, if not nil, runs once
+// before the first chunk is stored. A chunk is read only once a limiter
+// slot is free for it.
+func (v *VFS) uploadChunks(ctx context.Context, op Op, b Backend, prefix string, src chunkSource, firstIndex int, format keyFormat, existing map[int]string, begin func() error) error {
+	v.emit(Event{Op: op, Kind: EventStart, Total: src.total, BytesTotal: src.totalBytes})
 
-	fmt.Printf("Uploading %d chunks...\n", len(chunks))
 	var wg sync.WaitGroup
-	sem := make(chan struct{}, v.concurrency)
 	var errMu sync.Mutex
 	var firstErr error
+	var done int
+	var bytesDone int64
+	record := func(index int, n int) {
+		errMu.Lock()
+		done++
+		bytesDone += int64(n)
+		e := Event{Op: op, Kind: EventChunk, Index: index, Bytes: int64(n),
+			Done: done, Total: src.total, BytesDone: bytesDone, BytesTotal: src.totalBytes}
+		errMu.Unlock()
+		v.emit(e)
+		opFrom(ctx).count(op, 1, int64(n))
+		v.debug(ctx, "chunk stored", "chunk_index", index, "bytes", n)
+	}
+	fail := func(err error) {
+		errMu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMu.Unlock()
+	}
+	failed := func() bool {
+		errMu.Lock()
+		defer errMu.Unlock()
+		return firstErr != nil
+	}
 
-	for i, chunk := range chunks {
-		sem <- struct{}{}
+	for index := firstIndex; ctx.Err() == nil && !failed(); index++ {
+		data, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+			break
+		}
+		if index > format.maxIndex() {
+			fail(fmt.Errorf("%w: chunk %d does not fit in %d digits", ErrTooManyChunks, index, format.digits()))
+			break
+		}
+		if prev, ok := existing[index]; ok {
+			if format.holds(ctx, b, prefix, prev, index, data) {
+				delete(existing, index)
+				record(index, len(data))
+				continue
+			}
+			if !v.delta {
+				fail(fmt.Errorf("cannot resume %s: chunk %d belongs to a different file", prefix, index))
+				break
+			}
+		}
+		key, body := format.object(prefix, index, data)
+		if begin != nil {
+			if err := begin(); err != nil {
+				fail(err)
+				break
+			}
+			begin = nil
+		}
+		v.limiter.acquire()
 		wg.Add(1)
-		go func(index int, data []byte) {
+		go func() {
 			defer wg.Done()
-			defer func() { <-sem }()
-			encoded := base64.RawURLEncoding.EncodeToString(data)
-			key := path.Join(prefix, fmt.Sprintf("%d-%s", index+1, encoded))
-			_, err := v.client.PutObject(context.TODO(), &s3.PutObjectInput{
-				Bucket: &bucket,
-				Key:    &key,
-				Body:   nil,
-			})
-			if err != nil {
-				errMu.Lock()
-				if firstErr == nil {
-					firstErr = err
-				}
-				errMu.Unlock()
+			if err := v.putWithBackoff(ctx, b, key, body); err != nil {
+				fail(err)
 				return
 			}
-			fmt.Printf("\rUploaded: %d/%d", index+1, len(chunks))
-		}(i, chunk)
+			record(index, len(data))
+		}()
 	}
 
 	wg.Wait()
-	fmt.Println("\n✅ Upload complete.")
-	return firstErr
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	total, totalBytes := src.total, src.totalBytes
+	if total == 0 {
+		total, totalBytes = done, bytesDone
+	}
+	v.emit(Event{Op: op, Kind: EventDone, Done: done, Total: total, BytesDone: bytesDone, BytesTotal: totalBytes})
+	return nil
+}
+
+// putWithBackoff stores body (empty for key-only chunks) at key while
+// holding a limiter slot, which the caller must already have acquired.
+// Throttled requests give their slot back, shrinking the limit, and are
+// retried once a slot frees up again.
+func (v *VFS) putWithBackoff(ctx context.Context, b Backend, key string, body []byte) error {
+	return v.withBackoff(ctx, func() error { return b.Put(ctx, key, body) })
 }
 
-func (v *VFS) Restore(s3URI, outputPath string) error {
-	bucket, prefix, err := parseS3Path(s3URI)
+// Restore writes the file stored at uri to outputPath. It is written to
+// "<outputPath>.vfs-partial" first and renamed once complete, so a failed
+// restore leaves whatever was at outputPath alone; the partial file and
+// its state stay behind for WithResume.
+func (v *VFS) Restore(ctx context.Context, uri, outputPath string) (err error) {
+	ctx, end := v.startOp(ctx, OpRestore, "Restore", uri)
+	defer end(&err)
+	chunks, m, err := v.openArchive(ctx, uri)
 	if err != nil {
 		return err
 	}
 
-	var chunks []struct {
-		index   int
-		encoded string
+	if err := prepareOutputPath(outputPath); err != nil {
+		return err
 	}
 
-	p := s3.NewListObjectsV2Paginator(v.client, &s3.ListObjectsV2Input{
-		Bucket: &bucket,
-		Prefix: &prefix,
-	})
+	st := newRestoreState(outputPath, uri, chunks)
+	out, err := v.openRestoreOutput(st)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
 
-	for p.HasMorePages() {
-		page, err := p.NextPage(context.TODO())
-		if err != nil {
-			return err
-		}
-		for _, obj := range page.Contents {
-			name := strings.TrimPrefix(*obj.Key, prefix)
-			name = strings.TrimPrefix(name, "/")
-			parts := strings.SplitN(name, "-", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			index, err := strconv.Atoi(parts[0])
-			if err != nil {
-				continue
-			}
-			chunks = append(chunks, struct {
-				index   int
-				encoded string
-			}{index, parts[1]})
+	// A best-effort restore that had to skip chunks still delivers the
+	// rest, and reports the skipped ones once the file is in place.
+	var skipped *DecodeError
+	if err := v.restoreTo(ctx, uri, chunks, m, out, st); err != nil && !errors.As(err, &skipped) {
+		if saveErr := st.save(); saveErr != nil {
+			v.warn(OpRestore, "⚠️  failed to save restore state: "+saveErr.Error())
 		}
+		return err
+	}
+	if err := v.applyAttrs(out, m); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := st.finish(); err != nil {
+		return err
 	}
+	if skipped != nil {
+		return skipped
+	}
+	return nil
+}
 
-	sort.Slice(chunks, func(i, j int) bool {
-		return chunks[i].index < chunks[j].index
-	})
+// RestoreInto restores the file stored at uri into dir under the name it
+// was encoded from, and returns the path written. It fails with
+// ErrNoFilename for archives that record none, such as streams, and with
+// ErrOutputPathInvalid for a name that is not a plain file name. A
+// directory archive (see EncodeDir) is written as its tar stream, with
+// ".tar" added to the name.
+func (v *VFS) RestoreInto(ctx context.Context, uri, dir string) (string, error) {
+	m, err := v.Stat(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	name := m.Filename
+	if name == "" {
+		return "", ErrNoFilename
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: unsafe filename %q", ErrOutputPathInvalid, name)
+	}
+	if m.Tar {
+		name += ".tar"
+	}
+	path := filepath.Join(dir, name)
+	return path, v.Restore(ctx, uri, path)
+}
+
+// RestoreWriter streams the file stored at uri into w.
+func (v *VFS) RestoreWriter(ctx context.Context, uri string, w io.Writer) (err error) {
+	ctx, end := v.startOp(ctx, OpRestore, "RestoreWriter", uri)
+	defer end(&err)
+	chunks, m, err := v.openArchive(ctx, uri)
+	if err != nil {
+		return err
+	}
+	return v.restoreTo(ctx, uri, chunks, m, w, nil)
+}
+
+// openArchive lists the chunks of the archive at uri and reads its
+// manifest, which is nil for archives written before manifests. Chunks of
+// archives with parity are rebuilt from it if they are missing or do not
+// decode.
+func (v *VFS) openArchive(ctx context.Context, uri string) ([]storedChunk, *Manifest, error) {
+	b, prefix, err := v.open(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := v.checkMirrors(ctx, b, prefix); err != nil {
+		return nil, nil, err
+	}
+	chunks, m, err := v.archiveChunks(ctx, b, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chunks, err = v.heal(ctx, b, prefix, chunks, m); err != nil {
+		return nil, nil, err
+	}
 
 	// ✅ Abort restore if no chunks
 	if len(chunks) == 0 {
-		fmt.Printf("⚠️  No chunks found at s3://%s/%s. Restore aborted.\n", bucket, prefix)
-		return nil
+		return nil, nil, fmt.Errorf("%w at %s", ErrNoChunks, uri)
 	}
+	return chunks, m, nil
+}
 
-	if err := os.MkdirAll(path.Dir(outputPath), 0755); err != nil {
-		return err
+// restoreTo writes chunks to w and checks the result against the
+// manifest. In best-effort mode a chunk count that disagrees with the
+// manifest is only a warning. If st is not nil, w is its output file and
+// the chunks st has already written are skipped.
+func (v *VFS) restoreTo(ctx context.Context, uri string, chunks []storedChunk, m *Manifest, w io.Writer, st *restoreState) error {
+	if missing, duplicate := indexGaps(chunks); len(missing) > 0 || len(duplicate) > 0 {
+		var problems []string
+		if len(missing) > 0 {
+			problems = append(problems, "missing chunks "+formatIndices(missing))
+		}
+		if len(duplicate) > 0 {
+			problems = append(problems, "duplicate chunks "+formatIndices(duplicate))
+		}
+		err := fmt.Errorf("%w: %s has %s", ErrChunkGap, uri, strings.Join(problems, " and "))
+		if !v.allowGaps {
+			return err
+		}
+		v.warn(OpRestore, "⚠️  "+err.Error())
+		chunks = dedupChunks(chunks)
+	}
+	if m != nil && m.Chunks != len(chunks) {
+		err := fmt.Errorf("%w: %s has %d chunks, its manifest lists %d", ErrManifestMismatch, uri, len(chunks), m.Chunks)
+		if !v.bestEffort && !v.allowGaps {
+			return err
+		}
+		v.warn(OpRestore, "⚠️  "+err.Error())
 	}
 
-	out, err := os.Create(outputPath)
-	if err != nil {
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(w, h)}
+	if m.compression() != CompressionNone {
+		// Chunks hold the compressed stream, which cannot be resumed
+		// part way through.
+		st = nil
+	}
+	if st != nil && st.Last > 0 {
+		if err := st.seed(h); err != nil {
+			return err
+		}
+		cw.n = st.Bytes
+		chunks = chunks[sort.Search(len(chunks), func(i int) bool { return chunks[i].index > st.Last }):]
+	}
+	out := decompressTo(cw, m.compression())
+	if err := v.writeChunks(ctx, chunks, out, st); err != nil {
+		out.Close()
 		return err
 	}
-	defer out.Close()
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", uri, err)
+	}
+	if m == nil {
+		return nil
+	}
+	if cw.n != m.Size {
+		return fmt.Errorf("%w: restored %d bytes from %s, its manifest lists %d", ErrManifestMismatch, cw.n, uri, m.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.SHA256 {
+		return fmt.Errorf("%w: restored SHA-256 %s from %s, its manifest lists %s", ErrManifestMismatch, sum, uri, m.SHA256)
+	}
+	return nil
+}
 
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, v.concurrency)
-	results := make([][]byte, len(chunks))
-	var errMu sync.Mutex
-	var firstErr error
+// dedupChunks keeps the first chunk of each index; chunks must be sorted.
+func dedupChunks(chunks []storedChunk) []storedChunk {
+	out := chunks[:0:0]
+	for i, c := range chunks {
+		if i > 0 && c.index == chunks[i-1].index {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
 
-	fmt.Printf("Downloading %d chunks...\n", len(chunks))
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	for i, chunk := range chunks {
-		sem <- struct{}{}
-		wg.Add(1)
-		go func(i int, encoded string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			data, err := base64.RawURLEncoding.DecodeString(encoded)
-			if err != nil {
-				errMu.Lock()
-				if firstErr == nil {
-					firstErr = err
-				}
-				errMu.Unlock()
-				return
-			}
-			results[i] = data
-			fmt.Printf("\rDownloaded: %d/%d", i+1, len(chunks))
-		}(i, chunk.encoded)
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// archiveChunks lists the chunks of the archive at prefix in either layout
+// and reads its manifest, if it has one. An encrypted archive without its
+// manifest fails with ErrNoManifest rather than yield sealed chunks.
+func (v *VFS) archiveChunks(ctx context.Context, b Backend, prefix string) ([]storedChunk, *Manifest, error) {
+	dataPrefix, err := v.chunkPrefix(ctx, b, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := v.checkCommitted(ctx, b, prefix, dataPrefix); err != nil {
+		return nil, nil, err
+	}
+	m, err := v.readManifest(ctx, b, manifestKey(prefix, dataPrefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	if m == nil {
+		if err := v.checkPlainChunks(ctx, b, prefix, dataPrefix); err != nil {
+			return nil, nil, err
+		}
+	}
+	if m.deduped() {
+		return m.pooledChunks(ctx, b), m, nil
+	}
+	chunks, err := v.listStored(ctx, b, dataPrefix, m.bodies())
+	if err != nil {
+		return nil, nil, err
+	}
+	if m != nil {
+		m.seal(prefix, chunks)
 	}
+	return chunks, m, nil
+}
 
-	wg.Wait()
-	fmt.Println("\n✅ Download complete.")
-	if firstErr != nil {
-		return firstErr
+// writeChunks decodes chunks in parallel and writes each to w as soon as
+// every chunk before it has been written, recording it in st if st is not
+// nil. At most two chunks per worker are decoded ahead of the writer, so
+// memory stays flat however large the file is. In best-effort mode
+// undecodable chunks are skipped and reported as a *DecodeError once
+// everything else has been written; otherwise writing stops before the
+// first of them.
+func (v *VFS) writeChunks(ctx context.Context, chunks []storedChunk, w io.Writer, st *restoreState) error {
+	var total int64
+	for _, c := range chunks {
+		if c.load != nil {
+			total += c.size
+		} else {
+			total += int64(base64.RawURLEncoding.DecodedLen(len(c.encoded)))
+		}
+		if c.codec != nil {
+			total -= int64(c.codec.overhead())
+		}
+	}
+	v.emit(Event{Op: OpRestore, Kind: EventStart, Total: len(chunks), BytesTotal: total})
+
+	type decoded struct {
+		index int
+		data  []byte
+		err   error
 	}
+	// pending holds one result channel per dispatched chunk, in order; its
+	// capacity bounds how far decoding runs ahead of writing.
+	pending := make(chan chan decoded, 2*v.concurrency)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(pending)
+		sem := make(chan struct{}, v.concurrency)
+		for _, c := range chunks {
+			res := make(chan decoded, 1)
+			select {
+			case pending <- res:
+			case <-stop:
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func() {
+				defer func() { <-sem }()
+				data, err := c.decode()
+				res <- decoded{index: c.index, data: data, err: err}
+			}()
+		}
+	}()
 
-	for _, data := range results {
-		if _, err := out.Write(data); err != nil {
+	var failed []int
+	var done int
+	var bytesDone int64
+	for res := range pending {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		d := <-res
+		if d.err != nil {
+			if !v.bestEffort {
+				return d.err
+			}
+			failed = append(failed, d.index)
+			continue
+		}
+		if _, err := w.Write(d.data); err != nil {
+			return err
+		}
+		// Past a skipped chunk the file is no longer a prefix of the
+		// original, so a resume has to start again from the gap.
+		if len(failed) == 0 {
+			if err := st.advance(d.index, len(d.data)); err != nil {
+				return err
+			}
+		}
+		done++
+		bytesDone += int64(len(d.data))
+		v.emit(Event{Op: OpRestore, Kind: EventChunk, Index: d.index, Bytes: int64(len(d.data)),
+			Done: done, Total: len(chunks), BytesDone: bytesDone, BytesTotal: total})
+		opFrom(ctx).count(OpRestore, 1, int64(len(d.data)))
+		v.debug(ctx, "chunk restored", "chunk_index", d.index, "bytes", len(d.data))
 	}
-	fmt.Printf("Restored file written to: %s\n", outputPath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return &DecodeError{Indices: failed}
+	}
+	v.emit(Event{Op: OpRestore, Kind: EventDone, Done: done, Total: len(chunks), BytesDone: bytesDone, BytesTotal: total})
 	return nil
 }
 
-func (v *VFS) Delete(s3URI string) error {
-	bucket, prefix, err := parseS3Path(s3URI)
+// DecodeError lists the chunks a best-effort restore had to skip.
+type DecodeError struct {
+	Indices []int
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %d chunk(s), skipped indices %v", len(e.Indices), e.Indices)
+}
+
+// Delete removes everything under uri, including nested archives and the
+// versions and snapshots of the archive there. If any of them was written
+// with an Object Lock that still holds, Delete fails with ErrObjectLocked
+// before deleting anything.
+func (v *VFS) Delete(ctx context.Context, uri string) (err error) {
+	ctx, end := v.startOp(ctx, OpDelete, "Delete", uri)
+	defer end(&err)
+	b, prefix, err := v.open(ctx, uri)
 	if err != nil {
 		return err
 	}
+	if err := v.checkUnlocked(ctx, b, prefix, uri, false); err != nil {
+		return err
+	}
+	return v.deleteUnder(ctx, b, prefix, false)
+}
 
-	p := s3.NewListObjectsV2Paginator(v.client, &s3.ListObjectsV2Input{
-		Bucket: &bucket,
-		Prefix: &prefix,
-	})
+// deleteUnder deletes the objects under prefix, except the versions and
+// snapshots of the archive there if keepHistory is set.
+func (v *VFS) deleteUnder(ctx context.Context, b Backend, prefix string, keepHistory bool) error {
+	kept := func(key string) bool {
+		return keepHistory && isHistory(strings.TrimPrefix(key, prefix))
+	}
+	if err := v.releaseAll(ctx, b, prefix, kept); err != nil {
+		return err
+	}
 
 	deleted := 0
-	for p.HasMorePages() {
-		page, err := p.NextPage(context.TODO())
-		if err != nil {
-			return err
-		}
-		var toDelete []s3types.ObjectIdentifier
-		for _, obj := range page.Contents {
-			toDelete = append(toDelete, s3types.ObjectIdentifier{Key: obj.Key})
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		keys := make([]string, 0, len(objs))
+		for _, obj := range objs {
+			if !kept(obj.Key) {
+				keys = append(keys, obj.Key)
+			}
 		}
-		if len(toDelete) == 0 {
-			break
+		if len(keys) == 0 {
+			return nil
 		}
-		_, err = v.client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
-			Bucket: &bucket,
-			Delete: &s3types.Delete{Objects: toDelete},
-		})
-		if err != nil {
+		if err := v.deleteWithBackoff(ctx, b, keys); err != nil {
 			return err
 		}
-		deleted += len(toDelete)
-		fmt.Printf("\rDeleted: %d", deleted)
+		deleted += len(keys)
+		v.emit(Event{Op: OpDelete, Kind: EventChunk, Done: deleted})
+		opFrom(ctx).count(OpDelete, len(keys), 0)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	fmt.Println("\n✅ Delete complete.")
+	v.emit(Event{Op: OpDelete, Kind: EventDone, Done: deleted})
 	return nil
 }
 
-func (v *VFS) hasObjects(bucket, prefix string) (bool, error) {
-	maxKeys := int32(1)
-	p := s3.NewListObjectsV2Paginator(v.client, &s3.ListObjectsV2Input{
-		Bucket:  &bucket,
-		Prefix:  &prefix,
-		MaxKeys: &maxKeys,
-	})
-	page, err := p.NextPage(context.TODO())
+type storedChunk struct {
+	index    int
+	key      string
+	encoded  string
+	checksum string     // empty for keys written before checksums
+	width    int        // digits of a zero-padded index, 0 if it has no leading zero
+	codec    chunkCodec // set for chunks of obfuscated or encrypted archives
+
+	// Body parts carry their data in the object: load fetches its size
+	// bytes.
+	size int64
+	load func() ([]byte, error)
+
+	// rebuild, if set, rebuilds the chunk from the archive's parity
+	// shards when it does not decode.
+	rebuild func() ([]byte, error)
+}
+
+// A chunk key is "<index>-<data>.<crc>", where index is zero-padded to the
+// archive's index width, so listings come back in chunk order, and crc is the
+// hex CRC-32C of the chunk. '.' is not in the base64url alphabet, so keys
+// written before checksums existed ("<index>-<data>") still parse
+// unambiguously, as do unpadded indices from before padding.
+const checksumLen = 1 + 8
+
+// keyFormat describes how chunk keys are spelled. Appending keeps the
+// format an archive was written in, so its chunks stay the same size.
+type keyFormat struct {
+	checksum bool
+	width    int        // digits of the zero-padded index, 0 for unpadded keys
+	codec    chunkCodec // obfuscates or encrypts chunks
+	body     bool       // chunks are body parts of bodyChunkSize bytes
+	maxChunk int        // caps the size of key-only chunks when positive
+}
+
+var currentKeyFormat = keyFormat{checksum: true, width: minIndexWidth}
+
+// encodeKeyFormat returns the key format for a file of size bytes (-1 if
+// unknown), keeping the codec, body storage, and chunk size cap of base. It picks the
+// narrowest index width, from minIndexWidth up, that numbers every chunk. A
+// wider index leaves less room for data, so the chunk count is recomputed
+// for each width. Files too large for maxIndexWidth digits fail with
+// ErrTooManyChunks.
+func encodeKeyFormat(size int64, limit int, base keyFormat) (keyFormat, error) {
+	f := currentKeyFormat
+	f.codec, f.body, f.maxChunk = base.codec, base.body, base.maxChunk
+	if size < 0 {
+		return f, nil
+	}
+	for ; f.width <= maxIndexWidth; f.width++ {
+		chunkSize := f.chunkSize(limit)
+		if chunkSize < 1 {
+			return f, errChunkSizeTooSmall
+		}
+		if chunkCount(size, chunkSize) <= int64(f.maxIndex()) {
+			return f, nil
+		}
+	}
+	return f, fmt.Errorf("%w: %d bytes need more than %d chunks", ErrTooManyChunks, size, keyFormat{width: maxIndexWidth}.maxIndex())
+}
+
+// digits returns how many digits an index may have in this format.
+func (f keyFormat) digits() int {
+	if f.width > 0 {
+		return f.width
+	}
+	return maxIndexLen
+}
+
+// maxIndex returns the largest index the format can number.
+func (f keyFormat) maxIndex() int {
+	n := 1
+	for range f.digits() {
+		n *= 10
+	}
+	return n - 1
+}
+
+func (f keyFormat) key(prefix string, index int, data []byte) string {
+	key, _ := f.object(prefix, index, data)
+	return key
+}
+
+// object returns the key and body storing data at index: an empty body for
+// a key-only chunk, the encoded data for a body part. Negative indices are
+// parity shards (see parityIndex).
+func (f keyFormat) object(prefix string, index int, data []byte) (string, []byte) {
+	if f.codec != nil {
+		data = f.codec.encode(index, data)
+	}
+	if index < 0 {
+		var body []byte
+		if f.body {
+			body = data
+		}
+		return path.Join(prefix, f.parityName(index, data)), body
+	}
+	if f.body {
+		return f.partKey(prefix, index, data), data
+	}
+	name := strconv.Itoa(index)
+	if f.width > 0 {
+		name = fmt.Sprintf("%0*d", f.width, index)
+	}
+	name += "-" + base64.RawURLEncoding.EncodeToString(data)
+	if f.checksum {
+		name += "." + chunkChecksum(data)
+	}
+	return path.Join(prefix, name), nil
+}
+
+// holds reports whether the stored chunk key under prefix carries data at
+// index. Encrypted chunks differ in their nonce, so encoded chunks are
+// compared by content, which for body parts means fetching them from b.
+func (f keyFormat) holds(ctx context.Context, b Backend, prefix, key string, index int, data []byte) bool {
+	if f.codec == nil {
+		return key == f.key(prefix, index, data)
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	c, ok := parseChunkName(name)
+	if f.body {
+		c, ok = parsePartName(name)
+		c.load = func() ([]byte, error) { return b.Get(ctx, key) }
+	}
+	if !ok || c.index != index {
+		return false
+	}
+	c.codec = f.codec
+	got, err := c.decode()
+	return err == nil && bytes.Equal(got, data)
+}
+
+// chunkSize returns how many bytes a chunk holds when keys may have limit
+// bytes after the prefix. Body parts hold bodyChunkSize bytes whatever the
+// limit.
+func (f keyFormat) chunkSize(limit int) int {
+	if f.body {
+		return bodyChunkSize
+	}
+	if f.checksum {
+		limit -= checksumLen
+	}
+	available := limit - maxIndexLen
+	if f.width > 0 {
+		available = limit - f.width - 1 // unpadded formats reserved no room for the '-'
+	}
+	n := (available * 3) / 4
+	if f.codec != nil {
+		n -= f.codec.overhead()
+	}
+	if f.maxChunk > 0 {
+		n = min(n, f.maxChunk)
+	}
+	return max(n, 0)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func chunkChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, castagnoli))
+}
+
+// parseChunkName parses "<index>-<data>[.<crc>]".
+func parseChunkName(name string) (storedChunk, bool) {
+	parts := strings.SplitN(name, "-", 2)
+	if len(parts) != 2 {
+		return storedChunk{}, false
+	}
+	index, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return false, err
+		return storedChunk{}, false
+	}
+	c := storedChunk{index: index, encoded: parts[1]}
+	if len(parts[0]) > 1 && parts[0][0] == '0' {
+		c.width = len(parts[0])
 	}
-	return len(page.Contents) > 0, nil
+	if data, sum, ok := strings.Cut(parts[1], "."); ok {
+		c.encoded, c.checksum = data, sum
+	}
+	return c, true
 }
 
-func parseS3Path(s3Path string) (string, string, error) {
-	if !strings.HasPrefix(s3Path, "s3://") {
-		return "", "", fmt.Errorf("must start with s3://")
+// decode returns the chunk's data like decodeStored, falling back to
+// rebuilding it from parity if the archive has any.
+func (c storedChunk) decode() ([]byte, error) {
+	data, err := c.decodeStored()
+	if err != nil && c.rebuild != nil {
+		rebuilt, rerr := c.rebuild()
+		if rerr == nil {
+			return rebuilt, nil
+		}
+		return nil, fmt.Errorf("%w; parity cannot rebuild it: %v", err, rerr)
+	}
+	return data, err
+}
+
+// decodeStored returns the chunk's data, fetching it first if it is a body
+// part, verifying its checksum if it has one and undoing its codec, if
+// any. The checksum covers the stored bytes, so it can be checked without
+// the key.
+func (c storedChunk) decodeStored() ([]byte, error) {
+	var data []byte
+	var err error
+	if c.load != nil {
+		data, err = c.load()
+	} else {
+		data, err = base64.RawURLEncoding.DecodeString(c.encoded)
 	}
-	parsed, err := url.Parse(s3Path)
 	if err != nil {
-		return "", "", err
+		return nil, fmt.Errorf("chunk %d: %w", c.index, err)
+	}
+	if c.checksum != "" && c.checksum != chunkChecksum(data) {
+		return nil, fmt.Errorf("chunk %d: %w", c.index, ErrChunkChecksum)
 	}
-	bucket := parsed.Host
-	prefix := strings.TrimLeft(parsed.Path, "/")
-	if prefix != "" && !strings.HasSuffix(prefix, "/") {
-		prefix += "/"
+	if c.codec != nil {
+		if data, err = c.codec.decode(c.index, data); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", c.index, err)
+		}
+	}
+	return data, nil
+}
+
+// listChunks returns the chunk keys under prefix sorted by index. Keys that
+// do not follow the "<index>-<data>" layout are ignored.
+func (v *VFS) listChunks(ctx context.Context, b Backend, prefix string) ([]storedChunk, error) {
+	var chunks []storedChunk
+	err := b.List(ctx, prefix, func(objs []Object) error {
+		for _, obj := range objs {
+			name := strings.TrimPrefix(obj.Key, prefix)
+			name = strings.TrimPrefix(name, "/")
+			c, ok := parseChunkName(name)
+			if !ok {
+				continue
+			}
+			c.key = obj.Key
+			chunks = append(chunks, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return bucket, prefix, nil
+
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].index != chunks[j].index {
+			return chunks[i].index < chunks[j].index
+		}
+		return chunks[i].key < chunks[j].key
+	})
+	return chunks, nil
+}
+
+func chunkKey(prefix string, index int, data []byte) string {
+	return currentKeyFormat.key(prefix, index, data)
 }
 
+func splitChunks(data []byte, chunkSize int) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := min(chunkSize, len(data))
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+// prepareOutputPath creates the parent directories of outputPath, reporting
+// ErrOutputPathInvalid when outputPath is a directory or one of its parents
+// is an existing file.
+func prepareOutputPath(outputPath string) error {
+	if info, err := os.Stat(outputPath); err == nil && info.IsDir() {
+		return fmt.Errorf("%w: %s is a directory", ErrOutputPathInvalid, outputPath)
+	}
+	dir := filepath.Dir(outputPath)
+	for p := dir; ; p = filepath.Dir(p) {
+		if info, err := os.Stat(p); err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%w: %s is not a directory", ErrOutputPathInvalid, p)
+			}
+			break
+		}
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// calculateChunkSize returns how many bytes fit in an S3 chunk key under
+// prefix.
 func calculateChunkSize(prefix string) int {
-	available := s3MaxKeyLengthBytes - len(prefix) - maxIndexLen
-	if available <= 0 {
-		return 0
+	return keyChunkSize(s3MaxKeyLengthBytes - len(prefix))
+}
+
+// backendChunkSize returns how many bytes fit in a chunk key of b under
+// prefix.
+func backendChunkSize(b Backend, prefix string) int {
+	return keyChunkSize(b.KeyLimit(prefix))
+}
+
+// keyChunkSize returns how many bytes a chunk key of limit bytes can carry
+// besides its index and checksum.
+func keyChunkSize(limit int) int {
+	return currentKeyFormat.chunkSize(limit)
+}
+
+func chunkCount(size int64, chunkSize int) int64 {
+	n := size / int64(chunkSize)
+	if size%int64(chunkSize) != 0 {
+		n++
 	}
-	return (available * 3) / 4
+	return n
+}
+
+func storageModeAdvice(chunkSize int, size int64) string {
+	if chunkSize >= minKeyChunkSize {
+		return ""
+	}
+	return fmt.Sprintf("⚠️  Key-only chunk size is only %d bytes because the prefix is very long.\n"+
+		"⚠️  Key-only mode needs %d objects for this file; body-storage mode would need %d.\n"+
+		"⚠️  Consider a shorter prefix or storing the data in object bodies (--body-threshold or --auto-mode).",
+		chunkSize, chunkCount(size, chunkSize), chunkCount(size, bodyChunkSize))
+}
+
+// storageModeSwitch explains why auto mode stores a file in object bodies.
+func storageModeSwitch(chunkSize int, size int64) string {
+	return fmt.Sprintf("⚠️  Key-only chunk size is only %d bytes because the prefix is very long.\n"+
+		"⚠️  Storing this file in object bodies (--auto-mode): %d object(s) instead of %d in key-only mode.",
+		chunkSize, chunkCount(size, bodyChunkSize), chunkCount(size, chunkSize))
 }
 
 func getConcurrency() int {
@@ -323,4 +1332,3 @@ func getConcurrency() int {
 	}
 	return n
 }
-
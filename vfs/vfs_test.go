@@ -2,16 +2,37 @@
 package vfs
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 )
 
-func TestParseS3Path(t *testing.T) {
-	bucket, prefix, err := parseS3Path("s3://my-bucket/path/to/folder/")
+// legacyChunkKey spells a key as archives written before checksums did.
+func legacyChunkKey(prefix string, index int, data []byte) string {
+	return keyFormat{}.key(prefix, index, data)
+}
+
+// legacyKeyChunkSize is keyChunkSize for keys without a checksum.
+func legacyKeyChunkSize(limit int) int {
+	return keyFormat{}.chunkSize(limit)
+}
+
+func TestParseURI(t *testing.T) {
+	scheme, bucket, prefix, err := parseURI("s3://my-bucket/path/to/folder/")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if scheme != "s3" {
+		t.Errorf("expected scheme 's3', got '%s'", scheme)
+	}
 	if bucket != "my-bucket" {
 		t.Errorf("expected bucket 'my-bucket', got '%s'", bucket)
 	}
@@ -20,6 +41,22 @@ func TestParseS3Path(t *testing.T) {
 	}
 }
 
+func TestParseURI_KeepsUserAndPort(t *testing.T) {
+	_, bucket, prefix, err := parseURI("sftp://deploy@files.example.com:2222/srv/drop/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bucket != "deploy@files.example.com:2222" || prefix != "srv/drop/" {
+		t.Errorf("unexpected bucket %q, prefix %q", bucket, prefix)
+	}
+}
+
+func TestParseURI_RequiresScheme(t *testing.T) {
+	if _, _, _, err := parseURI("my-bucket/path/"); err == nil {
+		t.Error("expected an error for a URI without a scheme")
+	}
+}
+
 func TestCalculateChunkSize(t *testing.T) {
 	prefix := strings.Repeat("a", 100) + "/"
 	size := calculateChunkSize(prefix)
@@ -57,3 +94,551 @@ func TestGetConcurrency_Invalid(t *testing.T) {
 	}
 }
 
+func TestStorageModeAdvice_LongPrefix(t *testing.T) {
+	prefix := strings.Repeat("p", 950) + "/"
+	size := calculateChunkSize(prefix)
+	if size >= minKeyChunkSize {
+		t.Fatalf("expected chunk size below %d for long prefix, got %d", minKeyChunkSize, size)
+	}
+	advice := storageModeAdvice(size, 10<<20)
+	if advice == "" {
+		t.Fatal("expected a body-storage recommendation for a pathologically long prefix")
+	}
+	keyObjects := strconv.FormatInt(chunkCount(10<<20, size), 10)
+	if !strings.Contains(advice, keyObjects) || !strings.Contains(advice, "would need 2") {
+		t.Errorf("expected object-count comparison in advice, got %q", advice)
+	}
+}
+
+func TestStorageModeAdvice_NormalPrefix(t *testing.T) {
+	size := calculateChunkSize("backups/db/")
+	if advice := storageModeAdvice(size, 10<<20); advice != "" {
+		t.Errorf("expected no recommendation for a short prefix, got %q", advice)
+	}
+}
+
+func TestPrepareOutputPath_ParentIsFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := prepareOutputPath(filepath.Join(file, "sub", "out.bin"))
+	if !errors.Is(err, ErrOutputPathInvalid) {
+		t.Fatalf("expected ErrOutputPathInvalid, got %v", err)
+	}
+	if !strings.Contains(err.Error(), file) {
+		t.Errorf("expected error to name %s, got %q", file, err)
+	}
+}
+
+func TestPrepareOutputPath_OutputIsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	err := prepareOutputPath(dir)
+	if !errors.Is(err, ErrOutputPathInvalid) {
+		t.Fatalf("expected ErrOutputPathInvalid, got %v", err)
+	}
+}
+
+func TestPrepareOutputPath_CreatesParents(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "a", "b", "out.bin")
+	if err := prepareOutputPath(out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, err := os.Stat(filepath.Dir(out)); err != nil || !info.IsDir() {
+		t.Errorf("expected parent directory to be created")
+	}
+}
+
+func seedCorruptArchive(t *testing.T) (*fakeS3, []byte, []byte) {
+	t.Helper()
+	chunkSize := calculateChunkSize("salvage/")
+	first, third := randomBytes(chunkSize), randomBytes(100)
+	fake := newFakeS3()
+	fake.objects["bucket/"+chunkKey("salvage/", 1, first)] = nil
+	fake.objects["bucket/salvage/2-!!not*base64!!"] = nil
+	fake.objects["bucket/"+chunkKey("salvage/", 3, third)] = nil
+	return fake, first, third
+}
+
+func TestRestore_BestEffortSkipsUndecodableChunks(t *testing.T) {
+	fake, first, third := seedCorruptArchive(t)
+	v := newTestVFS(fake)
+	v.bestEffort = true
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	err := v.Restore(context.Background(), "s3://bucket/salvage/", out)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected *DecodeError, got %v", err)
+	}
+	if len(decodeErr.Indices) != 1 || decodeErr.Indices[0] != 2 {
+		t.Errorf("expected failed indices [2], got %v", decodeErr.Indices)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, append(first, third...)) {
+		t.Error("expected the decodable chunks to be recovered")
+	}
+}
+
+func TestRestore_DecodeErrorAbortsByDefault(t *testing.T) {
+	fake, first, _ := seedCorruptArchive(t)
+	v := newTestVFS(fake)
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	err := v.Restore(context.Background(), "s3://bucket/salvage/", out)
+	var decodeErr *DecodeError
+	if err == nil || errors.As(err, &decodeErr) {
+		t.Fatalf("expected a plain decode error, got %v", err)
+	}
+	// Restore streams, so the chunks before the bad one are already in
+	// the partial file, but nothing is under the output name.
+	if got, _ := os.ReadFile(out + ".vfs-partial"); !bytes.Equal(got, first) {
+		t.Errorf("expected only chunk 1 written without best effort, got %d bytes", len(got))
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected no output file, got %v", err)
+	}
+}
+
+func TestRestore_DetectsTamperedChunk(t *testing.T) {
+	fake := newFakeS3()
+	data := []byte("the quick brown fox")
+	key := chunkKey("tamper/", 1, data)
+	// Swap one base64 character: the key still decodes, to other bytes.
+	name := []byte(key)
+	i := strings.Index(key, "-") + 1
+	if name[i] = 'A'; key[i] == 'A' {
+		name[i] = 'B'
+	}
+	fake.objects["bucket/"+string(name)] = nil
+
+	err := newTestVFS(fake).RestoreWriter(context.Background(), "s3://bucket/tamper/", io.Discard)
+	if !errors.Is(err, ErrChunkChecksum) {
+		t.Errorf("expected ErrChunkChecksum, got %v", err)
+	}
+}
+
+func TestRestore_LegacyKeysWithoutChecksum(t *testing.T) {
+	fake := newFakeS3()
+	data := randomBytes(300)
+	fake.objects["bucket/"+legacyChunkKey("old/", 1, data)] = nil
+
+	var out bytes.Buffer
+	if err := newTestVFS(fake).RestoreWriter(context.Background(), "s3://bucket/old/", &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Error("restored data does not match")
+	}
+}
+
+func seedGappedArchive(fake *fakeS3) (first, third []byte) {
+	first, third = randomBytes(100), randomBytes(100)
+	fake.objects["bucket/"+chunkKey("gaps/", 1, first)] = nil
+	fake.objects["bucket/"+chunkKey("gaps/", 3, third)] = nil
+	fake.objects["bucket/"+chunkKey("gaps/", 3, randomBytes(100))] = nil
+	return first, third
+}
+
+func TestRestore_DetectsGapsAndDuplicates(t *testing.T) {
+	fake := newFakeS3()
+	seedGappedArchive(fake)
+
+	var out bytes.Buffer
+	err := newTestVFS(fake).RestoreWriter(context.Background(), "s3://bucket/gaps/", &out)
+	if !errors.Is(err, ErrChunkGap) {
+		t.Fatalf("expected ErrChunkGap, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "missing chunks [2]") || !strings.Contains(err.Error(), "duplicate chunks [3]") {
+		t.Errorf("expected the missing and duplicate indices in %q", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected nothing written, got %d bytes", out.Len())
+	}
+}
+
+func TestRestore_AllowGaps(t *testing.T) {
+	fake := newFakeS3()
+	seedGappedArchive(fake)
+	v := newTestVFS(fake)
+	v.allowGaps = true
+	events := collectEvents(v)
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(context.Background(), "s3://bucket/gaps/", &out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if out.Len() != 200 {
+		t.Errorf("expected chunk 1 and one copy of chunk 3, got %d bytes", out.Len())
+	}
+	if len(*events) == 0 || (*events)[0].Kind != EventWarning {
+		t.Errorf("expected a gap warning first, got %+v", *events)
+	}
+}
+
+func TestChunkKeys_ListInIndexOrder(t *testing.T) {
+	fake := newFakeS3()
+	chunkSize := calculateChunkSize("order/")
+	if err := newTestVFS(fake).EncodeReader(context.Background(), bytes.NewReader(randomBytes(12*chunkSize)), "s3://bucket/order/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	next := 1
+	for _, k := range fake.keys("bucket") {
+		c, ok := parseChunkName(strings.TrimPrefix(k, "order/"))
+		if !ok {
+			continue
+		}
+		if c.index != next {
+			t.Fatalf("expected chunk %d next in the listing, got %s", next, k[:20])
+		}
+		next++
+	}
+}
+
+func TestParseChunkName(t *testing.T) {
+	data := []byte{0xfb, 0xff, 0x00}
+	c, ok := parseChunkName(strings.TrimPrefix(chunkKey("p/", 12, data), "p/"))
+	if !ok || c.index != 12 || c.width != minIndexWidth || c.checksum != chunkChecksum(data) {
+		t.Fatalf("unexpected parse result %+v, %v", c, ok)
+	}
+	if c, ok := parseChunkName("12-AAAA"); !ok || c.index != 12 || c.width != 0 {
+		t.Errorf("expected an unpadded legacy index to parse, got %+v, %v", c, ok)
+	}
+	if got, err := c.decode(); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("decode returned %v, %v", got, err)
+	}
+	if _, ok := parseChunkName("manifest"); ok {
+		t.Error("expected a name without an index to be rejected")
+	}
+}
+
+func TestEncodeKeyFormat_WidensIndexForLargeFiles(t *testing.T) {
+	const limit = 29 // 9-byte chunks at both 6 and 7 digits
+	f, err := encodeKeyFormat(9*999_999, limit, keyFormat{})
+	if err != nil || f.width != minIndexWidth {
+		t.Fatalf("expected %d digits, got %+v, %v", minIndexWidth, f, err)
+	}
+	f, err = encodeKeyFormat(9*1_000_000, limit, keyFormat{})
+	if err != nil || f.width != minIndexWidth+1 {
+		t.Fatalf("expected %d digits, got %+v, %v", minIndexWidth+1, f, err)
+	}
+	if f, err := encodeKeyFormat(-1, limit, keyFormat{}); err != nil || f != currentKeyFormat {
+		t.Errorf("expected the default format for unknown sizes, got %+v, %v", f, err)
+	}
+	if _, err := encodeKeyFormat(1<<50, limit, keyFormat{}); !errors.Is(err, ErrTooManyChunks) {
+		t.Errorf("expected ErrTooManyChunks, got %v", err)
+	}
+}
+
+func TestEncode_RecordsIndexWidth(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	if err := v.EncodeReader(context.Background(), bytes.NewReader(randomBytes(1000)), "s3://bucket/width/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	m, err := v.readManifest(context.Background(), &s3Backend{client: fake, bucket: "bucket"}, "width/"+manifestName)
+	if err != nil || m == nil || m.IndexWidth != minIndexWidth {
+		t.Errorf("expected index width %d in the manifest, got %+v, %v", minIndexWidth, m, err)
+	}
+}
+
+func TestEncode_CapsChunkSize(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	v.maxChunkSize = 100
+	ctx := context.Background()
+	const uri = "s3://bucket/capped/"
+	data := randomBytes(1050)
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.Append(ctx, writeTempFile(t, data[:30]), uri); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	keys := fake.keys("bucket")
+	if len(keys) != 12 { // 11 chunks and the manifest
+		t.Fatalf("expected 11 chunks of at most 100 bytes, got %d keys", len(keys))
+	}
+	for _, k := range keys {
+		if len(k) > len("capped/")+minIndexWidth+1+136+checksumLen {
+			t.Errorf("key longer than a 100-byte chunk needs: %d bytes", len(k))
+		}
+	}
+	r, err := newTestVFS(fake).Verify(ctx, uri)
+	if err != nil || !r.OK() || r.Manifest.ChunkSize != 100 {
+		t.Errorf("expected verify to pass with 100-byte chunks, got %+v, %v", r, err)
+	}
+}
+
+func TestEncode_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var puts int32
+	fake := newFakeS3()
+	fake.putHook = func() error {
+		if atomic.AddInt32(&puts, 1) == 5 {
+			cancel()
+		}
+		return nil
+	}
+	v := newTestVFS(fake)
+	v.limiter = newAIMDLimiter(1)
+
+	chunkSize := calculateChunkSize("cancel/")
+	err := v.Encode(ctx, writeTempFile(t, randomBytes(100*chunkSize)), "s3://bucket/cancel/", true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n := len(fake.keys("bucket")); n >= 100 {
+		t.Errorf("expected encode to stop early, uploaded %d chunks", n)
+	}
+}
+
+func TestRestore_CanceledContext(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	if err := v.Encode(context.Background(), writeTempFile(t, randomBytes(2000)), "s3://bucket/r/", true); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := v.Restore(ctx, "s3://bucket/r/", filepath.Join(t.TempDir(), "out"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestEncodeReader_ShortReads(t *testing.T) {
+	const uri = "s3://bucket/stream/"
+	data := randomBytes(5*calculateChunkSize("stream/") + 3)
+
+	v := newTestVFS(newFakeS3())
+	if err := v.EncodeReader(context.Background(), iotest.HalfReader(bytes.NewReader(data)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	// Migrate validates that every chunk but the last is full-sized.
+	if err := v.Migrate(context.Background(), uri); err != nil {
+		t.Fatalf("short reads produced undersized chunks: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.bin")
+	if err := v.Restore(context.Background(), uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	if !bytes.Equal(got, data) {
+		t.Error("restored data does not match the reader's content")
+	}
+}
+
+func TestEncodeReader_Pipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readerSize(r) != -1 {
+		t.Fatal("expected a pipe to have no known size")
+	}
+	data := randomBytes(3*calculateChunkSize("pipe/") + 7)
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, r, "s3://bucket/pipe/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if m, err := v.Stat(ctx, "s3://bucket/pipe/"); err != nil || m.Size != int64(len(data)) || m.Chunks != 4 {
+		t.Errorf("expected the manifest to record the streamed size, got %+v, %v", m, err)
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, "s3://bucket/pipe/", &out); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected the piped data back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestEncodeReader_RefusesExistingData(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, strings.NewReader("first"), "s3://bucket/once/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if err := v.EncodeReader(ctx, strings.NewReader("second"), "s3://bucket/once/", false); !errors.Is(err, ErrPrefixExists) {
+		t.Errorf("expected ErrPrefixExists for a non-empty prefix, got %v", err)
+	}
+}
+
+func TestEncode_OverwriteNeedsForce(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/over/"
+	chunkSize := calculateChunkSize("over/")
+	if err := v.Encode(ctx, writeTempFile(t, randomBytes(5*chunkSize)), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	before := fake.keys("bucket")
+
+	second := randomBytes(2 * chunkSize)
+	if err := v.Encode(ctx, writeTempFile(t, second), uri, false); !errors.Is(err, ErrPrefixExists) {
+		t.Fatalf("expected ErrPrefixExists without force, got %v", err)
+	}
+	if got := fake.keys("bucket"); len(got) != len(before) {
+		t.Errorf("expected the archive untouched, had %d keys, now %d", len(before), len(got))
+	}
+
+	if err := v.Encode(ctx, writeTempFile(t, second), uri, true); err != nil {
+		t.Fatalf("forced encode failed: %v", err)
+	}
+	// Two chunks plus the manifest: nothing left over from the old file.
+	if got := fake.keys("bucket"); len(got) != 3 {
+		t.Errorf("expected 3 keys after overwrite, got %d", len(got))
+	}
+	var out bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &out); err != nil || !bytes.Equal(out.Bytes(), second) {
+		t.Errorf("expected the new file back, got %d bytes, %v", out.Len(), err)
+	}
+}
+
+func TestRestoreInto_UsesOriginalFilename(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	data := randomBytes(3000)
+	if err := v.Encode(ctx, writeTempFile(t, data), "s3://bucket/named/", false); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path, err := v.RestoreInto(ctx, "s3://bucket/named/", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "input.bin") {
+		t.Errorf("expected %s, got %s", filepath.Join(dir, "input.bin"), path)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("restored data does not match the original (%v)", err)
+	}
+
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), "s3://bucket/stream/", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.RestoreInto(ctx, "s3://bucket/stream/", dir); !errors.Is(err, ErrNoFilename) {
+		t.Errorf("expected ErrNoFilename for a stream, got %v", err)
+	}
+}
+
+func TestRestoreInto_RejectsUnsafeFilename(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	b, prefix, err := v.open(ctx, "s3://bucket/evil/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.encode(ctx, b, prefix, bytes.NewReader(randomBytes(100)), 100, Manifest{Filename: "../evil"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.RestoreInto(ctx, "s3://bucket/evil/", t.TempDir()); !errors.Is(err, ErrOutputPathInvalid) {
+		t.Errorf("expected ErrOutputPathInvalid, got %v", err)
+	}
+}
+
+func TestRestoreWriter(t *testing.T) {
+	const uri = "s3://bucket/writer/"
+	data := randomBytes(4000)
+	v := newTestVFS(newFakeS3())
+	ctx := context.Background()
+	if err := v.EncodeReader(ctx, bytes.NewReader(data), uri, false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := v.RestoreWriter(ctx, uri, &buf); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("restored data does not match the original")
+	}
+}
+
+func TestRestoreWriter_NoChunks(t *testing.T) {
+	v := newTestVFS(newFakeS3())
+	if err := v.RestoreWriter(context.Background(), "s3://bucket/none/", io.Discard); err == nil {
+		t.Error("expected error for an empty prefix")
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func TestEncodeReader_StreamsWithBoundedMemory(t *testing.T) {
+	const chunks = 500
+	chunkSize := calculateChunkSize("stream/")
+	input := &countingReader{r: io.LimitReader(zeroReader{}, int64(chunks*chunkSize))}
+
+	fake := newFakeS3()
+	var puts, maxAhead atomic.Int64
+	fake.putHook = func() error {
+		// Chunks read from the input that have not been stored yet.
+		ahead := input.n.Load()/int64(chunkSize) - puts.Add(1)
+		for m := maxAhead.Load(); ahead > m && !maxAhead.CompareAndSwap(m, ahead); m = maxAhead.Load() {
+		}
+		return nil
+	}
+	v := newTestVFS(fake)
+	if err := v.EncodeReader(context.Background(), input, "s3://bucket/stream/", false); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	if got := maxAhead.Load(); got > defaultConcurrency+1 {
+		t.Errorf("expected at most %d chunks in memory, read ahead %d", defaultConcurrency+1, got)
+	}
+
+	var out bytes.Buffer
+	if err := v.RestoreWriter(context.Background(), "s3://bucket/stream/", &out); err != nil || out.Len() != chunks*chunkSize {
+		t.Errorf("expected %d bytes back, got %d, %v", chunks*chunkSize, out.Len(), err)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestWriteChunks_BoundedReorderBuffer(t *testing.T) {
+	var chunks []storedChunk
+	want := randomBytes(300 * 50)
+	for i, c := range splitChunks(want, 50) {
+		sc, _ := parseChunkName(strings.TrimPrefix(chunkKey("p/", i+1, c), "p/"))
+		sc.key = chunkKey("p/", i+1, c)
+		chunks = append(chunks, sc)
+	}
+	v := newTestVFS(newFakeS3())
+	var last Event
+	v.progress = func(e Event) {
+		if e.Kind == EventChunk && e.Index != last.Index+1 {
+			t.Errorf("expected chunk %d to be written next, got %d", last.Index+1, e.Index)
+		}
+		last = e
+	}
+	var out bytes.Buffer
+	if err := v.writeChunks(context.Background(), chunks, &out, nil); err != nil {
+		t.Fatalf("writeChunks failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("expected the chunks written in index order")
+	}
+}
@@ -0,0 +1,145 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultDebounce is how long Watch waits for changes to settle.
+const defaultDebounce = 2 * time.Second
+
+// WatchOptions tunes Watch.
+type WatchOptions struct {
+	// Debounce is how long the path must go without changes before it is
+	// stored again, so a file being written is stored once, when the
+	// writer is done. Zero means 2 seconds.
+	Debounce time.Duration
+	// DeleteRemoved deletes the archives of files removed from a watched
+	// directory, as for Sync.
+	DeleteRemoved bool
+	// OnSync, if not nil, is called after every round of storing with
+	// what was stored, or the error that stopped the round.
+	OnSync func(*SyncReport, error)
+}
+
+// Watch keeps uri up to date with path until ctx is done. A file is
+// stored at uri as by Encode; a directory as by Sync, each file at its own
+// prefix below uri, including files in subdirectories created later. The
+// path is stored once at the start and again after every change, once the
+// changes have settled for opts.Debounce; unchanged files are skipped.
+// With WithVersioning the archive each change replaces is kept as a
+// version. A round that fails is reported to opts.OnSync and retried with
+// the next change. Watch returns nil when ctx is done, or an error if the
+// path cannot be watched.
+func (v *VFS) Watch(ctx context.Context, path, uri string, opts WatchOptions) error {
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultDebounce
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	path = filepath.Clean(path)
+	dir := info.IsDir()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if dir {
+		err = watchTree(w, path)
+	} else {
+		// Editors often save by replacing the file, which would end a
+		// watch on the file itself.
+		err = w.Add(filepath.Dir(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	round := func() {
+		var report *SyncReport
+		var err error
+		if dir {
+			report, err = v.Sync(ctx, path, uri, opts.DeleteRemoved)
+		} else {
+			report, err = v.syncFile(ctx, path, uri)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if opts.OnSync != nil {
+			opts.OnSync(report, err)
+		}
+	}
+	round()
+
+	timer := time.NewTimer(opts.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case e, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if e.Op == fsnotify.Chmod || !dir && filepath.Clean(e.Name) != path {
+				continue
+			}
+			if dir && e.Has(fsnotify.Create) {
+				if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+					if err := watchTree(w, e.Name); err != nil {
+						return err
+					}
+				}
+			}
+			timer.Reset(opts.Debounce)
+		case <-timer.C:
+			round()
+		}
+	}
+}
+
+// watchTree adds dir and every directory below it to w.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // removed while walking
+		}
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return w.Add(p)
+	})
+}
+
+// syncFile stores the file at path at uri unless the archive there already
+// holds the same data, reporting it by base name like Sync.
+func (v *VFS) syncFile(ctx context.Context, path, uri string) (*SyncReport, error) {
+	name := filepath.Base(path)
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	if m, err := v.Stat(ctx, uri); err == nil && m.SHA256 == sum {
+		return &SyncReport{Unchanged: []string{name}}, nil
+	}
+	if err := v.Encode(ctx, path, uri, true); err != nil {
+		return nil, err
+	}
+	return &SyncReport{Uploaded: []string{name}}, nil
+}
@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// watchRounds runs Watch on path in the background and returns the reports
+// of its rounds. The watch stops when the test ends.
+func watchRounds(t *testing.T, v *VFS, path, uri string) <-chan *SyncReport {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	rounds := make(chan *SyncReport, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- v.Watch(ctx, path, uri, WatchOptions{
+			Debounce: 50 * time.Millisecond,
+			OnSync: func(r *SyncReport, err error) {
+				if err != nil {
+					t.Errorf("round failed: %v", err)
+				}
+				rounds <- r
+			},
+		})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watch failed: %v", err)
+		}
+	})
+	return rounds
+}
+
+func nextRound(t *testing.T, rounds <-chan *SyncReport) *SyncReport {
+	t.Helper()
+	select {
+	case r := <-rounds:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a round")
+		return nil
+	}
+}
+
+func TestWatch_StoresFileAgainWhenItChanges(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	ctx := context.Background()
+	const uri = "s3://bucket/state/"
+	path := writeTempFile(t, randomBytes(300))
+	rounds := watchRounds(t, v, path, uri)
+
+	if r := nextRound(t, rounds); !reflect.DeepEqual(r.Uploaded, []string{"input.bin"}) {
+		t.Errorf("expected the file stored at the start, got %+v", r)
+	}
+	changed := randomBytes(500)
+	if err := os.WriteFile(path, changed, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := nextRound(t, rounds); !reflect.DeepEqual(r.Uploaded, []string{"input.bin"}) {
+		t.Errorf("expected the changed file stored, got %+v", r)
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	if err := v.Restore(ctx, uri, out); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, changed) {
+		t.Error("expected the archive to hold the changed file")
+	}
+}
+
+func TestWatch_SyncsNewSubdirectories(t *testing.T) {
+	fake := newFakeS3()
+	v := newTestVFS(fake)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a"), randomBytes(50), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rounds := watchRounds(t, v, dir, "s3://bucket/tree/")
+	if r := nextRound(t, rounds); !reflect.DeepEqual(r.Uploaded, []string{"a"}) {
+		t.Errorf("expected a stored at the start, got %+v", r)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	nextRound(t, rounds) // the new directory, still empty
+	if err := os.WriteFile(filepath.Join(sub, "b"), randomBytes(50), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := nextRound(t, rounds)
+	if !reflect.DeepEqual(r.Uploaded, []string{"sub/b"}) || !reflect.DeepEqual(r.Unchanged, []string{"a"}) {
+		t.Errorf("expected only sub/b stored, got %+v", r)
+	}
+}